@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"redis-clone-go/app/pubsub"
+	"redis-clone-go/app/replication"
+	"sync"
+	"sync/atomic"
+)
+
+type client struct {
+	conn       net.Conn
+	mu         sync.Mutex
+	subscriber *pubsub.Subscriber
+	// forwarded is closed once the subscriber's messages stopped being
+	// forwarded, closing tells forwardMessages to write what is left.
+	forwarded chan struct{}
+	closing   atomic.Bool
+	tx        *transaction
+	watched   map[string]watchedKey
+	// executing is set while EXEC or a script runs commands, blocking
+	// commands don't block then.
+	executing bool
+	// propagated collects the write commands of EXEC and scripts.
+	propagated [][]string
+	// alsoPropagated are the commands propagated after the one executing.
+	alsoPropagated [][]string
+	// listeningPort is the port a replica announced with REPLCONF.
+	listeningPort string
+	// replica is set once the client synced as a replica, the connection
+	// then carries the replication stream.
+	replica *replication.Replica
+	// replaying is set on the clients that replay the AOF or the master's
+	// stream, they write even on a read-only replica.
+	replaying bool
+	// woff is the replication offset after the client's last write, which
+	// is what WAIT and WAITAOF wait for.
+	woff int64
+	// asking is set by ASKING, the next command may then access a slot
+	// this node is importing.
+	asking bool
+}
+
+func newClient(conn net.Conn) *client {
+	return &client{conn: conn}
+}
+
+// write is safe to call concurrently, pushed pub/sub messages are written
+// from a separate goroutine.
+func (c *client) write(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.Write(data)
+}
+
+// reply writes the reply to a command. Once the client has a subscriber,
+// replies are queued behind the confirmations and messages pushed to it,
+// so everything is written in the order it was produced.
+func (c *client) reply(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	if c.subscriber != nil {
+		c.subscriber.Reply(data)
+		return
+	}
+
+	c.write(data)
+}
+
+// getSubscriber creates the client's subscriber on first use and starts
+// forwarding its messages to the connection.
+func (c *client) getSubscriber() *pubsub.Subscriber {
+	if c.subscriber == nil {
+		c.subscriber = pubsub.NewSubscriber()
+		c.forwarded = make(chan struct{})
+		go c.forwardMessages(c.subscriber)
+	}
+
+	return c.subscriber
+}
+
+func (c *client) forwardMessages(sub *pubsub.Subscriber) {
+	defer close(c.forwarded)
+
+	for {
+		select {
+		case <-sub.Ready():
+			c.writeAll(sub.Take())
+		case <-sub.Done():
+			//the subscriber is closed on disconnect or when it fell too far
+			//behind, in both cases the connection has to go. A client that
+			//is closing still gets the replies queued before, like QUIT's.
+			if c.closing.Load() {
+				c.writeAll(sub.Take())
+			}
+
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+func (c *client) writeAll(messages [][]byte) {
+	for _, message := range messages {
+		c.write(message)
+	}
+}
+
+// forwardReplication sends the dataset and then the replication stream to
+// a replica.
+func (c *client) forwardReplication(replica *replication.Replica) {
+	payload, err := replica.SyncPayload()
+	if err != nil {
+		fmt.Println("Error encoding the dataset for a replica: ", err.Error())
+		c.conn.Close()
+		return
+	}
+
+	c.write(payload)
+
+	for {
+		select {
+		case data := <-replica.Output():
+			c.write(data)
+		case <-replica.Done():
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+func (c *client) isSubscribed() bool {
+	if c.subscriber == nil {
+		return false
+	}
+
+	return pubsub.PS.SubscriptionCount(c.subscriber)+pubsub.ShardPS.SubscriptionCount(c.subscriber) > 0
+}
+
+func (c *client) close() {
+	if c.subscriber != nil {
+		pubsub.PS.RemoveSubscriber(c.subscriber)
+		pubsub.ShardPS.RemoveSubscriber(c.subscriber)
+		c.closing.Store(true)
+		c.subscriber.Close()
+		<-c.forwarded
+	}
+
+	if c.replica != nil {
+		replication.RemoveReplica(c.replica)
+	}
+
+	c.unwatch()
+	c.conn.Close()
+}
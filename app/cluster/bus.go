@@ -0,0 +1,223 @@
+package cluster
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"redis-clone-go/app/protocol"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Messages of the cluster bus. Every message is answered with a PONG,
+// except an authorization request a master grants, which is answered with
+// an ACK.
+const (
+	msgPing        = "PING"
+	msgPong        = "PONG"
+	msgMeet        = "MEET"
+	msgFail        = "FAIL"
+	msgAuthRequest = "FAILOVER_AUTH_REQUEST"
+	msgAuthAck     = "FAILOVER_AUTH_ACK"
+)
+
+// requestTimeout bounds a request on the bus, including connecting.
+const requestTimeout = time.Second
+
+var errBadMessage = errors.New("malformed cluster bus message")
+
+// message is a cluster bus message, encoded as an array of bulk strings:
+// "<type> <current-epoch> <offset> <sender> <payload> ...". The sender is
+// described like a line of the nodes file, with the slots it serves. For
+// PING, PONG and MEET each payload is such a line about another node, the
+// gossip, for FAIL it is the id of the failing node.
+type message struct {
+	kind         string
+	currentEpoch int64
+	offset       int64
+	sender       nodeLine
+	gossip       []nodeLine
+	failing      string
+}
+
+// newMessage builds a message from this node to receiver, mu has to be
+// held.
+func newMessage(kind string, receiver *Node) []string {
+	ranges := slotRanges()
+
+	//a replica announces its master's config epoch, which is what its
+	//slots are checked against during an election
+	header := *myself
+	header.configEpoch = myEpoch()
+
+	args := []string{kind, strconv.FormatInt(currentEpoch, 10), strconv.FormatInt(announcedOffset(), 10),
+		describeNode(&header, ranges[myself])}
+
+	if kind == msgPing || kind == msgPong || kind == msgMeet {
+		for _, node := range nodes {
+			if node != myself && node != receiver && !node.handshake {
+				args = append(args, describeNode(node, nil))
+			}
+		}
+	}
+
+	return args
+}
+
+func parseMessage(command *protocol.Command) (*message, error) {
+	if len(command.Args) < 3 {
+		return nil, errBadMessage
+	}
+
+	msg := &message{kind: command.Name}
+
+	var err1, err2 error
+	msg.currentEpoch, err1 = strconv.ParseInt(command.Args[0], 10, 64)
+	msg.offset, err2 = strconv.ParseInt(command.Args[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, errBadMessage
+	}
+
+	sender, err := parseNodeLine(strings.Fields(command.Args[2]))
+	if err != nil {
+		return nil, err
+	}
+
+	sender.node.myself = false
+	msg.sender = sender
+
+	if msg.kind == msgFail {
+		if len(command.Args) != 4 {
+			return nil, errBadMessage
+		}
+
+		msg.failing = command.Args[3]
+		return msg, nil
+	}
+
+	for _, arg := range command.Args[3:] {
+		line, err := parseNodeLine(strings.Fields(arg))
+		if err != nil {
+			return nil, err
+		}
+
+		line.node.myself = false
+		msg.gossip = append(msg.gossip, line)
+	}
+
+	return msg, nil
+}
+
+// claimedSlots returns the slots the sender of a message serves.
+func (msg *message) claimedSlots() ([]int, error) {
+	claimed := []int{}
+	for _, arg := range msg.sender.slots {
+		start, end, err := parseSlotRange(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		for slot := start; slot <= end; slot++ {
+			claimed = append(claimed, slot)
+		}
+	}
+
+	return claimed, nil
+}
+
+// link is the connection to another node's bus. It connects on demand and
+// is shared by pings, failure reports and elections, which take turns.
+type link struct {
+	mu     sync.Mutex
+	addr   string
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// do sends a message to addr and reads the reply. A broken connection is
+// closed and reopened by the next request, as is one to an address the
+// node no longer has.
+func (l *link) do(addr string, args []string) (*message, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil && l.addr != addr {
+		l.close()
+	}
+
+	if l.conn == nil {
+		conn, err := net.DialTimeout("tcp", addr, requestTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		l.addr = addr
+		l.conn = conn
+		l.reader = bufio.NewReader(conn)
+	}
+
+	l.conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if _, err := l.conn.Write(protocol.FormatBulkStringArray(args)); err != nil {
+		l.close()
+		return nil, err
+	}
+
+	reply, err := protocol.ParseCommand(l.reader)
+	if err != nil {
+		l.close()
+		return nil, err
+	}
+
+	return parseMessage(reply)
+}
+
+func (l *link) close() {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+}
+
+// serveBus accepts the connections of other nodes.
+func serveBus(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Println("Error accepting cluster bus connection: ", err.Error())
+			continue
+		}
+
+		go handleBusConnection(conn)
+	}
+}
+
+func handleBusConnection(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		command, err := protocol.ParseCommand(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				fmt.Println("Error reading from cluster bus: ", err.Error())
+			}
+
+			return
+		}
+
+		msg, err := parseMessage(command)
+		if err != nil {
+			fmt.Println("Error parsing cluster bus message: ", err.Error())
+			return
+		}
+
+		if _, err := conn.Write(protocol.FormatBulkStringArray(receive(msg, conn))); err != nil {
+			return
+		}
+	}
+}
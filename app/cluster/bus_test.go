@@ -0,0 +1,58 @@
+package cluster
+
+import (
+	"redis-clone-go/app/protocol"
+	"slices"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	command := &protocol.Command{Name: msgPing, Args: []string{
+		"7", "300",
+		"aaaa 127.0.0.1:7000@17000 myself,master - 0 0 5 connected 0-2 10",
+		"bbbb 127.0.0.1:7001@17001 slave aaaa 0 0 5 connected",
+		"cccc 127.0.0.1:7002@17002 master,fail? - 0 0 3 disconnected",
+	}}
+
+	msg, err := parseMessage(command)
+	if err != nil {
+		t.Fatalf("parseMessage() error = %v", err)
+	}
+
+	if msg.kind != msgPing || msg.currentEpoch != 7 || msg.offset != 300 {
+		t.Errorf("parseMessage() = %+v", msg)
+	}
+
+	sender := msg.sender.node
+	if sender.id != "aaaa" || sender.port != 7000 || sender.busPort != 17000 || sender.configEpoch != 5 || sender.myself {
+		t.Errorf("sender = %+v", sender)
+	}
+
+	claimed, err := msg.claimedSlots()
+	if err != nil || !slices.Equal(claimed, []int{0, 1, 2, 10}) {
+		t.Errorf("claimedSlots() = %v, %v, want [0 1 2 10]", claimed, err)
+	}
+
+	if len(msg.gossip) != 2 {
+		t.Fatalf("gossip has %d nodes, want 2", len(msg.gossip))
+	}
+
+	if msg.gossip[0].master != "aaaa" || !msg.gossip[1].node.pfail || msg.gossip[1].node.fail {
+		t.Errorf("gossip = %+v, %+v", msg.gossip[0], msg.gossip[1].node)
+	}
+}
+
+func TestParseMessageMalformed(t *testing.T) {
+	tests := []*protocol.Command{
+		{Name: msgPing, Args: []string{"7"}},
+		{Name: msgPing, Args: []string{"x", "0", "aaaa 127.0.0.1:7000@17000 master - 0 0 5 connected"}},
+		{Name: msgPing, Args: []string{"7", "0", "aaaa 127.0.0.1:7000 master - 0 0 5 connected"}},
+		{Name: msgFail, Args: []string{"7", "0", "aaaa 127.0.0.1:7000@17000 master - 0 0 5 connected"}},
+	}
+
+	for _, command := range tests {
+		if _, err := parseMessage(command); err == nil {
+			t.Errorf("parseMessage(%q) succeeded", command.Args)
+		}
+	}
+}
@@ -0,0 +1,759 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"redis-clone-go/app/persistence"
+	"redis-clone-go/app/replication"
+	"redis-clone-go/app/store"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errCrossSlot   = errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+	errDown        = errors.New("CLUSTERDOWN The cluster is down")
+	errNotServed   = errors.New("CLUSTERDOWN Hash slot not served")
+	errTryAgain    = errors.New("TRYAGAIN Multiple keys request during rehashing of slot")
+	errInvalidSlot = errors.New("ERR Invalid or out of range slot")
+)
+
+// Node is a member of the cluster as this node knows it.
+type Node struct {
+	id      string
+	ip      string
+	port    int
+	busPort int
+	myself  bool
+	// replicaOf is the id of the master of a replica, empty for masters
+	replicaOf   string
+	configEpoch int64
+
+	// handshake is set on a node met by its address until it replies and
+	// tells its id, meet while MEET is sent to it instead of PING
+	handshake bool
+	meet      bool
+	createdAt time.Time
+
+	// pfail is set when the node didn't reply within the node timeout, fail
+	// once a majority of masters agrees. failReports are the masters that
+	// reported it as failing by gossip.
+	pfail       bool
+	fail        bool
+	failTime    time.Time
+	failReports map[string]time.Time
+
+	link      *link
+	connected bool
+	pinging   bool
+	// lastPing is when the last PING was sent, pingSent when the oldest one
+	// still waiting for a reply was
+	lastPing     time.Time
+	pingSent     time.Time
+	pongReceived time.Time
+	// offset is the replication offset the node announced
+	offset int64
+	// votedAt is when this node last voted for a replica of this master
+	votedAt time.Time
+}
+
+func newNode(id, ip string, port, busPort int) *Node {
+	return &Node{
+		id:          id,
+		ip:          ip,
+		port:        port,
+		busPort:     busPort,
+		createdAt:   time.Now(),
+		failReports: map[string]time.Time{},
+		link:        &link{},
+	}
+}
+
+func (n *Node) busAddr() string {
+	return net.JoinHostPort(n.ip, strconv.Itoa(n.busPort))
+}
+
+func (n *Node) addr() string {
+	return net.JoinHostPort(n.ip, strconv.Itoa(n.port))
+}
+
+func (n *Node) isReplica() bool {
+	return n.replicaOf != ""
+}
+
+// hasSlots reports whether the node serves any slot, mu has to be held.
+func (n *Node) hasSlots() bool {
+	return slices.Contains(slots[:], n)
+}
+
+var (
+	// mu protects the cluster state, commands only read it
+	mu sync.RWMutex
+
+	enabled    bool
+	configFile = "nodes.conf"
+
+	myself *Node
+	nodes  = map[string]*Node{}
+	// slots maps each slot to the master serving it
+	slots [SlotCount]*Node
+	// a slot this node is migrating to another node or importing from
+	// one, keys are redirected with ASK meanwhile
+	migrating = map[int]*Node{}
+	importing = map[int]*Node{}
+
+	// stateOk is set while every slot is served, otherwise the cluster is
+	// down
+	stateOk bool
+
+	currentEpoch  int64
+	lastVoteEpoch int64
+
+	// nodeTimeout is how long a node may not reply until it is considered
+	// failing
+	nodeTimeout = 15 * time.Second
+)
+
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return enabled
+}
+
+func ClusterEnabled() string {
+	if Enabled() {
+		return "yes"
+	}
+
+	return "no"
+}
+
+func SetClusterEnabled(value string) error {
+	if value != "yes" && value != "no" {
+		return errors.New("argument must be 'yes' or 'no'")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	enabled = value == "yes"
+	return nil
+}
+
+func ConfigFile() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return configFile
+}
+
+func SetConfigFile(value string) error {
+	if value == "" {
+		return errors.New("cluster-config-file can't be empty")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	configFile = value
+	return nil
+}
+
+func NodeTimeout() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return strconv.FormatInt(nodeTimeout.Milliseconds(), 10)
+}
+
+func SetNodeTimeout(value string) error {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || ms <= 0 {
+		return errors.New("argument must be a positive number of milliseconds")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	nodeTimeout = time.Duration(ms) * time.Millisecond
+	return nil
+}
+
+// Start loads the cluster configuration from the nodes file, or creates a
+// new node with a random id. port is the port this node serves clients on.
+func Start(port string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return err
+	}
+
+	if err := loadConfig(nodesPath()); err != nil {
+		return err
+	}
+
+	if myself == nil {
+		myself = newNode(newNodeId(), "127.0.0.1", 0, 0)
+		myself.myself = true
+		nodes[myself.id] = myself
+		fmt.Printf("No cluster configuration found, I'm %v\n", myself.id)
+	} else {
+		fmt.Printf("Node configuration loaded, I'm %v\n", myself.id)
+	}
+
+	myself.port = p
+	myself.busPort = p + 10000
+
+	//a replica resumes replicating from its master, the link is
+	//established once the dataset is loaded
+	if master, ok := nodes[myself.replicaOf]; ok {
+		replication.SetReplicaOf(master.ip + " " + strconv.Itoa(master.port))
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("0.0.0.0", strconv.Itoa(myself.busPort)))
+	if err != nil {
+		return err
+	}
+
+	go serveBus(listener)
+	go cron()
+
+	updateState()
+	return saveConfig()
+}
+
+func nodesPath() string {
+	if filepath.IsAbs(configFile) {
+		return configFile
+	}
+
+	return filepath.Join(persistence.Dir(), configFile)
+}
+
+func newNodeId() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Route checks that this node serves the keys of a command. Otherwise the
+// client is redirected with MOVED, or with ASK while the slot is being
+// migrated. asking is set after the client sent ASKING.
+func Route(keys []string, asking bool) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	slot := KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if KeySlot(key) != slot {
+			return errCrossSlot
+		}
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	owner := slots[slot]
+	if owner == nil {
+		return errNotServed
+	}
+
+	if !stateOk {
+		return errDown
+	}
+
+	if owner == myself {
+		//keys that were already moved are asked for at the target
+		if target, ok := migrating[slot]; ok && missingKeys(keys) > 0 {
+			return askError(slot, target)
+		}
+
+		return nil
+	}
+
+	if _, ok := importing[slot]; ok && asking {
+		if len(keys) > 1 && missingKeys(keys) > 0 {
+			return errTryAgain
+		}
+
+		return nil
+	}
+
+	return movedError(slot, owner)
+}
+
+// CheckLocal checks that keys accessed by a script are served by this
+// node.
+func CheckLocal(keys []string) error {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, key := range keys {
+		slot := KeySlot(key)
+		if slots[slot] != myself {
+			if _, ok := importing[slot]; !ok {
+				return errors.New("ERR Script attempted to access a non local key in a cluster node script")
+			}
+		}
+	}
+
+	return nil
+}
+
+func movedError(slot int, node *Node) error {
+	return fmt.Errorf("MOVED %d %v", slot, node.addr())
+}
+
+func askError(slot int, node *Node) error {
+	return fmt.Errorf("ASK %d %v", slot, node.addr())
+}
+
+func missingKeys(keys []string) int {
+	missing := 0
+	for _, key := range keys {
+		if storedValue, ok := store.CM.Get(key); !ok || storedValue.IsExpired() {
+			missing++
+		}
+	}
+
+	return missing
+}
+
+// updateState recomputes stateOk after the slots or the failing nodes
+// changed, mu has to be held.
+func updateState() {
+	ok := true
+	for _, node := range slots {
+		if node == nil || node.fail {
+			ok = false
+			break
+		}
+	}
+
+	if ok != stateOk {
+		state := "ok"
+		if !ok {
+			state = "fail"
+		}
+
+		fmt.Printf("Cluster state changed: %v\n", state)
+	}
+
+	stateOk = ok
+}
+
+// ParseSlot parses a slot number.
+func ParseSlot(arg string) (int, error) {
+	slot, err := strconv.Atoi(arg)
+	if err != nil || slot < 0 || slot >= SlotCount {
+		return 0, errInvalidSlot
+	}
+
+	return slot, nil
+}
+
+// AddSlots assigns unassigned slots to this node.
+func AddSlots(list []int) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	seen := map[int]bool{}
+	for _, slot := range list {
+		if slots[slot] != nil {
+			return fmt.Errorf("ERR Slot %d is already busy", slot)
+		}
+
+		if seen[slot] {
+			return fmt.Errorf("ERR Slot %d specified multiple times", slot)
+		}
+
+		seen[slot] = true
+	}
+
+	for _, slot := range list {
+		slots[slot] = myself
+		delete(importing, slot)
+	}
+
+	updateState()
+	return saveConfig()
+}
+
+// DelSlots makes slots unassigned, whoever served them.
+func DelSlots(list []int) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	seen := map[int]bool{}
+	for _, slot := range list {
+		if slots[slot] == nil {
+			return fmt.Errorf("ERR Slot %d is already unassigned", slot)
+		}
+
+		if seen[slot] {
+			return fmt.Errorf("ERR Slot %d specified multiple times", slot)
+		}
+
+		seen[slot] = true
+	}
+
+	for _, slot := range list {
+		slots[slot] = nil
+		delete(migrating, slot)
+		delete(importing, slot)
+	}
+
+	updateState()
+	return saveConfig()
+}
+
+// FlushSlots makes all slots of this node unassigned, the node must not
+// hold any keys.
+func FlushSlots() error {
+	if len(store.CM.Keys()) > 0 {
+		return errors.New("ERR DB must be empty to perform CLUSTER FLUSHSLOTS.")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for slot, node := range slots {
+		if node == myself {
+			slots[slot] = nil
+		}
+	}
+
+	updateState()
+	return saveConfig()
+}
+
+// SetSlotNode assigns a slot to a node, which ends its migration.
+func SetSlotNode(slot int, id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	node, ok := nodes[id]
+	if !ok {
+		return fmt.Errorf("ERR I don't know about node %v", id)
+	}
+
+	if node.isReplica() {
+		return errors.New("ERR Can't assign hashslot to a replica node")
+	}
+
+	//a node giving away a slot it still has keys in would lose them
+	if slots[slot] == myself && node != myself && CountKeysInSlot(slot) > 0 {
+		return fmt.Errorf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot)
+	}
+
+	if node == myself {
+		if _, ok := importing[slot]; ok {
+			//the import is complete, the configuration needs a new epoch
+			//so it wins over the old owner's
+			currentEpoch++
+			myself.configEpoch = currentEpoch
+			delete(importing, slot)
+		}
+	}
+
+	delete(migrating, slot)
+	slots[slot] = node
+	updateState()
+	return saveConfig()
+}
+
+// SetSlotMigrating starts migrating a slot of this node to another master.
+// Keys that were already moved are redirected to it with ASK.
+func SetSlotMigrating(slot int, id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if slots[slot] != myself {
+		return fmt.Errorf("ERR I'm not the owner of hash slot %d", slot)
+	}
+
+	node, err := slotTarget(id)
+	if err != nil {
+		return err
+	}
+
+	migrating[slot] = node
+	return saveConfig()
+}
+
+// SetSlotImporting starts importing a slot from the master serving it,
+// clients sending ASKING may then access it here.
+func SetSlotImporting(slot int, id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if slots[slot] == myself {
+		return fmt.Errorf("ERR I'm already the owner of hash slot %d", slot)
+	}
+
+	node, err := slotTarget(id)
+	if err != nil {
+		return err
+	}
+
+	importing[slot] = node
+	return saveConfig()
+}
+
+// slotTarget looks up the other end of a migration, mu has to be held.
+func slotTarget(id string) (*Node, error) {
+	if myself.isReplica() {
+		return nil, errors.New("ERR Please use SETSLOT only with masters.")
+	}
+
+	node, ok := nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("ERR I don't know about node %v", id)
+	}
+
+	if node.isReplica() {
+		return nil, errors.New("ERR Target node is not a master")
+	}
+
+	return node, nil
+}
+
+// SetSlotStable cancels a migration or an import of slot.
+func SetSlotStable(slot int) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(migrating, slot)
+	delete(importing, slot)
+	return saveConfig()
+}
+
+// SaveConfig writes the nodes file, for CLUSTER SAVECONFIG.
+func SaveConfig() error {
+	mu.Lock()
+	defer mu.Unlock()
+	return saveConfig()
+}
+
+func MyId() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return myself.id
+}
+
+// CountKeysInSlot counts the keys of this node in slot.
+func CountKeysInSlot(slot int) int {
+	return len(keysInSlot(slot, -1))
+}
+
+// GetKeysInSlot returns up to count keys of this node in slot.
+func GetKeysInSlot(slot, count int) []string {
+	return keysInSlot(slot, count)
+}
+
+// keysInSlot scans the keyspace, there is no index of keys by slot.
+func keysInSlot(slot, count int) []string {
+	keys := store.CM.Keys()
+	slices.Sort(keys)
+
+	result := []string{}
+	for _, key := range keys {
+		if count >= 0 && len(result) >= count {
+			break
+		}
+
+		if KeySlot(key) != slot {
+			continue
+		}
+
+		if storedValue, ok := store.CM.Get(key); ok && !storedValue.IsExpired() {
+			result = append(result, key)
+		}
+	}
+
+	return result
+}
+
+// Info returns the CLUSTER INFO fields.
+func Info() string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	assigned, pfail, fail := 0, 0, 0
+	sizes := map[*Node]bool{}
+	for _, node := range slots {
+		if node == nil {
+			continue
+		}
+
+		assigned++
+		sizes[node] = true
+
+		switch {
+		case node.fail:
+			fail++
+		case node.pfail:
+			pfail++
+		}
+	}
+
+	state := "ok"
+	if !stateOk {
+		state = "fail"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "cluster_enabled:1\r\n")
+	fmt.Fprintf(&sb, "cluster_state:%v\r\n", state)
+	fmt.Fprintf(&sb, "cluster_slots_assigned:%d\r\n", assigned)
+	fmt.Fprintf(&sb, "cluster_slots_ok:%d\r\n", assigned-pfail-fail)
+	fmt.Fprintf(&sb, "cluster_slots_pfail:%d\r\n", pfail)
+	fmt.Fprintf(&sb, "cluster_slots_fail:%d\r\n", fail)
+	fmt.Fprintf(&sb, "cluster_known_nodes:%d\r\n", len(nodes))
+	fmt.Fprintf(&sb, "cluster_size:%d\r\n", len(sizes))
+	fmt.Fprintf(&sb, "cluster_current_epoch:%d\r\n", currentEpoch)
+	fmt.Fprintf(&sb, "cluster_my_epoch:%d\r\n", myEpoch())
+	return sb.String()
+}
+
+// myEpoch is the config epoch of this node, or of its master for a
+// replica. mu has to be held.
+func myEpoch() int64 {
+	if master, ok := nodes[myself.replicaOf]; ok {
+		return master.configEpoch
+	}
+
+	return myself.configEpoch
+}
+
+// Nodes returns the CLUSTER NODES description, one node per line.
+func Nodes() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return describeNodes()
+}
+
+// SlotRange is a range of consecutive slots, both ends included.
+type SlotRange struct {
+	Start, End int
+}
+
+// NodeInfo describes a node for CLUSTER SLOTS and CLUSTER SHARDS.
+type NodeInfo struct {
+	Id     string
+	Ip     string
+	Port   int
+	Role   string
+	Offset int64
+	Health string
+}
+
+// Shard is a master, its replicas and the slots they serve.
+type Shard struct {
+	Slots []SlotRange
+	Nodes []NodeInfo
+}
+
+// Shards returns the shards of the cluster ordered by their first slot,
+// masters without slots come last. offset is this node's replication
+// offset, the others' are the ones they announced.
+func Shards(offset int64) []Shard {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	ranges := slotRanges()
+
+	masters := []*Node{}
+	for _, node := range nodes {
+		if !node.isReplica() {
+			masters = append(masters, node)
+		}
+	}
+
+	first := func(node *Node) int {
+		if r := ranges[node]; len(r) > 0 {
+			return r[0].Start
+		}
+
+		return SlotCount
+	}
+
+	slices.SortFunc(masters, func(a, b *Node) int {
+		if c := first(a) - first(b); c != 0 {
+			return c
+		}
+
+		return strings.Compare(a.id, b.id)
+	})
+
+	info := func(node *Node) NodeInfo {
+		role := "master"
+		if node.isReplica() {
+			role = "replica"
+		}
+
+		nodeOffset := node.offset
+		if node == myself {
+			nodeOffset = offset
+		}
+
+		health := "online"
+		if node.fail || node.pfail {
+			health = "fail"
+		}
+
+		return NodeInfo{node.id, node.ip, node.port, role, nodeOffset, health}
+	}
+
+	shards := []Shard{}
+	for _, master := range masters {
+		shard := Shard{Slots: ranges[master], Nodes: []NodeInfo{info(master)}}
+		for _, replica := range replicasOf(master) {
+			shard.Nodes = append(shard.Nodes, info(replica))
+		}
+
+		shards = append(shards, shard)
+	}
+
+	return shards
+}
+
+// slotRanges groups the slots of each master into ranges. mu has to be
+// held.
+func slotRanges() map[*Node][]SlotRange {
+	ranges := map[*Node][]SlotRange{}
+	for slot := 0; slot < SlotCount; {
+		node := slots[slot]
+		end := slot
+		for end+1 < SlotCount && slots[end+1] == node {
+			end++
+		}
+
+		if node != nil {
+			ranges[node] = append(ranges[node], SlotRange{slot, end})
+		}
+
+		slot = end + 1
+	}
+
+	return ranges
+}
+
+// replicasOf returns the replicas of a master ordered by id, mu has to be
+// held.
+func replicasOf(master *Node) []*Node {
+	replicas := []*Node{}
+	for _, node := range nodes {
+		if node.replicaOf == master.id {
+			replicas = append(replicas, node)
+		}
+	}
+
+	slices.SortFunc(replicas, func(a, b *Node) int { return strings.Compare(a.id, b.id) })
+	return replicas
+}
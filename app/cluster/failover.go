@@ -0,0 +1,142 @@
+package cluster
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"redis-clone-go/app/replication"
+	"time"
+)
+
+// The election of a replica of a failed master. The replica waits a
+// little, longer the further behind its master it is than its siblings,
+// then asks the masters for their votes in a new epoch. A majority of the
+// masters serving slots makes it the new master.
+var (
+	// authTime is when the election starts, zero while there is none
+	authTime  time.Time
+	authSent  bool
+	authEpoch int64
+	authCount int
+)
+
+// handleReplicaFailover runs an election while the master of this replica
+// is failing, mu has to be held.
+func handleReplicaFailover(now time.Time) {
+	master := nodes[myself.replicaOf]
+	if master == nil || !master.fail || !master.hasSlots() {
+		resetElection()
+		return
+	}
+
+	//a vote is only valid for so long, after twice that the election is
+	//retried in a new epoch
+	authTimeout := max(2*nodeTimeout, 2*time.Second)
+
+	if authTime.IsZero() || now.Sub(authTime) > 2*authTimeout {
+		delay := 500*time.Millisecond + rand.N(500*time.Millisecond) + time.Duration(replicaRank())*time.Second
+		authTime = now.Add(delay)
+		authSent = false
+		authCount = 0
+		fmt.Printf("Start of election delayed for %v (rank #%d, offset %d)\n", delay, replicaRank(), announcedOffset())
+		return
+	}
+
+	if now.Before(authTime) || now.Sub(authTime) > authTimeout {
+		return
+	}
+
+	if !authSent {
+		currentEpoch++
+		authEpoch = currentEpoch
+		authSent = true
+		saveConfig()
+		fmt.Printf("Starting a failover election for epoch %d\n", authEpoch)
+
+		for _, node := range nodes {
+			if node != myself && !node.handshake && !node.isReplica() && node.hasSlots() {
+				send(node, newMessage(msgAuthRequest, node))
+			}
+		}
+
+		return
+	}
+
+	if authCount >= quorum() {
+		fmt.Printf("Failover election won, I'm the new master\n")
+		promote(master)
+	}
+}
+
+func resetElection() {
+	authTime = time.Time{}
+	authSent = false
+	authCount = 0
+}
+
+// replicaRank is the number of replicas of the same master that are
+// further ahead than this one, mu has to be held.
+func replicaRank() int {
+	offset := announcedOffset()
+
+	rank := 0
+	for _, node := range nodes {
+		if node != myself && node.replicaOf == myself.replicaOf && node.offset > offset {
+			rank++
+		}
+	}
+
+	return rank
+}
+
+// promote makes this replica the master of the failed master's slots, in
+// the epoch it was elected in. The other nodes learn about it from the
+// next pings. mu has to be held.
+func promote(old *Node) {
+	for slot, node := range slots {
+		if node == old {
+			slots[slot] = myself
+		}
+	}
+
+	myself.replicaOf = ""
+	myself.configEpoch = max(myself.configEpoch, authEpoch)
+	resetElection()
+	replication.SetReplicaOf("no one")
+
+	//let everyone know right away
+	for _, node := range nodes {
+		node.lastPing = time.Time{}
+	}
+
+	updateState()
+	saveConfig()
+}
+
+// vote grants a replica the vote of this master if its master is failing
+// and this master didn't vote in this epoch or for another replica of the
+// same master lately. mu has to be held, the sender's header was
+// processed.
+func vote(sender *Node, msg *message) bool {
+	if myself.isReplica() || !myself.hasSlots() {
+		return false
+	}
+
+	if msg.currentEpoch < currentEpoch || lastVoteEpoch == currentEpoch {
+		return false
+	}
+
+	master, ok := nodes[sender.replicaOf]
+	if !sender.isReplica() || !ok || !master.fail {
+		return false
+	}
+
+	if time.Since(master.votedAt) < 2*nodeTimeout {
+		return false
+	}
+
+	lastVoteEpoch = currentEpoch
+	master.votedAt = time.Now()
+	saveConfig()
+	fmt.Printf("Failover auth granted to %v for epoch %d\n", sender.id, currentEpoch)
+	return true
+}
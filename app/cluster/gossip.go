@@ -0,0 +1,502 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"redis-clone-go/app/replication"
+	"redis-clone-go/app/store"
+	"strconv"
+	"time"
+)
+
+const tickPeriod = 100 * time.Millisecond
+
+// cron pings the other nodes, detects failures and runs the elections of
+// this node while it is a replica of a failed master.
+func cron() {
+	for range time.Tick(tickPeriod) {
+		tick()
+	}
+}
+
+func tick() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	for id, node := range nodes {
+		if node == myself {
+			continue
+		}
+
+		//a node that doesn't reply to MEET is given up on
+		if node.handshake && now.Sub(node.createdAt) > max(nodeTimeout, time.Second) {
+			fmt.Printf("Handshake with %v timed out\n", node.busAddr())
+			delete(nodes, id)
+			node.link.close()
+			continue
+		}
+
+		if !node.pingSent.IsZero() && now.Sub(node.pingSent) > nodeTimeout && !node.pfail && !node.fail {
+			fmt.Printf("*** NODE %v possibly failing\n", node.id)
+			node.pfail = true
+		}
+
+		markFailing(node)
+
+		if !node.pinging && now.Sub(node.lastPing) >= pingPeriod() {
+			kind := msgPing
+			if node.meet {
+				kind = msgMeet
+			}
+
+			send(node, newMessage(kind, node))
+		}
+	}
+
+	if myself.isReplica() {
+		handleReplicaFailover(now)
+	}
+
+	updateState()
+}
+
+// pingPeriod is how often each node is pinged, at least twice within the
+// node timeout. mu has to be held.
+func pingPeriod() time.Duration {
+	return min(time.Second, nodeTimeout/2)
+}
+
+// send sends a message to a node in the background and processes its
+// reply. mu has to be held.
+func send(node *Node, args []string) {
+	if args[0] == msgPing || args[0] == msgMeet {
+		node.pinging = true
+		node.lastPing = time.Now()
+		if node.pingSent.IsZero() {
+			node.pingSent = node.lastPing
+		}
+	}
+
+	addr := node.busAddr()
+	go func() {
+		reply, err := node.link.do(addr, args)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if args[0] == msgPing || args[0] == msgMeet {
+			node.pinging = false
+		}
+
+		node.connected = err == nil
+		if err != nil {
+			return
+		}
+
+		processReply(node, reply)
+	}()
+}
+
+// broadcast sends a message to every node but this one, mu has to be held.
+func broadcast(kind string, payload ...string) {
+	for _, node := range nodes {
+		if node != myself && !node.handshake {
+			send(node, append(newMessage(kind, node), payload...))
+		}
+	}
+}
+
+// processReply handles the reply of a node, mu has to be held.
+func processReply(node *Node, reply *message) {
+	//the node was forgotten meanwhile
+	if nodes[node.id] != node {
+		return
+	}
+
+	if node.handshake {
+		completeHandshake(node, reply.sender.node.id)
+		if nodes[reply.sender.node.id] != node {
+			return
+		}
+	} else if reply.sender.node.id != node.id {
+		fmt.Printf("Node %v now answers as %v, ignoring it\n", node.id, reply.sender.node.id)
+		return
+	}
+
+	node.pingSent = time.Time{}
+	node.pongReceived = time.Now()
+	clearFailure(node)
+
+	processHeader(node, reply)
+
+	if reply.kind == msgAuthAck && reply.currentEpoch >= authEpoch && !node.isReplica() && node.hasSlots() {
+		authCount++
+	}
+}
+
+// completeHandshake names a node met by its address after its id, unless
+// the node is known already. mu has to be held.
+func completeHandshake(node *Node, id string) {
+	delete(nodes, node.id)
+
+	if _, ok := nodes[id]; ok || id == myself.id {
+		node.link.close()
+		return
+	}
+
+	fmt.Printf("Handshake with %v completed, it is %v\n", node.busAddr(), id)
+	node.id = id
+	node.handshake = false
+	node.meet = false
+	nodes[id] = node
+	saveConfig()
+}
+
+// receive handles a message from another node and returns the reply.
+func receive(msg *message, conn net.Conn) []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	sender := nodes[msg.sender.node.id]
+
+	//nodes join by MEET only, they are reached at the address they
+	//connected from. This node learns its own address the same way.
+	if sender == nil && msg.kind == msgMeet {
+		ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		header := msg.sender.node
+		sender = newNode(header.id, ip, header.port, header.busPort)
+		nodes[sender.id] = sender
+		fmt.Printf("Node %v joined by MEET from %v\n", sender.id, sender.busAddr())
+
+		if localIp, _, err := net.SplitHostPort(conn.LocalAddr().String()); err == nil && localIp != myself.ip {
+			fmt.Printf("Learned my address %v\n", localIp)
+			myself.ip = localIp
+		}
+
+		saveConfig()
+	}
+
+	if sender == nil || sender == myself {
+		return newMessage(msgPong, nil)
+	}
+
+	processHeader(sender, msg)
+
+	switch msg.kind {
+	case msgFail:
+		if node, ok := nodes[msg.failing]; ok && node != myself && !node.fail {
+			fmt.Printf("FAIL message received from %v about %v\n", sender.id, node.id)
+			setFailing(node)
+		}
+	case msgAuthRequest:
+		if vote(sender, msg) {
+			return newMessage(msgAuthAck, sender)
+		}
+	}
+
+	return newMessage(msgPong, sender)
+}
+
+// processHeader updates what this node knows about the sender of a
+// message and its gossip, mu has to be held.
+func processHeader(sender *Node, msg *message) {
+	header := msg.sender.node
+	dirty := false
+
+	if msg.currentEpoch > currentEpoch {
+		currentEpoch = msg.currentEpoch
+		dirty = true
+	}
+
+	sender.offset = msg.offset
+	if sender.port != header.port || sender.busPort != header.busPort {
+		sender.port, sender.busPort = header.port, header.busPort
+		dirty = true
+	}
+
+	if msg.sender.master != "-" {
+		//a master turned replica loses its slots
+		if !sender.isReplica() {
+			for slot, node := range slots {
+				if node == sender {
+					slots[slot] = nil
+				}
+			}
+		}
+
+		if _, ok := nodes[msg.sender.master]; ok && sender.replicaOf != msg.sender.master {
+			sender.replicaOf = msg.sender.master
+			dirty = true
+		}
+	} else {
+		if sender.isReplica() {
+			sender.replicaOf = ""
+			dirty = true
+		}
+
+		if header.configEpoch > sender.configEpoch {
+			sender.configEpoch = header.configEpoch
+			dirty = true
+		}
+
+		if claimed, err := msg.claimedSlots(); err == nil && updateSlots(sender, claimed) {
+			dirty = true
+		}
+
+		if handleEpochCollision(sender) {
+			dirty = true
+		}
+	}
+
+	if processGossip(sender, msg.gossip) {
+		dirty = true
+	}
+
+	updateState()
+	if dirty {
+		saveConfig()
+	}
+}
+
+// updateSlots gives the slots a master claims to it, unless their owner
+// has a greater config epoch. Slots being imported are left alone. If this
+// node, or its master, lost its last slot, it becomes a replica of the
+// sender. mu has to be held.
+func updateSlots(sender *Node, claimed []int) bool {
+	current := myself
+	if master, ok := nodes[myself.replicaOf]; ok {
+		current = master
+	}
+
+	hadSlots := current.hasSlots()
+	changed, lost := false, false
+
+	for _, slot := range claimed {
+		owner := slots[slot]
+		if owner == sender {
+			continue
+		}
+
+		if _, ok := importing[slot]; ok {
+			continue
+		}
+
+		if owner != nil && owner.configEpoch >= sender.configEpoch {
+			continue
+		}
+
+		slots[slot] = sender
+		delete(migrating, slot)
+		changed = true
+		lost = lost || owner == current
+	}
+
+	if lost && hadSlots && !current.hasSlots() {
+		fmt.Printf("Lost my last slot to %v, following it\n", sender.id)
+		setMaster(sender)
+	}
+
+	return changed
+}
+
+// handleEpochCollision gives this master a new config epoch if another
+// master has the same one. Of the two, the node with the smaller id does,
+// so after a while every master has its own epoch. mu has to be held.
+func handleEpochCollision(sender *Node) bool {
+	if myself.isReplica() || sender.configEpoch != myself.configEpoch || sender.id <= myself.id {
+		return false
+	}
+
+	currentEpoch++
+	myself.configEpoch = currentEpoch
+	fmt.Printf("Config epoch collision with %v, my epoch is now %d\n", sender.id, currentEpoch)
+	return true
+}
+
+// processGossip learns about the nodes the sender knows of and collects
+// its failure reports. mu has to be held.
+func processGossip(sender *Node, gossip []nodeLine) bool {
+	dirty := false
+	for _, line := range gossip {
+		if line.node.id == myself.id {
+			continue
+		}
+
+		node, ok := nodes[line.node.id]
+		if !ok {
+			if line.node.handshake {
+				continue
+			}
+
+			node = newNode(line.node.id, line.node.ip, line.node.port, line.node.busPort)
+			if _, ok := nodes[line.master]; ok {
+				node.replicaOf = line.master
+			}
+
+			nodes[node.id] = node
+			fmt.Printf("Discovered node %v at %v through %v\n", node.id, node.busAddr(), sender.id)
+			dirty = true
+			continue
+		}
+
+		//only masters' opinions count
+		if sender.isReplica() {
+			continue
+		}
+
+		if line.node.pfail || line.node.fail {
+			node.failReports[sender.id] = time.Now()
+			markFailing(node)
+		} else {
+			delete(node.failReports, sender.id)
+		}
+	}
+
+	return dirty
+}
+
+// markFailing turns a possible failure into a failure once a majority of
+// the masters serving slots reported it, and tells every node. mu has to
+// be held.
+func markFailing(node *Node) {
+	if !node.pfail || node.fail {
+		return
+	}
+
+	//old reports are no longer valid
+	for id, reported := range node.failReports {
+		if time.Since(reported) > 2*nodeTimeout {
+			delete(node.failReports, id)
+		}
+	}
+
+	reports := len(node.failReports)
+	if !myself.isReplica() {
+		reports++
+	}
+
+	if reports < quorum() {
+		return
+	}
+
+	fmt.Printf("Marking node %v as failing (quorum reached)\n", node.id)
+	setFailing(node)
+	broadcast(msgFail, node.id)
+}
+
+func setFailing(node *Node) {
+	node.fail = true
+	node.pfail = false
+	node.failTime = time.Now()
+	updateState()
+	saveConfig()
+}
+
+// clearFailure clears the failure of a node that replied again. A master
+// serving slots stays failed for a while, its replicas may be promoted
+// meanwhile. mu has to be held.
+func clearFailure(node *Node) {
+	node.pfail = false
+	if !node.fail {
+		return
+	}
+
+	if node.isReplica() || !node.hasSlots() || time.Since(node.failTime) > 2*nodeTimeout {
+		fmt.Printf("Clear FAIL state for node %v\n", node.id)
+		node.fail = false
+		clear(node.failReports)
+		updateState()
+		saveConfig()
+	}
+}
+
+// quorum is the majority of the masters serving slots, mu has to be held.
+func quorum() int {
+	masters := map[*Node]bool{}
+	for _, node := range slots {
+		if node != nil {
+			masters[node] = true
+		}
+	}
+
+	return len(masters)/2 + 1
+}
+
+// Meet starts a handshake with the node at ip and port, its bus port is
+// busPort or port+10000 if zero. The node joins the cluster once it
+// replies.
+func Meet(ip string, port, busPort int) error {
+	if net.ParseIP(ip) == nil || port < 1 || port > 65535 || busPort < 0 || busPort > 65535 {
+		return fmt.Errorf("ERR Invalid node address specified: %v", net.JoinHostPort(ip, strconv.Itoa(port)))
+	}
+
+	if busPort == 0 {
+		busPort = port + 10000
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, node := range nodes {
+		if node.handshake && node.ip == ip && node.port == port {
+			return nil
+		}
+	}
+
+	node := newNode(newNodeId(), ip, port, busPort)
+	node.handshake = true
+	node.meet = true
+	nodes[node.id] = node
+	return nil
+}
+
+// Replicate makes this node a replica of a master, it must not serve any
+// slots or hold keys while it is a master itself.
+func Replicate(id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	node, ok := nodes[id]
+	if !ok {
+		return fmt.Errorf("ERR Unknown node %v", id)
+	}
+
+	if node == myself {
+		return errors.New("ERR Can't replicate myself")
+	}
+
+	if node.isReplica() {
+		return errors.New("ERR I can only replicate a master, not a replica.")
+	}
+
+	if !myself.isReplica() && (myself.hasSlots() || len(store.CM.Keys()) > 0) {
+		return errors.New("ERR To set a master the node must be empty and without assigned slots.")
+	}
+
+	setMaster(node)
+	updateState()
+	return saveConfig()
+}
+
+// setMaster makes this node a replica of master, mu has to be held.
+func setMaster(master *Node) {
+	for slot, node := range slots {
+		if node == myself {
+			slots[slot] = nil
+		}
+	}
+
+	clear(migrating)
+	clear(importing)
+	resetElection()
+
+	myself.replicaOf = master.id
+	replication.SetReplicaOf(master.ip + " " + strconv.Itoa(master.port))
+}
+
+// announcedOffset is the replication offset other nodes rank replicas by.
+func announcedOffset() int64 {
+	return replication.Offset()
+}
@@ -0,0 +1,47 @@
+package cluster
+
+import "strings"
+
+// SlotCount is the number of hash slots the keyspace is divided into.
+const SlotCount = 16384
+
+// crc16Table is CRC16-CCITT (XModem), the checksum Redis hashes keys with.
+var crc16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+
+		table[i] = crc
+	}
+
+	return table
+}()
+
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^data[i]]
+	}
+
+	return crc
+}
+
+// KeySlot returns the hash slot of a key. If the key contains a non-empty
+// hash tag, like "{user1000}.following", only the tag is hashed, so
+// related keys can be put in the same slot.
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key)) & (SlotCount - 1)
+}
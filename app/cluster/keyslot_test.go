@@ -0,0 +1,49 @@
+package cluster
+
+import "testing"
+
+func TestCRC16(t *testing.T) {
+	if got := crc16("123456789"); got != 0x31c3 {
+		t.Errorf("crc16(\"123456789\") = %#x, want 0x31c3", got)
+	}
+}
+
+func TestKeySlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"foo", 12182},
+		{"bar", 5061},
+		{"", 0},
+		{"{user1000}.following", 3443},
+		{"{user1000}.followers", 3443},
+	}
+
+	for _, tt := range tests {
+		if got := KeySlot(tt.key); got != tt.want {
+			t.Errorf("KeySlot(%q) = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestKeySlotHashTags(t *testing.T) {
+	tests := []struct {
+		key    string
+		hashed string
+	}{
+		{"{user1000}.following", "user1000"},
+		{"foo{bar}{zap}", "bar"},
+		{"foo{{bar}}zap", "{bar"},
+		//an empty tag doesn't count, the whole key is hashed
+		{"foo{}{bar}", "foo{}{bar}"},
+		{"{bar", "{bar"},
+		{"bar}", "bar}"},
+	}
+
+	for _, tt := range tests {
+		if got, want := KeySlot(tt.key), int(crc16(tt.hashed))&(SlotCount-1); got != want {
+			t.Errorf("KeySlot(%q) = %d, want the slot of %q, %d", tt.key, got, tt.hashed, want)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package cluster
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errCorruptConfig = errors.New("corrupted cluster config file")
+
+// describeNodes formats the nodes like CLUSTER NODES and the nodes file,
+// one line per node. mu has to be held.
+func describeNodes() string {
+	ranges := slotRanges()
+
+	var sb strings.Builder
+	for _, id := range slices.Sorted(maps.Keys(nodes)) {
+		node := nodes[id]
+		sb.WriteString(describeNode(node, ranges[node]))
+
+		if node.myself {
+			for _, slot := range slices.Sorted(maps.Keys(migrating)) {
+				fmt.Fprintf(&sb, " [%d->-%v]", slot, migrating[slot].id)
+			}
+
+			for _, slot := range slices.Sorted(maps.Keys(importing)) {
+				fmt.Fprintf(&sb, " [%d-<-%v]", slot, importing[slot].id)
+			}
+		}
+
+		sb.WriteByte('\n')
+	}
+
+	return sb.String()
+}
+
+// describeNode formats a node as "<id> <ip:port@cport> <flags> <master>
+// <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...", the
+// cluster bus uses the same format. mu has to be held.
+func describeNode(node *Node, ranges []SlotRange) string {
+	flags := []string{}
+	if node.myself {
+		flags = append(flags, "myself")
+	}
+
+	if node.isReplica() {
+		flags = append(flags, "slave")
+	} else {
+		flags = append(flags, "master")
+	}
+
+	switch {
+	case node.fail:
+		flags = append(flags, "fail")
+	case node.pfail:
+		flags = append(flags, "fail?")
+	}
+
+	if node.handshake {
+		flags = append(flags, "handshake")
+	}
+
+	master := "-"
+	if node.isReplica() {
+		master = node.replicaOf
+	}
+
+	linkState := "disconnected"
+	if node.myself || node.connected {
+		linkState = "connected"
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v %v:%d@%d %v %v %d %d %d %v", node.id, node.ip, node.port, node.busPort,
+		strings.Join(flags, ","), master, unixMilli(node.pingSent), unixMilli(node.pongReceived),
+		node.configEpoch, linkState)
+
+	for _, r := range ranges {
+		if r.Start == r.End {
+			fmt.Fprintf(&sb, " %d", r.Start)
+		} else {
+			fmt.Fprintf(&sb, " %d-%d", r.Start, r.End)
+		}
+	}
+
+	return sb.String()
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixMilli()
+}
+
+// saveConfig replaces the nodes file atomically, mu has to be held.
+func saveConfig() error {
+	content := describeNodes() + fmt.Sprintf("vars currentEpoch %d lastVoteEpoch %d\n", currentEpoch, lastVoteEpoch)
+
+	path := nodesPath()
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-*.conf")
+	if err != nil {
+		return fmt.Errorf("ERR error saving the cluster node config: %w", err)
+	}
+
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("ERR error saving the cluster node config: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("ERR error saving the cluster node config: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("ERR error saving the cluster node config: %w", err)
+	}
+
+	return nil
+}
+
+// loadConfig restores the cluster state from the nodes file, a missing
+// file leaves it empty. mu has to be held.
+func loadConfig(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := parseConfig(file); err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+
+	return nil
+}
+
+// nodeLine is a line of the nodes file, its slots are applied once all
+// nodes are known.
+type nodeLine struct {
+	node   *Node
+	master string
+	slots  []string
+}
+
+func parseConfig(r io.Reader) error {
+	lines := []nodeLine{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "vars" {
+			if err := parseVars(fields[1:]); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		line, err := parseNodeLine(fields)
+		if err != nil {
+			return err
+		}
+
+		//nodes that never completed the handshake are forgotten, failures
+		//are detected again
+		if line.node.handshake {
+			continue
+		}
+
+		line.node.pfail = false
+		if line.node.fail {
+			line.node.failTime = time.Now()
+		}
+
+		lines = append(lines, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		if _, ok := nodes[line.node.id]; ok {
+			return fmt.Errorf("%w: node %v is listed twice", errCorruptConfig, line.node.id)
+		}
+
+		nodes[line.node.id] = line.node
+		if line.node.myself {
+			if myself != nil {
+				return fmt.Errorf("%w: more than one node is myself", errCorruptConfig)
+			}
+
+			myself = line.node
+		}
+	}
+
+	if len(lines) > 0 && myself == nil {
+		return fmt.Errorf("%w: myself is missing", errCorruptConfig)
+	}
+
+	for _, line := range lines {
+		if line.master != "-" {
+			if _, ok := nodes[line.master]; !ok {
+				return fmt.Errorf("%w: unknown master %v", errCorruptConfig, line.master)
+			}
+
+			line.node.replicaOf = line.master
+		}
+
+		for _, arg := range line.slots {
+			if err := parseSlots(line.node, arg); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseVars(fields []string) error {
+	for i := 0; i+1 < len(fields); i += 2 {
+		value, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: invalid %v", errCorruptConfig, fields[i])
+		}
+
+		switch fields[i] {
+		case "currentEpoch":
+			currentEpoch = value
+		case "lastVoteEpoch":
+			lastVoteEpoch = value
+		}
+	}
+
+	return nil
+}
+
+func parseNodeLine(fields []string) (nodeLine, error) {
+	if len(fields) < 8 {
+		return nodeLine{}, fmt.Errorf("%w: %q", errCorruptConfig, strings.Join(fields, " "))
+	}
+
+	node := newNode(fields[0], "", 0, 0)
+
+	//the address is ip:port@cport, optionally followed by ",hostname"
+	addr, _, _ := strings.Cut(fields[1], ",")
+	addr, busPort, ok := strings.Cut(addr, "@")
+	colon := strings.LastIndexByte(addr, ':')
+	if !ok || colon < 0 {
+		return nodeLine{}, fmt.Errorf("%w: invalid address %q", errCorruptConfig, fields[1])
+	}
+
+	var err1, err2, err3 error
+	node.ip = addr[:colon]
+	node.port, err1 = strconv.Atoi(addr[colon+1:])
+	node.busPort, err2 = strconv.Atoi(busPort)
+	node.configEpoch, err3 = strconv.ParseInt(fields[6], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nodeLine{}, fmt.Errorf("%w: %q", errCorruptConfig, strings.Join(fields, " "))
+	}
+
+	for _, flag := range strings.Split(fields[2], ",") {
+		switch flag {
+		case "myself":
+			node.myself = true
+		case "fail":
+			node.fail = true
+		case "fail?":
+			node.pfail = true
+		case "handshake":
+			node.handshake = true
+		}
+	}
+
+	return nodeLine{node: node, master: fields[3], slots: fields[8:]}, nil
+}
+
+// parseSlots applies a slot, a range "start-end" or, for this node, a
+// migration "[slot->-target]" or an import "[slot-<-source]". mu has to be
+// held.
+func parseSlots(node *Node, arg string) error {
+	if inner, ok := strings.CutPrefix(arg, "["); ok {
+		inner = strings.TrimSuffix(inner, "]")
+
+		slotArg, target, migrate := strings.Cut(inner, "->-")
+		source := ""
+		if !migrate {
+			slotArg, source, _ = strings.Cut(inner, "-<-")
+		}
+
+		slot, err := ParseSlot(slotArg)
+		if err != nil {
+			return fmt.Errorf("%w: invalid slot %q", errCorruptConfig, arg)
+		}
+
+		other, ok := nodes[target+source]
+		if !ok {
+			return fmt.Errorf("%w: unknown node in %q", errCorruptConfig, arg)
+		}
+
+		if migrate {
+			migrating[slot] = other
+		} else {
+			importing[slot] = other
+		}
+
+		return nil
+	}
+
+	start, end, err := parseSlotRange(arg)
+	if err != nil {
+		return err
+	}
+
+	for slot := start; slot <= end; slot++ {
+		slots[slot] = node
+	}
+
+	return nil
+}
+
+// parseSlotRange parses a slot or a range "start-end".
+func parseSlotRange(arg string) (int, int, error) {
+	startArg, endArg, isRange := strings.Cut(arg, "-")
+	if !isRange {
+		endArg = startArg
+	}
+
+	start, err1 := ParseSlot(startArg)
+	end, err2 := ParseSlot(endArg)
+	if err1 != nil || err2 != nil || start > end {
+		return 0, 0, fmt.Errorf("%w: invalid slots %q", errCorruptConfig, arg)
+	}
+
+	return start, end, nil
+}
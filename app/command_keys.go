@@ -0,0 +1,162 @@
+package main
+
+import (
+	"redis-clone-go/app/cluster"
+	"redis-clone-go/app/protocol"
+	"strconv"
+	"strings"
+)
+
+// commandKeys extracts the keys from the arguments of a command, cluster
+// mode routes commands by them. Commands without keys aren't listed.
+var commandKeys = map[string]func(args []string) []string{
+	"SET":            firstKey,
+	"GET":            firstKey,
+	"RPUSH":          firstKey,
+	"LRANGE":         firstKey,
+	"LPUSH":          firstKey,
+	"LLEN":           firstKey,
+	"LPOP":           firstKey,
+	"BLPOP":          allButLast,
+	"TYPE":           firstKey,
+	"DEL":            allKeys,
+	"XADD":           firstKey,
+	"XRANGE":         firstKey,
+	"XREAD":          streamKeys,
+	"XSETID":         firstKey,
+	"XTRIM":          firstKey,
+	"XGROUP":         subcommandKey,
+	"XREADGROUP":     streamKeys,
+	"XACK":           firstKey,
+	"XPENDING":       firstKey,
+	"XCLAIM":         firstKey,
+	"XAUTOCLAIM":     firstKey,
+	"XINFO":          subcommandKey,
+	"WATCH":          allKeys,
+	"EVAL":           scriptKeys,
+	"EVALSHA":        scriptKeys,
+	"FCALL":          scriptKeys,
+	"FCALL_RO":       scriptKeys,
+	"SSUBSCRIBE":     allKeys,
+	"SUNSUBSCRIBE":   allKeys,
+	"SPUBLISH":       firstKey,
+	"DUMP":           firstKey,
+	"RESTORE":        firstKey,
+	"RESTORE-ASKING": firstKey,
+	"MIGRATE":        migrateKeys,
+}
+
+func firstKey(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+
+	return args[:1]
+}
+
+func allKeys(args []string) []string {
+	return args
+}
+
+// allButLast is for commands ending with a timeout, like BLPOP.
+func allButLast(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+
+	return args[:len(args)-1]
+}
+
+// subcommandKey is for commands like "XINFO STREAM <key>" and
+// "XGROUP CREATE <key> ...".
+func subcommandKey(args []string) []string {
+	if len(args) < 2 {
+		return nil
+	}
+
+	return args[1:2]
+}
+
+// streamKeys is for "XREAD ... STREAMS <key> ... <id> ..." and
+// XREADGROUP.
+func streamKeys(args []string) []string {
+	for i, arg := range args {
+		if strings.EqualFold(arg, "STREAMS") {
+			streams := args[i+1:]
+			return streams[:len(streams)/2]
+		}
+	}
+
+	return nil
+}
+
+// scriptKeys is for "EVAL <script> <numkeys> <key> ... <arg> ...".
+func scriptKeys(args []string) []string {
+	if len(args) < 2 {
+		return nil
+	}
+
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || numKeys < 0 || numKeys > len(args)-2 {
+		return nil
+	}
+
+	return args[2 : 2+numKeys]
+}
+
+// migrateKeys is for "MIGRATE <host> <port> <key> ..." and, with an empty
+// key, "MIGRATE ... KEYS <key> ...".
+func migrateKeys(args []string) []string {
+	if len(args) < 3 {
+		return nil
+	}
+
+	if args[2] != "" {
+		return args[2:3]
+	}
+
+	for i, arg := range args {
+		if i > 4 && strings.EqualFold(arg, "KEYS") {
+			return args[i+1:]
+		}
+	}
+
+	return nil
+}
+
+// checkCluster redirects commands whose keys this node doesn't serve. The
+// commands of a transaction are checked together, as they have to be in
+// the same slot. The AOF and the master's stream are replayed regardless.
+func (c *client) checkCluster(commands ...*protocol.Command) error {
+	if c.replaying || !cluster.Enabled() {
+		return nil
+	}
+
+	//RESTORE-ASKING is sent by MIGRATE to a node importing the slot
+	asking := c.asking
+	keys := []string{}
+	for _, command := range commands {
+		if keysOf, ok := commandKeys[command.Name]; ok {
+			keys = append(keys, keysOf(command.Args)...)
+		}
+
+		asking = asking || command.Name == "RESTORE-ASKING"
+	}
+
+	return cluster.Route(keys, asking)
+}
+
+// checkScriptKeys rejects commands of scripts that access keys this node
+// doesn't serve.
+func (c *client) checkScriptKeys(command *protocol.Command) error {
+	if c.replaying || !cluster.Enabled() {
+		return nil
+	}
+
+	keysOf, ok := commandKeys[command.Name]
+	if !ok {
+		return nil
+	}
+
+	return cluster.CheckLocal(keysOf(command.Args))
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"redis-clone-go/app/commands"
+	"redis-clone-go/app/persistence"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/pubsub"
+	"redis-clone-go/app/replication"
+	"redis-clone-go/app/scripting"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type commandHandler func(c *client, args []string) ([]byte, error)
+
+type commandSpec struct {
+	handler commandHandler
+	// arity follows Redis: a positive arity is the exact number of arguments
+	// including the command name, a negative one is the minimum.
+	arity int
+	flags commandFlags
+}
+
+type commandFlags int
+
+const (
+	// flagWrite marks commands that modify the dataset.
+	flagWrite commandFlags = 1 << iota
+	// flagBlocking commands wait without holding the transaction lock, so
+	// they can be woken up by a transaction.
+	flagBlocking
+	// flagNoScript commands can't be called from scripts.
+	flagNoScript
+	// flagNoLock commands don't take the transaction lock at all, either
+	// because they don't touch the dataset or because they lock themselves.
+	flagNoLock
+	// flagStale commands are allowed on a replica that lost its master
+	// while replica-serve-stale-data is off.
+	flagStale
+)
+
+var commandTable = map[string]commandSpec{
+	"PING":           {ping, -1, flagStale},
+	"ECHO":           {withArgs(commands.Echo), 2, flagStale},
+	"SET":            {withArgs(commands.Set), -3, flagWrite},
+	"GET":            {withArgs(commands.Get), 2, 0},
+	"RPUSH":          {withServed(commands.Rpush), -3, flagWrite},
+	"LRANGE":         {withArgs(commands.Lrange), 4, 0},
+	"LPUSH":          {withServed(commands.Lpush), -3, flagWrite},
+	"LLEN":           {withArgs(commands.Llen), 2, 0},
+	"LPOP":           {withArgs(commands.Lpop), -2, flagWrite},
+	"BLPOP":          {blpop, -3, flagWrite | flagBlocking},
+	"TYPE":           {withArgs(commands.Type), 2, 0},
+	"KEYS":           {withArgs(commands.Keys), 2, 0},
+	"DEL":            {withArgs(commands.Del), -2, flagWrite},
+	"XADD":           {withArgs(commands.XAdd), -5, flagWrite},
+	"XRANGE":         {withArgs(commands.XRange), -4, 0},
+	"XREAD":          {xread, -4, flagBlocking},
+	"XSETID":         {withArgs(commands.XSetId), -3, flagWrite},
+	"XTRIM":          {withArgs(commands.XTrim), -4, flagWrite},
+	"XGROUP":         {withArgs(commands.XGroup), -2, flagWrite},
+	"XREADGROUP":     {xreadgroup, -7, flagWrite | flagBlocking},
+	"XACK":           {withArgs(commands.XAck), -4, flagWrite},
+	"XPENDING":       {withArgs(commands.XPending), -3, 0},
+	"XCLAIM":         {withEffects(commands.XClaim), -6, flagWrite},
+	"XAUTOCLAIM":     {withEffects(commands.XAutoClaim), -6, flagWrite},
+	"XINFO":          {withArgs(commands.XInfo), -2, 0},
+	"SUBSCRIBE":      {withSubscriber(commands.Subscribe), -2, flagNoScript | flagStale},
+	"UNSUBSCRIBE":    {withSubscriber(commands.Unsubscribe), -1, flagNoScript | flagStale},
+	"PSUBSCRIBE":     {withSubscriber(commands.PSubscribe), -2, flagNoScript | flagStale},
+	"PUNSUBSCRIBE":   {withSubscriber(commands.PUnsubscribe), -1, flagNoScript | flagStale},
+	"SSUBSCRIBE":     {withSubscriber(commands.SSubscribe), -2, flagNoScript | flagStale},
+	"SUNSUBSCRIBE":   {withSubscriber(commands.SUnsubscribe), -1, flagNoScript | flagStale},
+	"PUBLISH":        {withArgs(commands.Publish), 3, flagStale},
+	"SPUBLISH":       {withArgs(commands.SPublish), 3, flagStale},
+	"PUBSUB":         {withArgs(commands.PubSub), -2, flagStale},
+	"CONFIG":         {withArgs(commands.Config), -2, flagStale},
+	"UNWATCH":        {unwatch, 1, 0},
+	"SCRIPT":         {withArgs(commands.Script), -2, flagNoScript | flagNoLock},
+	"FUNCTION":       {withArgs(commands.Function), -2, flagNoScript | flagNoLock},
+	"SAVE":           {withArgs(commands.Save), 1, flagNoScript},
+	"BGSAVE":         {withArgs(commands.BgSave), -1, flagNoScript},
+	"LASTSAVE":       {withArgs(commands.LastSave), 1, flagStale},
+	"BGREWRITEAOF":   {withArgs(commands.BgRewriteAof), 1, flagNoScript},
+	"REPLICAOF":      {withArgs(commands.ReplicaOf), 3, flagNoScript | flagStale},
+	"SLAVEOF":        {withArgs(commands.ReplicaOf), 3, flagNoScript | flagStale},
+	"ROLE":           {withArgs(commands.Role), 1, flagNoScript | flagStale},
+	"REPLCONF":       {replconf, -1, flagNoScript | flagStale},
+	"PSYNC":          {psync, -3, flagNoScript | flagNoLock | flagStale},
+	"WAIT":           {wait, 3, flagBlocking | flagNoScript},
+	"WAITAOF":        {waitAof, 4, flagBlocking | flagNoScript},
+	"CLUSTER":        {withArgs(commands.Cluster), -2, flagNoScript | flagNoLock | flagStale},
+	"ASKING":         {asking, 1, flagNoScript | flagNoLock | flagStale},
+	"DUMP":           {withArgs(commands.Dump), 2, 0},
+	"RESTORE":        {withArgs(commands.Restore), -4, flagWrite},
+	"RESTORE-ASKING": {withArgs(commands.Restore), -4, flagWrite},
+	"MIGRATE":        {withArgs(commands.Migrate), -6, flagWrite | flagNoScript},
+}
+
+// subcommandFlags replaces the flags of the subcommands that differ from
+// their command's, like the writes among the FUNCTION subcommands.
+var subcommandFlags = map[string]map[string]commandFlags{
+	"FUNCTION": {
+		"LOAD":    flagWrite | flagNoScript | flagNoLock,
+		"DELETE":  flagWrite | flagNoScript | flagNoLock,
+		"FLUSH":   flagWrite | flagNoScript | flagNoLock,
+		"RESTORE": flagWrite | flagNoScript | flagNoLock,
+	},
+}
+
+// sentinelCommandTable replaces commandTable in sentinel mode.
+var sentinelCommandTable = map[string]commandSpec{
+	"PING":         {ping, -1, 0},
+	"SENTINEL":     {withArgs(commands.Sentinel), -2, flagNoLock},
+	"ROLE":         {withArgs(commands.SentinelRole), 1, flagNoLock},
+	"SUBSCRIBE":    {withSubscriber(commands.Subscribe), -2, flagNoLock},
+	"UNSUBSCRIBE":  {withSubscriber(commands.Unsubscribe), -1, flagNoLock},
+	"PSUBSCRIBE":   {withSubscriber(commands.PSubscribe), -2, flagNoLock},
+	"PUNSUBSCRIBE": {withSubscriber(commands.PUnsubscribe), -1, flagNoLock},
+}
+
+// scripts dispatch through commandTable, so the scripting commands have to
+// be added once it is initialized.
+func init() {
+	commandTable["EVAL"] = commandSpec{eval, -3, flagNoScript | flagNoLock}
+	commandTable["EVALSHA"] = commandSpec{evalSha, -3, flagNoScript | flagNoLock}
+	commandTable["FCALL"] = commandSpec{fcall, -3, flagNoScript | flagNoLock}
+	commandTable["FCALL_RO"] = commandSpec{fcallRo, -3, flagNoScript | flagNoLock}
+}
+
+func withArgs(handler func([]string) ([]byte, error)) commandHandler {
+	return func(c *client, args []string) ([]byte, error) {
+		return handler(args)
+	}
+}
+
+func withSubscriber(handler func(*pubsub.Subscriber, []string) ([]byte, error)) commandHandler {
+	return func(c *client, args []string) ([]byte, error) {
+		return handler(c.getSubscriber(), args)
+	}
+}
+
+func ping(c *client, args []string) ([]byte, error) {
+	if c.isSubscribed() {
+		return commands.SubscribedPing(args)
+	}
+
+	return commands.Ping()
+}
+
+func unwatch(c *client, args []string) ([]byte, error) {
+	c.unwatch()
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+func replconf(c *client, args []string) ([]byte, error) {
+	//acknowledgements come from replicas and aren't replied to
+	if len(args) > 0 && strings.ToLower(args[0]) == "ack" {
+		if c.replica != nil {
+			replconfAck(c.replica, args[1:])
+		}
+
+		return nil, nil
+	}
+
+	if len(args)%2 != 0 {
+		return nil, errors.New("ERR syntax error")
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "listening-port":
+			if port, err := strconv.Atoi(args[i+1]); err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("ERR Invalid port '%v'", args[i+1])
+			}
+
+			c.listeningPort = args[i+1]
+		case "capa", "ip-address":
+			//there is only one way to sync and replicas are reached at the
+			//address they connected from
+		default:
+			return nil, fmt.Errorf("ERR Unrecognized REPLCONF option: %v", args[i])
+		}
+	}
+
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+// replconfAck handles "REPLCONF ACK <offset> [FACK <aofoffset>]".
+func replconfAck(replica *replication.Replica, args []string) {
+	if len(args) == 0 {
+		return
+	}
+
+	ackOffset, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return
+	}
+
+	aofOffset := int64(0)
+	if len(args) == 3 && strings.ToLower(args[1]) == "fack" {
+		aofOffset, _ = strconv.ParseInt(args[2], 10, 64)
+	}
+
+	replication.Ack(replica, ackOffset, aofOffset)
+}
+
+func wait(c *client, args []string) ([]byte, error) {
+	return commands.Wait(c.woff, c.executing, args)
+}
+
+func waitAof(c *client, args []string) ([]byte, error) {
+	return commands.WaitAof(c.woff, c.executing, args)
+}
+
+// psync turns the connection into a replica's replication stream. The
+// replica continues from the backlog if possible, otherwise it gets a full
+// resync.
+func psync(c *client, args []string) ([]byte, error) {
+	if c.conn == nil || c.executing || c.replica != nil {
+		return nil, errors.New("ERR PSYNC not allowed for this client")
+	}
+
+	host, _, err := net.SplitHostPort(c.conn.RemoteAddr().String())
+	if err != nil {
+		host = c.conn.RemoteAddr().String()
+	}
+
+	//the offset is the next byte the replica needs, "?" and -1 ask for a
+	//full resync
+	requestedOffset, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || requestedOffset < 1 {
+		requestedOffset = 0
+	}
+
+	//the snapshot has to match the offset the replica continues from
+	resume := replication.PauseStream()
+	defer resume()
+
+	execLock.Lock()
+	defer execLock.Unlock()
+
+	c.replica = replication.Sync(host, c.listeningPort, args[0], requestedOffset-1, persistence.CurrentSnapshot)
+	go c.forwardReplication(c.replica)
+
+	return nil, nil
+}
+
+func asking(c *client, args []string) ([]byte, error) {
+	response, err := commands.Asking(args)
+	if err == nil {
+		c.asking = true
+	}
+
+	return response, err
+}
+
+// withServed wraps the pushes. The values they hand to blocked clients never
+// make it into the list, the push is propagated followed by their pops.
+func withServed(handler func([]string) ([]byte, int, error)) commandHandler {
+	return func(c *client, args []string) ([]byte, error) {
+		response, served, err := handler(args)
+		if served > 0 {
+			c.alsoPropagate([]string{"LPOP", args[0], strconv.Itoa(served)})
+		}
+
+		return response, err
+	}
+}
+
+// blpop propagates the pops itself, values handed to it are propagated by
+// the push. A value popped right away is propagated under the lock, before
+// any write that follows.
+func blpop(c *client, args []string) ([]byte, error) {
+	if c.executing {
+		response, err := commands.BlpopNoWait(args)
+		if err == nil && !bytes.Equal(response, protocol.FormatNullBulkString()) {
+			c.propagateArgs([]string{"LPOP", args[0]})
+		}
+
+		return response, err
+	}
+
+	execLock.Lock()
+	response, wait, err := commands.Blpop(args)
+	if err == nil && wait == nil {
+		c.propagateArgs([]string{"LPOP", args[0]})
+	}
+	execLock.Unlock()
+
+	if wait == nil {
+		return response, err
+	}
+
+	return wait()
+}
+
+// withEffects wraps the commands that are replayed as the commands they
+// return, instead of themselves.
+func withEffects(handler func([]string) ([]byte, [][]string, error)) commandHandler {
+	return func(c *client, args []string) ([]byte, error) {
+		response, effects, err := handler(args)
+		for _, effect := range effects {
+			c.alsoPropagate(effect)
+		}
+
+		return response, err
+	}
+}
+
+// xreadgroup reads and propagates under the lock, like blpop. While it
+// blocks, it reads again whenever an entry is added, until it gets some or
+// times out.
+func xreadgroup(c *client, args []string) ([]byte, error) {
+	parsed, err := commands.ParseXReadGroupArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.executing {
+		parsed.Block = false
+		response, effects, _, err := commands.XReadGroup(parsed)
+		for _, effect := range effects {
+			c.alsoPropagate(effect)
+		}
+
+		return response, err
+	}
+
+	var timeout <-chan time.Time
+	if parsed.Timeout > 0 {
+		timeout = time.After(parsed.Timeout)
+	}
+
+	for {
+		execLock.Lock()
+		response, effects, wait, err := commands.XReadGroup(parsed)
+		for _, effect := range effects {
+			c.alsoPropagate(effect)
+		}
+		c.propagateArgs(nil)
+		execLock.Unlock()
+
+		if wait == nil {
+			return response, err
+		}
+
+		if ready, err := wait(timeout); !ready || err != nil {
+			return protocol.FormatNullBulkString(), err
+		}
+	}
+}
+
+// xread reads under the lock like other reads, only waiting for entries
+// happens without it.
+func xread(c *client, args []string) ([]byte, error) {
+	if c.executing {
+		return commands.XReadNoWait(args)
+	}
+
+	execLock.RLock()
+	response, wait, err := commands.XRead(args)
+	execLock.RUnlock()
+
+	if wait == nil {
+		return response, err
+	}
+
+	return wait()
+}
+
+func eval(c *client, args []string) ([]byte, error) {
+	return runScript(c, args, commands.Eval)
+}
+
+func evalSha(c *client, args []string) ([]byte, error) {
+	return runScript(c, args, commands.EvalSha)
+}
+
+func fcall(c *client, args []string) ([]byte, error) {
+	return runScript(c, args, commands.FCall)
+}
+
+func fcallRo(c *client, args []string) ([]byte, error) {
+	return runScript(c, args, commands.FCallRo)
+}
+
+// runScript runs the script atomically, like EXEC. Inside a transaction
+// the lock is already held.
+func runScript(c *client, args []string, run func(scripting.CallFunc, []string) ([]byte, error)) ([]byte, error) {
+	if !c.executing {
+		execLock.Lock()
+		defer execLock.Unlock()
+
+		c.executing = true
+		defer func() {
+			c.executing = false
+			c.flushPropagated()
+		}()
+	}
+
+	return run(c.callFromScript, args)
+}
+
+// callFromScript runs a command issued by redis.call or redis.pcall.
+func (c *client) callFromScript(args []string, readOnly bool) ([]byte, bool, error) {
+	command := &protocol.Command{Name: strings.ToUpper(args[0]), Args: args[1:]}
+
+	spec, err := lookupCommand(command)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if spec.flags&flagNoScript != 0 {
+		return nil, false, errors.New("ERR This Redis command is not allowed from script")
+	}
+
+	if readOnly && spec.flags&flagWrite != 0 {
+		return nil, false, errors.New("ERR Write commands are not allowed from read-only scripts.")
+	}
+
+	if err := c.checkReplica(spec); err != nil {
+		return nil, false, err
+	}
+
+	if err := c.checkScriptKeys(command); err != nil {
+		return nil, false, err
+	}
+
+	response, err := call(c, spec, command)
+	return response, spec.flags&flagWrite != 0, err
+}
+
+// checkReplica rejects writes on a read-only replica and, with
+// replica-serve-stale-data off, most commands while the link to the master
+// is down. The master's stream and the AOF are replayed regardless.
+func (c *client) checkReplica(spec commandSpec) error {
+	if c.replaying {
+		return nil
+	}
+
+	if spec.flags&flagWrite != 0 && !replication.WritesAllowed() {
+		return errors.New("READONLY You can't write against a read only replica.")
+	}
+
+	if spec.flags&flagStale == 0 && replication.StaleRefused() {
+		return errors.New("MASTERDOWN Link with MASTER is down and replica-serve-stale-data is set to 'no'.")
+	}
+
+	return nil
+}
+
+func lookupCommand(command *protocol.Command) (commandSpec, error) {
+	spec, ok := commandTable[command.Name]
+	if !ok {
+		return spec, fmt.Errorf("unkown command '%v'", command.Name)
+	}
+
+	argc := len(command.Args) + 1
+	if (spec.arity > 0 && argc != spec.arity) || argc < -spec.arity {
+		return spec, fmt.Errorf("ERR wrong number of arguments for '%v' command", strings.ToLower(command.Name))
+	}
+
+	if subcommands, ok := subcommandFlags[command.Name]; ok {
+		if flags, ok := subcommands[strings.ToUpper(command.Args[0])]; ok {
+			spec.flags = flags
+		}
+	}
+
+	return spec, nil
+}
@@ -0,0 +1,310 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"redis-clone-go/app/cluster"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/replication"
+	"strconv"
+	"strings"
+)
+
+var errClusterDisabled = errors.New("ERR This instance has cluster support disabled")
+
+func Cluster(args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errArgNumber
+	}
+
+	subcommand := strings.ToLower(args[0])
+
+	//KEYSLOT only hashes, it doesn't need a cluster
+	if subcommand == "keyslot" {
+		if len(args) != 2 {
+			return nil, errArgNumber
+		}
+
+		return protocol.FormatInt(cluster.KeySlot(args[1]), false), nil
+	}
+
+	if !cluster.Enabled() {
+		return nil, errClusterDisabled
+	}
+
+	switch subcommand {
+	case "info":
+		return protocol.FormatBulkString(cluster.Info()), nil
+	case "myid":
+		return protocol.FormatBulkString(cluster.MyId()), nil
+	case "nodes":
+		return protocol.FormatBulkString(cluster.Nodes()), nil
+	case "slots":
+		return clusterSlots(), nil
+	case "shards":
+		return clusterShards(), nil
+	case "countkeysinslot":
+		if len(args) != 2 {
+			return nil, errArgNumber
+		}
+
+		slot, err := strconv.Atoi(args[1])
+		if err != nil || slot < 0 || slot >= cluster.SlotCount {
+			return nil, errors.New("ERR Invalid slot")
+		}
+
+		return protocol.FormatInt(cluster.CountKeysInSlot(slot), false), nil
+	case "getkeysinslot":
+		if len(args) != 3 {
+			return nil, errArgNumber
+		}
+
+		slot, err1 := strconv.Atoi(args[1])
+		count, err2 := strconv.Atoi(args[2])
+		if err1 != nil || err2 != nil || slot < 0 || slot >= cluster.SlotCount || count < 0 {
+			return nil, errors.New("ERR Invalid slot or number of keys")
+		}
+
+		return protocol.FormatBulkStringArray(cluster.GetKeysInSlot(slot, count)), nil
+	case "addslots", "delslots":
+		if len(args) < 2 {
+			return nil, errArgNumber
+		}
+
+		slots, err := parseSlots(args[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		return okOrError(changeSlots(subcommand == "addslots", slots))
+	case "addslotsrange", "delslotsrange":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return nil, errArgNumber
+		}
+
+		slots, err := parseSlotRanges(args[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		return okOrError(changeSlots(subcommand == "addslotsrange", slots))
+	case "flushslots":
+		return okOrError(cluster.FlushSlots())
+	case "setslot":
+		return clusterSetSlot(args[1:])
+	case "saveconfig":
+		return okOrError(cluster.SaveConfig())
+	case "meet":
+		return clusterMeet(args[1:])
+	case "replicate":
+		if len(args) != 2 {
+			return nil, errArgNumber
+		}
+
+		return okOrError(cluster.Replicate(args[1]))
+	default:
+		return nil, fmt.Errorf("ERR unknown subcommand '%v'. Try CLUSTER HELP.", args[0])
+	}
+}
+
+// Asking is only checked by the dispatcher, which lets the next command
+// access a slot that is being imported.
+func Asking(args []string) ([]byte, error) {
+	if !cluster.Enabled() {
+		return nil, errClusterDisabled
+	}
+
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+func changeSlots(add bool, slots []int) error {
+	if add {
+		return cluster.AddSlots(slots)
+	}
+
+	return cluster.DelSlots(slots)
+}
+
+func okOrError(err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+func parseSlots(args []string) ([]int, error) {
+	slots := make([]int, 0, len(args))
+	for _, arg := range args {
+		slot, err := cluster.ParseSlot(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		slots = append(slots, slot)
+	}
+
+	return slots, nil
+}
+
+func parseSlotRanges(args []string) ([]int, error) {
+	slots := []int{}
+	for i := 0; i < len(args); i += 2 {
+		start, err := cluster.ParseSlot(args[i])
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := cluster.ParseSlot(args[i+1])
+		if err != nil {
+			return nil, err
+		}
+
+		if start > end {
+			return nil, fmt.Errorf("ERR start slot number %d is greater than end slot number %d", start, end)
+		}
+
+		for slot := start; slot <= end; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+
+	return slots, nil
+}
+
+// clusterMeet handles "CLUSTER MEET <ip> <port> [<cluster-bus-port>]".
+func clusterMeet(args []string) ([]byte, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errArgNumber
+	}
+
+	port, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("ERR Invalid base port specified: %v", args[1])
+	}
+
+	busPort := 0
+	if len(args) == 3 {
+		if busPort, err = strconv.Atoi(args[2]); err != nil {
+			return nil, fmt.Errorf("ERR Invalid bus port specified: %v", args[2])
+		}
+	}
+
+	return okOrError(cluster.Meet(args[0], port, busPort))
+}
+
+// clusterSetSlot handles "CLUSTER SETSLOT <slot> MIGRATING|IMPORTING|NODE
+// <id>" and "CLUSTER SETSLOT <slot> STABLE".
+func clusterSetSlot(args []string) ([]byte, error) {
+	if len(args) < 2 {
+		return nil, errArgNumber
+	}
+
+	slot, err := cluster.ParseSlot(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(args[1]) {
+	case "node":
+		if len(args) != 3 {
+			return nil, errArgNumber
+		}
+
+		return okOrError(cluster.SetSlotNode(slot, args[2]))
+	case "migrating":
+		if len(args) != 3 {
+			return nil, errArgNumber
+		}
+
+		return okOrError(cluster.SetSlotMigrating(slot, args[2]))
+	case "importing":
+		if len(args) != 3 {
+			return nil, errArgNumber
+		}
+
+		return okOrError(cluster.SetSlotImporting(slot, args[2]))
+	case "stable":
+		if len(args) != 2 {
+			return nil, errArgNumber
+		}
+
+		return okOrError(cluster.SetSlotStable(slot))
+	default:
+		return nil, errors.New("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+}
+
+// clusterSlots replies with the slot ranges, each followed by the master
+// serving it and its replicas.
+func clusterSlots() []byte {
+	type slotRange struct {
+		cluster.SlotRange
+		nodes []cluster.NodeInfo
+	}
+
+	ranges := []slotRange{}
+	for _, shard := range cluster.Shards(replication.Offset()) {
+		for _, r := range shard.Slots {
+			ranges = append(ranges, slotRange{r, shard.Nodes})
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(ranges))
+	for _, r := range ranges {
+		fmt.Fprintf(&buf, "*%d\r\n", 2+len(r.nodes))
+		buf.Write(protocol.FormatInt(r.Start, false))
+		buf.Write(protocol.FormatInt(r.End, false))
+
+		for _, node := range r.nodes {
+			buf.WriteString("*3\r\n")
+			buf.Write(protocol.FormatBulkString(node.Ip))
+			buf.Write(protocol.FormatInt(node.Port, false))
+			buf.Write(protocol.FormatBulkString(node.Id))
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// clusterShards replies with each shard's slots and nodes as maps, which
+// are flat key/value arrays in RESP2.
+func clusterShards() []byte {
+	shards := cluster.Shards(replication.Offset())
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(shards))
+	for _, shard := range shards {
+		buf.WriteString("*4\r\n")
+		buf.Write(protocol.FormatBulkString("slots"))
+		fmt.Fprintf(&buf, "*%d\r\n", 2*len(shard.Slots))
+		for _, r := range shard.Slots {
+			buf.Write(protocol.FormatInt(r.Start, false))
+			buf.Write(protocol.FormatInt(r.End, false))
+		}
+
+		buf.Write(protocol.FormatBulkString("nodes"))
+		fmt.Fprintf(&buf, "*%d\r\n", len(shard.Nodes))
+		for _, node := range shard.Nodes {
+			buf.WriteString("*14\r\n")
+			buf.Write(protocol.FormatBulkString("id"))
+			buf.Write(protocol.FormatBulkString(node.Id))
+			buf.Write(protocol.FormatBulkString("port"))
+			buf.Write(protocol.FormatInt(node.Port, false))
+			buf.Write(protocol.FormatBulkString("ip"))
+			buf.Write(protocol.FormatBulkString(node.Ip))
+			buf.Write(protocol.FormatBulkString("endpoint"))
+			buf.Write(protocol.FormatBulkString(node.Ip))
+			buf.Write(protocol.FormatBulkString("role"))
+			buf.Write(protocol.FormatBulkString(node.Role))
+			buf.Write(protocol.FormatBulkString("replication-offset"))
+			buf.Write(protocol.FormatInt(int(node.Offset), false))
+			buf.Write(protocol.FormatBulkString("health"))
+			buf.Write(protocol.FormatBulkString(node.Health))
+		}
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,45 @@
+package commands
+
+import (
+	"fmt"
+	"redis-clone-go/app/config"
+	"redis-clone-go/app/protocol"
+	"strings"
+)
+
+func Config(args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errArgNumber
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		if len(args) < 2 {
+			return nil, errArgNumber
+		}
+
+		return protocol.FormatBulkStringArray(config.Get(args[1:]...)), nil
+	case "SET":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return nil, errArgNumber
+		}
+
+		if err := config.Set(args[1:]); err != nil {
+			return nil, err
+		}
+
+		return protocol.FormatSimpleString("OK"), nil
+	case "REWRITE":
+		if len(args) != 1 {
+			return nil, errArgNumber
+		}
+
+		if err := config.Rewrite(); err != nil {
+			return nil, err
+		}
+
+		return protocol.FormatSimpleString("OK"), nil
+	default:
+		return nil, fmt.Errorf("ERR unknown subcommand '%v'. Try CONFIG HELP.", args[0])
+	}
+}
@@ -5,3 +5,6 @@ import "errors"
 var errWrongtypeOperation = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
 var errArgNumber = errors.New("ERR wrong number of arguments for command")
 var errStreamIdTooSmall = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+var errSyntax = errors.New("ERR syntax error")
+var errNotInteger = errors.New("ERR value is not an integer or out of range")
+var errInvalidStreamId = errors.New("ERR Invalid stream ID specified as stream command argument")
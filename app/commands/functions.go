@@ -0,0 +1,192 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/scripting"
+	"strings"
+)
+
+func FCall(call scripting.CallFunc, args []string) ([]byte, error) {
+	return fCall(call, args, false)
+}
+
+func FCallRo(call scripting.CallFunc, args []string) ([]byte, error) {
+	return fCall(call, args, true)
+}
+
+func fCall(call scripting.CallFunc, args []string, readOnly bool) ([]byte, error) {
+	if len(args) < 2 {
+		return nil, errArgNumber
+	}
+
+	keys, argv, err := parseScriptArgs(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return scripting.Functions.Call(args[0], keys, argv, readOnly, call)
+}
+
+func Function(args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errArgNumber
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "LOAD":
+		return functionLoad(args[1:])
+	case "LIST":
+		return functionList(args[1:])
+	case "DELETE":
+		if len(args) != 2 {
+			return nil, errArgNumber
+		}
+
+		if err := scripting.Functions.Delete(args[1]); err != nil {
+			return nil, err
+		}
+
+		return protocol.FormatSimpleString("OK"), nil
+	case "FLUSH":
+		if len(args) > 2 {
+			return nil, errArgNumber
+		}
+
+		if len(args) == 2 && strings.ToUpper(args[1]) != "ASYNC" && strings.ToUpper(args[1]) != "SYNC" {
+			return nil, errors.New("ERR FUNCTION FLUSH only supports SYNC|ASYNC option")
+		}
+
+		scripting.Functions.Flush()
+		return protocol.FormatSimpleString("OK"), nil
+	case "DUMP":
+		if len(args) != 1 {
+			return nil, errArgNumber
+		}
+
+		return protocol.FormatBulkString(string(scripting.Functions.Dump())), nil
+	case "RESTORE":
+		return functionRestore(args[1:])
+	case "KILL":
+		if len(args) != 1 {
+			return nil, errArgNumber
+		}
+
+		if err := scripting.Functions.Kill(); err != nil {
+			return nil, err
+		}
+
+		return protocol.FormatSimpleString("OK"), nil
+	default:
+		return nil, fmt.Errorf("ERR unknown subcommand '%v'. Try FUNCTION HELP.", args[0])
+	}
+}
+
+func functionLoad(args []string) ([]byte, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return nil, errArgNumber
+	}
+
+	replace := false
+	if len(args) == 2 {
+		if strings.ToUpper(args[0]) != "REPLACE" {
+			return nil, fmt.Errorf("ERR Unknown option given: %v", args[0])
+		}
+
+		replace = true
+	}
+
+	name, err := scripting.Functions.Load(args[len(args)-1], replace)
+	if err != nil {
+		return nil, err
+	}
+
+	return protocol.FormatBulkString(name), nil
+}
+
+func functionRestore(args []string) ([]byte, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return nil, errArgNumber
+	}
+
+	policy := "APPEND"
+	if len(args) == 2 {
+		policy = strings.ToUpper(args[1])
+		if policy != "FLUSH" && policy != "APPEND" && policy != "REPLACE" {
+			return nil, errors.New("ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")
+		}
+	}
+
+	if err := scripting.Functions.Restore([]byte(args[0]), policy); err != nil {
+		return nil, err
+	}
+
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+func functionList(args []string) ([]byte, error) {
+	pattern := ""
+	withCode := false
+
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "WITHCODE":
+			withCode = true
+		case "LIBRARYNAME":
+			if i+1 >= len(args) {
+				return nil, errors.New("ERR library name argument was not given")
+			}
+
+			i++
+			pattern = args[i]
+		default:
+			return nil, fmt.Errorf("ERR Unknown argument %v", args[i])
+		}
+	}
+
+	libraries := scripting.Functions.Libraries(pattern)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(libraries))
+
+	for _, lib := range libraries {
+		if withCode {
+			buf.WriteString("*8\r\n")
+		} else {
+			buf.WriteString("*6\r\n")
+		}
+
+		buf.Write(protocol.FormatBulkString("library_name"))
+		buf.Write(protocol.FormatBulkString(lib.Name))
+		buf.Write(protocol.FormatBulkString("engine"))
+		buf.Write(protocol.FormatBulkString("LUA"))
+
+		buf.Write(protocol.FormatBulkString("functions"))
+		fmt.Fprintf(&buf, "*%d\r\n", len(lib.Functions))
+
+		for _, fn := range lib.Functions {
+			buf.WriteString("*6\r\n")
+			buf.Write(protocol.FormatBulkString("name"))
+			buf.Write(protocol.FormatBulkString(fn.Name))
+
+			buf.Write(protocol.FormatBulkString("description"))
+			if fn.Description != "" {
+				buf.Write(protocol.FormatBulkString(fn.Description))
+			} else {
+				buf.Write(protocol.FormatNullBulkString())
+			}
+
+			buf.Write(protocol.FormatBulkString("flags"))
+			buf.Write(protocol.FormatBulkStringArray(fn.Flags))
+		}
+
+		if withCode {
+			buf.Write(protocol.FormatBulkString("library_code"))
+			buf.Write(protocol.FormatBulkString(lib.Code))
+		}
+	}
+
+	return buf.Bytes(), nil
+}
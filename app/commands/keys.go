@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"redis-clone-go/app/glob"
+	"redis-clone-go/app/notify"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/replication"
+	"redis-clone-go/app/store"
+	"slices"
+	"sync"
+)
+
+// Propagate sends a command to the AOF and the replicas. Reads use it for
+// the deletion of the expired keys they run into.
+var Propagate = func(args []string) {}
+
+func Keys(args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errArgNumber
+	}
+
+	result := []string{}
+	for _, key := range store.CM.Keys() {
+		if !glob.Match(args[0], key) {
+			continue
+		}
+
+		storedValue, ok := store.CM.Get(key)
+		if !ok || storedValue.IsExpired() || storedValue.IsBlockedOnly() {
+			continue
+		}
+
+		result = append(result, key)
+	}
+
+	slices.Sort(result)
+	return protocol.FormatBulkStringArray(result), nil
+}
+
+func Del(args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errArgNumber
+	}
+
+	deleted := 0
+	for _, key := range args {
+		storedValue, ok := store.DeleteKey(key)
+		if !ok || storedValue.IsExpired() || storedValue.IsBlockedOnly() {
+			continue
+		}
+
+		deleted++
+		notify.KeyspaceEvent(notify.Generic, "del", key)
+	}
+
+	return protocol.FormatInt(deleted, false), nil
+}
+
+var expireMu sync.Mutex
+
+// expireKey deletes a key a read found to be expired and propagates the
+// deletion as DEL. Replicas keep the key until the master's DEL arrives, so
+// they never diverge from it, and only report it as missing meanwhile.
+// Reads run concurrently, so expireMu makes sure only the first of them to
+// run into the key deletes it.
+func expireKey(key string) {
+	if replication.IsReplica() {
+		return
+	}
+
+	expireMu.Lock()
+	defer expireMu.Unlock()
+
+	if storedValue, ok := store.CM.Get(key); !ok || !storedValue.IsExpired() {
+		return
+	}
+
+	store.DeleteKey(key)
+	notify.KeyspaceEvent(notify.Expired, "expired", key)
+	Propagate([]string{"DEL", key})
+}
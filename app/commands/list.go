@@ -2,16 +2,20 @@ package commands
 
 import (
 	"errors"
+	"redis-clone-go/app/notify"
 	"redis-clone-go/app/protocol"
 	"redis-clone-go/app/store"
 	"strconv"
 )
 
-func Rpush(args []string) ([]byte, error) {
+// Rpush also returns the number of values handed to blocked clients, which
+// never make it into the list.
+func Rpush(args []string) ([]byte, int, error) {
 	if len(args) < 2 {
-		return nil, errArgNumber
+		return nil, 0, errArgNumber
 	}
 
+	served := 0
 	returnCodeCraftersError := false
 	updatedValue, err := store.CM.SetOrUpdate(
 		args[0],
@@ -28,27 +32,31 @@ func Rpush(args []string) ([]byte, error) {
 				return nil
 			}
 
-			handleListListeners(storedValue, args[1:], false)
+			served = handleListListeners(storedValue, args[1:], false)
 			returnCodeCraftersError = true
 			return nil
 		})
 
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
+	notify.KeyspaceEvent(notify.List, "rpush", args[0])
+
 	if returnCodeCraftersError {
-		return protocol.FormatInt(1, false), nil
+		return protocol.FormatInt(1, false), served, nil
 	}
 
-	return protocol.FormatInt(len(updatedValue.Lval), false), nil
+	return protocol.FormatInt(len(updatedValue.Lval), false), served, nil
 }
 
-func Lpush(args []string) ([]byte, error) {
+// Lpush also returns the number of values handed to blocked clients.
+func Lpush(args []string) ([]byte, int, error) {
 	if len(args) < 2 {
-		return nil, errArgNumber
+		return nil, 0, errArgNumber
 	}
 
+	served := 0
 	storedValue, err := store.CM.SetOrUpdate(
 		args[0],
 		func() store.StoredValue {
@@ -66,18 +74,22 @@ func Lpush(args []string) ([]byte, error) {
 			}
 
 			valsReversed := reverseArray(args[1:])
-			handleListListeners(storedValue, valsReversed, true)
+			served = handleListListeners(storedValue, valsReversed, true)
 			return nil
 		})
 
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	return protocol.FormatInt(len(storedValue.Lval), false), nil
+	notify.KeyspaceEvent(notify.List, "lpush", args[0])
+
+	return protocol.FormatInt(len(storedValue.Lval), false), served, nil
 }
 
-func handleListListeners(storedValue *store.StoredValue, listValues []string, prepend bool) {
+// handleListListeners hands the first values to the blocked clients and
+// returns how many it handed.
+func handleListListeners(storedValue *store.StoredValue, listValues []string, prepend bool) int {
 	limit := min(len(storedValue.ListListeners), len(listValues))
 
 	for i := range limit {
@@ -92,6 +104,8 @@ func handleListListeners(storedValue *store.StoredValue, listValues []string, pr
 	} else {
 		storedValue.Lval = append(storedValue.Lval, listValues[limit:]...)
 	}
+
+	return limit
 }
 
 func Lrange(args []string) ([]byte, error) {
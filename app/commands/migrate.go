@@ -0,0 +1,273 @@
+package commands
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"redis-clone-go/app/cluster"
+	"redis-clone-go/app/notify"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/rdb"
+	"redis-clone-go/app/store"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errBusyKey = errors.New("BUSYKEY Target key name already exists.")
+
+func Dump(args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errArgNumber
+	}
+
+	storedValue, ok := store.CM.Get(args[0])
+	if !ok {
+		return protocol.FormatNullBulkString(), nil
+	}
+
+	if storedValue.IsExpired() {
+		expireKey(args[0])
+		return protocol.FormatNullBulkString(), nil
+	}
+
+	return protocol.FormatBulkString(string(rdb.DumpValue(storedValue))), nil
+}
+
+// Restore handles "RESTORE <key> <ttl> <payload> [REPLACE] [ABSTTL]
+// [IDLETIME <seconds>] [FREQ <frequency>]". There is no eviction, so the
+// idle time and frequency are only validated.
+func Restore(args []string) ([]byte, error) {
+	if len(args) < 3 {
+		return nil, errArgNumber
+	}
+
+	key := args[0]
+	replace, absTtl := false, false
+
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTtl = true
+		case "IDLETIME", "FREQ":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+
+			if n, err := strconv.ParseInt(args[i+1], 10, 64); err != nil || n < 0 {
+				return nil, fmt.Errorf("ERR Invalid %v value, must be >= 0", strings.ToUpper(args[i]))
+			}
+
+			i++
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	ttl, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+
+	if ttl < 0 {
+		return nil, errors.New("ERR Invalid TTL value, must be >= 0")
+	}
+
+	if storedValue, ok := store.CM.Get(key); ok && !storedValue.IsExpired() && !replace {
+		return nil, errBusyKey
+	}
+
+	value, err := rdb.LoadValue([]byte(args[2]))
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl > 0 {
+		value.ExpiresBy = ttl
+		if !absTtl {
+			value.ExpiresBy += time.Now().UnixMilli()
+		}
+	}
+
+	store.CM.Set(key, value)
+	notify.KeyspaceEvent(notify.Generic, "restore", key)
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+// Migrate handles "MIGRATE <host> <port> <key>|"" <destination-db> <timeout>
+// [COPY] [REPLACE] [AUTH <password>] [AUTH2 <username> <password>]
+// [KEYS <key> ...]". The keys are restored on the target, then deleted here
+// unless COPY is given. Only keys the target accepted are deleted, their
+// deletion is propagated as DEL.
+func Migrate(args []string) ([]byte, error) {
+	if len(args) < 5 {
+		return nil, errArgNumber
+	}
+
+	copyKeys, replace := false, false
+	auth := []string(nil)
+	keys := []string{args[2]}
+
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COPY":
+			copyKeys = true
+		case "REPLACE":
+			replace = true
+		case "AUTH":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+
+			auth = []string{"AUTH", args[i+1]}
+			i++
+		case "AUTH2":
+			if i+2 >= len(args) {
+				return nil, errSyntax
+			}
+
+			auth = []string{"AUTH", args[i+1], args[i+2]}
+			i += 2
+		case "KEYS":
+			if args[2] != "" {
+				return nil, errors.New("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+
+			keys = args[i+1:]
+			i = len(args)
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	if db, err := strconv.Atoi(args[3]); err != nil || db != 0 {
+		return nil, errors.New("ERR DB index is out of range")
+	}
+
+	timeout, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		return nil, errNotInteger
+	}
+
+	if timeout <= 0 {
+		timeout = 1000
+	}
+
+	//expired keys are skipped like missing ones
+	payloads := [][]string{}
+	migrated := []string{}
+	for _, key := range keys {
+		storedValue, ok := store.CM.Get(key)
+		if !ok || storedValue.IsExpired() {
+			continue
+		}
+
+		ttl := int64(0)
+		if storedValue.ExpiresBy != -1 {
+			ttl = max(storedValue.ExpiresBy-time.Now().UnixMilli(), 1)
+		}
+
+		//the target may be importing the slot, RESTORE-ASKING lets it
+		//accept the key anyway
+		restore := []string{"RESTORE", key, strconv.FormatInt(ttl, 10), string(rdb.DumpValue(storedValue))}
+		if cluster.Enabled() {
+			restore[0] = "RESTORE-ASKING"
+		}
+
+		if replace {
+			restore = append(restore, "REPLACE")
+		}
+
+		payloads = append(payloads, restore)
+		migrated = append(migrated, key)
+	}
+
+	if len(payloads) == 0 {
+		return protocol.FormatSimpleString("NOKEY"), nil
+	}
+
+	accepted, err := sendToTarget(net.JoinHostPort(args[0], args[1]), time.Duration(timeout)*time.Millisecond, auth, payloads)
+
+	if !copyKeys {
+		deleted := []string{}
+		for i, ok := range accepted {
+			if ok {
+				store.DeleteKey(migrated[i])
+				notify.KeyspaceEvent(notify.Generic, "del", migrated[i])
+				deleted = append(deleted, migrated[i])
+			}
+		}
+
+		if len(deleted) > 0 {
+			Propagate(append([]string{"DEL"}, deleted...))
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+// sendToTarget pipelines the RESTORE commands and reports which of them
+// succeeded. The first failure is returned as error.
+func sendToTarget(addr string, timeout time.Duration, auth []string, payloads [][]string) ([]bool, error) {
+	accepted := make([]bool, len(payloads))
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return accepted, errors.New("IOERR error or timeout connecting to the client")
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	reader := bufio.NewReader(conn)
+
+	var buf []byte
+	if auth != nil {
+		buf = append(buf, protocol.FormatBulkStringArray(auth)...)
+	}
+
+	for _, payload := range payloads {
+		buf = append(buf, protocol.FormatBulkStringArray(payload)...)
+	}
+
+	if _, err := conn.Write(buf); err != nil {
+		return accepted, errors.New("IOERR error or timeout writing to target instance")
+	}
+
+	if auth != nil {
+		reply, err := protocol.ParseReply(reader)
+		if err != nil {
+			return accepted, errors.New("IOERR error or timeout reading to target instance")
+		}
+
+		if reply.Type == protocol.ErrorReply {
+			return accepted, fmt.Errorf("ERR Target instance replied with error: %v", reply.Str)
+		}
+	}
+
+	var firstErr error
+	for i := range payloads {
+		reply, err := protocol.ParseReply(reader)
+		if err != nil {
+			return accepted, errors.New("IOERR error or timeout reading to target instance")
+		}
+
+		if reply.Type == protocol.ErrorReply {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("ERR Target instance replied with error: %v", reply.Str)
+			}
+
+			continue
+		}
+
+		accepted[i] = true
+	}
+
+	return accepted, firstErr
+}
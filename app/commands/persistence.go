@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"redis-clone-go/app/persistence"
+	"redis-clone-go/app/protocol"
+)
+
+func Save(args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errArgNumber
+	}
+
+	if err := persistence.Save(); err != nil {
+		return nil, err
+	}
+
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+func BgSave(args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errSyntax
+	}
+
+	if err := persistence.BackgroundSave(); err != nil {
+		return nil, err
+	}
+
+	return protocol.FormatSimpleString("Background saving started"), nil
+}
+
+func LastSave(args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errArgNumber
+	}
+
+	return protocol.FormatInt(int(persistence.LastSave()), false), nil
+}
+
+func BgRewriteAof(args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errArgNumber
+	}
+
+	if err := persistence.RewriteAOF(); err != nil {
+		return nil, err
+	}
+
+	return protocol.FormatSimpleString("Background append only file rewriting started"), nil
+}
@@ -3,6 +3,7 @@ package commands
 import (
 	"errors"
 	"fmt"
+	"redis-clone-go/app/notify"
 	"redis-clone-go/app/protocol"
 	"redis-clone-go/app/store"
 	"slices"
@@ -28,7 +29,7 @@ func Lpop(args []string) ([]byte, error) {
 
 	result := []string{}
 
-	_, err := store.CM.Update(
+	_, err := store.CM.UpdateQuietly(
 		args[0],
 		func(storedValue *store.StoredValue) error {
 			if storedValue.Type != store.TypeList {
@@ -52,6 +53,13 @@ func Lpop(args []string) ([]byte, error) {
 		return nil, err
 	}
 
+	if len(result) == 0 {
+		return protocol.FormatNullBulkString(), nil
+	}
+
+	store.CM.Touch(args[0])
+	notify.KeyspaceEvent(notify.List, "lpop", args[0])
+
 	if len(args) == 1 {
 		return protocol.FormatBulkString(result[0]), nil
 	}
@@ -59,21 +67,25 @@ func Lpop(args []string) ([]byte, error) {
 	return protocol.FormatBulkStringArray(result), nil
 }
 
-func Blpop(args []string) ([]byte, error) {
+// Blpop pops the first value of the list. If the list is empty, it instead
+// registers to be handed the next value pushed and returns the function
+// that waits for it.
+func Blpop(args []string) ([]byte, func() ([]byte, error), error) {
 	//TODO: add multiple list args
 	if len(args) != 2 {
-		return nil, errArgNumber
+		return nil, nil, errArgNumber
 	}
 
 	timeout, err := strconv.ParseFloat(args[1], 64)
 	if err != nil || timeout < 0 {
-		return nil, errors.New("timeout couldn't be parsed")
+		return nil, nil, errors.New("timeout couldn't be parsed")
 	}
 
 	result := ""
 	c := make(chan string, 1)
 
-	_, err = store.CM.SetOrUpdate(
+	//only popping is a change, blocking isn't
+	_, err = store.CM.SetOrUpdateQuietly(
 		args[0],
 		func() store.StoredValue {
 			return store.NewListListener(c)
@@ -95,13 +107,21 @@ func Blpop(args []string) ([]byte, error) {
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if result != "" {
-		return protocol.FormatBulkStringArray([]string{args[0], result}), nil
+		store.CM.Touch(args[0])
+		notify.KeyspaceEvent(notify.List, "lpop", args[0])
+		return protocol.FormatBulkStringArray([]string{args[0], result}), nil, nil
 	}
 
+	return nil, func() ([]byte, error) {
+		return waitForList(args[0], c, timeout)
+	}, nil
+}
+
+func waitForList(key string, c chan string, timeout float64) ([]byte, error) {
 	var timeoutChannel <-chan time.Time
 	if timeout > 0 {
 		timeoutChannel = time.After(time.Duration(timeout * float64(time.Second)))
@@ -113,10 +133,12 @@ func Blpop(args []string) ([]byte, error) {
 			return nil, errors.New("error receiving value from list")
 		}
 
-		return protocol.FormatBulkStringArray([]string{args[0], result}), nil
+		notify.KeyspaceEvent(notify.List, "lpop", key)
+
+		return protocol.FormatBulkStringArray([]string{key, result}), nil
 
 	case <-timeoutChannel:
-		err = removeListListener(args[0], c)
+		err := removeListListener(key, c)
 		if err != nil {
 			return nil, fmt.Errorf("error removing channel: %w", err)
 		}
@@ -125,12 +147,59 @@ func Blpop(args []string) ([]byte, error) {
 	}
 }
 
+// BlpopNoWait pops like BLPOP but never blocks, like Redis does for BLPOP
+// inside a transaction.
+func BlpopNoWait(args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errArgNumber
+	}
+
+	timeout, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || timeout < 0 {
+		return nil, errors.New("timeout couldn't be parsed")
+	}
+
+	result := ""
+	popped := false
+
+	_, err = store.CM.UpdateQuietly(
+		args[0],
+		func(storedValue *store.StoredValue) error {
+			if storedValue.Type != store.TypeList {
+				return errWrongtypeOperation
+			}
+
+			if len(storedValue.Lval) > 0 {
+				result = storedValue.Lval[0]
+				storedValue.Lval = storedValue.Lval[1:]
+				popped = true
+			}
+
+			return nil
+		},
+	)
+
+	if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		return nil, err
+	}
+
+	if !popped {
+		return protocol.FormatNullBulkString(), nil
+	}
+
+	store.CM.Touch(args[0])
+	notify.KeyspaceEvent(notify.List, "lpop", args[0])
+	return protocol.FormatBulkStringArray([]string{args[0], result}), nil
+}
+
+// removeListListener stops waiting on a list. The key may have been
+// overwritten meanwhile, its listeners are gone then.
 func removeListListener(key string, c chan string) error {
-	_, err := store.CM.Update(
+	_, err := store.CM.UpdateQuietly(
 		key,
 		func(storedValue *store.StoredValue) error {
 			if storedValue.Type != store.TypeList {
-				return errWrongtypeOperation
+				return nil
 			}
 
 			storedValue.ListListeners = slices.DeleteFunc(storedValue.ListListeners, func(channel chan string) bool {
@@ -141,11 +210,7 @@ func removeListListener(key string, c chan string) error {
 		},
 	)
 
-	if err != nil {
-		if errors.Is(err, store.ErrKeyNotFound) {
-			return errors.New("error getting list for key")
-		}
-
+	if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
 		return err
 	}
 
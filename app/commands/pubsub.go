@@ -0,0 +1,133 @@
+package commands
+
+import (
+	"fmt"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/pubsub"
+	"strings"
+)
+
+// Subscribe replies are pushed through the subscriber, so they are ordered
+// correctly with the messages published to the channels.
+func Subscribe(sub *pubsub.Subscriber, args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errArgNumber
+	}
+
+	pubsub.PS.Subscribe(sub, args)
+	return nil, nil
+}
+
+func Unsubscribe(sub *pubsub.Subscriber, args []string) ([]byte, error) {
+	pubsub.PS.Unsubscribe(sub, args)
+	return nil, nil
+}
+
+func PSubscribe(sub *pubsub.Subscriber, args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errArgNumber
+	}
+
+	pubsub.PS.PSubscribe(sub, args)
+	return nil, nil
+}
+
+func PUnsubscribe(sub *pubsub.Subscriber, args []string) ([]byte, error) {
+	pubsub.PS.PUnsubscribe(sub, args)
+	return nil, nil
+}
+
+func SSubscribe(sub *pubsub.Subscriber, args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errArgNumber
+	}
+
+	pubsub.ShardPS.Subscribe(sub, args)
+	return nil, nil
+}
+
+func SUnsubscribe(sub *pubsub.Subscriber, args []string) ([]byte, error) {
+	pubsub.ShardPS.Unsubscribe(sub, args)
+	return nil, nil
+}
+
+func SPublish(args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errArgNumber
+	}
+
+	receivers := pubsub.ShardPS.Publish(args[0], args[1])
+	return protocol.FormatInt(receivers, false), nil
+}
+
+func Publish(args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errArgNumber
+	}
+
+	receivers := pubsub.PS.Publish(args[0], args[1])
+	return protocol.FormatInt(receivers, false), nil
+}
+
+// SubscribedPing is the PING reply for connections in subscriber mode.
+func SubscribedPing(args []string) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, errArgNumber
+	}
+
+	message := ""
+	if len(args) == 1 {
+		message = args[0]
+	}
+
+	return protocol.FormatBulkStringArray([]string{"pong", message}), nil
+}
+
+func PubSub(args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errArgNumber
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "CHANNELS":
+		return formatChannels(args[1:], pubsub.PS)
+	case "NUMSUB":
+		return formatNumSub(args[1:], pubsub.PS.NumSub), nil
+	case "SHARDCHANNELS":
+		return formatChannels(args[1:], pubsub.ShardPS)
+	case "SHARDNUMSUB":
+		return formatNumSub(args[1:], pubsub.ShardPS.NumSub), nil
+	case "NUMPAT":
+		if len(args) != 1 {
+			return nil, errArgNumber
+		}
+
+		return protocol.FormatInt(pubsub.PS.NumPat(), false), nil
+	default:
+		return nil, fmt.Errorf("ERR unknown subcommand '%v'. Try PUBSUB HELP.", args[0])
+	}
+}
+
+func formatChannels(args []string, broker *pubsub.Broker) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, errArgNumber
+	}
+
+	pattern := ""
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+
+	return protocol.FormatBulkStringArray(broker.Channels(pattern)), nil
+}
+
+func formatNumSub(channels []string, numSub func(string) int) []byte {
+	reply := fmt.Appendf(nil, "*%d\r\n", len(channels)*2)
+
+	for _, channel := range channels {
+		reply = append(reply, protocol.FormatBulkString(channel)...)
+		reply = append(reply, protocol.FormatInt(numSub(channel), false)...)
+	}
+
+	return reply
+}
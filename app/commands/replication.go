@@ -0,0 +1,194 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"redis-clone-go/app/cluster"
+	"redis-clone-go/app/persistence"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/replication"
+	"strconv"
+	"time"
+)
+
+func ReplicaOf(args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errArgNumber
+	}
+
+	if cluster.Enabled() {
+		return nil, errors.New("ERR REPLICAOF not allowed in cluster mode.")
+	}
+
+	if err := replication.SetReplicaOf(args[0] + " " + args[1]); err != nil {
+		return nil, fmt.Errorf("ERR %w", err)
+	}
+
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+func Role(args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errArgNumber
+	}
+
+	var buf bytes.Buffer
+
+	if host, port, state, ok := replication.ReplicaState(); ok {
+		buf.WriteString("*5\r\n")
+		buf.Write(protocol.FormatBulkString("slave"))
+		buf.Write(protocol.FormatBulkString(host))
+		portNum, _ := strconv.Atoi(port)
+		buf.Write(protocol.FormatInt(portNum, false))
+		buf.Write(protocol.FormatBulkString(state))
+		buf.Write(protocol.FormatInt(int(replication.Offset()), false))
+		return buf.Bytes(), nil
+	}
+
+	replicas := replication.Replicas()
+
+	buf.WriteString("*3\r\n")
+	buf.Write(protocol.FormatBulkString("master"))
+	buf.Write(protocol.FormatInt(int(replication.Offset()), false))
+	fmt.Fprintf(&buf, "*%d\r\n", len(replicas))
+	for _, replica := range replicas {
+		ackOffset := strconv.FormatInt(replica.AckOffset(), 10)
+		buf.Write(protocol.FormatBulkStringArray([]string{replica.Host, replica.Port, ackOffset}))
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Wait blocks until numreplicas replicas acknowledged the client's last
+// write at offset woff, or the timeout in milliseconds passed. It returns
+// the number of replicas that did.
+func Wait(woff int64, noWait bool, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errArgNumber
+	}
+
+	if replication.IsReplica() {
+		return nil, errors.New("ERR WAIT cannot be used with replica instances")
+	}
+
+	numReplicas, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errNotInteger
+	}
+
+	timeoutChannel, err := parseWaitTimeout(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	acked, next := replication.Acked(woff, false)
+	if acked >= numReplicas || noWait {
+		return protocol.FormatInt(acked, false), nil
+	}
+
+	replication.RequestAck()
+
+	for {
+		select {
+		case <-next:
+			acked, next = replication.Acked(woff, false)
+			if acked >= numReplicas {
+				return protocol.FormatInt(acked, false), nil
+			}
+		case <-timeoutChannel:
+			acked, _ = replication.Acked(woff, false)
+			return protocol.FormatInt(acked, false), nil
+		}
+	}
+}
+
+// WaitAof blocks until the local AOF, if numlocal is 1, and numreplicas
+// replicas fsynced the client's last write at offset woff, or the timeout
+// in milliseconds passed. It returns whether the local AOF did and the
+// number of replicas that did.
+func WaitAof(woff int64, noWait bool, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, errArgNumber
+	}
+
+	if replication.IsReplica() {
+		return nil, errors.New("ERR WAITAOF cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated.")
+	}
+
+	numLocal, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, errNotInteger
+	}
+
+	numReplicas, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, errNotInteger
+	}
+
+	if numLocal > 0 && persistence.AppendOnly() == "no" {
+		return nil, errors.New("ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled.")
+	}
+
+	timeoutChannel, err := parseWaitTimeout(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	status := func() (int, int, <-chan struct{}, <-chan struct{}) {
+		local := 0
+		fsynced, nextFsync := persistence.FsyncedOffset()
+		if fsynced >= woff && persistence.AppendOnly() == "yes" {
+			local = 1
+		}
+
+		acked, nextAck := replication.Acked(woff, true)
+		return local, acked, nextFsync, nextAck
+	}
+
+	reply := func(local, acked int) []byte {
+		return fmt.Appendf(nil, "*2\r\n:%d\r\n:%d\r\n", local, acked)
+	}
+
+	local, acked, nextFsync, nextAck := status()
+	if local >= numLocal && acked >= numReplicas || noWait {
+		return reply(local, acked), nil
+	}
+
+	if acked < numReplicas {
+		replication.RequestAck()
+	}
+
+	for {
+		select {
+		case <-nextFsync:
+		case <-nextAck:
+		case <-timeoutChannel:
+			local, acked, _, _ = status()
+			return reply(local, acked), nil
+		}
+
+		local, acked, nextFsync, nextAck = status()
+		if local >= numLocal && acked >= numReplicas {
+			return reply(local, acked), nil
+		}
+	}
+}
+
+// parseWaitTimeout parses a timeout in milliseconds, 0 blocks forever.
+func parseWaitTimeout(arg string) (<-chan time.Time, error) {
+	timeoutMs, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return nil, errors.New("ERR timeout is not an integer or out of range")
+	}
+
+	if timeoutMs < 0 {
+		return nil, errors.New("ERR timeout is negative")
+	}
+
+	if timeoutMs == 0 {
+		return nil, nil
+	}
+
+	return time.After(time.Duration(timeoutMs) * time.Millisecond), nil
+}
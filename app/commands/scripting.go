@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/scripting"
+	"strconv"
+	"strings"
+)
+
+func Eval(call scripting.CallFunc, args []string) ([]byte, error) {
+	if len(args) < 2 {
+		return nil, errArgNumber
+	}
+
+	keys, argv, err := parseScriptArgs(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	sha, err := scripting.Scripts.Load(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return scripting.Scripts.Run(sha, keys, argv, call)
+}
+
+func EvalSha(call scripting.CallFunc, args []string) ([]byte, error) {
+	if len(args) < 2 {
+		return nil, errArgNumber
+	}
+
+	keys, argv, err := parseScriptArgs(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return scripting.Scripts.Run(args[0], keys, argv, call)
+}
+
+// parseScriptArgs splits "numkeys key [key ...] arg [arg ...]" into the
+// KEYS and ARGV of a script.
+func parseScriptArgs(args []string) ([]string, []string, error) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, nil, errNotInteger
+	}
+
+	if numKeys < 0 {
+		return nil, nil, errors.New("ERR Number of keys can't be negative")
+	}
+
+	if numKeys > len(args)-1 {
+		return nil, nil, errors.New("ERR Number of keys can't be greater than number of args")
+	}
+
+	return args[1 : numKeys+1], args[numKeys+1:], nil
+}
+
+func Script(args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errArgNumber
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "LOAD":
+		if len(args) != 2 {
+			return nil, errArgNumber
+		}
+
+		sha, err := scripting.Scripts.Load(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		return protocol.FormatBulkString(sha), nil
+	case "EXISTS":
+		if len(args) < 2 {
+			return nil, errArgNumber
+		}
+
+		reply := fmt.Appendf(nil, "*%d\r\n", len(args)-1)
+		for _, sha := range args[1:] {
+			exists := 0
+			if scripting.Scripts.Exists(sha) {
+				exists = 1
+			}
+
+			reply = append(reply, protocol.FormatInt(exists, false)...)
+		}
+
+		return reply, nil
+	case "FLUSH":
+		if len(args) > 2 {
+			return nil, errArgNumber
+		}
+
+		if len(args) == 2 && strings.ToUpper(args[1]) != "ASYNC" && strings.ToUpper(args[1]) != "SYNC" {
+			return nil, errors.New("ERR SCRIPT FLUSH only support SYNC|ASYNC option")
+		}
+
+		scripting.Scripts.Flush()
+		return protocol.FormatSimpleString("OK"), nil
+	case "KILL":
+		if len(args) != 1 {
+			return nil, errArgNumber
+		}
+
+		if err := scripting.Scripts.Kill(); err != nil {
+			return nil, err
+		}
+
+		return protocol.FormatSimpleString("OK"), nil
+	default:
+		return nil, fmt.Errorf("ERR unknown subcommand '%v'. Try SCRIPT HELP.", args[0])
+	}
+}
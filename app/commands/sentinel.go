@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/sentinel"
+	"strconv"
+	"strings"
+)
+
+func Sentinel(args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errArgNumber
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "get-master-addr-by-name":
+		if len(args) != 2 {
+			return nil, errArgNumber
+		}
+
+		host, port, ok := sentinel.MasterAddr(args[1])
+		if !ok {
+			return protocol.FormatNullArray(), nil
+		}
+
+		return protocol.FormatBulkStringArray([]string{host, port}), nil
+	case "masters":
+		if len(args) != 1 {
+			return nil, errArgNumber
+		}
+
+		return formatInfoList(sentinel.Masters()), nil
+	case "master":
+		if len(args) != 2 {
+			return nil, errArgNumber
+		}
+
+		info, err := sentinel.Master(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		return protocol.FormatBulkStringArray(info), nil
+	case "replicas", "slaves":
+		if len(args) != 2 {
+			return nil, errArgNumber
+		}
+
+		replicas, err := sentinel.Replicas(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		return formatInfoList(replicas), nil
+	case "sentinels":
+		if len(args) != 2 {
+			return nil, errArgNumber
+		}
+
+		sentinels, err := sentinel.Sentinels(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		return formatInfoList(sentinels), nil
+	case "is-master-down-by-addr":
+		if len(args) != 5 {
+			return nil, errArgNumber
+		}
+
+		epoch, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return nil, errNotInteger
+		}
+
+		down, leader, leaderEpoch := sentinel.IsMasterDownByAddr(args[1], args[2], epoch, args[4])
+
+		var buf bytes.Buffer
+		buf.WriteString("*3\r\n")
+		if down {
+			buf.Write(protocol.FormatInt(1, false))
+		} else {
+			buf.Write(protocol.FormatInt(0, false))
+		}
+		buf.Write(protocol.FormatBulkString(leader))
+		buf.Write(protocol.FormatInt(int(leaderEpoch), false))
+		return buf.Bytes(), nil
+	case "failover":
+		if len(args) != 2 {
+			return nil, errArgNumber
+		}
+
+		if err := sentinel.Failover(args[1]); err != nil {
+			return nil, err
+		}
+
+		return protocol.FormatSimpleString("OK"), nil
+	case "monitor":
+		if len(args) != 5 {
+			return nil, errArgNumber
+		}
+
+		if err := sentinel.Monitor(args[1], args[2], args[3], args[4]); err != nil {
+			return nil, err
+		}
+
+		return protocol.FormatSimpleString("OK"), nil
+	case "remove":
+		if len(args) != 2 {
+			return nil, errArgNumber
+		}
+
+		if err := sentinel.Remove(args[1]); err != nil {
+			return nil, err
+		}
+
+		return protocol.FormatSimpleString("OK"), nil
+	case "set":
+		if len(args) < 4 || len(args)%2 != 0 {
+			return nil, errArgNumber
+		}
+
+		if err := sentinel.Set(args[1], args[2:]); err != nil {
+			return nil, err
+		}
+
+		return protocol.FormatSimpleString("OK"), nil
+	case "myid":
+		if len(args) != 1 {
+			return nil, errArgNumber
+		}
+
+		return protocol.FormatBulkString(sentinel.MyId()), nil
+	default:
+		return nil, fmt.Errorf("ERR unknown subcommand '%v'. Try SENTINEL HELP.", args[0])
+	}
+}
+
+// SentinelRole is ROLE in sentinel mode, it lists the monitored masters.
+func SentinelRole(args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, errArgNumber
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("*2\r\n")
+	buf.Write(protocol.FormatBulkString("sentinel"))
+	buf.Write(protocol.FormatBulkStringArray(sentinel.MasterNames()))
+	return buf.Bytes(), nil
+}
+
+func formatInfoList(list [][]string) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(list))
+	for _, info := range list {
+		buf.Write(protocol.FormatBulkStringArray(info))
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,1185 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"redis-clone-go/app/notify"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/store"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errXGroupNoKey = errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+
+func XGroup(args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errArgNumber
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "CREATE":
+		return xGroupCreate(args[1:])
+	case "SETID":
+		return xGroupSetId(args[1:])
+	case "DESTROY":
+		return xGroupDestroy(args[1:])
+	case "CREATECONSUMER":
+		return xGroupCreateConsumer(args[1:])
+	case "DELCONSUMER":
+		return xGroupDelConsumer(args[1:])
+	default:
+		return nil, fmt.Errorf("ERR unknown subcommand '%v'. Try XGROUP HELP.", args[0])
+	}
+}
+
+func xGroupCreate(args []string) ([]byte, error) {
+	if len(args) < 3 {
+		return nil, errArgNumber
+	}
+
+	key, name := args[0], args[1]
+	mkStream := false
+	entriesRead := int64(-1)
+
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "MKSTREAM":
+			mkStream = true
+		case "ENTRIESREAD":
+			if i+1 == len(args) {
+				return nil, errSyntax
+			}
+
+			i++
+			parsed, err := parseEntriesRead(args[i])
+			if err != nil {
+				return nil, err
+			}
+
+			entriesRead = parsed
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	lastId, err := parseGroupId(args[2], store.StoredValue{})
+	if err != nil {
+		return nil, err
+	}
+
+	create := func(storedValue *store.StoredValue) error {
+		if storedValue.Type != store.TypeStream {
+			return errWrongtypeOperation
+		}
+
+		if !mkStream && storedValue.IsBlockedOnly() {
+			return errXGroupNoKey
+		}
+
+		if _, ok := storedValue.XGroups[name]; ok {
+			return errors.New("BUSYGROUP Consumer Group name already exists")
+		}
+
+		lastId, _ := parseGroupId(args[2], *storedValue)
+		storedValue.SetGroup(name, store.NewStreamGroup(lastId, entriesRead))
+		return nil
+	}
+
+	if mkStream {
+		_, err = store.CM.SetOrUpdate(
+			key,
+			func() store.StoredValue {
+				storedValue := store.NewStreamValue(nil)
+				storedValue.SetGroup(name, store.NewStreamGroup(lastId, entriesRead))
+				return storedValue
+			},
+			create,
+		)
+	} else {
+		_, err = store.CM.Update(key, create)
+	}
+
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			return nil, errXGroupNoKey
+		}
+
+		return nil, err
+	}
+
+	notify.KeyspaceEvent(notify.Stream, "xgroup-create", key)
+
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+func xGroupSetId(args []string) ([]byte, error) {
+	if len(args) != 3 && len(args) != 5 {
+		return nil, errArgNumber
+	}
+
+	key, name := args[0], args[1]
+	entriesRead := int64(-1)
+
+	if len(args) == 5 {
+		if strings.ToUpper(args[3]) != "ENTRIESREAD" {
+			return nil, errSyntax
+		}
+
+		parsed, err := parseEntriesRead(args[4])
+		if err != nil {
+			return nil, err
+		}
+
+		entriesRead = parsed
+	}
+
+	if _, err := parseGroupId(args[2], store.StoredValue{}); err != nil {
+		return nil, err
+	}
+
+	err := updateXGroup(key, func(storedValue *store.StoredValue) error {
+		group, ok := storedValue.XGroups[name]
+		if !ok {
+			return errNoGroup(key, name)
+		}
+
+		group.LastId, _ = parseGroupId(args[2], *storedValue)
+		group.EntriesRead = entriesRead
+		storedValue.SetGroup(name, group)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	notify.KeyspaceEvent(notify.Stream, "xgroup-setid", key)
+
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+func xGroupDestroy(args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errArgNumber
+	}
+
+	key, name := args[0], args[1]
+	destroyed := false
+
+	err := updateXGroup(key, func(storedValue *store.StoredValue) error {
+		if _, destroyed = storedValue.XGroups[name]; destroyed {
+			storedValue.DeleteGroup(name)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !destroyed {
+		return protocol.FormatInt(0, false), nil
+	}
+
+	notify.KeyspaceEvent(notify.Stream, "xgroup-destroy", key)
+
+	return protocol.FormatInt(1, false), nil
+}
+
+func xGroupCreateConsumer(args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, errArgNumber
+	}
+
+	key, name, consumer := args[0], args[1], args[2]
+	created := false
+
+	err := updateXGroup(key, func(storedValue *store.StoredValue) error {
+		group, ok := storedValue.XGroups[name]
+		if !ok {
+			return errNoGroup(key, name)
+		}
+
+		group = group.Clone()
+		created = createConsumer(&group, consumer, time.Now().UnixMilli())
+		storedValue.SetGroup(name, group)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !created {
+		return protocol.FormatInt(0, false), nil
+	}
+
+	notify.KeyspaceEvent(notify.Stream, "xgroup-createconsumer", key)
+
+	return protocol.FormatInt(1, false), nil
+}
+
+func xGroupDelConsumer(args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, errArgNumber
+	}
+
+	key, name, consumer := args[0], args[1], args[2]
+	pending := -1
+
+	err := updateXGroup(key, func(storedValue *store.StoredValue) error {
+		group, ok := storedValue.XGroups[name]
+		if !ok {
+			return errNoGroup(key, name)
+		}
+
+		if _, ok := group.Consumers[consumer]; !ok {
+			return nil
+		}
+
+		group = group.Clone()
+		pending = group.DeleteConsumer(consumer)
+		storedValue.SetGroup(name, group)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if pending == -1 {
+		return protocol.FormatInt(0, false), nil
+	}
+
+	notify.KeyspaceEvent(notify.Stream, "xgroup-delconsumer", key)
+
+	return protocol.FormatInt(pending, false), nil
+}
+
+// updateXGroup runs update on the stream of an XGROUP subcommand, which has
+// to exist.
+func updateXGroup(key string, update func(storedValue *store.StoredValue) error) error {
+	_, err := store.CM.Update(key, func(storedValue *store.StoredValue) error {
+		if storedValue.Type != store.TypeStream {
+			return errWrongtypeOperation
+		}
+
+		if storedValue.IsBlockedOnly() {
+			return errXGroupNoKey
+		}
+
+		return update(storedValue)
+	})
+
+	if errors.Is(err, store.ErrKeyNotFound) {
+		return errXGroupNoKey
+	}
+
+	return err
+}
+
+// parseGroupId parses the last id of a group, "$" is the last id of the
+// stream.
+func parseGroupId(id string, storedValue store.StoredValue) (store.StreamId, error) {
+	if id == "$" {
+		return storedValue.XMeta.LastId, nil
+	}
+
+	return parseStreamIdOrMs(id)
+}
+
+func parseEntriesRead(arg string) (int64, error) {
+	entriesRead, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+
+	if entriesRead < -1 {
+		return 0, errors.New("ERR value for ENTRIESREAD must be positive or -1")
+	}
+
+	return entriesRead, nil
+}
+
+func errNoGroup(key, name string) error {
+	return fmt.Errorf("NOGROUP No such consumer group '%v' for key name '%v'", name, key)
+}
+
+// createConsumer adds a consumer to a group unless it exists, it returns
+// whether it did.
+func createConsumer(group *store.StreamGroup, name string, now int64) bool {
+	if _, ok := group.Consumers[name]; ok {
+		return false
+	}
+
+	group.Consumers[name] = store.NewStreamConsumer(now)
+	return true
+}
+
+// touchConsumer creates a consumer if needed and records that it was seen.
+// The commands replaying its creation are returned.
+func touchConsumer(key, name string, group *store.StreamGroup, consumerName string, now int64) [][]string {
+	effects := [][]string{}
+	if createConsumer(group, consumerName, now) {
+		effects = append(effects, []string{"XGROUP", "CREATECONSUMER", key, name, consumerName})
+		notify.KeyspaceEvent(notify.Stream, "xgroup-createconsumer", key)
+	}
+
+	consumer := group.Consumers[consumerName]
+	consumer.SeenTime = now
+	group.Consumers[consumerName] = consumer
+	return effects
+}
+
+// activateConsumer records that a consumer got entries.
+func activateConsumer(group *store.StreamGroup, consumerName string, now int64) {
+	consumer := group.Consumers[consumerName]
+	consumer.ActiveTime = now
+	group.Consumers[consumerName] = consumer
+}
+
+// xClaimEffect is the XCLAIM replaying the delivery of a pending entry,
+// like Redis propagates it.
+func xClaimEffect(key, name, consumer string, id store.StreamId, pending store.PendingEntry, lastId store.StreamId) []string {
+	return []string{
+		"XCLAIM", key, name, consumer, "0", id.String(),
+		"TIME", strconv.FormatInt(pending.DeliveryTime, 10),
+		"RETRYCOUNT", strconv.FormatInt(pending.DeliveryCount, 10),
+		"FORCE", "JUSTID", "LASTID", lastId.String(),
+	}
+}
+
+type XReadGroupArgs struct {
+	XReadArgs
+	Group    string
+	Consumer string
+	NoAck    bool
+}
+
+func ParseXReadGroupArgs(args []string) (*XReadGroupArgs, error) {
+	if len(args) < 3 || strings.ToUpper(args[0]) != "GROUP" {
+		return nil, errors.New("ERR Missing GROUP option for XREADGROUP")
+	}
+
+	parsed := &XReadGroupArgs{Group: args[1], Consumer: args[2]}
+
+	i := 3
+	for ; i < len(args) && strings.ToUpper(args[i]) != "STREAMS"; i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 == len(args) {
+				return nil, errSyntax
+			}
+
+			i++
+			count, err := strconv.Atoi(args[i])
+			if err != nil {
+				return nil, errNotInteger
+			}
+
+			//a count of 0 or less means no limit
+			parsed.Count = max(0, count)
+		case "BLOCK":
+			if i+1 == len(args) {
+				return nil, errSyntax
+			}
+
+			i++
+			timeoutMs, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return nil, errNotInteger
+			}
+
+			if timeoutMs < 0 {
+				return nil, errors.New("ERR timeout is negative")
+			}
+
+			parsed.Block = true
+			parsed.Timeout = time.Duration(timeoutMs) * time.Millisecond
+		case "NOACK":
+			parsed.NoAck = true
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	if i == len(args) {
+		return nil, errSyntax
+	}
+
+	keysAndIds := args[i+1:]
+	if len(keysAndIds) == 0 {
+		return nil, errArgNumber
+	}
+
+	if len(keysAndIds)%2 != 0 {
+		return nil, errors.New("ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified.")
+	}
+
+	numKeys := len(keysAndIds) / 2
+	parsed.Keys = keysAndIds[:numKeys]
+	parsed.Ids = keysAndIds[numKeys:]
+
+	for _, id := range parsed.Ids {
+		if id == "$" {
+			return nil, errors.New("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		}
+
+		if _, err := parseStreamIdOrMs(id); id != ">" && err != nil {
+			return nil, err
+		}
+	}
+
+	return parsed, nil
+}
+
+// XReadGroup reads for a consumer of a group, under the lock of the writes.
+// It returns the commands replaying what it changed. With ">" it reads the
+// entries no consumer of the group got yet, otherwise the ones pending for
+// the consumer after the id. When there is nothing to read and it may
+// block, it waits for entries to be added and returns a func waiting for
+// them, which returns false if the timeout comes first.
+func XReadGroup(args *XReadGroupArgs) ([]byte, [][]string, func(timeout <-chan time.Time) (bool, error), error) {
+	//all the groups have to exist before any of them is read from
+	for _, key := range args.Keys {
+		storedValue, ok := store.CM.Get(key)
+		if ok && storedValue.Type != store.TypeStream {
+			return nil, nil, nil, errWrongtypeOperation
+		}
+
+		if _, exists := storedValue.XGroups[args.Group]; !exists {
+			return nil, nil, nil, fmt.Errorf("NOGROUP No such key '%v' or consumer group '%v' in XREADGROUP with GROUP option", key, args.Group)
+		}
+	}
+
+	results := []xReadResult{}
+	effects := [][]string{}
+	now := time.Now().UnixMilli()
+
+	for i, key := range args.Keys {
+		result := xReadResult{key, []store.StreamEntry{}}
+
+		_, err := store.CM.Update(key, func(storedValue *store.StoredValue) error {
+			group := storedValue.XGroups[args.Group].Clone()
+			effects = append(effects, touchConsumer(key, args.Group, &group, args.Consumer, now)...)
+
+			var readEffects [][]string
+			if args.Ids[i] == ">" {
+				result.entries, readEffects = readNewEntries(storedValue, &group, key, args, now)
+			} else {
+				id, _ := parseStreamIdOrMs(args.Ids[i])
+				result.entries, readEffects = readPendingEntries(storedValue, &group, key, id, args, now)
+			}
+
+			if len(result.entries) > 0 {
+				activateConsumer(&group, args.Consumer, now)
+			}
+
+			effects = append(effects, readEffects...)
+			storedValue.SetGroup(args.Group, group)
+			return nil
+		})
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		//the history is returned even if it's empty
+		if len(result.entries) > 0 || args.Ids[i] != ">" {
+			results = append(results, result)
+		}
+	}
+
+	if len(results) > 0 {
+		return FormatXReadResponse(results), effects, nil, nil
+	}
+
+	if !args.Block {
+		return protocol.FormatNullBulkString(), effects, nil, nil
+	}
+
+	wait, err := waitForGroupEntries(args)
+	return nil, effects, wait, err
+}
+
+// readNewEntries delivers the entries after the group's last id to the
+// consumer, adding them to its PEL unless NOACK is given.
+func readNewEntries(storedValue *store.StoredValue, group *store.StreamGroup, key string, args *XReadGroupArgs, now int64) ([]store.StreamEntry, [][]string) {
+	effects := [][]string{}
+
+	from, ok := group.LastId.Next()
+	if !ok {
+		return []store.StreamEntry{}, effects
+	}
+
+	maxId := store.StreamId{Ms: math.MaxInt64, Sequence: math.MaxInt64}
+	entries := storedValue.Xval.Range(from, maxId, args.Count)
+
+	for _, entry := range entries {
+		storedValue.AdvanceGroup(group, entry.Id)
+
+		if !args.NoAck {
+			pending := store.PendingEntry{Consumer: args.Consumer, DeliveryTime: now, DeliveryCount: 1}
+			group.Deliver(entry.Id, args.Consumer, pending.DeliveryTime, pending.DeliveryCount)
+			effects = append(effects, xClaimEffect(key, args.Group, args.Consumer, entry.Id, pending, group.LastId))
+		}
+	}
+
+	if len(entries) > 0 {
+		entriesRead := strconv.FormatInt(group.EntriesRead, 10)
+		effects = append(effects, []string{"XGROUP", "SETID", key, args.Group, group.LastId.String(), "ENTRIESREAD", entriesRead})
+	}
+
+	return entries, effects
+}
+
+// readPendingEntries delivers the entries pending for the consumer after id
+// again. The ones deleted from the stream are returned without their pairs.
+func readPendingEntries(storedValue *store.StoredValue, group *store.StreamGroup, key string, id store.StreamId, args *XReadGroupArgs, now int64) ([]store.StreamEntry, [][]string) {
+	entries := []store.StreamEntry{}
+	effects := [][]string{}
+
+	from, ok := id.Next()
+	if !ok {
+		return entries, effects
+	}
+
+	consumer := group.Consumers[args.Consumer]
+
+	for pendingId := range consumer.Pending.Ascend(from) {
+		if args.Count > 0 && len(entries) == args.Count {
+			break
+		}
+
+		found := storedValue.Xval.Range(pendingId, pendingId, 1)
+		if len(found) == 0 {
+			entries = append(entries, store.StreamEntry{Id: pendingId})
+			continue
+		}
+
+		pending, _ := group.Pending.Get(pendingId)
+		pending.DeliveryTime = now
+		pending.DeliveryCount++
+		group.Deliver(pendingId, args.Consumer, pending.DeliveryTime, pending.DeliveryCount)
+
+		entries = append(entries, found[0])
+		effects = append(effects, xClaimEffect(key, args.Group, args.Consumer, pendingId, pending, group.LastId))
+	}
+
+	return entries, effects
+}
+
+// waitForGroupEntries waits for entries after the last ids of the groups.
+func waitForGroupEntries(args *XReadGroupArgs) (func(timeout <-chan time.Time) (bool, error), error) {
+	listeners := make([]store.StreamListener, 0, len(args.Keys))
+	for _, key := range args.Keys {
+		listener := store.StreamListener{C: make(chan store.StreamEntry, 1), Key: key}
+
+		_, err := store.CM.UpdateQuietly(key, func(storedValue *store.StoredValue) error {
+			listener.Id = storedValue.XGroups[args.Group].LastId
+			storedValue.AddStreamListener(listener)
+			return nil
+		})
+
+		if err != nil {
+			if removeErr := removeStreamListeners(listeners); removeErr != nil {
+				return nil, fmt.Errorf("error removing stream listeners: %w", removeErr)
+			}
+
+			return nil, err
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	readyChannel := make(chan struct{}, len(listeners))
+	listenForResult(listeners, readyChannel)
+
+	return func(timeout <-chan time.Time) (bool, error) {
+		ready := false
+		select {
+		case <-readyChannel:
+			ready = true
+		case <-timeout:
+		}
+
+		if err := removeStreamListeners(listeners); err != nil {
+			return false, fmt.Errorf("error removing stream listeners: %w", err)
+		}
+
+		return ready, nil
+	}, nil
+}
+
+func XAck(args []string) ([]byte, error) {
+	if len(args) < 3 {
+		return nil, errArgNumber
+	}
+
+	key, name := args[0], args[1]
+
+	ids := make([]store.StreamId, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, err := parseStreamIdOrMs(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	acked := 0
+	_, err := store.CM.Update(key, func(storedValue *store.StoredValue) error {
+		if storedValue.Type != store.TypeStream {
+			return errWrongtypeOperation
+		}
+
+		group, ok := storedValue.XGroups[name]
+		if !ok {
+			return nil
+		}
+
+		group = group.Clone()
+		for _, id := range ids {
+			if group.Ack(id) {
+				acked++
+			}
+		}
+
+		storedValue.SetGroup(name, group)
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+		return nil, err
+	}
+
+	return protocol.FormatInt(acked, false), nil
+}
+
+// XPending summarizes the PEL of a group, or lists its entries with the
+// extended form XPENDING key group [IDLE min-idle-time] start end count
+// [consumer].
+func XPending(args []string) ([]byte, error) {
+	if len(args) < 2 {
+		return nil, errArgNumber
+	}
+
+	key, name := args[0], args[1]
+	args = args[2:]
+
+	minIdle := int64(0)
+	if len(args) > 0 && strings.ToUpper(args[0]) == "IDLE" {
+		if len(args) < 2 {
+			return nil, errSyntax
+		}
+
+		parsed, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, errNotInteger
+		}
+
+		minIdle = parsed
+		args = args[2:]
+
+		//IDLE only filters the extended form
+		if len(args) == 0 {
+			return nil, errSyntax
+		}
+	}
+
+	if len(args) != 0 && len(args) != 3 && len(args) != 4 {
+		return nil, errSyntax
+	}
+
+	if len(args) == 0 {
+		group, err := getGroup(key, name)
+		if err != nil {
+			return nil, err
+		}
+
+		return formatPendingSummary(group), nil
+	}
+
+	start, err := parseIntervalId(args[0], true)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := parseIntervalId(args[1], false)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		return nil, errNotInteger
+	}
+
+	group, err := getGroup(key, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := group.Pending.Ascend(start)
+	if len(args) == 4 {
+		consumer, ok := group.Consumers[args[3]]
+		if !ok {
+			return protocol.FormatBulkStringArray([]string{}), nil
+		}
+
+		ids = func(yield func(store.StreamId, store.PendingEntry) bool) {
+			for id := range consumer.Pending.Ascend(start) {
+				pending, _ := group.Pending.Get(id)
+				if !yield(id, pending) {
+					return
+				}
+			}
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	rows := 0
+	var buf bytes.Buffer
+
+	for id, pending := range ids {
+		if rows >= count || id.IsGreaterThan(end) {
+			break
+		}
+
+		idle := now - pending.DeliveryTime
+		if idle < minIdle {
+			continue
+		}
+
+		buf.WriteString("*4\r\n")
+		buf.Write(protocol.FormatBulkString(id.String()))
+		buf.Write(protocol.FormatBulkString(pending.Consumer))
+		buf.Write(protocol.FormatInt(int(idle), false))
+		buf.Write(protocol.FormatInt(int(pending.DeliveryCount), false))
+		rows++
+	}
+
+	return append(fmt.Appendf(nil, "*%d\r\n", rows), buf.Bytes()...), nil
+}
+
+func formatPendingSummary(group store.StreamGroup) []byte {
+	if group.Pending.Len() == 0 {
+		return []byte("*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n")
+	}
+
+	first, _, _ := group.Pending.First()
+	last, _, _ := group.Pending.Last()
+
+	//consumers are listed by name, like Redis does
+	names := []string{}
+	for name, consumer := range group.Consumers {
+		if consumer.Pending.Len() > 0 {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+
+	var buf bytes.Buffer
+	buf.WriteString("*4\r\n")
+	buf.Write(protocol.FormatInt(group.Pending.Len(), false))
+	buf.Write(protocol.FormatBulkString(first.String()))
+	buf.Write(protocol.FormatBulkString(last.String()))
+
+	fmt.Fprintf(&buf, "*%d\r\n", len(names))
+	for _, name := range names {
+		consumer := group.Consumers[name]
+		pending := strconv.Itoa(consumer.Pending.Len())
+		buf.Write(protocol.FormatBulkStringArray([]string{name, pending}))
+	}
+
+	return buf.Bytes()
+}
+
+// getGroup returns a group of a stream, for the commands that fail when
+// either doesn't exist.
+func getGroup(key, name string) (store.StreamGroup, error) {
+	storedValue, ok := store.CM.Get(key)
+	if ok && storedValue.Type != store.TypeStream {
+		return store.StreamGroup{}, errWrongtypeOperation
+	}
+
+	group, exists := storedValue.XGroups[name]
+	if !exists {
+		return group, errNoKeyOrGroup(key, name)
+	}
+
+	return group, nil
+}
+
+func errNoKeyOrGroup(key, name string) error {
+	return fmt.Errorf("NOGROUP No such key '%v' or consumer group '%v'", key, name)
+}
+
+// parseIntervalId parses a bound of an interval of ids. "-" and "+" are the
+// smallest and the greatest id, and a "(" prefix excludes the id.
+func parseIntervalId(id string, start bool) (store.StreamId, error) {
+	bound := "end"
+	if start {
+		bound = "start"
+	}
+
+	switch id {
+	case "-":
+		return store.StreamId{Ms: 0, Sequence: 0}, nil
+	case "+":
+		return store.StreamId{Ms: math.MaxInt64, Sequence: math.MaxInt64}, nil
+	}
+
+	exclusive := strings.HasPrefix(id, "(")
+	parsed, err := parseStreamIdOrMs(strings.TrimPrefix(id, "("))
+	if err != nil || !exclusive {
+		return parsed, err
+	}
+
+	ok := false
+	if start {
+		parsed, ok = parsed.Next()
+	} else {
+		parsed, ok = parsed.Prev()
+	}
+
+	if !ok {
+		return parsed, fmt.Errorf("ERR invalid %v ID for the interval", bound)
+	}
+
+	return parsed, nil
+}
+
+// claimArgs are the options shared by XCLAIM and XAUTOCLAIM.
+type claimArgs struct {
+	key          string
+	group        string
+	consumer     string
+	minIdle      int64
+	deliveryTime int64
+	retryCount   int64
+	force        bool
+	justId       bool
+}
+
+func parseClaimArgs(args []string, command string) (claimArgs, error) {
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		return claimArgs{}, fmt.Errorf("ERR Invalid min-idle-time argument for %v", command)
+	}
+
+	return claimArgs{
+		key:          args[0],
+		group:        args[1],
+		consumer:     args[2],
+		minIdle:      max(0, minIdle),
+		deliveryTime: time.Now().UnixMilli(),
+		retryCount:   -1,
+	}, nil
+}
+
+// claim hands a pending entry to the consumer unless it was idle for less
+// than minIdle. Entries deleted from the stream are acknowledged instead,
+// and the second result is false then.
+func (c *claimArgs) claim(storedValue *store.StoredValue, group *store.StreamGroup, id store.StreamId, pending store.PendingEntry) (store.StreamEntry, bool, [][]string) {
+	found := storedValue.Xval.Range(id, id, 1)
+	if len(found) == 0 {
+		group.Ack(id)
+		return store.StreamEntry{Id: id}, false, [][]string{{"XACK", c.key, c.group, id.String()}}
+	}
+
+	pending.DeliveryTime = c.deliveryTime
+	switch {
+	case c.retryCount >= 0:
+		pending.DeliveryCount = c.retryCount
+	case !c.justId:
+		pending.DeliveryCount++
+	}
+
+	group.Deliver(id, c.consumer, pending.DeliveryTime, pending.DeliveryCount)
+	return found[0], true, [][]string{xClaimEffect(c.key, c.group, c.consumer, id, pending, group.LastId)}
+}
+
+func (c *claimArgs) isIdle(pending store.PendingEntry, now int64) bool {
+	return c.minIdle == 0 || now-pending.DeliveryTime >= c.minIdle
+}
+
+func (c *claimArgs) format(claimed []store.StreamEntry) []byte {
+	if !c.justId {
+		return FormatStreamEntries(claimed)
+	}
+
+	ids := make([]string, len(claimed))
+	for i, entry := range claimed {
+		ids[i] = entry.Id.String()
+	}
+
+	return protocol.FormatBulkStringArray(ids)
+}
+
+// XClaim hands pending entries to a consumer and returns the commands
+// replaying it, like XCLAIM key group consumer min-idle-time id [id ...]
+// [IDLE ms] [TIME unix-time-ms] [RETRYCOUNT count] [FORCE] [JUSTID]
+// [LASTID id].
+func XClaim(args []string) ([]byte, [][]string, error) {
+	if len(args) < 5 {
+		return nil, nil, errArgNumber
+	}
+
+	c, err := parseClaimArgs(args, "XCLAIM")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	i := 4
+	ids := []store.StreamId{}
+	for ; i < len(args); i++ {
+		id, err := parseStreamIdOrMs(args[i])
+		if err != nil {
+			break
+		}
+
+		ids = append(ids, id)
+	}
+
+	now := c.deliveryTime
+	lastId := store.StreamId{}
+
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch option {
+		case "FORCE":
+			c.force = true
+			continue
+		case "JUSTID":
+			c.justId = true
+			continue
+		case "IDLE", "TIME", "RETRYCOUNT", "LASTID":
+			if i+1 == len(args) {
+				return nil, nil, errSyntax
+			}
+		default:
+			return nil, nil, fmt.Errorf("ERR Unrecognized XCLAIM option '%v'", args[i])
+		}
+
+		i++
+		if option == "LASTID" {
+			if lastId, err = parseStreamIdOrMs(args[i]); err != nil {
+				return nil, nil, err
+			}
+
+			continue
+		}
+
+		value, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ERR Invalid %v option argument for XCLAIM", option)
+		}
+
+		switch option {
+		case "IDLE":
+			c.deliveryTime = now - value
+		case "TIME":
+			c.deliveryTime = value
+		case "RETRYCOUNT":
+			c.retryCount = value
+		}
+	}
+
+	if c.deliveryTime < 0 || c.deliveryTime > now {
+		c.deliveryTime = now
+	}
+
+	claimed := []store.StreamEntry{}
+	effects := [][]string{}
+
+	_, err = store.CM.Update(c.key, func(storedValue *store.StoredValue) error {
+		if storedValue.Type != store.TypeStream {
+			return errWrongtypeOperation
+		}
+
+		group, ok := storedValue.XGroups[c.group]
+		if !ok {
+			return errNoKeyOrGroup(c.key, c.group)
+		}
+
+		group = group.Clone()
+		effects = touchConsumer(c.key, c.group, &group, c.consumer, now)
+
+		lastIdMoved := lastId.IsGreaterThan(group.LastId)
+		if lastIdMoved {
+			group.LastId = lastId
+		}
+
+		claimEffects := [][]string{}
+		for _, id := range ids {
+			pending, ok := group.Pending.Get(id)
+			if !ok {
+				//FORCE creates the pending entries of existing entries
+				if !c.force || len(storedValue.Xval.Range(id, id, 1)) == 0 {
+					continue
+				}
+
+				pending = store.PendingEntry{Consumer: c.consumer, DeliveryTime: now}
+			}
+
+			if !c.isIdle(pending, now) {
+				continue
+			}
+
+			entry, delivered, entryEffects := c.claim(storedValue, &group, id, pending)
+			claimEffects = append(claimEffects, entryEffects...)
+			if delivered {
+				claimed = append(claimed, entry)
+			}
+		}
+
+		if lastIdMoved && len(claimEffects) == 0 {
+			entriesRead := strconv.FormatInt(group.EntriesRead, 10)
+			claimEffects = append(claimEffects, []string{"XGROUP", "SETID", c.key, c.group, group.LastId.String(), "ENTRIESREAD", entriesRead})
+		}
+
+		if len(claimed) > 0 {
+			activateConsumer(&group, c.consumer, now)
+		}
+
+		effects = append(effects, claimEffects...)
+		storedValue.SetGroup(c.group, group)
+		return nil
+	})
+
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			return nil, nil, errNoKeyOrGroup(c.key, c.group)
+		}
+
+		return nil, nil, err
+	}
+
+	return c.format(claimed), effects, nil
+}
+
+// XAutoClaim claims the entries pending for longer than min-idle-time,
+// scanning the PEL from start like XAUTOCLAIM key group consumer
+// min-idle-time start [COUNT count] [JUSTID]. It returns the id to
+// continue from, 0-0 once the scan is over, with the claimed entries and
+// the ids of the deleted ones.
+func XAutoClaim(args []string) ([]byte, [][]string, error) {
+	if len(args) < 5 {
+		return nil, nil, errArgNumber
+	}
+
+	c, err := parseClaimArgs(args, "XAUTOCLAIM")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	start, err := parseIntervalId(args[4], true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	count := 100
+	for i := 5; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "JUSTID":
+			c.justId = true
+		case "COUNT":
+			if i+1 == len(args) {
+				return nil, nil, errSyntax
+			}
+
+			i++
+			parsed, err := strconv.Atoi(args[i])
+			if err != nil {
+				return nil, nil, errNotInteger
+			}
+
+			if parsed < 1 || parsed > math.MaxInt32 {
+				return nil, nil, errors.New("ERR COUNT must be > 0")
+			}
+
+			count = parsed
+		default:
+			return nil, nil, errSyntax
+		}
+	}
+
+	now := c.deliveryTime
+	next := store.StreamId{}
+	claimed := []store.StreamEntry{}
+	deleted := []string{}
+	effects := [][]string{}
+
+	_, err = store.CM.Update(c.key, func(storedValue *store.StoredValue) error {
+		if storedValue.Type != store.TypeStream {
+			return errWrongtypeOperation
+		}
+
+		group, ok := storedValue.XGroups[c.group]
+		if !ok {
+			return errNoKeyOrGroup(c.key, c.group)
+		}
+
+		group = group.Clone()
+		effects = touchConsumer(c.key, c.group, &group, c.consumer, now)
+		claimEffects := [][]string{}
+
+		//like Redis, at most 10 entries are looked at for each to claim.
+		//Claiming copies the PEL, the scan goes on over the one it started
+		//with.
+		attempts := count * 10
+		pel := group.Pending
+		for id, pending := range pel.Ascend(start) {
+			if attempts == 0 || len(claimed) == count {
+				next = id
+				break
+			}
+
+			attempts--
+			if !c.isIdle(pending, now) {
+				continue
+			}
+
+			entry, delivered, entryEffects := c.claim(storedValue, &group, id, pending)
+			claimEffects = append(claimEffects, entryEffects...)
+			if delivered {
+				claimed = append(claimed, entry)
+			} else {
+				deleted = append(deleted, id.String())
+			}
+		}
+
+		if len(claimed) > 0 {
+			activateConsumer(&group, c.consumer, now)
+		}
+
+		effects = append(effects, claimEffects...)
+		storedValue.SetGroup(c.group, group)
+		return nil
+	})
+
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			return nil, nil, errNoKeyOrGroup(c.key, c.group)
+		}
+
+		return nil, nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("*3\r\n")
+	buf.Write(protocol.FormatBulkString(next.String()))
+	buf.Write(c.format(claimed))
+	buf.Write(protocol.FormatBulkStringArray(deleted))
+
+	return buf.Bytes(), effects, nil
+}
@@ -0,0 +1,228 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/store"
+	"slices"
+	"testing"
+)
+
+func parseTestReply(t *testing.T, response []byte) protocol.Reply {
+	t.Helper()
+
+	reply, err := protocol.ParseReply(bufio.NewReader(bytes.NewReader(response)))
+	if err != nil {
+		t.Fatalf("ParseReply(%q) error = %v", response, err)
+	}
+
+	return reply
+}
+
+// replyIds returns the ids of a reply listing entries or ids.
+func replyIds(reply protocol.Reply) []string {
+	ids := []string{}
+	for _, element := range reply.Array {
+		if element.Type == protocol.ArrayReply {
+			ids = append(ids, element.Array[0].Str)
+		} else {
+			ids = append(ids, element.Str)
+		}
+	}
+
+	return ids
+}
+
+func xReadGroup(t *testing.T, args ...string) (protocol.Reply, [][]string) {
+	t.Helper()
+
+	parsed, err := ParseXReadGroupArgs(args)
+	if err != nil {
+		t.Fatalf("ParseXReadGroupArgs(%q) error = %v", args, err)
+	}
+
+	response, effects, _, err := XReadGroup(parsed)
+	if err != nil {
+		t.Fatalf("XReadGroup(%q) error = %v", args, err)
+	}
+
+	return parseTestReply(t, response), effects
+}
+
+func pendingOf(t *testing.T, key, group string) store.StreamGroup {
+	t.Helper()
+
+	storedValue, _ := store.CM.Get(key)
+	return storedValue.XGroups[group]
+}
+
+func TestXReadGroup(t *testing.T) {
+	store.CM.Set("stream", newTestStream(6)) // 1-0 1-1 2-0 2-1 3-0 3-1
+	defer store.CM.Delete("stream")
+
+	if _, err := XGroup([]string{"CREATE", "stream", "group", "0"}); err != nil {
+		t.Fatalf("XGROUP CREATE error = %v", err)
+	}
+
+	if _, err := XGroup([]string{"CREATE", "stream", "group", "0"}); err == nil {
+		t.Errorf("XGROUP CREATE of an existing group succeeded")
+	}
+
+	reply, effects := xReadGroup(t, "GROUP", "group", "alice", "COUNT", "2", "STREAMS", "stream", ">")
+	if got := replyIds(reply.Array[0].Array[1]); !slices.Equal(got, []string{"1-0", "1-1"}) {
+		t.Errorf("alice read %v", got)
+	}
+
+	//the consumer is created, each entry claimed and the last id set
+	wantEffects := []string{"CREATECONSUMER", "XCLAIM", "XCLAIM", "SETID"}
+	gotEffects := []string{}
+	for _, effect := range effects {
+		if effect[0] == "XGROUP" {
+			gotEffects = append(gotEffects, effect[1])
+		} else {
+			gotEffects = append(gotEffects, effect[0])
+		}
+	}
+
+	if !slices.Equal(gotEffects, wantEffects) {
+		t.Errorf("effects = %q", effects)
+	}
+
+	reply, _ = xReadGroup(t, "GROUP", "group", "bob", "STREAMS", "stream", ">")
+	if got := replyIds(reply.Array[0].Array[1]); !slices.Equal(got, []string{"2-0", "2-1", "3-0", "3-1"}) {
+		t.Errorf("bob read %v", got)
+	}
+
+	if reply, _ = xReadGroup(t, "GROUP", "group", "bob", "STREAMS", "stream", ">"); !reply.IsNull {
+		t.Errorf("bob read again %v", reply)
+	}
+
+	//the history of a consumer is delivered again
+	reply, _ = xReadGroup(t, "GROUP", "group", "alice", "STREAMS", "stream", "0")
+	if got := replyIds(reply.Array[0].Array[1]); !slices.Equal(got, []string{"1-0", "1-1"}) {
+		t.Errorf("alice history %v", got)
+	}
+
+	if pending, _ := pendingOf(t, "stream", "group").Pending.Get(store.StreamId{Ms: 1}); pending.DeliveryCount != 2 {
+		t.Errorf("1-0 was delivered %d times, want 2", pending.DeliveryCount)
+	}
+
+	response, err := XAck([]string{"stream", "group", "1-0", "2-0", "9-0"})
+	if err != nil || string(response) != ":2\r\n" {
+		t.Errorf("XACK = %q, %v", response, err)
+	}
+
+	reply = parseTestReply(t, mustSucceed(t)(XPending([]string{"stream", "group"})))
+	if reply.Array[0].Int != 4 || reply.Array[1].Str != "1-1" || reply.Array[2].Str != "3-1" {
+		t.Errorf("XPENDING summary = %+v", reply)
+	}
+
+	reply = parseTestReply(t, mustSucceed(t)(XPending([]string{"stream", "group", "-", "+", "10", "bob"})))
+	if got := replyIds(reply); !slices.Equal(got, []string{"2-1", "3-0", "3-1"}) {
+		t.Errorf("XPENDING of bob = %v", got)
+	}
+
+	if storedValue, _ := store.CM.Get("stream"); storedValue.Lag(pendingOf(t, "stream", "group")) != 0 {
+		t.Errorf("lag = %d, want 0", storedValue.Lag(pendingOf(t, "stream", "group")))
+	}
+}
+
+func mustSucceed(t *testing.T) func(response []byte, err error) []byte {
+	return func(response []byte, err error) []byte {
+		t.Helper()
+
+		if err != nil {
+			t.Fatalf("error = %v", err)
+		}
+
+		return response
+	}
+}
+
+func TestXClaim(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		want       []string
+		wantOwner  string
+		wantCount  int64
+		wantEffect int
+	}{
+		{"claim", []string{"0", "1-0"}, []string{"1-0"}, "bob", 2, 1},
+		{"not idle long enough", []string{"3600000", "1-0"}, []string{}, "alice", 1, 0},
+		{"idle option", []string{"3600000", "1-0", "IDLE", "7200000"}, []string{}, "alice", 1, 0},
+		{"justid doesn't count a delivery", []string{"0", "1-0", "JUSTID"}, []string{"1-0"}, "bob", 1, 1},
+		{"retrycount", []string{"0", "1-0", "RETRYCOUNT", "5"}, []string{"1-0"}, "bob", 5, 1},
+		{"not pending", []string{"0", "2-0"}, []string{}, "alice", 1, 0},
+		{"force", []string{"0", "2-0", "FORCE"}, []string{"2-0"}, "alice", 1, 1},
+		{"lastid", []string{"0", "2-0", "LASTID", "2-0"}, []string{}, "alice", 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.CM.Set("stream", newTestStream(6))
+			defer store.CM.Delete("stream")
+
+			mustSucceed(t)(XGroup([]string{"CREATE", "stream", "group", "0"}))
+			xReadGroup(t, "GROUP", "group", "alice", "COUNT", "2", "STREAMS", "stream", ">")
+
+			response, effects, err := XClaim(append([]string{"stream", "group", "bob"}, tt.args...))
+			if err != nil {
+				t.Fatalf("XClaim() error = %v", err)
+			}
+
+			if got := replyIds(parseTestReply(t, response)); !slices.Equal(got, tt.want) {
+				t.Errorf("XClaim() = %v, want %v", got, tt.want)
+			}
+
+			//bob is created first
+			if len(effects) != 1+tt.wantEffect {
+				t.Errorf("effects = %q", effects)
+			}
+
+			if pending, _ := pendingOf(t, "stream", "group").Pending.Get(store.StreamId{Ms: 1}); pending.Consumer != tt.wantOwner || pending.DeliveryCount != tt.wantCount {
+				t.Errorf("1-0 is pending %+v", pending)
+			}
+		})
+	}
+}
+
+func TestXAutoClaim(t *testing.T) {
+	store.CM.Set("stream", newTestStream(6))
+	defer store.CM.Delete("stream")
+
+	mustSucceed(t)(XGroup([]string{"CREATE", "stream", "group", "0"}))
+	xReadGroup(t, "GROUP", "group", "alice", "STREAMS", "stream", ">")
+	mustSucceed(t)(XTrim([]string{"stream", "MINID", "2-0"}))
+
+	response, effects, err := XAutoClaim([]string{"stream", "group", "bob", "0", "-", "COUNT", "2"})
+	if err != nil {
+		t.Fatalf("XAutoClaim() error = %v", err)
+	}
+
+	reply := parseTestReply(t, response)
+	if reply.Array[0].Str != "3-0" || !slices.Equal(replyIds(reply.Array[1]), []string{"2-0", "2-1"}) ||
+		!slices.Equal(replyIds(reply.Array[2]), []string{"1-0", "1-1"}) {
+		t.Errorf("XAutoClaim() = %+v", reply)
+	}
+
+	//the trimmed entries are acknowledged
+	if !slices.ContainsFunc(effects, func(effect []string) bool { return effect[0] == "XACK" }) {
+		t.Errorf("effects = %q", effects)
+	}
+
+	response, _, err = XAutoClaim([]string{"stream", "group", "bob", "0", reply.Array[0].Str, "JUSTID"})
+	if err != nil {
+		t.Fatalf("XAutoClaim() error = %v", err)
+	}
+
+	reply = parseTestReply(t, response)
+	if reply.Array[0].Str != "0-0" || !slices.Equal(replyIds(reply.Array[1]), []string{"3-0", "3-1"}) {
+		t.Errorf("XAutoClaim() = %+v", reply)
+	}
+
+	if group := pendingOf(t, "stream", "group"); group.Pending.Len() != 4 || group.Consumers["bob"].Pending.Len() != 4 {
+		t.Errorf("bob has %d of %d pending entries", group.Consumers["bob"].Pending.Len(), group.Pending.Len())
+	}
+}
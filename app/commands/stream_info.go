@@ -0,0 +1,341 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/store"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultXInfoFullCount = 10
+
+func XInfo(args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, errArgNumber
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "STREAM":
+		return xInfoStream(args[1:])
+	case "GROUPS":
+		return xInfoGroups(args[1:])
+	case "CONSUMERS":
+		return xInfoConsumers(args[1:])
+	default:
+		return nil, fmt.Errorf("ERR unknown subcommand '%v'. Try XINFO HELP.", args[0])
+	}
+}
+
+func xInfoStream(args []string) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 && len(args) != 4 {
+		return nil, errArgNumber
+	}
+
+	full := false
+	count := defaultXInfoFullCount
+
+	if len(args) > 1 {
+		if strings.ToUpper(args[1]) != "FULL" {
+			return nil, errSyntax
+		}
+
+		full = true
+	}
+
+	if len(args) == 4 {
+		if strings.ToUpper(args[2]) != "COUNT" {
+			return nil, errSyntax
+		}
+
+		parsedCount, err := strconv.Atoi(args[3])
+		if err != nil || parsedCount < 0 {
+			return nil, errNotInteger
+		}
+
+		count = parsedCount
+	}
+
+	storedValue, err := getStream(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if full {
+		return formatXInfoStreamFull(storedValue, count), nil
+	}
+
+	return formatXInfoStream(storedValue), nil
+}
+
+func xInfoGroups(args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errArgNumber
+	}
+
+	storedValue, err := getStream(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	names := slices.Sorted(maps.Keys(storedValue.XGroups))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(names))
+	for _, name := range names {
+		group := storedValue.XGroups[name]
+		buf.WriteString("*12\r\n")
+
+		buf.Write(protocol.FormatBulkString("name"))
+		buf.Write(protocol.FormatBulkString(name))
+
+		buf.Write(protocol.FormatBulkString("consumers"))
+		buf.Write(protocol.FormatInt(len(group.Consumers), false))
+
+		buf.Write(protocol.FormatBulkString("pending"))
+		buf.Write(protocol.FormatInt(group.Pending.Len(), false))
+
+		buf.Write(protocol.FormatBulkString("last-delivered-id"))
+		buf.Write(protocol.FormatBulkString(group.LastId.String()))
+
+		writeGroupCounters(&buf, storedValue, group)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeGroupCounters writes the entries read and the lag of a group, null
+// when they aren't known.
+func writeGroupCounters(buf *bytes.Buffer, storedValue store.StoredValue, group store.StreamGroup) {
+	buf.Write(protocol.FormatBulkString("entries-read"))
+	if group.EntriesRead == -1 {
+		buf.Write(protocol.FormatNullBulkString())
+	} else {
+		buf.Write(protocol.FormatInt(int(group.EntriesRead), false))
+	}
+
+	buf.Write(protocol.FormatBulkString("lag"))
+	if lag := storedValue.Lag(group); lag == -1 {
+		buf.Write(protocol.FormatNullBulkString())
+	} else {
+		buf.Write(protocol.FormatInt(int(lag), false))
+	}
+}
+
+func xInfoConsumers(args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errArgNumber
+	}
+
+	storedValue, err := getStream(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	group, ok := storedValue.XGroups[args[1]]
+	if !ok {
+		return nil, errNoGroup(args[0], args[1])
+	}
+
+	names := slices.Sorted(maps.Keys(group.Consumers))
+	now := time.Now().UnixMilli()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(names))
+	for _, name := range names {
+		consumer := group.Consumers[name]
+		buf.WriteString("*8\r\n")
+
+		buf.Write(protocol.FormatBulkString("name"))
+		buf.Write(protocol.FormatBulkString(name))
+
+		buf.Write(protocol.FormatBulkString("pending"))
+		buf.Write(protocol.FormatInt(consumer.Pending.Len(), false))
+
+		buf.Write(protocol.FormatBulkString("idle"))
+		buf.Write(protocol.FormatInt(int(now-consumer.SeenTime), false))
+
+		//consumers that never read anything were never active
+		inactive := int64(-1)
+		if consumer.ActiveTime != -1 {
+			inactive = now - consumer.ActiveTime
+		}
+
+		buf.Write(protocol.FormatBulkString("inactive"))
+		buf.Write(protocol.FormatInt(int(inactive), false))
+	}
+
+	return buf.Bytes(), nil
+}
+
+func getStream(key string) (store.StoredValue, error) {
+	storedValue, ok := store.CM.Get(key)
+	if !ok || storedValue.IsBlockedOnly() {
+		return storedValue, errors.New("ERR no such key")
+	}
+
+	if storedValue.Type != store.TypeStream {
+		return storedValue, errWrongtypeOperation
+	}
+
+	return storedValue, nil
+}
+
+func formatXInfoStream(storedValue store.StoredValue) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*20\r\n")
+
+	writeStreamMetadata(&buf, storedValue)
+
+	buf.Write(protocol.FormatBulkString("groups"))
+	buf.Write(protocol.FormatInt(len(storedValue.XGroups), false))
+
+	buf.Write(protocol.FormatBulkString("first-entry"))
+	if first, ok := storedValue.Xval.First(); ok {
+		buf.Write(FormatStreamEntry(first))
+	} else {
+		buf.Write(protocol.FormatNullBulkString())
+	}
+
+	buf.Write(protocol.FormatBulkString("last-entry"))
+	if last, ok := storedValue.Xval.Last(); ok {
+		buf.Write(FormatStreamEntry(last))
+	} else {
+		buf.Write(protocol.FormatNullBulkString())
+	}
+
+	return buf.Bytes()
+}
+
+func formatXInfoStreamFull(storedValue store.StoredValue, count int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*18\r\n")
+
+	writeStreamMetadata(&buf, storedValue)
+
+	//a count of 0 returns the whole stream
+	minId := store.StreamId{Ms: 0, Sequence: 0}
+	maxId := store.StreamId{Ms: math.MaxInt64, Sequence: math.MaxInt64}
+
+	buf.Write(protocol.FormatBulkString("entries"))
+	buf.Write(FormatStreamEntries(storedValue.Xval.Range(minId, maxId, count)))
+
+	names := slices.Sorted(maps.Keys(storedValue.XGroups))
+	buf.Write(protocol.FormatBulkString("groups"))
+	fmt.Fprintf(&buf, "*%d\r\n", len(names))
+	for _, name := range names {
+		writeXInfoGroupFull(&buf, storedValue, name, count)
+	}
+
+	return buf.Bytes()
+}
+
+// writeXInfoGroupFull writes a group with its PEL and consumers, listing at
+// most count pending entries of each.
+func writeXInfoGroupFull(buf *bytes.Buffer, storedValue store.StoredValue, name string, count int) {
+	group := storedValue.XGroups[name]
+	buf.WriteString("*14\r\n")
+
+	buf.Write(protocol.FormatBulkString("name"))
+	buf.Write(protocol.FormatBulkString(name))
+
+	buf.Write(protocol.FormatBulkString("last-delivered-id"))
+	buf.Write(protocol.FormatBulkString(group.LastId.String()))
+
+	writeGroupCounters(buf, storedValue, group)
+
+	buf.Write(protocol.FormatBulkString("pel-count"))
+	buf.Write(protocol.FormatInt(group.Pending.Len(), false))
+
+	var pending bytes.Buffer
+	rows := 0
+	for id, entry := range group.Pending.Ascend(store.StreamId{}) {
+		if count > 0 && rows == count {
+			break
+		}
+
+		pending.WriteString("*4\r\n")
+		pending.Write(protocol.FormatBulkString(id.String()))
+		pending.Write(protocol.FormatBulkString(entry.Consumer))
+		pending.Write(protocol.FormatInt(int(entry.DeliveryTime), false))
+		pending.Write(protocol.FormatInt(int(entry.DeliveryCount), false))
+		rows++
+	}
+
+	buf.Write(protocol.FormatBulkString("pending"))
+	fmt.Fprintf(buf, "*%d\r\n", rows)
+	buf.Write(pending.Bytes())
+
+	consumers := slices.Sorted(maps.Keys(group.Consumers))
+	buf.Write(protocol.FormatBulkString("consumers"))
+	fmt.Fprintf(buf, "*%d\r\n", len(consumers))
+	for _, consumerName := range consumers {
+		consumer := group.Consumers[consumerName]
+		buf.WriteString("*10\r\n")
+
+		buf.Write(protocol.FormatBulkString("name"))
+		buf.Write(protocol.FormatBulkString(consumerName))
+
+		buf.Write(protocol.FormatBulkString("seen-time"))
+		buf.Write(protocol.FormatInt(int(consumer.SeenTime), false))
+
+		buf.Write(protocol.FormatBulkString("active-time"))
+		buf.Write(protocol.FormatInt(int(consumer.ActiveTime), false))
+
+		buf.Write(protocol.FormatBulkString("pel-count"))
+		buf.Write(protocol.FormatInt(consumer.Pending.Len(), false))
+
+		pending.Reset()
+		rows = 0
+		for id := range consumer.Pending.Ascend(store.StreamId{}) {
+			if count > 0 && rows == count {
+				break
+			}
+
+			entry, _ := group.Pending.Get(id)
+			pending.WriteString("*3\r\n")
+			pending.Write(protocol.FormatBulkString(id.String()))
+			pending.Write(protocol.FormatInt(int(entry.DeliveryTime), false))
+			pending.Write(protocol.FormatInt(int(entry.DeliveryCount), false))
+			rows++
+		}
+
+		buf.Write(protocol.FormatBulkString("pending"))
+		fmt.Fprintf(buf, "*%d\r\n", rows)
+		buf.Write(pending.Bytes())
+	}
+}
+
+func writeStreamMetadata(buf *bytes.Buffer, storedValue store.StoredValue) {
+	firstId := store.StreamId{Ms: 0, Sequence: 0}
+	if first, ok := storedValue.Xval.First(); ok {
+		firstId = first.Id
+	}
+
+	buf.Write(protocol.FormatBulkString("length"))
+	buf.Write(protocol.FormatInt(storedValue.Xval.Len(), false))
+
+	buf.Write(protocol.FormatBulkString("radix-tree-keys"))
+	buf.Write(protocol.FormatInt(storedValue.Xval.Nodes(), false))
+
+	buf.Write(protocol.FormatBulkString("radix-tree-nodes"))
+	buf.Write(protocol.FormatInt(storedValue.Xval.TreeNodes(), false))
+
+	buf.Write(protocol.FormatBulkString("last-generated-id"))
+	buf.Write(protocol.FormatBulkString(storedValue.XMeta.LastId.String()))
+
+	buf.Write(protocol.FormatBulkString("max-deleted-entry-id"))
+	buf.Write(protocol.FormatBulkString(storedValue.XMeta.MaxDeletedId.String()))
+
+	buf.Write(protocol.FormatBulkString("entries-added"))
+	buf.Write(protocol.FormatInt(int(storedValue.XMeta.EntriesAdded), false))
+
+	buf.Write(protocol.FormatBulkString("recorded-first-entry-id"))
+	buf.Write(protocol.FormatBulkString(firstId.String()))
+}
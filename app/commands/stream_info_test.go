@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/store"
+	"testing"
+)
+
+// replyMap returns the fields of a reply listing field names and values.
+func replyMap(reply protocol.Reply) map[string]protocol.Reply {
+	fields := map[string]protocol.Reply{}
+	for i := 0; i+1 < len(reply.Array); i += 2 {
+		fields[reply.Array[i].Str] = reply.Array[i+1]
+	}
+
+	return fields
+}
+
+func TestXInfoGroups(t *testing.T) {
+	store.CM.Set("stream", newTestStream(6)) // 1-0 1-1 2-0 2-1 3-0 3-1
+	defer store.CM.Delete("stream")
+
+	mustSucceed(t)(XGroup([]string{"CREATE", "stream", "readers", "0"}))
+	mustSucceed(t)(XGroup([]string{"CREATE", "stream", "idle", "$"}))
+	mustSucceed(t)(XGroup([]string{"CREATECONSUMER", "stream", "readers", "bob"}))
+	xReadGroup(t, "GROUP", "readers", "alice", "COUNT", "4", "STREAMS", "stream", ">")
+
+	reply := parseTestReply(t, mustSucceed(t)(XInfo([]string{"GROUPS", "stream"})))
+	if len(reply.Array) != 2 {
+		t.Fatalf("XINFO GROUPS returned %d groups", len(reply.Array))
+	}
+
+	tests := []struct {
+		group           protocol.Reply
+		name            string
+		consumers       int64
+		pending         int64
+		lastDeliveredId string
+		lag             int64
+	}{
+		{reply.Array[0], "idle", 0, 0, "3-1", 0},
+		{reply.Array[1], "readers", 2, 4, "2-1", 2},
+	}
+
+	for _, tt := range tests {
+		fields := replyMap(tt.group)
+		if fields["name"].Str != tt.name || fields["consumers"].Int != tt.consumers || fields["pending"].Int != tt.pending ||
+			fields["last-delivered-id"].Str != tt.lastDeliveredId || fields["lag"].Int != tt.lag {
+			t.Errorf("group %q = %+v", tt.name, fields)
+		}
+	}
+
+	//the entries read of a group created at $ aren't known
+	if fields := replyMap(reply.Array[0]); !fields["entries-read"].IsNull {
+		t.Errorf("entries-read of idle = %+v", fields["entries-read"])
+	}
+
+	reply = parseTestReply(t, mustSucceed(t)(XInfo([]string{"CONSUMERS", "stream", "readers"})))
+	alice, bob := replyMap(reply.Array[0]), replyMap(reply.Array[1])
+	if alice["name"].Str != "alice" || alice["pending"].Int != 4 || alice["inactive"].Int < 0 {
+		t.Errorf("alice = %+v", alice)
+	}
+
+	if bob["name"].Str != "bob" || bob["pending"].Int != 0 || bob["inactive"].Int != -1 {
+		t.Errorf("bob = %+v", bob)
+	}
+
+	if _, err := XInfo([]string{"CONSUMERS", "stream", "missing"}); err == nil {
+		t.Errorf("XINFO CONSUMERS of a missing group succeeded")
+	}
+
+	info := replyMap(parseTestReply(t, mustSucceed(t)(XInfo([]string{"STREAM", "stream"}))))
+	if info["groups"].Int != 2 {
+		t.Errorf("XINFO STREAM groups = %d", info["groups"].Int)
+	}
+
+	full := replyMap(parseTestReply(t, mustSucceed(t)(XInfo([]string{"STREAM", "stream", "FULL", "COUNT", "3"}))))
+	readers := replyMap(full["groups"].Array[1])
+	if readers["pel-count"].Int != 4 || len(readers["pending"].Array) != 3 || len(readers["consumers"].Array) != 2 {
+		t.Errorf("XINFO STREAM FULL readers = %+v", readers)
+	}
+
+	if consumer := replyMap(readers["consumers"].Array[0]); consumer["pel-count"].Int != 4 || len(consumer["pending"].Array) != 3 {
+		t.Errorf("XINFO STREAM FULL alice = %+v", consumer)
+	}
+}
+
+func TestXInfoBlockedOnlyKey(t *testing.T) {
+	store.CM.Set("blocked", store.NewStreamListener(store.StreamListener{}))
+	defer store.CM.Delete("blocked")
+
+	if _, err := XInfo([]string{"STREAM", "blocked"}); err == nil || err.Error() != "ERR no such key" {
+		t.Errorf("XINFO STREAM error = %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"redis-clone-go/app/notify"
 	"redis-clone-go/app/protocol"
 	"redis-clone-go/app/store"
 	"slices"
@@ -30,7 +31,7 @@ func XAdd(args []string) ([]byte, error) {
 	_, err = store.CM.SetOrUpdate(
 		args[0],
 		func() store.StoredValue {
-			streamId.GenerateValues(nil)
+			streamId.GenerateValues(store.StreamId{})
 			streamEntry := store.NewStreamEntry(streamId, args[2:])
 
 			return store.NewStreamValue([]store.StreamEntry{streamEntry})
@@ -40,14 +41,15 @@ func XAdd(args []string) ([]byte, error) {
 				return errWrongtypeOperation
 			}
 
-			streamId.GenerateValues(storedValue.Xval)
+			streamId.GenerateValues(storedValue.XMeta.LastId)
 
-			if !streamId.CanAppendKey(storedValue.Xval) {
+			if !streamId.CanAppendKey(storedValue.XMeta.LastId) {
 				return errStreamIdTooSmall
 			}
 
 			streamEntry := store.NewStreamEntry(streamId, args[2:])
-			storedValue.Xval = append(storedValue.Xval, streamEntry)
+			storedValue.Xval.Append(streamEntry)
+			storedValue.XMeta.AddEntry(streamEntry)
 			handleStreamListeners(storedValue, streamEntry)
 
 			return nil
@@ -58,9 +60,205 @@ func XAdd(args []string) ([]byte, error) {
 		return nil, err
 	}
 
+	notify.KeyspaceEvent(notify.Stream, "xadd", args[0])
+
 	return protocol.FormatBulkString(streamId.String()), nil
 }
 
+func XSetId(args []string) ([]byte, error) {
+	if len(args) != 2 && len(args) != 4 && len(args) != 6 {
+		return nil, errArgNumber
+	}
+
+	lastId, err := store.ParseStreamId(args[1])
+	if err != nil || lastId.Ms < 0 || lastId.Sequence < 0 {
+		return nil, errInvalidStreamId
+	}
+
+	entriesAdded := int64(-1)
+	maxDeletedId := store.StreamId{}
+	hasMaxDeletedId := false
+
+	for i := 2; i < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "ENTRIESADDED":
+			entriesAdded, err = strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || entriesAdded < 0 {
+				return nil, errNotInteger
+			}
+		case "MAXDELETEDID":
+			maxDeletedId, err = store.ParseStreamId(args[i+1])
+			if err != nil || maxDeletedId.Ms < 0 || maxDeletedId.Sequence < 0 {
+				return nil, errInvalidStreamId
+			}
+
+			hasMaxDeletedId = true
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	if hasMaxDeletedId && maxDeletedId.IsGreaterThan(lastId) {
+		return nil, errors.New("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+	}
+
+	_, err = store.CM.Update(
+		args[0],
+		func(storedValue *store.StoredValue) error {
+			if storedValue.Type != store.TypeStream {
+				return errWrongtypeOperation
+			}
+
+			if last, ok := storedValue.Xval.Last(); ok && last.Id.IsGreaterThan(lastId) {
+				return errors.New("ERR The ID specified in XSETID is smaller than the target stream top item")
+			}
+
+			if entriesAdded != -1 && int64(storedValue.Xval.Len()) > entriesAdded {
+				return errors.New("ERR The entries_added specified in XSETID is smaller than the target stream length")
+			}
+
+			storedValue.XMeta.LastId = lastId
+
+			if entriesAdded != -1 {
+				storedValue.XMeta.EntriesAdded = entriesAdded
+			}
+
+			if hasMaxDeletedId {
+				storedValue.XMeta.MaxDeletedId = maxDeletedId
+			}
+
+			return nil
+		},
+	)
+
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			return nil, errors.New("ERR no such key")
+		}
+
+		return nil, err
+	}
+
+	notify.KeyspaceEvent(notify.Stream, "xsetid", args[0])
+
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+// XTrim trims a stream like XTRIM key MAXLEN|MINID [=|~] threshold
+// [LIMIT count] and returns the number of entries it removed.
+func XTrim(args []string) ([]byte, error) {
+	if len(args) < 3 {
+		return nil, errArgNumber
+	}
+
+	key := args[0]
+	strategy := strings.ToUpper(args[1])
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		return nil, errSyntax
+	}
+
+	args = args[2:]
+	approx := false
+	if args[0] == "=" || args[0] == "~" {
+		approx = args[0] == "~"
+		args = args[1:]
+	}
+
+	if len(args) != 1 && len(args) != 3 {
+		return nil, errSyntax
+	}
+
+	//like Redis, approximate trims do at most 100 nodes of work by default
+	limit := 0
+	if approx {
+		limit = 100 * store.StreamNodeMaxEntries
+	}
+
+	if len(args) == 3 {
+		if strings.ToUpper(args[1]) != "LIMIT" {
+			return nil, errSyntax
+		}
+
+		if !approx {
+			return nil, errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+
+		parsedLimit, err := strconv.Atoi(args[2])
+		if err != nil || parsedLimit < 0 {
+			return nil, errors.New("ERR The LIMIT argument must be >= 0.")
+		}
+
+		limit = parsedLimit
+	}
+
+	var trim func(stream *store.Stream) int
+	if strategy == "MAXLEN" {
+		maxLen, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, errNotInteger
+		}
+
+		if maxLen < 0 {
+			return nil, errors.New("ERR The MAXLEN argument must be >= 0.")
+		}
+
+		trim = func(stream *store.Stream) int {
+			return stream.TrimMaxLen(maxLen, approx, limit)
+		}
+	} else {
+		minId, err := parseStreamIdOrMs(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		trim = func(stream *store.Stream) int {
+			return stream.TrimMinId(minId, approx, limit)
+		}
+	}
+
+	removed := 0
+	_, err := store.CM.Update(
+		key,
+		func(storedValue *store.StoredValue) error {
+			if storedValue.Type != store.TypeStream {
+				return errWrongtypeOperation
+			}
+
+			removed = trim(&storedValue.Xval)
+			return nil
+		},
+	)
+
+	if err != nil {
+		if errors.Is(err, store.ErrKeyNotFound) {
+			return protocol.FormatInt(0, false), nil
+		}
+
+		return nil, err
+	}
+
+	if removed > 0 {
+		notify.KeyspaceEvent(notify.Stream, "xtrim", key)
+	}
+
+	return protocol.FormatInt(removed, false), nil
+}
+
+// parseStreamIdOrMs parses an id whose sequence may be left out, like the
+// MINID of XTRIM. It defaults to 0.
+func parseStreamIdOrMs(id string) (store.StreamId, error) {
+	if ms, err := strconv.ParseInt(id, 10, 64); err == nil && ms >= 0 {
+		return store.StreamId{Ms: ms, Sequence: 0}, nil
+	}
+
+	minId, err := store.ParseStreamId(id)
+	if err != nil || minId.Ms < 0 || minId.Sequence < 0 {
+		return store.StreamId{}, errInvalidStreamId
+	}
+
+	return minId, nil
+}
+
 func XRange(args []string) ([]byte, error) {
 	if len(args) != 3 {
 		return nil, errArgNumber
@@ -85,147 +283,197 @@ func XRange(args []string) ([]byte, error) {
 		return nil, errWrongtypeOperation
 	}
 
-	startIdx, _ := findIndex(start, storedValue.Xval, true)
-	endIdx, _ := findIndex(end, storedValue.Xval, false)
-
-	result := storedValue.Xval[startIdx : endIdx+1]
-
-	return FormatStreamEntries(result), nil
+	return FormatStreamEntries(storedValue.Xval.Range(start, end, 0)), nil
 }
 
 type XReadArgs struct {
 	Keys    []string
 	Ids     []string
+	Count   int
 	Block   bool
 	Timeout time.Duration
 }
 
-func XRead(args []string) ([]byte, error) {
+// XRead reads the entries after the given ids. When there are none and it
+// may block, it returns a func waiting for entries to be added, so the
+// caller only holds the lock while reading.
+func XRead(args []string) ([]byte, func() ([]byte, error), error) {
 	parsedArgs, err := parseXReadArgs(args)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	results, err := getResults(parsedArgs)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if len(results) > 0 {
-		return FormatXReadResponse(results), nil
+		return FormatXReadResponse(results), nil, nil
 	}
 
 	if parsedArgs.Block {
-		return handleBlockingXRead(parsedArgs)
+		wait, err := handleBlockingXRead(parsedArgs)
+		return nil, wait, err
+	}
+
+	return protocol.FormatNullBulkString(), nil, nil
+}
+
+// XReadNoWait ignores BLOCK, like Redis does for XREAD inside a transaction.
+func XReadNoWait(args []string) ([]byte, error) {
+	parsedArgs, err := parseXReadArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := getResults(parsedArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) > 0 {
+		return FormatXReadResponse(results), nil
 	}
 
 	return protocol.FormatNullBulkString(), nil
 }
 
 func parseXReadArgs(args []string) (*XReadArgs, error) {
-	if len(args) < 3 {
-		return nil, errArgNumber
+	streamsIdx := findStreamsIndex(args)
+	if streamsIdx == -1 {
+		return nil, errSyntax
 	}
 
 	parsed := &XReadArgs{}
-	var streamsIdx int
 
-	if strings.ToUpper(args[0]) == "BLOCK" {
-		if len(args) < 5 || len(args)%2 != 1 {
-			return nil, errArgNumber
+	for i := 0; i < streamsIdx; i += 2 {
+		if i+1 >= streamsIdx {
+			return nil, errSyntax
 		}
 
-		parsed.Block = true
-		timeoutMs, err := strconv.ParseInt(args[1], 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("value is not an integer or out of range")
-		}
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, errNotInteger
+			}
 
-		parsed.Timeout = time.Duration(timeoutMs) * time.Millisecond
-		streamsIdx = findStreamsIndex(args)
-		if streamsIdx != 2 {
-			return nil, errors.New("syntax error")
-		}
-	} else {
-		if len(args)%2 != 1 {
-			return nil, errArgNumber
-		}
-		streamsIdx = findStreamsIndex(args)
-		if streamsIdx != 0 {
-			return nil, errors.New("syntax error")
+			//a count of 0 or less means no limit
+			parsed.Count = max(0, count)
+		case "BLOCK":
+			timeoutMs, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return nil, errNotInteger
+			}
+
+			if timeoutMs < 0 {
+				return nil, errors.New("ERR timeout is negative")
+			}
+
+			parsed.Block = true
+			parsed.Timeout = time.Duration(timeoutMs) * time.Millisecond
+		default:
+			return nil, errSyntax
 		}
 	}
 
 	keysAndIds := args[streamsIdx+1:]
+	if len(keysAndIds) == 0 {
+		return nil, errArgNumber
+	}
+
+	if len(keysAndIds)%2 != 0 {
+		return nil, errors.New("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+
 	numKeys := len(keysAndIds) / 2
 	parsed.Keys = keysAndIds[:numKeys]
 	parsed.Ids = keysAndIds[numKeys:]
 
-	if len(parsed.Keys) != len(parsed.Ids) {
-		return nil, errors.New("unbalanced XREAD list of streams: keys and IDs must be specified in pairs")
-	}
-
 	return parsed, nil
 }
 
-func handleBlockingXRead(args *XReadArgs) ([]byte, error) {
-	listeners := make([]store.StreamListener, len(args.Keys))
-	for i := range args.Keys {
-		key := args.Keys[i]
-		idStr := args.Ids[i]
-		storedValue, ok := store.CM.Get(key)
-		if !ok {
-			id, err := parseXReadId(idStr, []store.StreamEntry{})
-			if err != nil {
-				return nil, fmt.Errorf("error parsing stream id: %w", err)
+func handleBlockingXRead(args *XReadArgs) (func() ([]byte, error), error) {
+	listeners := make([]store.StreamListener, 0, len(args.Keys))
+	for i, key := range args.Keys {
+		listener, err := addStreamListener(key, args.Ids[i])
+		if err != nil {
+			if removeErr := removeStreamListeners(listeners); removeErr != nil {
+				return nil, fmt.Errorf("error removing stream listeners: %w", removeErr)
 			}
-			c := make(chan store.StreamEntry, 1)
-			listener := store.StreamListener{C: c, Id: id, Key: key}
-			listeners[i] = listener
-			//TODO: this set operation is a concurrency issue
-			store.CM.Set(key, store.NewStreamListener(listener))
-			continue
-		}
 
-		if storedValue.Type != store.TypeStream {
-			return nil, errWrongtypeOperation
-		}
-
-		id, err := parseXReadId(idStr, storedValue.Xval)
-		if err != nil {
-			return nil, fmt.Errorf("error parsing stream id: %w", err)
+			return nil, err
 		}
 
-		c := make(chan store.StreamEntry, 1)
-		listener := store.StreamListener{C: c, Id: id, Key: key}
-		listeners[i] = listener
-		storedValue.AddStreamListener(listener)
-		//TODO: this set operation is a concurrency issue
-		store.CM.Set(key, storedValue)
+		listeners = append(listeners, listener)
 	}
 
-	resultChannel := make(chan xReadResult, 1)
+	readyChannel := make(chan struct{}, len(listeners))
+	listenForResult(listeners, readyChannel)
+
+	return func() ([]byte, error) {
+		return waitForXReadResults(args, listeners, readyChannel)
+	}, nil
+}
+
+func waitForXReadResults(args *XReadArgs, listeners []store.StreamListener, readyChannel chan struct{}) ([]byte, error) {
 	var timeoutChannel <-chan time.Time
 	if args.Timeout > 0 {
 		timeoutChannel = time.After(args.Timeout)
 	}
 
-	listenForResult(listeners, resultChannel)
-
 	select {
-	case res := <-resultChannel:
+	case <-readyChannel:
 		if err := removeStreamListeners(listeners); err != nil {
 			return nil, fmt.Errorf("error removing stream listeners: %w", err)
 		}
-		return FormatXReadResponse([]xReadResult{res}), nil
+
+		//several streams may have become ready at once, so read all of them
+		//instead of only returning the entry that woke us up
+		results, err := getResultsAfterIds(listeners, args.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		return FormatXReadResponse(results), nil
 	case <-timeoutChannel:
 		if err := removeStreamListeners(listeners); err != nil {
 			return nil, fmt.Errorf("error removing stream listeners: %w", err)
 		}
+
 		return protocol.FormatNullBulkString(), nil
 	}
 }
 
+func addStreamListener(key, idStr string) (store.StreamListener, error) {
+	id, err := parseXReadId(idStr, &store.Stream{})
+	if err != nil {
+		return store.StreamListener{}, fmt.Errorf("error parsing stream id: %w", err)
+	}
+
+	listener := store.StreamListener{C: make(chan store.StreamEntry, 1), Id: id, Key: key}
+
+	_, err = store.CM.SetOrUpdateQuietly(
+		key,
+		func() store.StoredValue {
+			return store.NewStreamListener(listener)
+		},
+		func(storedValue *store.StoredValue) error {
+			if storedValue.Type != store.TypeStream {
+				return errWrongtypeOperation
+			}
+
+			//ids relative to the stream have to be resolved under the lock
+			listener.Id, _ = parseXReadId(idStr, &storedValue.Xval)
+			storedValue.AddStreamListener(listener)
+			return nil
+		},
+	)
+
+	return listener, err
+}
+
 func getResults(args *XReadArgs) ([]xReadResult, error) {
 	results := []xReadResult{}
 	for i := range len(args.Keys) {
@@ -240,12 +488,41 @@ func getResults(args *XReadArgs) ([]xReadResult, error) {
 			return nil, errWrongtypeOperation
 		}
 
-		id, err := parseXReadId(idStr, storedValue.Xval)
+		if idStr == "+" {
+			if last, ok := storedValue.Xval.Last(); ok {
+				results = append(results, xReadResult{key, []store.StreamEntry{last}})
+			}
+
+			continue
+		}
+
+		id, err := parseXReadId(idStr, &storedValue.Xval)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing stream id: %w", err)
 		}
 
-		result := getxReadResult(key, id, storedValue.Xval)
+		result := getxReadResult(key, id, &storedValue.Xval, args.Count)
+		if len(result.entries) > 0 {
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
+
+func getResultsAfterIds(listeners []store.StreamListener, count int) ([]xReadResult, error) {
+	results := []xReadResult{}
+	for _, listener := range listeners {
+		storedValue, ok := store.CM.Get(listener.Key)
+		if !ok {
+			continue
+		}
+
+		if storedValue.Type != store.TypeStream {
+			return nil, errWrongtypeOperation
+		}
+
+		result := getxReadResult(listener.Key, listener.Id, &storedValue.Xval, count)
 		if len(result.entries) > 0 {
 			results = append(results, result)
 		}
@@ -272,32 +549,18 @@ func parseXRangeEndId(id string) (store.StreamId, error) {
 	return store.ParseStreamId(id)
 }
 
-func parseXReadId(id string, prevEntries []store.StreamEntry) (store.StreamId, error) {
-	if id == "$" {
-		if len(prevEntries) > 0 {
-			return prevEntries[len(prevEntries)-1].Id, nil
+func parseXReadId(id string, stream *store.Stream) (store.StreamId, error) {
+	//"+" only differs from "$" when the stream already has entries,
+	//which getResults answers without blocking
+	if id == "$" || id == "+" {
+		if last, ok := stream.Last(); ok {
+			return last.Id, nil
 		}
 
 		return store.StreamId{Ms: 0, Sequence: 0}, nil
 	}
 
-	return store.ParseStreamId(id)
-}
-
-func findIndex(id store.StreamId, entries []store.StreamEntry, start bool) (int, bool) {
-	for i, val := range entries {
-		//TODO: this is too simple, it doesnt handle * yet
-
-		if id.Ms == val.Id.Ms && id.Sequence == val.Id.Sequence {
-			return i, true
-		}
-	}
-
-	if start {
-		return 0, true
-	}
-
-	return len(entries) - 1, true
+	return parseStreamIdOrMs(id)
 }
 
 func findStreamsIndex(array []string) int {
@@ -312,16 +575,15 @@ func findStreamsIndex(array []string) int {
 	return -1
 }
 
-func getxReadResult(key string, id store.StreamId, entries []store.StreamEntry) xReadResult {
-	for i, entry := range entries {
-		if entry.Id.IsEqualTo(id) {
-			return xReadResult{key, entries[i+1:]}
-		} else if entry.Id.IsGreaterThan(id) {
-			return xReadResult{key, entries[i:]}
-		}
+// getxReadResult returns up to count entries after id.
+func getxReadResult(key string, id store.StreamId, stream *store.Stream, count int) xReadResult {
+	next, ok := id.Next()
+	if !ok {
+		return xReadResult{key, []store.StreamEntry{}}
 	}
 
-	return xReadResult{key, entries[:0]}
+	maxId := store.StreamId{Ms: math.MaxInt64, Sequence: math.MaxInt64}
+	return xReadResult{key, stream.Range(next, maxId, count)}
 }
 
 func FormatXReadResponse(results []xReadResult) []byte {
@@ -348,23 +610,30 @@ func FormatStreamEntries(entries []store.StreamEntry) []byte {
 	return buf.Bytes()
 }
 
+// FormatStreamEntry formats an entry, whose pairs are nil if it was
+// deleted, like the pending entries XREADGROUP returns.
 func FormatStreamEntry(entry store.StreamEntry) []byte {
 	var buf bytes.Buffer
 	buf.WriteString("*2\r\n")
 	buf.Write(protocol.FormatBulkString(entry.Id.String()))
-	buf.Write(protocol.FormatBulkStringArray(entry.Pairs))
+	if entry.Pairs == nil {
+		buf.Write(protocol.FormatNullArray())
+	} else {
+		buf.Write(protocol.FormatBulkStringArray(entry.Pairs))
+	}
+
 	return buf.Bytes()
 }
 
-func listenForResult(listeners []store.StreamListener, resultChannel chan xReadResult) {
-	writeXReadResult := func(key string, c chan store.StreamEntry) {
-		if val, ok := <-c; ok {
-			resultChannel <- xReadResult{key, []store.StreamEntry{val}}
+func listenForResult(listeners []store.StreamListener, readyChannel chan struct{}) {
+	waitForEntry := func(c chan store.StreamEntry) {
+		if _, ok := <-c; ok {
+			readyChannel <- struct{}{}
 		}
 	}
 
 	for _, listener := range listeners {
-		go writeXReadResult(listener.Key, listener.C)
+		go waitForEntry(listener.C)
 	}
 }
 
@@ -373,10 +642,10 @@ func removeStreamListeners(toRemove []store.StreamListener) error {
 		return nil
 	}
 
-	idsToRemove := getIdsMap(toRemove)
+	channelsToRemove := getChannelsMap(toRemove)
 
 	for _, listener := range toRemove {
-		err := removeStreamListener(listener.Key, idsToRemove)
+		err := removeStreamListener(listener.Key, channelsToRemove)
 		if err != nil {
 			return err
 		}
@@ -385,26 +654,34 @@ func removeStreamListeners(toRemove []store.StreamListener) error {
 	return nil
 }
 
-func getIdsMap(listeners []store.StreamListener) map[store.StreamId]struct{} {
-	removeIds := make(map[store.StreamId]struct{}, len(listeners))
+func getChannelsMap(listeners []store.StreamListener) map[chan store.StreamEntry]struct{} {
+	removeChannels := make(map[chan store.StreamEntry]struct{}, len(listeners))
 
 	for _, listener := range listeners {
-		removeIds[listener.Id] = struct{}{}
+		removeChannels[listener.C] = struct{}{}
 	}
 
-	return removeIds
+	return removeChannels
 }
 
-func removeStreamListener(key string, idsToRemove map[store.StreamId]struct{}) error {
-	_, err := store.CM.Update(
+// removeStreamListener stops waiting on a stream. The key may have been
+// overwritten meanwhile, its listeners are gone then.
+func removeStreamListener(key string, channelsToRemove map[chan store.StreamEntry]struct{}) error {
+	_, err := store.CM.UpdateQuietly(
 		key,
 		func(sv *store.StoredValue) error {
 			if sv.Type != store.TypeStream {
-				return errWrongtypeOperation
+				return nil
 			}
 
 			sv.StreamListeners = slices.DeleteFunc(sv.StreamListeners, func(l store.StreamListener) bool {
-				_, shouldRemove := idsToRemove[l.Id]
+				_, shouldRemove := channelsToRemove[l.C]
+				if shouldRemove {
+					//listeners still registered were never notified, closing
+					//their channel stops the goroutine waiting on it
+					close(l.C)
+				}
+
 				return shouldRemove
 			})
 
@@ -412,11 +689,7 @@ func removeStreamListener(key string, idsToRemove map[store.StreamId]struct{}) e
 		},
 	)
 
-	if err != nil {
-		if errors.Is(err, store.ErrKeyNotFound) {
-			return errors.New("error getting stream for key")
-		}
-
+	if err != nil && !errors.Is(err, store.ErrKeyNotFound) {
 		return err
 	}
 
@@ -424,7 +697,7 @@ func removeStreamListener(key string, idsToRemove map[store.StreamId]struct{}) e
 }
 
 func handleStreamListeners(storedValue *store.StoredValue, latestEntry store.StreamEntry) {
-	if len(storedValue.StreamListeners) == 0 || len(storedValue.Xval) == 0 {
+	if len(storedValue.StreamListeners) == 0 || storedValue.Xval.Len() == 0 {
 		return
 	}
 
@@ -0,0 +1,272 @@
+package commands
+
+import (
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/store"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestStream(length int) store.StoredValue {
+	storedValue := store.NewStreamValue(nil)
+	for i := range length {
+		id := store.StreamId{Ms: int64(i/2 + 1), Sequence: int64(i % 2)}
+		entry := store.NewStreamEntry(id, []string{"field", "value"})
+		storedValue.Xval.Append(entry)
+		storedValue.XMeta.AddEntry(entry)
+	}
+
+	return storedValue
+}
+
+// largeTestStream is shared by the benchmarks, copies of a stream don't
+// see the changes of the others.
+var largeTestStream = sync.OnceValue(func() store.StoredValue {
+	return newTestStream(10_000_000)
+})
+
+func TestXRange(t *testing.T) {
+	store.CM.Set("stream", newTestStream(6)) // 1-0 1-1 2-0 2-1 3-0 3-1
+	defer store.CM.Delete("stream")
+
+	tests := []struct {
+		name  string
+		start string
+		end   string
+		want  []string
+	}{
+		{"before first entry", "0-5", "1-0", []string{"1-0"}},
+		{"exact match", "2-0", "2-0", []string{"2-0"}},
+		{"between entries", "2-5", "+", []string{"3-0", "3-1"}},
+		{"after last entry", "4-0", "+", []string{}},
+		{"whole stream", "-", "+", []string{"1-0", "1-1", "2-0", "2-1", "3-0", "3-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := XRange([]string{"stream", tt.start, tt.end})
+			if err != nil {
+				t.Fatalf("XRange() error = %v", err)
+			}
+
+			want := make([]store.StreamEntry, len(tt.want))
+			for i, id := range tt.want {
+				parsed, _ := store.ParseStreamId(id)
+				want[i] = store.NewStreamEntry(parsed, []string{"field", "value"})
+			}
+
+			if string(got) != string(FormatStreamEntries(want)) {
+				t.Errorf("XRange() = %q, want %q", got, FormatStreamEntries(want))
+			}
+		})
+	}
+}
+
+func TestXTrim(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		want       int
+		wantLength int
+	}{
+		{"exact maxlen", []string{"MAXLEN", "750"}, 250, 750},
+		{"exact maxlen with =", []string{"MAXLEN", "=", "750"}, 250, 750},
+		{"approximate maxlen", []string{"MAXLEN", "~", "750"}, 200, 800},
+		{"approximate maxlen with limit", []string{"MAXLEN", "~", "0", "LIMIT", "250"}, 200, 800},
+		{"minid", []string{"MINID", "126-1"}, 251, 749},
+		{"minid without sequence", []string{"MINID", "126"}, 250, 750},
+		{"above the length", []string{"MAXLEN", "2000"}, 0, 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.CM.Set("stream", newTestStream(1000))
+			defer store.CM.Delete("stream")
+
+			got, err := XTrim(append([]string{"stream"}, tt.args...))
+			if err != nil {
+				t.Fatalf("XTrim() error = %v", err)
+			}
+
+			if string(got) != string(protocol.FormatInt(tt.want, false)) {
+				t.Errorf("XTrim() = %q, want %d", got, tt.want)
+			}
+
+			if storedValue, _ := store.CM.Get("stream"); storedValue.Xval.Len() != tt.wantLength {
+				t.Errorf("length = %d, want %d", storedValue.Xval.Len(), tt.wantLength)
+			}
+		})
+	}
+}
+
+func TestXTrimErrors(t *testing.T) {
+	store.CM.Set("stream", newTestStream(10))
+	defer store.CM.Delete("stream")
+
+	for _, args := range [][]string{
+		{"stream", "MAXLEN"},
+		{"stream", "COUNT", "5"},
+		{"stream", "MAXLEN", "-1"},
+		{"stream", "MAXLEN", "=", "5", "LIMIT", "10"},
+		{"stream", "MAXLEN", "~", "5", "LIMIT", "-1"},
+		{"stream", "MINID", "x-1"},
+	} {
+		if _, err := XTrim(args); err == nil {
+			t.Errorf("XTrim(%q) succeeded", args)
+		}
+	}
+}
+
+func TestXSetIdErrors(t *testing.T) {
+	store.CM.Set("stream", newTestStream(6)) // 1-0 1-1 2-0 2-1 3-0 3-1
+	defer store.CM.Delete("stream")
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{"below the top entry", []string{"stream", "3-0"}, "ERR The ID specified in XSETID is smaller than the target stream top item"},
+		{"entries added below the length", []string{"stream", "5-0", "ENTRIESADDED", "5"}, "ERR The entries_added specified in XSETID is smaller than the target stream length"},
+		{"max deleted id above the id", []string{"stream", "5-0", "MAXDELETEDID", "5-1"}, "ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := XSetId(tt.args); err == nil || err.Error() != tt.wantErr {
+				t.Errorf("XSetId(%q) error = %v, want %q", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+
+	if storedValue, _ := store.CM.Get("stream"); storedValue.XMeta.LastId != (store.StreamId{Ms: 3, Sequence: 1}) {
+		t.Errorf("last id = %v after failed XSETIDs", storedValue.XMeta.LastId)
+	}
+}
+
+func TestXSetId(t *testing.T) {
+	store.CM.Set("stream", newTestStream(6))
+	defer store.CM.Delete("stream")
+
+	mustSucceed(t)(XSetId([]string{"stream", "5-0", "ENTRIESADDED", "10", "MAXDELETEDID", "4-0"}))
+
+	storedValue, _ := store.CM.Get("stream")
+	if got := storedValue.XMeta; got.LastId != (store.StreamId{Ms: 5}) || got.EntriesAdded != 10 || got.MaxDeletedId != (store.StreamId{Ms: 4}) {
+		t.Errorf("metadata = %+v", got)
+	}
+
+	//ids are generated after the last id, not the top entry
+	if _, err := XAdd([]string{"stream", "4-0", "field", "value"}); err == nil {
+		t.Errorf("XAdd() below the last id succeeded")
+	}
+
+	if got := mustSucceed(t)(XAdd([]string{"stream", "5-*", "field", "value"})); string(got) != string(protocol.FormatBulkString("5-1")) {
+		t.Errorf("XAdd(5-*) = %q", got)
+	}
+
+	future := strconv.FormatInt(time.Now().UnixMilli()+time.Hour.Milliseconds(), 10)
+	mustSucceed(t)(XSetId([]string{"stream", future + "-7"}))
+	if got := mustSucceed(t)(XAdd([]string{"stream", "*", "field", "value"})); string(got) != string(protocol.FormatBulkString(future+"-8")) {
+		t.Errorf("XAdd(*) = %q, want %s-8", got, future)
+	}
+}
+
+func TestXRead(t *testing.T) {
+	store.CM.Set("stream", newTestStream(6)) // 1-0 1-1 2-0 2-1 3-0 3-1
+	defer store.CM.Delete("stream")
+
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"all entries", []string{"STREAMS", "stream", "0"}, []string{"1-0", "1-1", "2-0", "2-1", "3-0", "3-1"}},
+		{"count", []string{"COUNT", "2", "STREAMS", "stream", "0"}, []string{"1-0", "1-1"}},
+		{"after a full id", []string{"STREAMS", "stream", "2-0"}, []string{"2-1", "3-0", "3-1"}},
+		{"after a time", []string{"COUNT", "2", "STREAMS", "stream", "2"}, []string{"2-1", "3-0"}},
+		{"last entry", []string{"STREAMS", "stream", "+"}, []string{"3-1"}},
+		{"new entries", []string{"STREAMS", "stream", "$"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := parseTestReply(t, mustSucceed(t)(XReadNoWait(tt.args)))
+			if tt.want == nil {
+				if !reply.IsNull {
+					t.Errorf("XREAD = %+v, want null", reply)
+				}
+
+				return
+			}
+
+			if got := replyIds(reply.Array[0].Array[1]); !slices.Equal(got, tt.want) {
+				t.Errorf("XREAD = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXReadBlock(t *testing.T) {
+	store.CM.Set("first", newTestStream(2))
+	store.CM.Set("second", newTestStream(2))
+	defer store.CM.Delete("first")
+	defer store.CM.Delete("second")
+
+	response, wait, err := XRead([]string{"BLOCK", "1000", "STREAMS", "first", "second", "$", "$"})
+	if err != nil || wait == nil {
+		t.Fatalf("XRead() = %q, %v, want to block", response, err)
+	}
+
+	//the reader is woken by the first entry, and gets the ones added to
+	//the other stream meanwhile as well
+	mustSucceed(t)(XAdd([]string{"first", "5-0", "field", "value"}))
+	mustSucceed(t)(XAdd([]string{"second", "6-0", "field", "value"}))
+
+	reply := parseTestReply(t, mustSucceed(t)(wait()))
+	if len(reply.Array) != 2 || reply.Array[0].Array[0].Str != "first" || reply.Array[1].Array[0].Str != "second" {
+		t.Fatalf("XREAD = %+v", reply)
+	}
+
+	if first, second := replyIds(reply.Array[0].Array[1]), replyIds(reply.Array[1].Array[1]); !slices.Equal(first, []string{"5-0"}) || !slices.Equal(second, []string{"6-0"}) {
+		t.Errorf("XREAD read %v and %v", first, second)
+	}
+}
+
+func BenchmarkXAdd(b *testing.B) {
+	store.CM.Set("stream", largeTestStream())
+	defer store.CM.Delete("stream")
+
+	ms := int64(10_000_000)
+	for b.Loop() {
+		ms++
+		if _, err := XAdd([]string{"stream", strconv.FormatInt(ms, 10) + "-0", "field", "value"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkXRange(b *testing.B) {
+	store.CM.Set("stream", largeTestStream())
+	defer store.CM.Delete("stream")
+
+	for b.Loop() {
+		if _, err := XRange([]string{"stream", "3333333-0", "3333343-1"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkXTrim(b *testing.B) {
+	stream := largeTestStream()
+	defer store.CM.Delete("stream")
+
+	for b.Loop() {
+		store.CM.Set("stream", stream)
+		if _, err := XTrim([]string{"stream", "MAXLEN", "9999000"}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
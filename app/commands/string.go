@@ -3,6 +3,7 @@ package commands
 import (
 	"errors"
 	"fmt"
+	"redis-clone-go/app/notify"
 	"redis-clone-go/app/protocol"
 	"redis-clone-go/app/store"
 	"strconv"
@@ -17,7 +18,8 @@ func Set(args []string) ([]byte, error) {
 
 	expiresBy := int64(-1)
 	if len(args) == 4 {
-		if strings.ToUpper(args[2]) != "PX" {
+		option := strings.ToUpper(args[2])
+		if option != "PX" && option != "PXAT" {
 			return nil, fmt.Errorf("unknown argument: %v", args[2])
 		}
 
@@ -26,11 +28,19 @@ func Set(args []string) ([]byte, error) {
 			return nil, errors.New("expire time couldn't be parsed")
 		}
 
-		expiresBy = time.Now().UnixMilli() + ms
+		expiresBy = ms
+		if option == "PX" {
+			expiresBy += time.Now().UnixMilli()
+		}
 	}
 
 	store.CM.Set(args[0], store.NewStringValue(args[1], expiresBy))
 
+	notify.KeyspaceEvent(notify.String, "set", args[0])
+	if expiresBy != -1 {
+		notify.KeyspaceEvent(notify.Generic, "expire", args[0])
+	}
+
 	return protocol.FormatSimpleString("OK"), nil
 }
 
@@ -49,9 +59,7 @@ func Get(args []string) ([]byte, error) {
 	}
 
 	if storedValue.IsExpired() {
-		fmt.Println("tried to access expired value")
-		store.CM.Delete(args[0])
-
+		expireKey(args[0])
 		return protocol.FormatNullBulkString(), nil
 	}
 
@@ -69,7 +77,7 @@ func Type(args []string) ([]byte, error) {
 	}
 
 	if storedValue.IsExpired() {
-		store.CM.Delete(args[0])
+		expireKey(args[0])
 		return protocol.FormatSimpleString("none"), nil
 	}
 
@@ -0,0 +1,256 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"redis-clone-go/app/cluster"
+	"redis-clone-go/app/glob"
+	"redis-clone-go/app/notify"
+	"redis-clone-go/app/persistence"
+	"redis-clone-go/app/replication"
+	"redis-clone-go/app/sentinel"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type parameter struct {
+	get func() string
+	set func(value string) error
+}
+
+var parameters = map[string]parameter{
+	"notify-keyspace-events":   {notify.Flags, notify.SetFlags},
+	"dir":                      {persistence.Dir, persistence.SetDir},
+	"dbfilename":               {persistence.DbFilename, persistence.SetDbFilename},
+	"save":                     {persistence.SaveRules, persistence.SetSaveRules},
+	"appendonly":               {persistence.AppendOnly, persistence.SetAppendOnly},
+	"appendfsync":              {persistence.AppendFsync, persistence.SetAppendFsync},
+	"appenddirname":            {persistence.AppendDirname, persistence.SetAppendDirname},
+	"appendfilename":           {persistence.AppendFilename, persistence.SetAppendFilename},
+	"aof-load-truncated":       {persistence.AofLoadTruncated, persistence.SetAofLoadTruncated},
+	"replicaof":                {replication.ReplicaOf, replication.SetReplicaOf},
+	"repl-backlog-size":        {replication.BacklogSize, replication.SetBacklogSize},
+	"replica-read-only":        {replication.ReadOnly, replication.SetReadOnly},
+	"replica-serve-stale-data": {replication.ServeStaleData, replication.SetServeStaleData},
+	"port":                     {Port, setPort},
+	"bind":                     {Bind, setBind},
+	"cluster-enabled":          {cluster.ClusterEnabled, cluster.SetClusterEnabled},
+	"cluster-config-file":      {cluster.ConfigFile, cluster.SetConfigFile},
+	"cluster-node-timeout":     {cluster.NodeTimeout, cluster.SetNodeTimeout},
+}
+
+// immutable parameters can only be given on the command line.
+var immutable = map[string]bool{
+	"port":                true,
+	"bind":                true,
+	"cluster-enabled":     true,
+	"cluster-config-file": true,
+}
+
+// directives are command line arguments that aren't parameters, like
+// "--sentinel monitor mymaster 127.0.0.1 6379 2".
+var directives = map[string]func(value string) error{
+	"sentinel": sentinel.Configure,
+}
+
+// port is empty until one is given, the default depends on the mode.
+var port string
+
+// Port returns the port to listen on, 6379 or 26379 for a sentinel.
+func Port() string {
+	switch {
+	case port != "":
+		return port
+	case sentinel.Enabled():
+		return "26379"
+	default:
+		return "6379"
+	}
+}
+
+func setPort(value string) error {
+	if p, err := strconv.Atoi(value); err != nil || p < 1 || p > 65535 {
+		return errors.New("argument must be between 1 and 65535")
+	}
+
+	port = value
+	return nil
+}
+
+// bind lists the addresses to listen on. "*" is every IPv4 address, "::*"
+// every IPv6 one, and a "-" prefix marks an address that may be
+// unavailable.
+var bind = "* -::*"
+
+func Bind() string {
+	return bind
+}
+
+func setBind(value string) error {
+	addrs := strings.Fields(value)
+	if len(addrs) == 0 {
+		return errors.New("at least one address is needed")
+	}
+
+	for _, addr := range addrs {
+		addr = strings.TrimPrefix(addr, "-")
+		if addr != "*" && addr != "::*" && net.ParseIP(addr) == nil {
+			return fmt.Errorf("invalid address '%v'", addr)
+		}
+	}
+
+	bind = strings.Join(addrs, " ")
+	return nil
+}
+
+// BindAddr is an address to listen on, an optional one is skipped if it
+// can't be bound.
+type BindAddr struct {
+	Host     string
+	Optional bool
+}
+
+func BindAddrs() []BindAddr {
+	result := []BindAddr{}
+	for _, addr := range strings.Fields(bind) {
+		host, optional := strings.CutPrefix(addr, "-")
+		switch host {
+		case "*":
+			host = "0.0.0.0"
+		case "::*":
+			host = "::"
+		}
+
+		result = append(result, BindAddr{host, optional})
+	}
+
+	return result
+}
+
+// Get returns the names and values of all parameters matching one of the
+// glob-style patterns, as alternating name/value pairs. Each parameter is
+// returned once, even if several patterns match it.
+func Get(patterns ...string) []string {
+	names := []string{}
+	for name := range parameters {
+		for _, pattern := range patterns {
+			if glob.Match(strings.ToLower(pattern), name) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+
+	slices.Sort(names)
+
+	result := make([]string, 0, len(names)*2)
+	for _, name := range names {
+		result = append(result, name, parameters[name].get())
+	}
+
+	return result
+}
+
+// Set sets parameters given as alternating name/value pairs. Either all
+// of them are set or, if one fails, the ones before are restored.
+func Set(pairs []string) error {
+	seen := map[string]bool{}
+	for i := 0; i < len(pairs); i += 2 {
+		name := strings.ToLower(pairs[i])
+		if _, ok := parameters[name]; !ok {
+			return fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%v'", pairs[i])
+		}
+
+		if immutable[name] {
+			return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%v') - can't set immutable config", pairs[i])
+		}
+
+		if seen[name] {
+			return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%v') - duplicate parameter", pairs[i])
+		}
+
+		seen[name] = true
+	}
+
+	old := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		param := parameters[strings.ToLower(pairs[i])]
+		old = append(old, param.get())
+
+		if err := set(pairs[i], param, pairs[i+1]); err != nil {
+			for j := range old[:len(old)-1] {
+				parameters[strings.ToLower(pairs[2*j])].set(old[j])
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func set(name string, param parameter, value string) error {
+	if err := param.set(value); err != nil {
+		return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%v') - %w", name, err)
+	}
+
+	return nil
+}
+
+// defaults are the values of the parameters before the config file and
+// the command line were applied, CONFIG REWRITE only adds parameters that
+// differ from them.
+var defaults map[string]string
+
+// ParseArgs applies the arguments the server was started with, an optional
+// config file followed by options like "--port 6380" that override it.
+// Values can span multiple arguments, e.g. "--save 60 1000".
+func ParseArgs(args []string) error {
+	defaults = map[string]string{}
+	for name, param := range parameters {
+		defaults[name] = param.get()
+	}
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		if err := loadFile(args[0]); err != nil {
+			return fmt.Errorf("reading the configuration file %v: %w", args[0], err)
+		}
+
+		args = args[1:]
+	}
+
+	for i := 0; i < len(args); {
+		name, ok := strings.CutPrefix(args[i], "--")
+		if !ok {
+			return fmt.Errorf("unexpected argument %q", args[i])
+		}
+
+		values := []string{}
+		for i++; i < len(args) && !strings.HasPrefix(args[i], "--"); i++ {
+			values = append(values, args[i])
+		}
+
+		value := strings.Join(values, " ")
+
+		if directive, ok := directives[strings.ToLower(name)]; ok {
+			if err := directive(value); err != nil {
+				return fmt.Errorf("%v: %w", name, err)
+			}
+
+			continue
+		}
+
+		param, ok := parameters[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("unknown option '%v'", name)
+		}
+
+		if err := set(name, param, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
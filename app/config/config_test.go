@@ -0,0 +1,31 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		want     []string
+	}{
+		{[]string{"port"}, []string{"port"}},
+		{[]string{"PORT"}, []string{"port"}},
+		{[]string{"port", "p*"}, []string{"port"}},
+		{[]string{"dir", "d*"}, []string{"dbfilename", "dir"}},
+		{[]string{"no-such-parameter"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		got := []string{}
+		pairs := Get(tt.patterns...)
+		for i := 0; i < len(pairs); i += 2 {
+			got = append(got, pairs[i])
+		}
+
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Get(%q) returned %q, want %q", tt.patterns, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// configFile is the absolute path of the config file the server was
+// started with, CONFIG REWRITE updates it.
+var configFile string
+
+// listValues are parameters whose value is a list of words, they are
+// written without quotes.
+var listValues = map[string]bool{
+	"save":      true,
+	"bind":      true,
+	"replicaof": true,
+}
+
+// rewriteMarker precedes the parameters CONFIG REWRITE appends.
+const rewriteMarker = "# Generated by CONFIG REWRITE"
+
+// loadFile applies a redis.conf-style file, one "<name> <value> ..." per
+// line. Empty lines and comments starting with "#" are skipped. Like in
+// Redis, the save rules of several "save" lines add up.
+func loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	saves := []string(nil)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := applyLine(line, &saves); err != nil {
+			return fmt.Errorf("at line %d\n>>> '%v'\n%w", i+1, line, err)
+		}
+	}
+
+	if saves != nil {
+		if err := parameters["save"].set(strings.Join(saves, " ")); err != nil {
+			return err
+		}
+	}
+
+	if configFile, err = filepath.Abs(path); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func applyLine(line string, saves *[]string) error {
+	args, err := splitArgs(line)
+	if err != nil {
+		return err
+	}
+
+	name := strings.ToLower(args[0])
+	value := strings.Join(args[1:], " ")
+
+	if directive, ok := directives[name]; ok {
+		return directive(value)
+	}
+
+	param, ok := parameters[name]
+	if !ok || len(args) < 2 {
+		return errors.New("Bad directive or wrong number of arguments")
+	}
+
+	if name == "save" {
+		//save "" disables the rules of the lines before
+		if value == "" {
+			*saves = []string{}
+		} else {
+			*saves = append(*saves, value)
+		}
+
+		return nil
+	}
+
+	return param.set(value)
+}
+
+// splitArgs splits a line into words like Redis does. Words can be quoted
+// with double quotes, which support escapes like "\n" and "\x41", or with
+// single quotes, which only support "\'".
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+	for i := 0; ; {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+
+		if i == len(line) {
+			return args, nil
+		}
+
+		var word strings.Builder
+		switch line[i] {
+		case '"':
+			i++
+			for ; i < len(line) && line[i] != '"'; i++ {
+				if line[i] != '\\' || i+1 == len(line) {
+					word.WriteByte(line[i])
+					continue
+				}
+
+				i++
+				switch line[i] {
+				case 'n':
+					word.WriteByte('\n')
+				case 'r':
+					word.WriteByte('\r')
+				case 't':
+					word.WriteByte('\t')
+				case 'b':
+					word.WriteByte('\b')
+				case 'a':
+					word.WriteByte('\a')
+				case 'x':
+					if i+2 < len(line) {
+						if b, err := strconv.ParseUint(line[i+1:i+3], 16, 8); err == nil {
+							word.WriteByte(byte(b))
+							i += 2
+							break
+						}
+					}
+
+					word.WriteByte('x')
+				default:
+					word.WriteByte(line[i])
+				}
+			}
+		case '\'':
+			i++
+			for ; i < len(line) && line[i] != '\''; i++ {
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+				}
+
+				word.WriteByte(line[i])
+			}
+		default:
+			for ; i < len(line) && !isSpace(line[i]); i++ {
+				word.WriteByte(line[i])
+			}
+
+			args = append(args, word.String())
+			continue
+		}
+
+		//the closing quote has to end the word
+		if i == len(line) || i+1 < len(line) && !isSpace(line[i+1]) {
+			return nil, errors.New("unbalanced quotes in configuration line")
+		}
+
+		args = append(args, word.String())
+		i++
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// Rewrite updates the config file with the current parameters. Lines of
+// parameters are rewritten in place and parameters that differ from their
+// defaults are appended, everything else is kept as it is.
+func Rewrite() error {
+	if configFile == "" {
+		return errors.New("ERR The server is running without a config file")
+	}
+
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return fmt.Errorf("ERR Rewriting config file: %w", err)
+	}
+
+	current := map[string]string{}
+	for name, param := range parameters {
+		current[name] = param.get()
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	content := strings.Join(rewriteLines(lines, current, defaults), "\n") + "\n"
+
+	tmp, err := os.CreateTemp(filepath.Dir(configFile), "redis-conf-*.tmp")
+	if err != nil {
+		return fmt.Errorf("ERR Rewriting config file: %w", err)
+	}
+
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("ERR Rewriting config file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("ERR Rewriting config file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), configFile); err != nil {
+		return fmt.Errorf("ERR Rewriting config file: %w", err)
+	}
+
+	return nil
+}
+
+// rewriteLines replaces the first line of each parameter with its current
+// value and drops the repeated ones. Parameters that aren't in the file
+// but differ from their defaults are appended, after the marker the first
+// rewrite added.
+func rewriteLines(lines []string, current, defaults map[string]string) []string {
+	written := map[string]bool{}
+	result := []string{}
+	marked := false
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		marked = marked || trimmed == rewriteMarker
+
+		args, err := splitArgs(trimmed)
+		if err != nil || len(args) == 0 || strings.HasPrefix(trimmed, "#") {
+			result = append(result, line)
+			continue
+		}
+
+		name := strings.ToLower(args[0])
+		value, ok := current[name]
+		if !ok {
+			result = append(result, line)
+			continue
+		}
+
+		if !written[name] {
+			result = append(result, formatLine(name, value))
+			written[name] = true
+		}
+	}
+
+	appended := []string{}
+	for name, value := range current {
+		if !written[name] && value != defaults[name] {
+			appended = append(appended, formatLine(name, value))
+		}
+	}
+
+	if len(appended) > 0 {
+		slices.Sort(appended)
+		if !marked {
+			result = append(result, rewriteMarker)
+		}
+
+		result = append(result, appended...)
+	}
+
+	return result
+}
+
+func formatLine(name, value string) string {
+	if listValues[name] && value != "" {
+		return name + " " + value
+	}
+
+	return name + " " + quote(value)
+}
+
+// quote quotes a value if it is empty or would otherwise be split or
+// unescaped by splitArgs.
+func quote(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\r\n\"'\\") {
+		return value
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' || c == '"':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c < ' ' || c == 0x7f:
+			fmt.Fprintf(&sb, `\x%02x`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	sb.WriteByte('"')
+	return sb.String()
+}
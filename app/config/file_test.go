@@ -0,0 +1,91 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"port 6380", []string{"port", "6380"}},
+		{"  save 60   1000\t", []string{"save", "60", "1000"}},
+		{`dir "/tmp/with space"`, []string{"dir", "/tmp/with space"}},
+		{`notify-keyspace-events ""`, []string{"notify-keyspace-events", ""}},
+		{`x "a\n\x41\"b"`, []string{"x", "a\nA\"b"}},
+		{`x 'it\'s "raw"\n'`, []string{"x", `it's "raw"\n`}},
+	}
+
+	for _, tt := range tests {
+		got, err := splitArgs(tt.line)
+		if err != nil {
+			t.Errorf("splitArgs(%q) error = %v", tt.line, err)
+			continue
+		}
+
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestSplitArgsUnbalanced(t *testing.T) {
+	for _, line := range []string{`dir "/tmp`, `dir "/tmp"x`, `dir 'a`} {
+		if _, err := splitArgs(line); err == nil {
+			t.Errorf("splitArgs(%q) succeeded", line)
+		}
+	}
+}
+
+func TestRewriteLines(t *testing.T) {
+	lines := []string{
+		"# a comment",
+		"port 6380",
+		"save 900 1",
+		"save 300 10",
+		"",
+		"sentinel monitor mymaster 127.0.0.1 6379 2",
+		"appendonly no",
+	}
+
+	current := map[string]string{
+		"port":                   "6380",
+		"save":                   "60 1000",
+		"appendonly":             "yes",
+		"dir":                    "/tmp/with space",
+		"notify-keyspace-events": "",
+		"dbfilename":             "dump.rdb",
+	}
+
+	defaults := map[string]string{
+		"port":                   "6379",
+		"save":                   "3600 1 300 100 60 10000",
+		"appendonly":             "no",
+		"dir":                    ".",
+		"notify-keyspace-events": "",
+		"dbfilename":             "dump.rdb",
+	}
+
+	want := []string{
+		"# a comment",
+		"port 6380",
+		"save 60 1000",
+		"",
+		"sentinel monitor mymaster 127.0.0.1 6379 2",
+		"appendonly yes",
+		rewriteMarker,
+		`dir "/tmp/with space"`,
+	}
+
+	got := rewriteLines(lines, current, defaults)
+	if !slices.Equal(got, want) {
+		t.Errorf("rewriteLines() = %q, want %q", got, want)
+	}
+
+	//rewriting again only keeps the marker
+	if again := rewriteLines(got, current, defaults); !slices.Equal(again, want) {
+		t.Errorf("second rewriteLines() = %q, want %q", again, want)
+	}
+}
@@ -0,0 +1,125 @@
+package glob
+
+// Match reports whether str matches the Redis glob-style pattern.
+// It supports '*', '?', character classes like [abc], [^a] and [a-z],
+// and '\' to escape the next character.
+func Match(pattern, str string) bool {
+	skipLongerMatches := false
+	return match([]byte(pattern), []byte(str), &skipLongerMatches)
+}
+
+// match is the matcher of Redis 7. Once a star failed to match the rest of
+// the string at any length, the stars before it can't do better by matching
+// longer, skipLongerMatches stops them so matching doesn't take exponential
+// time.
+func match(pattern, str []byte, skipLongerMatches *bool) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			//collapse consecutive stars, they match the same strings
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for i := 0; i <= len(str); i++ {
+				if match(pattern[1:], str[i:], skipLongerMatches) {
+					return true
+				}
+
+				if *skipLongerMatches {
+					return false
+				}
+			}
+
+			*skipLongerMatches = true
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+
+			matched, rest := matchClass(pattern[1:], str[0])
+			if !matched {
+				return false
+			}
+
+			pattern = rest
+			str = str[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+
+			str = str[1:]
+		}
+
+		pattern = pattern[1:]
+	}
+
+	return len(str) == 0
+}
+
+// matchClass matches c against the class starting after '[' and returns
+// the pattern following the closing ']'. An unterminated class extends
+// to the end of the pattern, like in Redis.
+func matchClass(pattern []byte, c byte) (bool, []byte) {
+	negate := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate = true
+		pattern = pattern[1:]
+	}
+
+	matched := false
+
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			if pattern[1] == c {
+				matched = true
+			}
+
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			start, end := pattern[0], pattern[2]
+			if start > end {
+				start, end = end, start
+			}
+
+			if c >= start && c <= end {
+				matched = true
+			}
+
+			pattern = pattern[3:]
+		default:
+			if pattern[0] == c {
+				matched = true
+			}
+
+			pattern = pattern[1:]
+		}
+	}
+
+	if len(pattern) > 0 {
+		//skip the closing bracket
+		pattern = pattern[1:]
+	}
+
+	return matched != negate, pattern
+}
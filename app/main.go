@@ -7,18 +7,63 @@ import (
 	"io"
 	"net"
 	"os"
-	"redis-clone-go/app/commands"
+	"redis-clone-go/app/cluster"
+	"redis-clone-go/app/config"
+	"redis-clone-go/app/persistence"
 	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/replication"
+	"redis-clone-go/app/sentinel"
+	"strings"
+	"time"
 )
 
 func main() {
-	l, err := net.Listen("tcp", "0.0.0.0:6379")
-	if err != nil {
-		fmt.Println("Failed to bind to port 6379")
+	if len(os.Args) > 1 && os.Args[1] == "check-aof" {
+		os.Exit(checkAOF(os.Args[2:]))
+	}
+
+	if err := config.ParseArgs(os.Args[1:]); err != nil {
+		fmt.Println("Error parsing arguments: ", err.Error())
+		os.Exit(1)
+	}
+
+	if sentinel.Enabled() {
+		//a sentinel has no dataset, it only monitors masters
+		commandTable = sentinelCommandTable
+		sentinel.Start(config.Port())
+	} else {
+		startServer()
+	}
+
+	listeners := []net.Listener{}
+	for _, addr := range config.BindAddrs() {
+		l, err := net.Listen("tcp", net.JoinHostPort(addr.Host, config.Port()))
+		if err != nil {
+			if addr.Optional {
+				continue
+			}
+
+			fmt.Printf("Failed to bind to %v: %v\n", net.JoinHostPort(addr.Host, config.Port()), err)
+			os.Exit(1)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	if len(listeners) == 0 {
+		fmt.Printf("Failed to bind to port %v\n", config.Port())
 		os.Exit(1)
 	}
 
 	fmt.Println("Listening..")
+	for _, l := range listeners {
+		go serve(l)
+	}
+
+	select {}
+}
+
+func serve(l net.Listener) {
 	defer l.Close()
 
 	for {
@@ -33,15 +78,45 @@ func main() {
 	}
 }
 
+// startServer loads the dataset and starts replication and background
+// saves.
+func startServer() {
+	if cluster.Enabled() {
+		if err := cluster.Start(config.Port()); err != nil {
+			fmt.Println("Error loading the cluster configuration: ", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if err := persistence.Load(replayer()); err != nil {
+		fmt.Println("Error loading data: ", err.Error())
+		os.Exit(1)
+	}
+
+	replication.Start(replication.Hooks{
+		Load:     loadFromMaster,
+		Replayer: masterReplayer,
+		Port:     config.Port,
+		Applied:  persistence.AdvanceAOFOffset,
+		FsyncedOffset: func() int64 {
+			offset, _ := persistence.FsyncedOffset()
+			return offset
+		},
+	})
+
+	go saveOnChanges()
+}
+
 func handleConnection(conn net.Conn) {
-	defer conn.Close()
+	c := newClient(conn)
+	defer c.close()
 
 	reader := bufio.NewReader(conn)
 
 	for {
 		command, err := protocol.ParseCommand(reader)
 		if err != nil {
-			if errors.Is(err, io.EOF) {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
 				fmt.Println("client disconnected")
 				return
 			}
@@ -50,46 +125,162 @@ func handleConnection(conn net.Conn) {
 			continue
 		}
 
-		response, err := handleCommand(command)
+		if command.Name == "QUIT" {
+			c.reply(protocol.FormatSimpleString("OK"))
+			return
+		}
+
+		if c.isSubscribed() && !allowedWhileSubscribed[command.Name] {
+			c.reply(protocol.FormatError(fmt.Errorf(
+				"ERR Can't execute '%v': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context",
+				strings.ToLower(command.Name))))
+			continue
+		}
+
+		response, err := handleCommand(c, command)
 		if err != nil {
-			conn.Write(protocol.FormatError(err))
+			c.reply(protocol.FormatError(err))
+		}
+
+		//ASKING only applies to the command that follows it
+		if command.Name != "ASKING" {
+			c.asking = false
 		}
 
-		conn.Write(response)
+		c.reply(response)
 	}
 }
 
-func handleCommand(command *protocol.Command) ([]byte, error) {
+var allowedWhileSubscribed = map[string]bool{
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"PSUBSCRIBE":   true,
+	"PUNSUBSCRIBE": true,
+	"SSUBSCRIBE":   true,
+	"SUNSUBSCRIBE": true,
+	"PING":         true,
+}
+
+func handleCommand(c *client, command *protocol.Command) ([]byte, error) {
 	switch command.Name {
-	case "PING":
-		return commands.Ping()
-	case "ECHO":
-		return commands.Echo(command.Args)
-	case "SET":
-		return commands.Set(command.Args)
-	case "GET":
-		return commands.Get(command.Args)
-	case "RPUSH":
-		return commands.Rpush(command.Args)
-	case "LRANGE":
-		return commands.Lrange(command.Args)
-	case "LPUSH":
-		return commands.Lpush(command.Args)
-	case "LLEN":
-		return commands.Llen(command.Args)
-	case "LPOP":
-		return commands.Lpop(command.Args)
-	case "BLPOP":
-		return commands.Blpop(command.Args)
-	case "TYPE":
-		return commands.Type(command.Args)
-	case "XADD":
-		return commands.XAdd(command.Args)
-	case "XRANGE":
-		return commands.XRange(command.Args)
-	case "XREAD":
-		return commands.XRead(command.Args)
+	case "MULTI":
+		return c.multi()
+	case "EXEC":
+		return c.exec()
+	case "DISCARD":
+		return c.discard()
+	case "WATCH":
+		if err := c.checkCluster(command); err != nil {
+			return nil, err
+		}
+
+		return c.watch(command.Args)
+	}
+
+	if c.tx != nil {
+		return c.queue(command)
+	}
+
+	spec, err := lookupCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.checkReplica(spec); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkCluster(command); err != nil {
+		return nil, err
+	}
+
+	//writes hold the lock exclusively, so they are propagated in the same
+	//order as they were applied
+	switch {
+	case spec.flags&(flagBlocking|flagNoLock) != 0:
+	case spec.flags&flagWrite != 0:
+		execLock.Lock()
+		defer execLock.Unlock()
 	default:
-		return nil, fmt.Errorf("unkown command '%v'", command.Name)
+		execLock.RLock()
+		defer execLock.RUnlock()
+	}
+
+	return call(c, spec, command)
+}
+
+// saveOnChanges starts a background save whenever one of the save rules is
+// met. The snapshot is taken under the transaction lock, so it never
+// contains half of a transaction.
+func saveOnChanges() {
+	for range time.Tick(time.Second) {
+		if !persistence.ShouldSave() {
+			continue
+		}
+
+		execLock.RLock()
+		if err := persistence.BackgroundSave(); err != nil {
+			fmt.Println("Error starting background save: ", err.Error())
+		}
+		execLock.RUnlock()
+	}
+}
+
+// replayer returns the function that executes the commands of the AOF. They
+// all run on the same client, so transactions are replayed as such.
+func replayer() persistence.ReplayFunc {
+	c := newClient(nil)
+	c.replaying = true
+
+	return func(command *protocol.Command) error {
+		_, known := commandTable[command.Name]
+		if !known && command.Name != "MULTI" && command.Name != "EXEC" {
+			return fmt.Errorf("unknown command '%v' in the AOF", command.Name)
+		}
+
+		//like in Redis, errors of single commands don't stop loading
+		handleCommand(c, command)
+		return nil
+	}
+}
+
+// loadFromMaster replaces the dataset with the one received from the
+// master.
+func loadFromMaster(data []byte) error {
+	execLock.Lock()
+	defer execLock.Unlock()
+	return persistence.LoadRDB(data)
+}
+
+// masterReplayer returns the function that executes the commands received
+// from the master, on a client of its own like the AOF.
+func masterReplayer() func(*protocol.Command) {
+	c := newClient(nil)
+	c.replaying = true
+
+	return func(command *protocol.Command) {
+		handleCommand(c, command)
+	}
+}
+
+// checkAOF implements "check-aof [--fix] <file>", which validates an AOF
+// file or manifest offline like redis-check-aof.
+func checkAOF(args []string) int {
+	fix := len(args) == 2 && args[0] == "--fix"
+	if len(args) != 1 && !fix {
+		fmt.Println("Usage: check-aof [--fix] <file.manifest|file.aof>")
+		return 1
+	}
+
+	ok, err := persistence.CheckAOF(args[len(args)-1], fix, os.Stdout)
+	if err != nil {
+		fmt.Println("Error checking the AOF: ", err.Error())
+		return 1
 	}
+
+	if !ok {
+		return 1
+	}
+
+	return 0
 }
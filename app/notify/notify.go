@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"fmt"
+	"redis-clone-go/app/pubsub"
+	"strings"
+	"sync/atomic"
+)
+
+// Event classes as used by the notify-keyspace-events setting.
+const (
+	Keyspace = 1 << iota
+	Keyevent
+	Generic
+	String
+	List
+	Set
+	Hash
+	Zset
+	Expired
+	Evicted
+	Stream
+)
+
+const allClasses = Generic | String | List | Set | Hash | Zset | Expired | Evicted | Stream
+
+var flags atomic.Int64
+
+// classFlags is ordered like in Redis, so Flags returns the same string.
+var classFlags = []struct {
+	char  byte
+	class int64
+}{
+	{'g', Generic},
+	{'$', String},
+	{'l', List},
+	{'s', Set},
+	{'h', Hash},
+	{'z', Zset},
+	{'x', Expired},
+	{'e', Evicted},
+	{'t', Stream},
+	{'K', Keyspace},
+	{'E', Keyevent},
+}
+
+// SetFlags parses a notify-keyspace-events value like "KEA" or "Kx$".
+func SetFlags(value string) error {
+	parsed := int64(0)
+
+	for i := range len(value) {
+		if value[i] == 'A' {
+			parsed |= allClasses
+			continue
+		}
+
+		found := false
+		for _, flag := range classFlags {
+			if flag.char == value[i] {
+				parsed |= flag.class
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("invalid notify-keyspace-events flag %q", value[i])
+		}
+	}
+
+	flags.Store(parsed)
+	return nil
+}
+
+func Flags() string {
+	current := flags.Load()
+
+	var sb strings.Builder
+	if current&allClasses == allClasses {
+		sb.WriteByte('A')
+	}
+
+	for _, flag := range classFlags {
+		isClass := flag.class&allClasses != 0
+		if isClass && current&allClasses == allClasses {
+			continue
+		}
+
+		if current&flag.class != 0 {
+			sb.WriteByte(flag.char)
+		}
+	}
+
+	return sb.String()
+}
+
+// KeyspaceEvent publishes the event for key if its class is enabled.
+func KeyspaceEvent(class int64, event, key string) {
+	current := flags.Load()
+	if current&class == 0 {
+		return
+	}
+
+	if current&Keyspace != 0 {
+		pubsub.PS.Publish("__keyspace@0__:"+key, event)
+	}
+
+	if current&Keyevent != 0 {
+		pubsub.PS.Publish("__keyevent@0__:"+event, key)
+	}
+}
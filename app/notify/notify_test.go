@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/pubsub"
+	"slices"
+	"testing"
+)
+
+func TestSetFlags(t *testing.T) {
+	defer SetFlags("")
+
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"KEA", "AKE", false},
+		{"Kx$", "$xK", false},
+		{"Elsg", "glsE", false},
+		{"Ke", "eK", false},
+		{"g$lshzxet", "A", false},
+		{"AK", "AK", false},
+		{"KQ", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			SetFlags("")
+
+			err := SetFlags(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetFlags(%q) error = %v", tt.value, err)
+			}
+
+			if got := Flags(); got != tt.want {
+				t.Errorf("Flags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyspaceEvent(t *testing.T) {
+	defer SetFlags("")
+
+	sub := pubsub.NewSubscriber()
+	pubsub.PS.Subscribe(sub, []string{"__keyspace@0__:key", "__keyevent@0__:rpush", "__keyevent@0__:set"})
+	defer pubsub.PS.RemoveSubscriber(sub)
+	sub.Take()
+
+	tests := []struct {
+		flags string
+		class int64
+		event string
+		want  [][]string
+	}{
+		{"KEl", List, "rpush", [][]string{
+			{"message", "__keyspace@0__:key", "rpush"},
+			{"message", "__keyevent@0__:rpush", "key"},
+		}},
+		{"Kl", List, "rpush", [][]string{{"message", "__keyspace@0__:key", "rpush"}}},
+		{"El", List, "rpush", [][]string{{"message", "__keyevent@0__:rpush", "key"}}},
+		{"KEA", String, "set", [][]string{
+			{"message", "__keyspace@0__:key", "set"},
+			{"message", "__keyevent@0__:set", "key"},
+		}},
+		{"KEl", String, "set", nil},
+		{"l", List, "rpush", nil},
+	}
+
+	for _, tt := range tests {
+		SetFlags(tt.flags)
+		KeyspaceEvent(tt.class, tt.event, "key")
+
+		want := [][]byte{}
+		for _, message := range tt.want {
+			want = append(want, protocol.FormatBulkStringArray(message))
+		}
+
+		if got := sub.Take(); !slices.EqualFunc(got, want, slices.Equal) {
+			t.Errorf("%q with %q published %q, want %q", tt.event, tt.flags, got, want)
+		}
+	}
+}
@@ -0,0 +1,499 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"redis-clone-go/app/protocol"
+	"sync"
+	"time"
+)
+
+const (
+	fsyncAlways   = "always"
+	fsyncEverySec = "everysec"
+	fsyncNo       = "no"
+)
+
+var (
+	aofMu          sync.Mutex
+	appendOnly     bool
+	appendFsync    = fsyncEverySec
+	appendDirname  = "appendonlydir"
+	appendFilename = "appendonly.aof"
+	// aofLoadTruncated allows loading an AOF whose last command was only
+	// partially written.
+	aofLoadTruncated = true
+
+	// started is set once the dataset was loaded, from then on enabling
+	// appendonly creates the AOF.
+	started bool
+	// aofFile is the incremental file commands are appended to, it is nil
+	// while the AOF is disabled or being loaded.
+	aofFile      *os.File
+	aofManifest  manifest
+	aofRewriting bool
+	fsyncOnce    sync.Once
+
+	// writtenOffset is the replication offset the AOF is written up to and
+	// fsyncedOffset the one it is known to be on disk up to, WAITAOF waits
+	// for the latter. fsynced is closed and replaced whenever it advances.
+	writtenOffset int64
+	fsyncedOffset int64
+	fsynced       = make(chan struct{})
+)
+
+var errRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+
+// FeedAOF appends a write command to the AOF.
+func FeedAOF(args []string) {
+	aofMu.Lock()
+	defer aofMu.Unlock()
+
+	if aofFile == nil {
+		return
+	}
+
+	if _, err := aofFile.Write(protocol.FormatBulkStringArray(args)); err != nil {
+		fmt.Println("Error writing to the AOF: ", err.Error())
+		return
+	}
+
+	if appendFsync == fsyncAlways {
+		if err := aofFile.Sync(); err != nil {
+			fmt.Println("Error syncing the AOF: ", err.Error())
+		}
+	}
+}
+
+// AdvanceAOFOffset records that the commands up to the replication offset
+// were fed to the AOF. With appendfsync always they are on disk already.
+func AdvanceAOFOffset(offset int64) {
+	aofMu.Lock()
+	defer aofMu.Unlock()
+
+	writtenOffset = offset
+	if aofFile != nil && appendFsync == fsyncAlways {
+		setFsyncedOffset(offset)
+	}
+}
+
+// FsyncedOffset returns the replication offset up to which the AOF is on
+// disk, and a channel that is closed once that changes.
+func FsyncedOffset() (int64, <-chan struct{}) {
+	aofMu.Lock()
+	defer aofMu.Unlock()
+	return fsyncedOffset, fsynced
+}
+
+// setFsyncedOffset wakes up the clients waiting in WAITAOF, aofMu has to
+// be held.
+func setFsyncedOffset(offset int64) {
+	if offset == fsyncedOffset {
+		return
+	}
+
+	fsyncedOffset = offset
+	close(fsynced)
+	fsynced = make(chan struct{})
+}
+
+// syncAOF flushes the AOF to disk, aofMu has to be held.
+func syncAOF() {
+	if err := aofFile.Sync(); err != nil {
+		fmt.Println("Error syncing the AOF: ", err.Error())
+		return
+	}
+
+	setFsyncedOffset(writtenOffset)
+}
+
+func fsyncEverySecond() {
+	for range time.Tick(time.Second) {
+		aofMu.Lock()
+		if aofFile != nil && appendFsync == fsyncEverySec {
+			syncAOF()
+		}
+		aofMu.Unlock()
+	}
+}
+
+// loadAOF replays the base and incremental files listed in the manifest.
+// Without a manifest the AOF is created from the RDB file, if there is one.
+func loadAOF(replay ReplayFunc) error {
+	aofDir := aofDirPath()
+
+	m, err := readManifest(filepath.Join(aofDir, manifestName()))
+	if errors.Is(err, os.ErrNotExist) {
+		if err := loadRDBFile(path()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		return startAOF()
+	}
+
+	if err != nil {
+		return fmt.Errorf("error reading the AOF manifest: %w", err)
+	}
+
+	if m.base != nil {
+		basePath := filepath.Join(aofDir, m.base.name)
+		if filepath.Ext(m.base.name) == ".rdb" {
+			err = loadRDBFile(basePath)
+		} else {
+			err = replayAOFFile(basePath, replay, false)
+		}
+
+		if err != nil {
+			return fmt.Errorf("error loading %v: %w", m.base.name, err)
+		}
+	}
+
+	for i, incr := range m.incrs {
+		last := i == len(m.incrs)-1
+		if err := replayAOFFile(filepath.Join(aofDir, incr.name), replay, last); err != nil {
+			return fmt.Errorf("error loading %v: %w", incr.name, err)
+		}
+	}
+
+	aofMu.Lock()
+	defer aofMu.Unlock()
+
+	if len(m.incrs) == 0 {
+		incr := newManifestEntry(m.lastSeq()+1, aofTypeIncr)
+		m.incrs = append(m.incrs, incr)
+
+		if err := writeManifest(aofDir, m); err != nil {
+			return err
+		}
+	}
+
+	file, err := openIncr(m.incrs[len(m.incrs)-1])
+	if err != nil {
+		return err
+	}
+
+	aofManifest = m
+	aofFile = file
+	fsyncOnce.Do(func() { go fsyncEverySecond() })
+
+	return nil
+}
+
+// replayAOFFile replays the commands of an AOF file. If the file is
+// truncated and may be fixed, it is cut back to its last complete command
+// when aof-load-truncated is enabled.
+func replayAOFFile(path string, replay ReplayFunc, truncatable bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	commands, valid, err := scanAOF(file, replay)
+	if errors.Is(err, errAOFTruncated) && truncatable {
+		aofMu.Lock()
+		loadTruncated := aofLoadTruncated
+		aofMu.Unlock()
+
+		if !loadTruncated {
+			return fmt.Errorf("%w, use 'check-aof --fix' on the manifest or enable aof-load-truncated", err)
+		}
+
+		fmt.Printf("!!! Warning: short read while loading the AOF file %v, truncating it at offset %d\n", path, valid)
+		err = os.Truncate(path, valid)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("AOF loaded from %v: %d commands\n", path, commands)
+	return nil
+}
+
+// startAOF creates a new AOF from the current dataset. Files of a previous
+// AOF are removed. The caller has to make sure nothing is written
+// meanwhile.
+func startAOF() error {
+	aofMu.Lock()
+	defer aofMu.Unlock()
+
+	aofDir := aofDirPath()
+	if err := os.MkdirAll(aofDir, 0755); err != nil {
+		return err
+	}
+
+	previous, err := readManifest(filepath.Join(aofDir, manifestName()))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading the AOF manifest: %w", err)
+	}
+
+	seq := previous.lastSeq() + 1
+	base := newManifestEntry(seq, aofTypeBase)
+	incr := newManifestEntry(seq, aofTypeIncr)
+
+	snapshot, _ := takeSnapshot()
+	if err := writeRDBFile(filepath.Join(aofDir, base.name), snapshot); err != nil {
+		return err
+	}
+
+	file, err := openIncr(incr)
+	if err != nil {
+		return err
+	}
+
+	m := manifest{base: &base, incrs: []manifestEntry{incr}}
+	if err := writeManifest(aofDir, m); err != nil {
+		file.Close()
+		return err
+	}
+
+	removeAOFFiles(previous.files())
+
+	aofManifest = m
+	aofFile = file
+	fsyncOnce.Do(func() { go fsyncEverySecond() })
+
+	//the base file contains everything written so far
+	setFsyncedOffset(writtenOffset)
+
+	return nil
+}
+
+func stopAOF() {
+	aofMu.Lock()
+	defer aofMu.Unlock()
+
+	if aofFile == nil {
+		return
+	}
+
+	syncAOF()
+	aofFile.Close()
+	aofFile = nil
+}
+
+// RewriteAOF compacts the AOF into a new base file in the background. New
+// writes go to a new incremental file right away, the old files are
+// removed once the new base is written. The caller has to make sure
+// nothing is written while the rewrite starts.
+func RewriteAOF() error {
+	aofMu.Lock()
+	defer aofMu.Unlock()
+
+	if aofFile == nil {
+		return errors.New("ERR Append only file is not enabled")
+	}
+
+	if aofRewriting {
+		return errRewriteInProgress
+	}
+
+	aofDir := aofDirPath()
+	previous := aofManifest
+	seq := previous.lastSeq() + 1
+	incr := newManifestEntry(seq, aofTypeIncr)
+
+	file, err := openIncr(incr)
+	if err != nil {
+		return err
+	}
+
+	//until the new base is written, the old files are still needed
+	m := manifest{base: previous.base, incrs: append(previous.incrs[:len(previous.incrs):len(previous.incrs)], incr)}
+	if err := writeManifest(aofDir, m); err != nil {
+		file.Close()
+		return err
+	}
+
+	syncAOF()
+	aofFile.Close()
+	aofFile = file
+	aofManifest = m
+	aofRewriting = true
+
+	snapshot, _ := takeSnapshot()
+	base := newManifestEntry(seq, aofTypeBase)
+
+	go func() {
+		err := writeRDBFile(filepath.Join(aofDir, base.name), snapshot)
+
+		aofMu.Lock()
+		defer aofMu.Unlock()
+		aofRewriting = false
+
+		if err != nil {
+			fmt.Println("Background AOF rewrite error: ", err.Error())
+			return
+		}
+
+		//keep the incremental files started during the rewrite
+		rewritten := manifest{base: &base, incrs: aofManifest.incrs[len(previous.incrs):]}
+		if err := writeManifest(aofDir, rewritten); err != nil {
+			fmt.Println("Background AOF rewrite error: ", err.Error())
+			return
+		}
+
+		removeAOFFiles(previous.files())
+		aofManifest = rewritten
+		fmt.Println("Background AOF rewrite finished successfully")
+	}()
+
+	return nil
+}
+
+func openIncr(incr manifestEntry) (*os.File, error) {
+	return os.OpenFile(filepath.Join(aofDirPath(), incr.name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+func removeAOFFiles(files []manifestEntry) {
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(aofDirPath(), file.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Println("Error removing old AOF file: ", err.Error())
+		}
+	}
+}
+
+func newManifestEntry(seq int, fileType string) manifestEntry {
+	extension := "aof"
+	if fileType == aofTypeBase {
+		extension = "rdb"
+	}
+
+	return manifestEntry{fmt.Sprintf("%v.%d.%v.%v", appendFilename, seq, fileType, extension), seq, fileType}
+}
+
+func aofDirPath() string {
+	return filepath.Join(Dir(), appendDirname)
+}
+
+func manifestName() string {
+	return appendFilename + ".manifest"
+}
+
+func AppendOnly() string {
+	aofMu.Lock()
+	defer aofMu.Unlock()
+	return yesNo(appendOnly)
+}
+
+// SetAppendOnly enables or disables the AOF. At runtime enabling it writes
+// the current dataset to a new AOF, so the caller has to make sure nothing
+// is written meanwhile.
+func SetAppendOnly(value string) error {
+	enable, err := parseYesNo(value)
+	if err != nil {
+		return err
+	}
+
+	aofMu.Lock()
+	changed := appendOnly != enable
+	appendOnly = enable
+	running := started
+	aofMu.Unlock()
+
+	if !changed || !running {
+		return nil
+	}
+
+	if enable {
+		return startAOF()
+	}
+
+	stopAOF()
+	return nil
+}
+
+func AofLoadTruncated() string {
+	aofMu.Lock()
+	defer aofMu.Unlock()
+	return yesNo(aofLoadTruncated)
+}
+
+func SetAofLoadTruncated(value string) error {
+	enable, err := parseYesNo(value)
+	if err != nil {
+		return err
+	}
+
+	aofMu.Lock()
+	defer aofMu.Unlock()
+	aofLoadTruncated = enable
+	return nil
+}
+
+func AppendFsync() string {
+	aofMu.Lock()
+	defer aofMu.Unlock()
+	return appendFsync
+}
+
+func SetAppendFsync(value string) error {
+	if value != fsyncAlways && value != fsyncEverySec && value != fsyncNo {
+		return fmt.Errorf("argument must be one of %v, %v or %v", fsyncAlways, fsyncEverySec, fsyncNo)
+	}
+
+	aofMu.Lock()
+	defer aofMu.Unlock()
+	appendFsync = value
+	return nil
+}
+
+func AppendDirname() string {
+	aofMu.Lock()
+	defer aofMu.Unlock()
+	return appendDirname
+}
+
+func SetAppendDirname(value string) error {
+	return setStartupOnly(&appendDirname, value)
+}
+
+func AppendFilename() string {
+	aofMu.Lock()
+	defer aofMu.Unlock()
+	return appendFilename
+}
+
+func SetAppendFilename(value string) error {
+	return setStartupOnly(&appendFilename, value)
+}
+
+// setStartupOnly sets the names of AOF files, which can't change once the
+// AOF may have been created.
+func setStartupOnly(target *string, value string) error {
+	if value == "" || filepath.Base(value) != value {
+		return errors.New("must be a file name, not a path")
+	}
+
+	aofMu.Lock()
+	defer aofMu.Unlock()
+
+	if started {
+		return errors.New("can't be changed at runtime")
+	}
+
+	*target = value
+	return nil
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+
+	return "no"
+}
+
+func parseYesNo(value string) (bool, error) {
+	switch value {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	default:
+		return false, errors.New("argument must be 'yes' or 'no'")
+	}
+}
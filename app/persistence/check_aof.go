@@ -0,0 +1,170 @@
+package persistence
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/rdb"
+	"strings"
+)
+
+// errAOFTruncated means the AOF ends in the middle of a command or of a
+// transaction, which happens when the server dies while writing it.
+var errAOFTruncated = errors.New("unexpected end of file")
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// scanAOF calls fn for every command of the AOF. It returns the number of
+// commands and the offset up to which the file is valid, that is after the
+// last command that isn't part of an unfinished transaction.
+func scanAOF(r io.Reader, fn func(*protocol.Command) error) (int, int64, error) {
+	counter := &countingReader{r: r}
+	reader := bufio.NewReader(counter)
+
+	commands := 0
+	valid := int64(0)
+	inMulti := false
+
+	for {
+		if _, err := reader.Peek(1); errors.Is(err, io.EOF) {
+			break
+		}
+
+		command, err := protocol.ParseCommand(reader)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return commands, valid, errAOFTruncated
+		}
+
+		if err != nil {
+			offset := counter.n - int64(reader.Buffered())
+			return commands, valid, fmt.Errorf("bad file format reading the append only file at offset %d: %w", offset, err)
+		}
+
+		if err := fn(command); err != nil {
+			return commands, valid, err
+		}
+
+		commands++
+
+		switch command.Name {
+		case "MULTI":
+			inMulti = true
+		case "EXEC", "DISCARD":
+			inMulti = false
+		}
+
+		if !inMulti {
+			valid = counter.n - int64(reader.Buffered())
+		}
+	}
+
+	if inMulti {
+		return commands, valid, errAOFTruncated
+	}
+
+	return commands, valid, nil
+}
+
+// CheckAOF validates an AOF file, or all files of the manifest it is given.
+// With fix, a truncated last incremental file is cut back to its last
+// complete command. It reports whether the AOF is valid afterwards.
+func CheckAOF(path string, fix bool, out io.Writer) (bool, error) {
+	if !strings.HasSuffix(path, ".manifest") {
+		return checkAOFFile(path, fix, out)
+	}
+
+	m, err := readManifest(path)
+	if err != nil {
+		return false, fmt.Errorf("error reading the manifest: %w", err)
+	}
+
+	aofDir := filepath.Dir(path)
+	files := m.files()
+
+	for i, file := range files {
+		filePath := filepath.Join(aofDir, file.name)
+
+		var ok bool
+		if filepath.Ext(file.name) == ".rdb" {
+			ok, err = checkRDBFile(filePath, out)
+		} else {
+			//only the tail of the AOF can be truncated by a crash
+			ok, err = checkAOFFile(filePath, fix && i == len(files)-1, out)
+		}
+
+		if err != nil || !ok {
+			return ok, err
+		}
+	}
+
+	return true, nil
+}
+
+func checkRDBFile(path string, out io.Writer) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := rdb.Read(data); err != nil {
+		fmt.Fprintf(out, "RDB preamble %v is not valid: %v\n", path, err)
+		return false, nil
+	}
+
+	fmt.Fprintf(out, "RDB preamble %v is valid\n", path)
+	return true, nil
+}
+
+func checkAOFFile(path string, fix bool, out io.Writer) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	commands, valid, err := scanAOF(file, func(*protocol.Command) error { return nil })
+	fmt.Fprintf(out, "AOF analyzed: filename=%v, size=%d, ok_up_to=%d, commands=%d, diff=%d\n",
+		path, info.Size(), valid, commands, info.Size()-valid)
+
+	if err == nil {
+		fmt.Fprintf(out, "AOF %v is valid\n", path)
+		return true, nil
+	}
+
+	fmt.Fprintf(out, "AOF %v is not valid: %v\n", path, err)
+	if !fix {
+		fmt.Fprintln(out, "Use the --fix option to try fixing it.")
+		return false, nil
+	}
+
+	if !errors.Is(err, errAOFTruncated) {
+		fmt.Fprintf(out, "The AOF is corrupted before its end, fixing it would drop %d bytes. Truncate it manually at offset %d if that is acceptable.\n",
+			info.Size()-valid, valid)
+		return false, nil
+	}
+
+	if err := os.Truncate(path, valid); err != nil {
+		return false, err
+	}
+
+	fmt.Fprintln(out, "Successfully truncated AOF", path)
+	return true, nil
+}
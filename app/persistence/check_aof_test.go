@@ -0,0 +1,54 @@
+package persistence
+
+import (
+	"errors"
+	"redis-clone-go/app/protocol"
+	"strings"
+	"testing"
+)
+
+func TestScanAOF(t *testing.T) {
+	set := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n"
+	multi := "*1\r\n$5\r\nMULTI\r\n"
+	exec := "*1\r\n$4\r\nEXEC\r\n"
+
+	tests := []struct {
+		name     string
+		content  string
+		commands int
+		valid    int
+		err      error
+	}{
+		{"empty", "", 0, 0, nil},
+		{"complete", set + set, 2, 2 * len(set), nil},
+		{"transaction", set + multi + set + exec, 4, len(set + multi + set + exec), nil},
+		{"truncated command", set + set[:10], 1, len(set), errAOFTruncated},
+		{"truncated transaction", set + multi + set, 3, len(set), errAOFTruncated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands, valid, err := scanAOF(strings.NewReader(tt.content), func(*protocol.Command) error { return nil })
+
+			if !errors.Is(err, tt.err) {
+				t.Errorf("error = %v, want %v", err, tt.err)
+			}
+
+			if commands != tt.commands || valid != int64(tt.valid) {
+				t.Errorf("scanAOF() = %d, %d, want %d, %d", commands, valid, tt.commands, tt.valid)
+			}
+		})
+	}
+}
+
+func TestScanAOFBadFormat(t *testing.T) {
+	_, valid, err := scanAOF(strings.NewReader("*1\r\n$4\r\nPING\r\n!garbage\r\n"), func(*protocol.Command) error { return nil })
+
+	if err == nil || errors.Is(err, errAOFTruncated) {
+		t.Fatalf("error = %v, want a format error", err)
+	}
+
+	if valid != 14 {
+		t.Errorf("valid = %d, want 14", valid)
+	}
+}
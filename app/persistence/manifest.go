@@ -0,0 +1,146 @@
+package persistence
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	aofTypeBase     = "base"
+	aofTypeIncr     = "incr"
+	manifestBase    = "b"
+	manifestIncr    = "i"
+	manifestHistory = "h"
+)
+
+// manifest lists the files that make up the AOF, in the format of Redis 7:
+// one base file with a snapshot followed by incremental files.
+type manifest struct {
+	base  *manifestEntry
+	incrs []manifestEntry
+}
+
+type manifestEntry struct {
+	name     string
+	seq      int
+	fileType string
+}
+
+func (m manifest) files() []manifestEntry {
+	files := []manifestEntry{}
+	if m.base != nil {
+		files = append(files, *m.base)
+	}
+
+	return append(files, m.incrs...)
+}
+
+func (m manifest) lastSeq() int {
+	seq := 0
+	for _, file := range m.files() {
+		seq = max(seq, file.seq)
+	}
+
+	return seq
+}
+
+// readManifest parses lines like
+// "file appendonly.aof.1.base.rdb seq 1 type b".
+func readManifest(path string) (manifest, error) {
+	m := manifest{}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return m, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return m, fmt.Errorf("invalid manifest line %q", line)
+		}
+
+		entry := manifestEntry{}
+		fileType := ""
+
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				entry.name = fields[i+1]
+			case "seq":
+				if entry.seq, err = strconv.Atoi(fields[i+1]); err != nil {
+					return m, fmt.Errorf("invalid manifest line %q", line)
+				}
+			case "type":
+				fileType = fields[i+1]
+			}
+		}
+
+		if entry.name == "" || filepath.Base(entry.name) != entry.name {
+			return m, fmt.Errorf("invalid manifest line %q", line)
+		}
+
+		switch fileType {
+		case manifestBase:
+			entry.fileType = aofTypeBase
+			m.base = &entry
+		case manifestIncr:
+			entry.fileType = aofTypeIncr
+			m.incrs = append(m.incrs, entry)
+		case manifestHistory:
+			//history files are left over from a rewrite and not loaded
+		default:
+			return m, fmt.Errorf("invalid manifest line %q", line)
+		}
+	}
+
+	return m, scanner.Err()
+}
+
+// writeManifest replaces the manifest atomically.
+func writeManifest(aofDir string, m manifest) error {
+	var sb strings.Builder
+	for _, file := range m.files() {
+		fileType := manifestIncr
+		if file.fileType == aofTypeBase {
+			fileType = manifestBase
+		}
+
+		fmt.Fprintf(&sb, "file %v seq %d type %v\n", file.name, file.seq, fileType)
+	}
+
+	tmp, err := os.CreateTemp(aofDir, "temp-*.manifest")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.WriteString(sb.String()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(aofDir, manifestName()))
+}
@@ -0,0 +1,55 @@
+package persistence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+
+	base := newManifestEntry(3, aofTypeBase)
+	m := manifest{base: &base, incrs: []manifestEntry{newManifestEntry(3, aofTypeIncr), newManifestEntry(4, aofTypeIncr)}}
+
+	if err := writeManifest(dir, m); err != nil {
+		t.Fatalf("writeManifest() error = %v", err)
+	}
+
+	got, err := readManifest(filepath.Join(dir, manifestName()))
+	if err != nil {
+		t.Fatalf("readManifest() error = %v", err)
+	}
+
+	if got.base == nil || *got.base != base {
+		t.Errorf("base = %+v, want %+v", got.base, base)
+	}
+
+	if len(got.incrs) != 2 || got.incrs[1].name != "appendonly.aof.4.incr.aof" {
+		t.Errorf("incrs = %+v", got.incrs)
+	}
+
+	if got.lastSeq() != 4 {
+		t.Errorf("lastSeq() = %d, want 4", got.lastSeq())
+	}
+}
+
+func TestReadManifestSkipsHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof.manifest")
+	content := "file appendonly.aof.1.base.aof seq 1 type h\n" +
+		"file appendonly.aof.2.base.rdb seq 2 type b\n" +
+		"file appendonly.aof.2.incr.aof seq 2 type i\n"
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readManifest(path)
+	if err != nil {
+		t.Fatalf("readManifest() error = %v", err)
+	}
+
+	if got.base == nil || got.base.name != "appendonly.aof.2.base.rdb" || len(got.incrs) != 1 {
+		t.Errorf("readManifest() = %+v", got)
+	}
+}
@@ -0,0 +1,370 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/rdb"
+	"redis-clone-go/app/scripting"
+	"redis-clone-go/app/store"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type saveRule struct {
+	seconds int64
+	changes uint64
+}
+
+var (
+	mu         sync.Mutex
+	dir        = "."
+	dbFilename = "dump.rdb"
+	saveRules  = []saveRule{{3600, 1}, {300, 100}, {60, 10000}}
+
+	saveInProgress bool
+	lastSave       = time.Now().Unix()
+	// savedChanges is the store.CM change count included in the last
+	// snapshot, writes since then are what save rules count.
+	savedChanges uint64
+)
+
+var errSaveInProgress = errors.New("ERR Background save already in progress")
+
+// Save writes a snapshot of the dataset to the RDB file.
+func Save() error {
+	mu.Lock()
+	if saveInProgress {
+		mu.Unlock()
+		return errSaveInProgress
+	}
+
+	saveInProgress = true
+	mu.Unlock()
+
+	defer func() {
+		mu.Lock()
+		saveInProgress = false
+		mu.Unlock()
+	}()
+
+	snapshot, changes := takeSnapshot()
+	if err := writeSnapshot(snapshot, changes); err != nil {
+		return fmt.Errorf("ERR %w", err)
+	}
+
+	return nil
+}
+
+// BackgroundSave copies the dataset and writes it to the RDB file without
+// blocking other clients.
+func BackgroundSave() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if saveInProgress {
+		return errSaveInProgress
+	}
+
+	saveInProgress = true
+	snapshot, changes := takeSnapshot()
+
+	go func() {
+		if err := writeSnapshot(snapshot, changes); err != nil {
+			fmt.Println("Background saving error: ", err.Error())
+		}
+
+		mu.Lock()
+		saveInProgress = false
+		mu.Unlock()
+	}()
+
+	return nil
+}
+
+func LastSave() int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return lastSave
+}
+
+// ShouldSave reports whether one of the save rules is met.
+func ShouldSave() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if saveInProgress {
+		return false
+	}
+
+	changes := store.CM.Changes() - savedChanges
+	elapsed := time.Now().Unix() - lastSave
+
+	for _, rule := range saveRules {
+		if changes >= rule.changes && elapsed >= rule.seconds {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ReplayFunc executes a command read from the AOF.
+type ReplayFunc func(command *protocol.Command) error
+
+// Load restores the dataset on startup. With appendonly enabled it is read
+// from the AOF, otherwise from the RDB file. A missing file is not an
+// error.
+func Load(replay ReplayFunc) error {
+	aofMu.Lock()
+	enabled := appendOnly
+	aofMu.Unlock()
+
+	if enabled {
+		if err := loadAOF(replay); err != nil {
+			return err
+		}
+	} else if err := loadRDBFile(path()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	mu.Lock()
+	savedChanges = store.CM.Changes()
+	mu.Unlock()
+
+	aofMu.Lock()
+	started = true
+	aofMu.Unlock()
+
+	return nil
+}
+
+func loadRDBFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := rdb.Read(data)
+	if err != nil {
+		return err
+	}
+
+	if err := loadSnapshot(snapshot); err != nil {
+		return err
+	}
+
+	fmt.Printf("DB loaded from %v: %d keys, %d skipped\n", path, len(snapshot.Keys), len(snapshot.Skipped))
+	return nil
+}
+
+// LoadRDB replaces the dataset with an RDB file received from a master.
+// With appendonly enabled the AOF is recreated from the new dataset. The
+// caller has to make sure nothing is written meanwhile.
+func LoadRDB(data []byte) error {
+	snapshot, err := rdb.Read(data)
+	if err != nil {
+		return err
+	}
+
+	scripting.Functions.Flush()
+	store.CM.Clear()
+
+	if err := loadSnapshot(snapshot); err != nil {
+		return err
+	}
+
+	aofMu.Lock()
+	enabled := appendOnly && aofFile != nil
+	aofMu.Unlock()
+
+	if enabled {
+		stopAOF()
+		return startAOF()
+	}
+
+	return nil
+}
+
+func loadSnapshot(snapshot rdb.Snapshot) error {
+	for _, code := range snapshot.Functions {
+		if _, err := scripting.Functions.Load(code, true); err != nil {
+			return fmt.Errorf("error loading function library: %w", err)
+		}
+	}
+
+	for key, value := range snapshot.Keys {
+		store.CM.Set(key, value)
+	}
+
+	for _, skipped := range snapshot.Skipped {
+		fmt.Printf("Skipped key %q from database %d: unsupported %v\n", skipped.Key, skipped.Db, skipped.Reason)
+	}
+
+	return nil
+}
+
+// CurrentSnapshot copies the dataset, e.g. to send it to a replica.
+func CurrentSnapshot() rdb.Snapshot {
+	snapshot, _ := takeSnapshot()
+	return snapshot
+}
+
+func takeSnapshot() (rdb.Snapshot, uint64) {
+	keys, changes := store.CM.Snapshot()
+
+	functions := []string{}
+	for _, lib := range scripting.Functions.Libraries("") {
+		functions = append(functions, lib.Code)
+	}
+
+	return rdb.Snapshot{Keys: keys, Functions: functions}, changes
+}
+
+func writeSnapshot(snapshot rdb.Snapshot, changes uint64) error {
+	mu.Lock()
+	target := path()
+	mu.Unlock()
+
+	if err := writeRDBFile(target, snapshot); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	lastSave = time.Now().Unix()
+	savedChanges = max(savedChanges, changes)
+	mu.Unlock()
+
+	return nil
+}
+
+// writeRDBFile writes to a temporary file first, so the target is never
+// left half written.
+func writeRDBFile(target string, snapshot rdb.Snapshot) error {
+	file, err := os.CreateTemp(filepath.Dir(target), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+
+	tmp := file.Name()
+
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := rdb.Write(file, snapshot); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+func path() string {
+	return filepath.Join(dir, dbFilename)
+}
+
+func Dir() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return dir
+}
+
+func SetDir(value string) error {
+	info, err := os.Stat(value)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%v is not a directory", value)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	dir = value
+	return nil
+}
+
+func DbFilename() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return dbFilename
+}
+
+func SetDbFilename(value string) error {
+	if value == "" || strings.ContainsRune(value, '/') {
+		return errors.New("dbfilename can't be a path, just a filename")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	dbFilename = value
+	return nil
+}
+
+// SaveRules formats the rules like the save config parameter, e.g.
+// "3600 1 300 100".
+func SaveRules() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	parts := []string{}
+	for _, rule := range saveRules {
+		parts = append(parts, strconv.FormatInt(rule.seconds, 10), strconv.FormatUint(rule.changes, 10))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// SetSaveRules parses pairs of seconds and changes, an empty value
+// disables automatic snapshots.
+func SetSaveRules(value string) error {
+	parts := strings.Fields(value)
+	if len(parts)%2 != 0 {
+		return errors.New("Invalid save parameters")
+	}
+
+	rules := []saveRule{}
+	for i := 0; i < len(parts); i += 2 {
+		seconds, err := strconv.ParseInt(parts[i], 10, 64)
+		if err != nil || seconds < 1 {
+			return errors.New("Invalid save parameters")
+		}
+
+		changes, err := strconv.ParseUint(parts[i+1], 10, 64)
+		if err != nil {
+			return errors.New("Invalid save parameters")
+		}
+
+		rules = append(rules, saveRule{seconds, changes})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	saveRules = rules
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"redis-clone-go/app/commands"
+	"redis-clone-go/app/persistence"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/replication"
+	"redis-clone-go/app/store"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	commands.Propagate = func(args []string) {
+		feed([][]string{args})
+	}
+}
+
+// call runs a command and propagates it when it wrote to the dataset.
+func call(c *client, spec commandSpec, command *protocol.Command) ([]byte, error) {
+	response, err := spec.handler(c, command.Args)
+	if err != nil || spec.flags&flagWrite == 0 {
+		return response, err
+	}
+
+	//blocking commands and the ones that lock themselves ran without the
+	//lock, it is only taken to propagate them in the order they were applied
+	if spec.flags&(flagBlocking|flagNoLock) != 0 && !c.executing {
+		execLock.Lock()
+		defer execLock.Unlock()
+	}
+
+	c.propagate(command, response)
+	return response, nil
+}
+
+// propagate records a write command.
+func (c *client) propagate(command *protocol.Command, response []byte) {
+	args := append([]string{command.Name}, command.Args...)
+	if rewrite, ok := propagateAs[command.Name]; ok {
+		args = rewrite(command.Args, response)
+	}
+
+	c.propagateArgs(args)
+}
+
+// propagateArgs records a command followed by the ones queued with
+// alsoPropagate, args is nil if only those are propagated. Inside EXEC and
+// scripts the commands are collected, so they can be propagated as one
+// transaction.
+func (c *client) propagateArgs(args []string) {
+	if args != nil {
+		c.propagated = append(c.propagated, args)
+	}
+
+	c.propagated = append(c.propagated, c.alsoPropagated...)
+	c.alsoPropagated = nil
+
+	if !c.executing {
+		c.flushPropagated()
+	}
+}
+
+// alsoPropagate queues a command to be propagated right after the one that
+// is executing, in the same transaction.
+func (c *client) alsoPropagate(args []string) {
+	c.alsoPropagated = append(c.alsoPropagated, args)
+}
+
+// flushPropagated propagates the commands collected by EXEC or a script,
+// wrapped in MULTI and EXEC if there is more than one.
+func (c *client) flushPropagated() {
+	commands := c.propagated
+	c.propagated = nil
+
+	if len(commands) > 1 {
+		commands = append([][]string{{"MULTI"}}, commands...)
+		commands = append(commands, []string{"EXEC"})
+	}
+
+	if len(commands) > 0 {
+		feed(commands)
+		c.woff = replication.Offset()
+	}
+}
+
+// feedMu keeps the AOF and the replication stream in the same order, reads
+// propagate the deletion of expired keys concurrently.
+var feedMu sync.Mutex
+
+func feed(commands [][]string) {
+	feedMu.Lock()
+	defer feedMu.Unlock()
+
+	for _, args := range commands {
+		persistence.FeedAOF(args)
+	}
+
+	replication.Feed(commands)
+	persistence.AdvanceAOFOffset(replication.Offset())
+}
+
+// propagateAs rewrites commands whose effect would differ when they are
+// replayed later, it returns nil if nothing has to be propagated.
+var propagateAs = map[string]func(args []string, response []byte) []string{
+	"SET": func(args []string, response []byte) []string {
+		if len(args) == 4 && strings.ToUpper(args[2]) == "PX" {
+			ms, _ := strconv.ParseInt(args[3], 10, 64)
+			expiresBy := strconv.FormatInt(time.Now().UnixMilli()+ms, 10)
+			return []string{"SET", args[0], args[1], "PXAT", expiresBy}
+		}
+
+		return append([]string{"SET"}, args...)
+	},
+	"XADD": func(args []string, response []byte) []string {
+		//the id may have been generated
+		reply, err := protocol.ParseReply(bufio.NewReader(bytes.NewReader(response)))
+		if err != nil {
+			return nil
+		}
+
+		return append([]string{"XADD", args[0], reply.Str}, args[2:]...)
+	},
+	//approximate trims depend on how the entries are stored, replicas trim
+	//down to the same length instead
+	"XTRIM": func(args []string, response []byte) []string {
+		storedValue, ok := store.CM.Get(args[0])
+		if !ok || bytes.Equal(response, protocol.FormatInt(0, false)) {
+			return nil
+		}
+
+		return []string{"XTRIM", args[0], "MAXLEN", "=", strconv.Itoa(storedValue.Xval.Len())}
+	},
+	//the consumer group commands that depend on the time propagate the
+	//commands replaying them
+	"XREADGROUP": func(args []string, response []byte) []string {
+		return nil
+	},
+	"XCLAIM": func(args []string, response []byte) []string {
+		return nil
+	},
+	"XAUTOCLAIM": func(args []string, response []byte) []string {
+		return nil
+	},
+	//BLPOP propagates its pops itself
+	"BLPOP": func(args []string, response []byte) []string {
+		return nil
+	},
+	"RESTORE":        propagateRestore,
+	"RESTORE-ASKING": propagateRestore,
+	//MIGRATE propagates the deletion of the keys it moved itself
+	"MIGRATE": func(args []string, response []byte) []string {
+		return nil
+	},
+}
+
+// propagateRestore replays RESTORE with the absolute expiration time the
+// key got, replacing whatever the key holds by then.
+func propagateRestore(args []string, response []byte) []string {
+	expiresBy := int64(0)
+	if storedValue, ok := store.CM.Get(args[0]); ok && storedValue.ExpiresBy != -1 {
+		expiresBy = storedValue.ExpiresBy
+	}
+
+	return []string{"RESTORE", args[0], strconv.FormatInt(expiresBy, 10), args[2], "REPLACE", "ABSTTL"}
+}
@@ -1,6 +1,9 @@
 package protocol
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 func FormatSimpleString(input string) []byte {
 	return fmt.Appendf(nil, "+%v\r\n", input)
@@ -14,6 +17,10 @@ func FormatNullBulkString() []byte {
 	return []byte("$-1\r\n")
 }
 
+func FormatNullArray() []byte {
+	return []byte("*-1\r\n")
+}
+
 func FormatInt(num int, signed bool) []byte {
 	if signed {
 		return fmt.Appendf(nil, ":%+d\r\n", num)
@@ -33,5 +40,8 @@ func FormatBulkStringArray(elements []string) []byte {
 }
 
 func FormatError(err error) []byte {
-	return fmt.Appendf(nil, "-%v\r\n", err)
+	//errors are single line simple strings, so newlines have to go
+	msg := strings.TrimSpace(err.Error())
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	return fmt.Appendf(nil, "-%v\r\n", msg)
 }
@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type ReplyType byte
+
+const (
+	SimpleStringReply ReplyType = '+'
+	ErrorReply        ReplyType = '-'
+	IntegerReply      ReplyType = ':'
+	BulkStringReply   ReplyType = '$'
+	ArrayReply        ReplyType = '*'
+)
+
+// Reply is a decoded RESP2 reply. Null bulk strings and null arrays have
+// IsNull set.
+type Reply struct {
+	Type   ReplyType
+	Str    string
+	Int    int64
+	Array  []Reply
+	IsNull bool
+}
+
+func ParseReply(reader *bufio.Reader) (Reply, error) {
+	typeIndicator, err := reader.ReadByte()
+	if err != nil {
+		return Reply{}, fmt.Errorf("error reading type header: %w", err)
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return Reply{}, fmt.Errorf("error reading reply: %w", err)
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	reply := Reply{Type: ReplyType(typeIndicator)}
+
+	switch reply.Type {
+	case SimpleStringReply, ErrorReply:
+		reply.Str = line
+	case IntegerReply:
+		reply.Int, err = strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return reply, fmt.Errorf("integer couldn't be parsed: %w", err)
+		}
+	case BulkStringReply:
+		length, err := strconv.Atoi(line)
+		if err != nil {
+			return reply, fmt.Errorf("bulk string length couldn't be parsed: %w", err)
+		}
+
+		if length < 0 {
+			reply.IsNull = true
+			return reply, nil
+		}
+
+		buf := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return reply, fmt.Errorf("error reading bulk string: %w", err)
+		}
+
+		reply.Str = string(buf[:length])
+	case ArrayReply:
+		length, err := strconv.Atoi(line)
+		if err != nil {
+			return reply, fmt.Errorf("array length couldn't be parsed: %w", err)
+		}
+
+		if length < 0 {
+			reply.IsNull = true
+			return reply, nil
+		}
+
+		reply.Array = make([]Reply, 0, length)
+		for range length {
+			element, err := ParseReply(reader)
+			if err != nil {
+				return reply, err
+			}
+
+			reply.Array = append(reply.Array, element)
+		}
+	default:
+		return reply, fmt.Errorf("unknown reply type %q", typeIndicator)
+	}
+
+	return reply, nil
+}
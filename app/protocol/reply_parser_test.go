@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestParseReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Reply
+		wantErr bool
+	}{
+		{
+			name:  "simple string",
+			input: "+OK\r\n",
+			want:  Reply{Type: SimpleStringReply, Str: "OK"},
+		},
+		{
+			name:  "error",
+			input: "-ERR oops\r\n",
+			want:  Reply{Type: ErrorReply, Str: "ERR oops"},
+		},
+		{
+			name:  "integer",
+			input: ":-42\r\n",
+			want:  Reply{Type: IntegerReply, Int: -42},
+		},
+		{
+			name:  "null bulk string",
+			input: "$-1\r\n",
+			want:  Reply{Type: BulkStringReply, IsNull: true},
+		},
+		{
+			name:  "nested array",
+			input: "*2\r\n$3\r\nfoo\r\n*1\r\n:1\r\n",
+			want: Reply{Type: ArrayReply, Array: []Reply{
+				{Type: BulkStringReply, Str: "foo"},
+				{Type: ArrayReply, Array: []Reply{{Type: IntegerReply, Int: 1}}},
+			}},
+		},
+		{
+			name:    "unknown type",
+			input:   "?1\r\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := ParseReply(bufio.NewReader(strings.NewReader(tt.input)))
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseReply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if !equalReplies(reply, tt.want) {
+				t.Errorf("ParseReply() = %+v, want %+v", reply, tt.want)
+			}
+		})
+	}
+}
+
+func equalReplies(a, b Reply) bool {
+	if a.Type != b.Type || a.Str != b.Str || a.Int != b.Int || a.IsNull != b.IsNull || len(a.Array) != len(b.Array) {
+		return false
+	}
+
+	for i := range a.Array {
+		if !equalReplies(a.Array[i], b.Array[i]) {
+			return false
+		}
+	}
+
+	return true
+}
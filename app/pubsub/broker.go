@@ -0,0 +1,186 @@
+package pubsub
+
+import (
+	"redis-clone-go/app/glob"
+	"slices"
+	"sync"
+)
+
+var PS = NewBroker()
+
+// ShardPS holds the sharded channels of SSUBSCRIBE and SPUBLISH, which are
+// isolated from the regular channels.
+var ShardPS = NewShardBroker()
+
+type Broker struct {
+	mu       sync.RWMutex
+	channels subscriptions
+	patterns subscriptions
+	kinds    replyKinds
+}
+
+// replyKinds are the names used in confirmations and messages, they differ
+// between regular and sharded pub/sub.
+type replyKinds struct {
+	subscribe   string
+	unsubscribe string
+	message     string
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		channels: newSubscriptions(),
+		patterns: newSubscriptions(),
+		kinds:    replyKinds{"subscribe", "unsubscribe", "message"},
+	}
+}
+
+func NewShardBroker() *Broker {
+	return &Broker{
+		channels: newSubscriptions(),
+		patterns: newSubscriptions(),
+		kinds:    replyKinds{"ssubscribe", "sunsubscribe", "smessage"},
+	}
+}
+
+func (b *Broker) Subscribe(sub *Subscriber, channels []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, channel := range channels {
+		b.channels.add(sub, channel)
+
+		//the confirmation is queued under the lock, so it is always
+		//delivered before any message published to the channel
+		sub.confirm(formatSubscription(b.kinds.subscribe, channel, b.subscriptionCount(sub)))
+	}
+}
+
+func (b *Broker) PSubscribe(sub *Subscriber, patterns []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, pattern := range patterns {
+		b.patterns.add(sub, pattern)
+		sub.confirm(formatSubscription("psubscribe", pattern, b.subscriptionCount(sub)))
+	}
+}
+
+// Unsubscribe removes the subscriptions to the given channels, or to all
+// channels of the subscriber if none are given.
+func (b *Broker) Unsubscribe(sub *Subscriber, channels []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.unsubscribe(sub, &b.channels, channels, b.kinds.unsubscribe)
+}
+
+// PUnsubscribe removes the subscriptions to the given patterns, or to all
+// patterns of the subscriber if none are given.
+func (b *Broker) PUnsubscribe(sub *Subscriber, patterns []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.unsubscribe(sub, &b.patterns, patterns, "punsubscribe")
+}
+
+func (b *Broker) unsubscribe(sub *Subscriber, s *subscriptions, subjects []string, kind string) {
+	if len(subjects) == 0 {
+		subjects = s.subjectsOf(sub)
+
+		if len(subjects) == 0 {
+			sub.confirm(formatNullSubscription(kind, b.subscriptionCount(sub)))
+			return
+		}
+	}
+
+	for _, subject := range subjects {
+		s.remove(sub, subject)
+		sub.confirm(formatSubscription(kind, subject, b.subscriptionCount(sub)))
+	}
+}
+
+// Publish delivers the message to all subscribers of the channel and of
+// matching patterns, and returns the number of deliveries.
+func (b *Broker) Publish(channel, message string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	receivers := 0
+
+	if subscribers := b.channels.bySubject[channel]; len(subscribers) > 0 {
+		formatted := formatMessage(b.kinds.message, channel, message)
+		for sub := range subscribers {
+			sub.Push(formatted)
+		}
+
+		receivers += len(subscribers)
+	}
+
+	for pattern, subscribers := range b.patterns.bySubject {
+		if !glob.Match(pattern, channel) {
+			continue
+		}
+
+		formatted := formatPatternMessage(pattern, channel, message)
+		for sub := range subscribers {
+			sub.Push(formatted)
+		}
+
+		receivers += len(subscribers)
+	}
+
+	return receivers
+}
+
+// SubscriptionCount returns the number of channels and patterns the
+// subscriber is subscribed to.
+func (b *Broker) SubscriptionCount(sub *Subscriber) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.subscriptionCount(sub)
+}
+
+func (b *Broker) subscriptionCount(sub *Subscriber) int {
+	return b.channels.count(sub) + b.patterns.count(sub)
+}
+
+// Channels returns the channels with at least one subscriber that match
+// the glob-style pattern. An empty pattern matches every channel.
+func (b *Broker) Channels(pattern string) []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	channels := []string{}
+	for channel := range b.channels.bySubject {
+		if pattern == "" || glob.Match(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+
+	slices.Sort(channels)
+	return channels
+}
+
+func (b *Broker) NumSub(channel string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.channels.bySubject[channel])
+}
+
+// NumPat returns the number of unique patterns subscribed to.
+func (b *Broker) NumPat() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.patterns.bySubject)
+}
+
+// RemoveSubscriber drops all subscriptions of a disconnected subscriber
+// without sending it any confirmations.
+func (b *Broker) RemoveSubscriber(sub *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.channels.removeSubscriber(sub)
+	b.patterns.removeSubscriber(sub)
+}
@@ -0,0 +1,146 @@
+package pubsub
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func takeStrings(sub *Subscriber) []string {
+	taken := []string{}
+	for _, data := range sub.Take() {
+		taken = append(taken, string(data))
+	}
+
+	return taken
+}
+
+func TestPatternSubscribe(t *testing.T) {
+	broker := NewBroker()
+	sub := NewSubscriber()
+
+	broker.PSubscribe(sub, []string{"news.*"})
+	broker.Subscribe(sub, []string{"news.tech"})
+
+	//a channel matching a pattern is delivered for both subscriptions
+	if receivers := broker.Publish("news.tech", "hi"); receivers != 2 {
+		t.Errorf("Publish() = %d, want 2", receivers)
+	}
+
+	if receivers := broker.Publish("sports", "hi"); receivers != 0 {
+		t.Errorf("Publish() = %d, want 0", receivers)
+	}
+
+	want := []string{
+		string(formatSubscription("psubscribe", "news.*", 1)),
+		string(formatSubscription("subscribe", "news.tech", 2)),
+		string(formatMessage("message", "news.tech", "hi")),
+		string(formatPatternMessage("news.*", "news.tech", "hi")),
+	}
+
+	if got := takeStrings(sub); !slices.Equal(got, want) {
+		t.Errorf("subscriber got %q, want %q", got, want)
+	}
+
+	broker.PUnsubscribe(sub, nil)
+	broker.PUnsubscribe(sub, nil)
+	want = []string{
+		string(formatSubscription("punsubscribe", "news.*", 1)),
+		string(formatNullSubscription("punsubscribe", 1)),
+	}
+
+	if got := takeStrings(sub); !slices.Equal(got, want) {
+		t.Errorf("subscriber got %q, want %q", got, want)
+	}
+
+	if receivers := broker.Publish("news.sports", "hi"); receivers != 0 || broker.NumPat() != 0 {
+		t.Errorf("Publish() = %d after unsubscribing, %d patterns", receivers, broker.NumPat())
+	}
+}
+
+func TestShardSubscribe(t *testing.T) {
+	broker, shardBroker := NewBroker(), NewShardBroker()
+	sub := NewSubscriber()
+
+	shardBroker.Subscribe(sub, []string{"orders"})
+
+	//sharded channels are separate from the regular ones
+	if receivers := broker.Publish("orders", "1"); receivers != 0 {
+		t.Errorf("PUBLISH reached %d subscribers", receivers)
+	}
+
+	if receivers := shardBroker.Publish("orders", "1"); receivers != 1 {
+		t.Errorf("SPUBLISH reached %d subscribers", receivers)
+	}
+
+	shardBroker.Unsubscribe(sub, []string{"orders"})
+	want := []string{
+		string(formatSubscription("ssubscribe", "orders", 1)),
+		string(formatMessage("smessage", "orders", "1")),
+		string(formatSubscription("sunsubscribe", "orders", 0)),
+	}
+
+	if got := takeStrings(sub); !slices.Equal(got, want) {
+		t.Errorf("subscriber got %q, want %q", got, want)
+	}
+}
+
+func TestSubscriberFallingBehind(t *testing.T) {
+	sub := NewSubscriber()
+	for range messageBufferSize {
+		sub.Push([]byte("message"))
+	}
+
+	select {
+	case <-sub.Done():
+		t.Fatalf("subscriber closed with a full buffer")
+	default:
+	}
+
+	//one message more than it can buffer disconnects it
+	sub.Push([]byte("message"))
+	select {
+	case <-sub.Done():
+	default:
+		t.Fatalf("subscriber isn't closed after falling behind")
+	}
+
+	if taken := sub.Take(); len(taken) != messageBufferSize {
+		t.Errorf("%d messages were queued", len(taken))
+	}
+}
+
+func TestSubscriberRepliesWait(t *testing.T) {
+	sub := NewSubscriber()
+	for range messageBufferSize {
+		sub.Reply([]byte("reply"))
+	}
+
+	replied := make(chan struct{})
+	go func() {
+		sub.Reply([]byte("last"))
+		close(replied)
+	}()
+
+	//replies aren't dropped, the client waits until they are written
+	select {
+	case <-replied:
+		t.Fatalf("Reply() returned with a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if taken := sub.Take(); len(taken) != messageBufferSize {
+		t.Errorf("%d replies were queued", len(taken))
+	}
+
+	<-replied
+	if taken := takeStrings(sub); !slices.Equal(taken, []string{"last"}) {
+		t.Errorf("queued %q after the wait", taken)
+	}
+
+	select {
+	case <-sub.Done():
+		t.Errorf("subscriber closed by replies")
+	default:
+	}
+}
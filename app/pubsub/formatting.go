@@ -0,0 +1,27 @@
+package pubsub
+
+import (
+	"redis-clone-go/app/protocol"
+)
+
+func formatMessage(kind, channel, message string) []byte {
+	return protocol.FormatBulkStringArray([]string{kind, channel, message})
+}
+
+func formatPatternMessage(pattern, channel, message string) []byte {
+	return protocol.FormatBulkStringArray([]string{"pmessage", pattern, channel, message})
+}
+
+func formatSubscription(kind, channel string, count int) []byte {
+	reply := []byte("*3\r\n")
+	reply = append(reply, protocol.FormatBulkString(kind)...)
+	reply = append(reply, protocol.FormatBulkString(channel)...)
+	return append(reply, protocol.FormatInt(count, false)...)
+}
+
+func formatNullSubscription(kind string, count int) []byte {
+	reply := []byte("*3\r\n")
+	reply = append(reply, protocol.FormatBulkString(kind)...)
+	reply = append(reply, protocol.FormatNullBulkString()...)
+	return append(reply, protocol.FormatInt(count, false)...)
+}
@@ -0,0 +1,125 @@
+package pubsub
+
+import "sync"
+
+// messageBufferSize is the number of pushed messages a subscriber can fall
+// behind by before it is disconnected, similar to Redis' output buffer limit.
+// Replies are queued up to the same size before the client has to wait.
+const messageBufferSize = 1024
+
+// Subscriber queues what has to be written to a subscriber's connection,
+// the replies to its commands and the messages published to it, in the
+// order they were produced.
+type Subscriber struct {
+	mu    sync.Mutex
+	taken *sync.Cond
+	queue [][]byte
+	// pushed is the number of published messages in the queue
+	pushed int
+	ready  chan struct{}
+	done   chan struct{}
+	closed bool
+}
+
+func NewSubscriber() *Subscriber {
+	s := &Subscriber{
+		ready: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+
+	s.taken = sync.NewCond(&s.mu)
+	return s
+}
+
+// Ready receives once something was queued since the last Take.
+func (s *Subscriber) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Take returns what was queued, to be written in order.
+func (s *Subscriber) Take() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := s.queue
+	s.queue = nil
+	s.pushed = 0
+	s.taken.Broadcast()
+	return queue
+}
+
+// Done is closed once the subscriber has been closed.
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Subscriber) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closeLocked()
+}
+
+func (s *Subscriber) closeLocked() {
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+		s.taken.Broadcast()
+	}
+}
+
+// Push queues a published message. It never blocks, so a slow subscriber
+// can't hold up a publisher. Subscribers that can't keep up are closed
+// instead.
+func (s *Subscriber) Push(message []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	if s.pushed == messageBufferSize {
+		s.closeLocked()
+		return
+	}
+
+	s.pushed++
+	s.enqueue(message)
+}
+
+// Reply queues the reply to a command. It waits while the queue is full,
+// so a client that doesn't read its replies stops being served instead of
+// losing them.
+func (s *Subscriber) Reply(reply []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.queue) >= messageBufferSize && !s.closed {
+		s.taken.Wait()
+	}
+
+	if !s.closed {
+		s.enqueue(reply)
+	}
+}
+
+// confirm queues a subscription confirmation. The broker does it under its
+// lock, so it doesn't wait, the confirmations of a command are few.
+func (s *Subscriber) confirm(reply []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.enqueue(reply)
+	}
+}
+
+func (s *Subscriber) enqueue(data []byte) {
+	s.queue = append(s.queue, data)
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,63 @@
+package pubsub
+
+import "slices"
+
+// subscriptions indexes subscribers by channel (or pattern) and the other
+// way around, so both publishing and unsubscribing a client are cheap.
+type subscriptions struct {
+	bySubject    map[string]map[*Subscriber]struct{}
+	bySubscriber map[*Subscriber]map[string]struct{}
+}
+
+func newSubscriptions() subscriptions {
+	return subscriptions{
+		bySubject:    make(map[string]map[*Subscriber]struct{}),
+		bySubscriber: make(map[*Subscriber]map[string]struct{}),
+	}
+}
+
+func (s *subscriptions) add(sub *Subscriber, subject string) {
+	if s.bySubject[subject] == nil {
+		s.bySubject[subject] = make(map[*Subscriber]struct{})
+	}
+
+	if s.bySubscriber[sub] == nil {
+		s.bySubscriber[sub] = make(map[string]struct{})
+	}
+
+	s.bySubject[subject][sub] = struct{}{}
+	s.bySubscriber[sub][subject] = struct{}{}
+}
+
+func (s *subscriptions) remove(sub *Subscriber, subject string) {
+	delete(s.bySubject[subject], sub)
+	if len(s.bySubject[subject]) == 0 {
+		delete(s.bySubject, subject)
+	}
+
+	delete(s.bySubscriber[sub], subject)
+	if len(s.bySubscriber[sub]) == 0 {
+		delete(s.bySubscriber, sub)
+	}
+}
+
+func (s *subscriptions) removeSubscriber(sub *Subscriber) {
+	for subject := range s.bySubscriber[sub] {
+		s.remove(sub, subject)
+	}
+}
+
+// subjectsOf returns the sorted channels or patterns of a subscriber.
+func (s *subscriptions) subjectsOf(sub *Subscriber) []string {
+	subjects := make([]string, 0, len(s.bySubscriber[sub]))
+	for subject := range s.bySubscriber[sub] {
+		subjects = append(subjects, subject)
+	}
+
+	slices.Sort(subjects)
+	return subjects
+}
+
+func (s *subscriptions) count(sub *Subscriber) int {
+	return len(s.bySubscriber[sub])
+}
@@ -0,0 +1,16 @@
+package rdb
+
+import "hash/crc64"
+
+// Redis checksums RDB files and DUMP payloads with the reflected
+// CRC-64/Jones polynomial, without the pre and post inversion of the
+// standard library's crc64.Update.
+var jonesTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+func UpdateChecksum(crc uint64, data []byte) uint64 {
+	for _, b := range data {
+		crc = jonesTable[byte(crc)^b] ^ (crc >> 8)
+	}
+
+	return crc
+}
@@ -0,0 +1,74 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"redis-clone-go/app/store"
+)
+
+var ErrBadPayload = errors.New("ERR DUMP payload version or checksum are wrong")
+
+// AppendPayloadFooter appends the RDB version and checksum that end a DUMP
+// payload.
+func AppendPayloadFooter(payload []byte) []byte {
+	payload = binary.LittleEndian.AppendUint16(payload, Version)
+	return binary.LittleEndian.AppendUint64(payload, UpdateChecksum(0, payload))
+}
+
+// VerifyPayload checks the footer of a DUMP payload and returns the
+// payload without it.
+func VerifyPayload(payload []byte) ([]byte, error) {
+	if len(payload) < 10 {
+		return nil, ErrBadPayload
+	}
+
+	footer := payload[len(payload)-10:]
+	version := binary.LittleEndian.Uint16(footer[:2])
+	checksum := binary.LittleEndian.Uint64(footer[2:])
+
+	if version > Version || checksum != UpdateChecksum(0, payload[:len(payload)-8]) {
+		return nil, ErrBadPayload
+	}
+
+	return payload[:len(payload)-10], nil
+}
+
+// DumpValue serializes a value like DUMP, its type and encoding followed by
+// the footer. The expiration isn't part of it.
+func DumpValue(value store.StoredValue) []byte {
+	payload := []byte{valueType(value)}
+	payload = appendValue(payload, value)
+	return AppendPayloadFooter(payload)
+}
+
+// LoadValue deserializes a DUMP payload. The value doesn't expire.
+func LoadValue(payload []byte) (store.StoredValue, error) {
+	body, err := VerifyPayload(payload)
+	if err != nil {
+		return store.StoredValue{}, err
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(body))
+	valueType, err := reader.ReadByte()
+	if err != nil {
+		return store.StoredValue{}, ErrBadPayload
+	}
+
+	value, err := readValue(reader, valueType)
+	if errors.Is(err, errUnsupportedType) {
+		return store.StoredValue{}, errors.New("ERR Bad data format")
+	}
+
+	if err != nil {
+		return store.StoredValue{}, ErrBadPayload
+	}
+
+	if _, err := reader.ReadByte(); err == nil {
+		return store.StoredValue{}, ErrBadPayload
+	}
+
+	value.ExpiresBy = -1
+	return value, nil
+}
@@ -0,0 +1,50 @@
+package rdb
+
+import (
+	"redis-clone-go/app/store"
+	"slices"
+	"testing"
+)
+
+func TestDumpRoundTrip(t *testing.T) {
+	stream := []store.StreamEntry{
+		store.NewStreamEntry(store.StreamId{Ms: 1, Sequence: 0}, []string{"a", "1"}),
+		store.NewStreamEntry(store.StreamId{Ms: 2, Sequence: 5}, []string{"b", "2"}),
+	}
+
+	tests := []struct {
+		name  string
+		value store.StoredValue
+	}{
+		{"string", store.NewStringValue("value", 12345)},
+		{"list", store.NewListValue([]string{"a", "1", "-20"})},
+		{"stream", store.NewStreamValue(stream)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadValue(DumpValue(tt.value))
+			if err != nil {
+				t.Fatalf("LoadValue() error = %v", err)
+			}
+
+			if got.Type != tt.value.Type || got.Val != tt.value.Val || !slices.Equal(got.Lval, tt.value.Lval) ||
+				got.Xval.Len() != tt.value.Xval.Len() || got.XMeta != tt.value.XMeta {
+				t.Errorf("LoadValue() = %+v, want %+v", got, tt.value)
+			}
+
+			if got.ExpiresBy != -1 {
+				t.Errorf("LoadValue().ExpiresBy = %d, want -1", got.ExpiresBy)
+			}
+		})
+	}
+}
+
+func TestLoadValueCorrupted(t *testing.T) {
+	payload := DumpValue(store.NewStringValue("value", -1))
+	payload[1] ^= 0xff
+
+	if _, err := LoadValue(payload); err != ErrBadPayload {
+		t.Errorf("LoadValue() error = %v, want %v", err, ErrBadPayload)
+	}
+}
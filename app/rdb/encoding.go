@@ -0,0 +1,168 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Version is the RDB format version written by this server, it matches
+// Redis 7.2.
+const Version = 11
+
+const (
+	len6Bit  = 0
+	len14Bit = 1
+	len32Bit = 0x80
+	len64Bit = 0x81
+	encoded  = 3
+)
+
+var ErrEncodedLength = errors.New("length is a special encoding")
+
+// AppendLength appends n in the variable length encoding used by RDB.
+func AppendLength(buf []byte, n uint64) []byte {
+	switch {
+	case n < 1<<6:
+		return append(buf, byte(n))
+	case n < 1<<14:
+		return append(buf, byte(n>>8)|len14Bit<<6, byte(n))
+	case n <= 0xffffffff:
+		buf = append(buf, len32Bit)
+		return binary.BigEndian.AppendUint32(buf, uint32(n))
+	default:
+		buf = append(buf, len64Bit)
+		return binary.BigEndian.AppendUint64(buf, n)
+	}
+}
+
+func AppendString(buf []byte, s string) []byte {
+	buf = AppendLength(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// ReadLength reads a length, it returns ErrEncodedLength together with the
+// encoding type if the value is a specially encoded string instead.
+func ReadLength(reader *bufio.Reader) (uint64, error) {
+	first, err := reader.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("error reading length: %w", err)
+	}
+
+	switch first >> 6 {
+	case len6Bit:
+		return uint64(first & 0x3f), nil
+	case len14Bit:
+		second, err := reader.ReadByte()
+		if err != nil {
+			return 0, fmt.Errorf("error reading length: %w", err)
+		}
+
+		return uint64(first&0x3f)<<8 | uint64(second), nil
+	case encoded:
+		return uint64(first & 0x3f), ErrEncodedLength
+	}
+
+	switch first {
+	case len32Bit:
+		var buf [4]byte
+		if _, err := io.ReadFull(reader, buf[:]); err != nil {
+			return 0, fmt.Errorf("error reading length: %w", err)
+		}
+
+		return uint64(binary.BigEndian.Uint32(buf[:])), nil
+	case len64Bit:
+		var buf [8]byte
+		if _, err := io.ReadFull(reader, buf[:]); err != nil {
+			return 0, fmt.Errorf("error reading length: %w", err)
+		}
+
+		return binary.BigEndian.Uint64(buf[:]), nil
+	default:
+		return 0, fmt.Errorf("unknown length encoding %#x", first)
+	}
+}
+
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// ReadString reads a string, including integers and LZF compressed strings
+// in their special encodings.
+func ReadString(reader *bufio.Reader) (string, error) {
+	length, err := ReadLength(reader)
+	if errors.Is(err, ErrEncodedLength) {
+		return readEncodedString(reader, length)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	buf, err := readBytes(reader, length)
+	return string(buf), err
+}
+
+func readEncodedString(reader *bufio.Reader, encoding uint64) (string, error) {
+	switch encoding {
+	case encInt8:
+		buf, err := readBytes(reader, 1)
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.Itoa(int(int8(buf[0]))), nil
+	case encInt16:
+		buf, err := readBytes(reader, 2)
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf)))), nil
+	case encInt32:
+		buf, err := readBytes(reader, 4)
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf)))), nil
+	case encLZF:
+		compressedLength, err := ReadLength(reader)
+		if err != nil {
+			return "", err
+		}
+
+		length, err := ReadLength(reader)
+		if err != nil {
+			return "", err
+		}
+
+		compressed, err := readBytes(reader, compressedLength)
+		if err != nil {
+			return "", err
+		}
+
+		buf, err := decompressLZF(compressed, int(length))
+		return string(buf), err
+	default:
+		return "", fmt.Errorf("unknown string encoding %d", encoding)
+	}
+}
+
+func readBytes(reader *bufio.Reader, n uint64) ([]byte, error) {
+	//don't trust the length for the allocation, a corrupted file could ask
+	//for any amount of memory
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, reader, int64(n)); err != nil {
+		return nil, fmt.Errorf("error reading string: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
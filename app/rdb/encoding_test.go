@@ -0,0 +1,43 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	//reference value from the Redis test suite
+	if got := UpdateChecksum(0, []byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("UpdateChecksum() = %#x, want %#x", got, uint64(0xe9c6d914c4b8d9ca))
+	}
+}
+
+func TestLengthRoundTrip(t *testing.T) {
+	for _, n := range []uint64{0, 63, 64, 16383, 16384, 1 << 32, 1<<32 + 1} {
+		encoded := AppendLength(nil, n)
+
+		got, err := ReadLength(bufio.NewReader(bytes.NewReader(encoded)))
+		if err != nil {
+			t.Fatalf("ReadLength(%d) error = %v", n, err)
+		}
+
+		if got != n {
+			t.Errorf("ReadLength() = %d, want %d", got, n)
+		}
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	for _, s := range []string{"", "hello", strings.Repeat("x", 20000)} {
+		got, err := ReadString(bufio.NewReader(bytes.NewReader(AppendString(nil, s))))
+		if err != nil {
+			t.Fatalf("ReadString() error = %v", err)
+		}
+
+		if got != s {
+			t.Errorf("ReadString() returned %d bytes, want %d", len(got), len(s))
+		}
+	}
+}
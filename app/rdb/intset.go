@@ -0,0 +1,41 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+var errBadIntset = errors.New("invalid intset")
+
+// decodeIntset returns the members of an intset, the encoding used for
+// small sets of integers.
+func decodeIntset(buf []byte) ([]string, error) {
+	if len(buf) < 8 {
+		return nil, errBadIntset
+	}
+
+	width := int(binary.LittleEndian.Uint32(buf))
+	length := int(binary.LittleEndian.Uint32(buf[4:]))
+	contents := buf[8:]
+
+	if (width != 2 && width != 4 && width != 8) || len(contents) != width*length {
+		return nil, errBadIntset
+	}
+
+	members := make([]string, length)
+	for i := range length {
+		member := contents[i*width : (i+1)*width]
+
+		switch width {
+		case 2:
+			members[i] = strconv.Itoa(int(int16(binary.LittleEndian.Uint16(member))))
+		case 4:
+			members[i] = strconv.Itoa(int(int32(binary.LittleEndian.Uint32(member))))
+		default:
+			members[i] = strconv.FormatInt(int64(binary.LittleEndian.Uint64(member)), 10)
+		}
+	}
+
+	return members, nil
+}
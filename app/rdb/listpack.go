@@ -0,0 +1,206 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// listpacks are the compact encoding Redis 7 uses for small lists, hashes,
+// sets and for the nodes of streams.
+
+const listpackHeaderSize = 6
+const listpackEnd = 0xff
+
+var errBadListpack = errors.New("invalid listpack")
+
+type listpack struct {
+	buf   []byte
+	count int
+}
+
+func newListpack() *listpack {
+	return &listpack{buf: make([]byte, listpackHeaderSize)}
+}
+
+// appendString appends s, strings that are canonical integers are encoded
+// as integers like Redis does.
+func (lp *listpack) appendString(s string) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(n, 10) == s {
+		lp.appendInt(n)
+		return
+	}
+
+	start := len(lp.buf)
+	switch {
+	case len(s) < 1<<6:
+		lp.buf = append(lp.buf, 0x80|byte(len(s)))
+	case len(s) < 1<<12:
+		lp.buf = append(lp.buf, 0xe0|byte(len(s)>>8), byte(len(s)))
+	default:
+		lp.buf = append(lp.buf, 0xf0)
+		lp.buf = binary.LittleEndian.AppendUint32(lp.buf, uint32(len(s)))
+	}
+
+	lp.buf = append(lp.buf, s...)
+	lp.appendBacklen(len(lp.buf) - start)
+}
+
+func (lp *listpack) appendInt(n int64) {
+	start := len(lp.buf)
+	switch {
+	case n >= 0 && n <= 127:
+		lp.buf = append(lp.buf, byte(n))
+	case n >= -4096 && n <= 4095:
+		u := uint64(n) & 0x1fff
+		lp.buf = append(lp.buf, 0xc0|byte(u>>8), byte(u))
+	case n >= -1<<15 && n < 1<<15:
+		lp.buf = append(lp.buf, 0xf1)
+		lp.buf = binary.LittleEndian.AppendUint16(lp.buf, uint16(n))
+	case n >= -1<<23 && n < 1<<23:
+		lp.buf = append(lp.buf, 0xf2, byte(n), byte(n>>8), byte(n>>16))
+	case n >= -1<<31 && n < 1<<31:
+		lp.buf = append(lp.buf, 0xf3)
+		lp.buf = binary.LittleEndian.AppendUint32(lp.buf, uint32(n))
+	default:
+		lp.buf = append(lp.buf, 0xf4)
+		lp.buf = binary.LittleEndian.AppendUint64(lp.buf, uint64(n))
+	}
+
+	lp.appendBacklen(len(lp.buf) - start)
+}
+
+// appendBacklen appends the length of the previous element, which allows
+// listpacks to be traversed back to front.
+func (lp *listpack) appendBacklen(length int) {
+	size := backlenSize(length)
+	lp.buf = append(lp.buf, byte(length>>(7*(size-1))))
+
+	for i := size - 2; i >= 0; i-- {
+		lp.buf = append(lp.buf, byte(length>>(7*i))&0x7f|0x80)
+	}
+
+	lp.count++
+}
+
+func backlenSize(length int) int {
+	switch {
+	case length < 1<<7:
+		return 1
+	case length < 1<<14:
+		return 2
+	case length < 1<<21:
+		return 3
+	case length < 1<<28:
+		return 4
+	default:
+		return 5
+	}
+}
+
+func (lp *listpack) bytes() []byte {
+	buf := append(lp.buf, listpackEnd)
+	binary.LittleEndian.PutUint32(buf, uint32(len(buf)))
+
+	count := min(lp.count, 0xffff)
+	binary.LittleEndian.PutUint16(buf[4:], uint16(count))
+
+	return buf
+}
+
+// decodeListpack returns the elements of a listpack, integers are
+// formatted as strings.
+func decodeListpack(buf []byte) ([]string, error) {
+	if len(buf) < listpackHeaderSize+1 || int(binary.LittleEndian.Uint32(buf)) != len(buf) {
+		return nil, errBadListpack
+	}
+
+	elements := []string{}
+	pos := listpackHeaderSize
+
+	for {
+		if pos >= len(buf) {
+			return nil, errBadListpack
+		}
+
+		if buf[pos] == listpackEnd {
+			return elements, nil
+		}
+
+		element, size, err := decodeListpackElement(buf[pos:])
+		if err != nil {
+			return nil, err
+		}
+
+		elements = append(elements, element)
+		pos += size + backlenSize(size)
+	}
+}
+
+// decodeListpackElement returns the element at the start of buf and the
+// size of its encoding, without the backlen.
+func decodeListpackElement(buf []byte) (string, int, error) {
+	first := buf[0]
+
+	intElement := func(size int, value func([]byte) int64) (string, int, error) {
+		if len(buf) < size {
+			return "", 0, errBadListpack
+		}
+
+		return strconv.FormatInt(value(buf[:size]), 10), size, nil
+	}
+
+	strElement := func(headerSize, length int) (string, int, error) {
+		if len(buf) < headerSize+length {
+			return "", 0, errBadListpack
+		}
+
+		return string(buf[headerSize : headerSize+length]), headerSize + length, nil
+	}
+
+	switch {
+	case first&0x80 == 0:
+		return strconv.Itoa(int(first)), 1, nil
+	case first&0xc0 == 0x80:
+		return strElement(1, int(first&0x3f))
+	case first&0xe0 == 0xc0:
+		return intElement(2, func(b []byte) int64 {
+			u := int64(b[0]&0x1f)<<8 | int64(b[1])
+			if u >= 1<<12 {
+				u -= 1 << 13
+			}
+
+			return u
+		})
+	case first&0xf0 == 0xe0:
+		if len(buf) < 2 {
+			return "", 0, errBadListpack
+		}
+
+		return strElement(2, int(first&0x0f)<<8|int(buf[1]))
+	case first == 0xf0:
+		if len(buf) < 5 {
+			return "", 0, errBadListpack
+		}
+
+		return strElement(5, int(binary.LittleEndian.Uint32(buf[1:])))
+	case first == 0xf1:
+		return intElement(3, func(b []byte) int64 {
+			return int64(int16(binary.LittleEndian.Uint16(b[1:])))
+		})
+	case first == 0xf2:
+		return intElement(4, func(b []byte) int64 {
+			return int64(int32(uint32(b[1])<<8|uint32(b[2])<<16|uint32(b[3])<<24) >> 8)
+		})
+	case first == 0xf3:
+		return intElement(5, func(b []byte) int64 {
+			return int64(int32(binary.LittleEndian.Uint32(b[1:])))
+		})
+	case first == 0xf4:
+		return intElement(9, func(b []byte) int64 {
+			return int64(binary.LittleEndian.Uint64(b[1:]))
+		})
+	default:
+		return "", 0, errBadListpack
+	}
+}
@@ -0,0 +1,29 @@
+package rdb
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestListpackRoundTrip(t *testing.T) {
+	elements := []string{
+		"0", "127", "128", "-1", "4095", "-4096", "32767", "-32768",
+		"8388607", "-8388608", "2147483647", "-2147483648", "9223372036854775807",
+		"", "hello", "007", strings.Repeat("x", 100), strings.Repeat("y", 5000),
+	}
+
+	lp := newListpack()
+	for _, element := range elements {
+		lp.appendString(element)
+	}
+
+	got, err := decodeListpack(lp.bytes())
+	if err != nil {
+		t.Fatalf("decodeListpack() error = %v", err)
+	}
+
+	if !slices.Equal(got, elements) {
+		t.Errorf("decodeListpack() = %q, want %q", got, elements)
+	}
+}
@@ -0,0 +1,60 @@
+package rdb
+
+import "errors"
+
+var errBadLZF = errors.New("invalid LZF compressed string")
+
+// decompressLZF decompresses the LZF format Redis uses for long strings.
+// Each chunk is either a run of literal bytes or a back reference into the
+// output.
+func decompressLZF(in []byte, length int) ([]byte, error) {
+	out := []byte{}
+
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 1<<5 {
+			literal := ctrl + 1
+			if i+literal > len(in) {
+				return nil, errBadLZF
+			}
+
+			out = append(out, in[i:i+literal]...)
+			i += literal
+			continue
+		}
+
+		refLength := ctrl >> 5
+		if refLength == 7 {
+			if i >= len(in) {
+				return nil, errBadLZF
+			}
+
+			refLength += int(in[i])
+			i++
+		}
+
+		if i >= len(in) {
+			return nil, errBadLZF
+		}
+
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+
+		if ref < 0 {
+			return nil, errBadLZF
+		}
+
+		//the reference may overlap the bytes being written
+		for j := range refLength + 2 {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != length {
+		return nil, errBadLZF
+	}
+
+	return out, nil
+}
@@ -0,0 +1,295 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"redis-clone-go/app/store"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const magic = "REDIS"
+
+// maxReadVersion is the RDB version of Redis 7.4, its files load as long
+// as they don't use the new hash types.
+const maxReadVersion = 12
+
+const (
+	opcodeSlotInfo = 244
+	// OpcodeFunction precedes a function library, it is also used in
+	// FUNCTION DUMP payloads.
+	OpcodeFunction     = 245
+	opcodeModuleAux    = 247
+	opcodeIdle         = 248
+	opcodeFreq         = 249
+	opcodeAux          = 250
+	opcodeResizeDb     = 251
+	opcodeExpireTimeMs = 252
+	opcodeExpireTime   = 253
+	opcodeSelectDb     = 254
+	opcodeEOF          = 255
+)
+
+// Snapshot is the content of an RDB file.
+type Snapshot struct {
+	Keys      map[string]store.StoredValue
+	Functions []string
+	// Skipped lists the keys Read couldn't load, either because of their
+	// type or because they are in another database than 0.
+	Skipped []SkippedKey
+}
+
+type SkippedKey struct {
+	Key    string
+	Db     uint64
+	Reason string
+}
+
+// Write encodes the snapshot in the RDB format. Expired keys and the empty
+// values left behind by blocked clients are skipped.
+func Write(w io.Writer, snapshot Snapshot) error {
+	buf := fmt.Appendf(nil, "%v%04d", magic, Version)
+
+	buf = appendAux(buf, "redis-ver", "7.2.0")
+	buf = appendAux(buf, "redis-bits", "64")
+	buf = appendAux(buf, "ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	buf = appendAux(buf, "aof-base", "0")
+
+	for _, code := range snapshot.Functions {
+		buf = append(buf, OpcodeFunction)
+		buf = AppendString(buf, code)
+	}
+
+	keys := []string{}
+	expires := 0
+	for key, value := range snapshot.Keys {
+		if value.IsExpired() || isPlaceholder(value) {
+			continue
+		}
+
+		keys = append(keys, key)
+		if value.ExpiresBy != -1 {
+			expires++
+		}
+	}
+
+	slices.Sort(keys)
+
+	buf = append(buf, opcodeSelectDb, 0)
+	buf = append(buf, opcodeResizeDb)
+	buf = AppendLength(buf, uint64(len(keys)))
+	buf = AppendLength(buf, uint64(expires))
+
+	for _, key := range keys {
+		buf = appendKey(buf, key, snapshot.Keys[key])
+	}
+
+	buf = append(buf, opcodeEOF)
+	buf = binary.LittleEndian.AppendUint64(buf, UpdateChecksum(0, buf))
+
+	_, err := w.Write(buf)
+	return err
+}
+
+func appendAux(buf []byte, key, value string) []byte {
+	buf = append(buf, opcodeAux)
+	buf = AppendString(buf, key)
+	return AppendString(buf, value)
+}
+
+// isPlaceholder reports whether the value only exists to hold the
+// listeners of blocked clients.
+func isPlaceholder(value store.StoredValue) bool {
+	switch value.Type {
+	case store.TypeList:
+		return len(value.Lval) == 0
+	case store.TypeStream:
+		return value.Xval.Len() == 0 && value.XMeta == store.StreamMetadata{} && len(value.XGroups) == 0
+	default:
+		return false
+	}
+}
+
+func appendKey(buf []byte, key string, value store.StoredValue) []byte {
+	if value.ExpiresBy != -1 {
+		buf = append(buf, opcodeExpireTimeMs)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(value.ExpiresBy))
+	}
+
+	buf = append(buf, valueType(value))
+	buf = AppendString(buf, key)
+	return appendValue(buf, value)
+}
+
+func valueType(value store.StoredValue) byte {
+	switch value.Type {
+	case store.TypeString:
+		return typeString
+	case store.TypeList:
+		return typeList
+	default:
+		return typeStreamListpacks3
+	}
+}
+
+func appendValue(buf []byte, value store.StoredValue) []byte {
+	switch value.Type {
+	case store.TypeString:
+		return AppendString(buf, value.Val)
+	case store.TypeList:
+		buf = AppendLength(buf, uint64(len(value.Lval)))
+		for _, element := range value.Lval {
+			buf = AppendString(buf, element)
+		}
+
+		return buf
+	default:
+		return appendStream(buf, value)
+	}
+}
+
+// Read decodes an RDB file. Keys that are already expired are left out.
+func Read(data []byte) (Snapshot, error) {
+	snapshot := Snapshot{Keys: make(map[string]store.StoredValue), Functions: []string{}}
+
+	if len(data) < len(magic)+4+1 || string(data[:len(magic)]) != magic {
+		return snapshot, errors.New("not an RDB file")
+	}
+
+	version, err := strconv.Atoi(string(data[len(magic) : len(magic)+4]))
+	if err != nil || version > maxReadVersion {
+		return snapshot, fmt.Errorf("unsupported RDB version %q", data[len(magic):len(magic)+4])
+	}
+
+	body := data
+	if version >= 5 {
+		if len(data) < 8 {
+			return snapshot, errors.New("RDB file is truncated")
+		}
+
+		body = data[:len(data)-8]
+		checksum := binary.LittleEndian.Uint64(data[len(data)-8:])
+
+		//a checksum of zero means it was disabled when writing the file
+		if checksum != 0 && checksum != UpdateChecksum(0, body) {
+			return snapshot, errors.New("RDB checksum mismatch")
+		}
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(body[len(magic)+4:]))
+	expiresBy := int64(-1)
+	db := uint64(0)
+
+	for {
+		opcode, err := reader.ReadByte()
+		if err != nil {
+			return snapshot, fmt.Errorf("RDB file is truncated: %w", err)
+		}
+
+		switch opcode {
+		case opcodeEOF:
+			return snapshot, nil
+		case opcodeAux:
+			if _, err := ReadString(reader); err != nil {
+				return snapshot, err
+			}
+
+			if _, err := ReadString(reader); err != nil {
+				return snapshot, err
+			}
+		case OpcodeFunction:
+			code, err := ReadString(reader)
+			if err != nil {
+				return snapshot, err
+			}
+
+			snapshot.Functions = append(snapshot.Functions, code)
+		case opcodeSelectDb:
+			if db, err = ReadLength(reader); err != nil {
+				return snapshot, err
+			}
+		case opcodeResizeDb:
+			if _, err := ReadLength(reader); err != nil {
+				return snapshot, err
+			}
+
+			if _, err := ReadLength(reader); err != nil {
+				return snapshot, err
+			}
+		case opcodeExpireTimeMs:
+			var ms [8]byte
+			if _, err := io.ReadFull(reader, ms[:]); err != nil {
+				return snapshot, err
+			}
+
+			expiresBy = int64(binary.LittleEndian.Uint64(ms[:]))
+		case opcodeExpireTime:
+			var seconds [4]byte
+			if _, err := io.ReadFull(reader, seconds[:]); err != nil {
+				return snapshot, err
+			}
+
+			expiresBy = int64(binary.LittleEndian.Uint32(seconds[:])) * 1000
+		case opcodeIdle:
+			if _, err := ReadLength(reader); err != nil {
+				return snapshot, err
+			}
+		case opcodeFreq:
+			if _, err := reader.ReadByte(); err != nil {
+				return snapshot, err
+			}
+		case opcodeSlotInfo:
+			//slot id, slot size and expires slot size
+			for range 3 {
+				if _, err := ReadLength(reader); err != nil {
+					return snapshot, err
+				}
+			}
+		case opcodeModuleAux:
+			//module id and when opcode, when, then the module's data
+			for range 3 {
+				if _, err := ReadLength(reader); err != nil {
+					return snapshot, err
+				}
+			}
+
+			if err := skipModuleValue(reader); err != nil {
+				return snapshot, err
+			}
+		default:
+			key, err := ReadString(reader)
+			if err != nil {
+				return snapshot, err
+			}
+
+			value, err := readValue(reader, opcode)
+			if errors.Is(err, errUnsupportedType) {
+				snapshot.Skipped = append(snapshot.Skipped, SkippedKey{key, db, typeName(opcode)})
+				expiresBy = -1
+				continue
+			}
+
+			if err != nil {
+				return snapshot, fmt.Errorf("error loading key %q: %w", key, err)
+			}
+
+			if db != 0 {
+				snapshot.Skipped = append(snapshot.Skipped, SkippedKey{key, db, "database"})
+				expiresBy = -1
+				continue
+			}
+
+			value.ExpiresBy = expiresBy
+			expiresBy = -1
+
+			if !value.IsExpired() {
+				snapshot.Keys[key] = value
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package rdb
+
+import (
+	"bytes"
+	"redis-clone-go/app/store"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	expiresBy := time.Now().Add(time.Hour).UnixMilli()
+
+	stream := []store.StreamEntry{}
+	for i := range 150 {
+		pairs := []string{"field", "value", "n", "1"}
+		if i%7 == 0 {
+			pairs = []string{"other", "value"}
+		}
+
+		stream = append(stream, store.NewStreamEntry(store.StreamId{Ms: int64(1000 + i/3), Sequence: int64(i % 3)}, pairs))
+	}
+
+	streamValue := store.NewStreamValue(stream)
+	group := store.NewStreamGroup(stream[10].Id, 11)
+	group.Deliver(stream[3].Id, "alice", 1000, 2)
+	group.Deliver(stream[5].Id, "bob", 2000, 1)
+	group.Deliver(stream[10].Id, "alice", 3000, 1)
+	group.Consumers["carol"] = store.NewStreamConsumer(4000)
+	streamValue.SetGroup("group", group)
+	streamValue.SetGroup("empty", store.NewStreamGroup(store.StreamId{}, 0))
+
+	snapshot := Snapshot{
+		Keys: map[string]store.StoredValue{
+			"string":  store.NewStringValue("value", -1),
+			"expires": store.NewStringValue("value", expiresBy),
+			"expired": store.NewStringValue("value", 1),
+			"list":    store.NewListValue([]string{"a", "1", "-20"}),
+			"stream":  streamValue,
+			"blocked": store.NewListListener(make(chan string)),
+		},
+		Functions: []string{"#!lua name=lib\nredis.register_function('f', function() return 1 end)"},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, snapshot); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	got, err := Read(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	if len(got.Keys) != 4 {
+		t.Errorf("Read() loaded %d keys, want 4", len(got.Keys))
+	}
+
+	if got.Keys["string"].Val != "value" || got.Keys["string"].ExpiresBy != -1 {
+		t.Errorf("string = %+v", got.Keys["string"])
+	}
+
+	if got.Keys["expires"].ExpiresBy != expiresBy {
+		t.Errorf("expires.ExpiresBy = %d, want %d", got.Keys["expires"].ExpiresBy, expiresBy)
+	}
+
+	if !slices.Equal(got.Keys["list"].Lval, []string{"a", "1", "-20"}) {
+		t.Errorf("list = %q", got.Keys["list"].Lval)
+	}
+
+	gotStream := got.Keys["stream"]
+	if gotStream.Xval.Len() != len(stream) || gotStream.XMeta != snapshot.Keys["stream"].XMeta {
+		t.Fatalf("stream has %d entries and metadata %+v", gotStream.Xval.Len(), gotStream.XMeta)
+	}
+
+	for i, entry := range slices.Collect(gotStream.Xval.All()) {
+		if entry.Id != stream[i].Id || !slices.Equal(entry.Pairs, stream[i].Pairs) {
+			t.Errorf("stream entry %d = %+v, want %+v", i, entry, stream[i])
+		}
+	}
+
+	if len(gotStream.XGroups) != 2 || gotStream.XGroups["empty"].Pending.Len() != 0 {
+		t.Fatalf("stream groups = %+v", gotStream.XGroups)
+	}
+
+	gotGroup := gotStream.XGroups["group"]
+	if gotGroup.LastId != group.LastId || gotGroup.EntriesRead != 11 || gotGroup.Pending.Len() != 3 ||
+		len(gotGroup.Consumers) != len(group.Consumers) {
+		t.Errorf("group = %+v", gotGroup)
+	}
+
+	for id, pending := range group.Pending.Ascend(store.StreamId{}) {
+		if gotPending, _ := gotGroup.Pending.Get(id); gotPending != pending {
+			t.Errorf("pending entry %v = %+v, want %+v", id, gotPending, pending)
+		}
+	}
+
+	for name, consumer := range group.Consumers {
+		gotConsumer := gotGroup.Consumers[name]
+		if gotConsumer.SeenTime != consumer.SeenTime || gotConsumer.ActiveTime != consumer.ActiveTime ||
+			gotConsumer.Pending.Len() != consumer.Pending.Len() {
+			t.Errorf("consumer %q = %+v, want %+v", name, gotConsumer, consumer)
+		}
+	}
+
+	if !slices.Equal(got.Functions, snapshot.Functions) {
+		t.Errorf("Functions = %q, want %q", got.Functions, snapshot.Functions)
+	}
+}
+
+func TestReadCorrupted(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, Snapshot{Keys: map[string]store.StoredValue{"k": store.NewStringValue("v", -1)}}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	data := buf.Bytes()
+	data[len(data)-12] ^= 0xff
+
+	if _, err := Read(data); err == nil {
+		t.Errorf("Read() of a corrupted file succeeded")
+	}
+}
@@ -0,0 +1,459 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"redis-clone-go/app/store"
+	"slices"
+	"strconv"
+)
+
+// streamNodeMaxEntries matches the default of stream-node-max-entries.
+const streamNodeMaxEntries = 100
+
+const (
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+)
+
+// appendStream encodes a stream as RDB_TYPE_STREAM_LISTPACKS_3. Entries
+// are stored in listpack nodes keyed by the id of their first entry.
+func appendStream(buf []byte, value store.StoredValue) []byte {
+	entries := slices.Collect(value.Xval.All())
+	nodes := (len(entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	buf = AppendLength(buf, uint64(nodes))
+
+	for start := 0; start < len(entries); start += streamNodeMaxEntries {
+		node := entries[start:min(start+streamNodeMaxEntries, len(entries))]
+		master := node[0].Id
+
+		buf = AppendString(buf, string(appendRawStreamId(nil, master)))
+		buf = AppendString(buf, string(encodeStreamNode(node)))
+	}
+
+	firstId := store.StreamId{}
+	if len(entries) > 0 {
+		firstId = entries[0].Id
+	}
+
+	meta := value.XMeta
+	buf = AppendLength(buf, uint64(len(entries)))
+	buf = appendStreamId(buf, meta.LastId)
+	buf = appendStreamId(buf, firstId)
+	buf = appendStreamId(buf, meta.MaxDeletedId)
+	buf = AppendLength(buf, uint64(meta.EntriesAdded))
+
+	names := slices.Sorted(maps.Keys(value.XGroups))
+	buf = AppendLength(buf, uint64(len(names)))
+	for _, name := range names {
+		buf = appendConsumerGroup(buf, name, value.XGroups[name])
+	}
+
+	return buf
+}
+
+// appendConsumerGroup encodes a group with its PEL, then its consumers with
+// the ids of their pending entries.
+func appendConsumerGroup(buf []byte, name string, group store.StreamGroup) []byte {
+	buf = AppendString(buf, name)
+	buf = appendStreamId(buf, group.LastId)
+	buf = AppendLength(buf, uint64(group.EntriesRead))
+
+	buf = AppendLength(buf, uint64(group.Pending.Len()))
+	for id, pending := range group.Pending.Ascend(store.StreamId{}) {
+		buf = appendRawStreamId(buf, id)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(pending.DeliveryTime))
+		buf = AppendLength(buf, uint64(pending.DeliveryCount))
+	}
+
+	consumers := slices.Sorted(maps.Keys(group.Consumers))
+	buf = AppendLength(buf, uint64(len(consumers)))
+	for _, consumerName := range consumers {
+		consumer := group.Consumers[consumerName]
+		buf = AppendString(buf, consumerName)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(consumer.SeenTime))
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(consumer.ActiveTime))
+
+		buf = AppendLength(buf, uint64(consumer.Pending.Len()))
+		for id := range consumer.Pending.Ascend(store.StreamId{}) {
+			buf = appendRawStreamId(buf, id)
+		}
+	}
+
+	return buf
+}
+
+// appendRawStreamId encodes an id in 16 big endian bytes, like node keys.
+func appendRawStreamId(buf []byte, id store.StreamId) []byte {
+	buf = binary.BigEndian.AppendUint64(buf, uint64(id.Ms))
+	return binary.BigEndian.AppendUint64(buf, uint64(id.Sequence))
+}
+
+func appendStreamId(buf []byte, id store.StreamId) []byte {
+	buf = AppendLength(buf, uint64(id.Ms))
+	return AppendLength(buf, uint64(id.Sequence))
+}
+
+func encodeStreamNode(entries []store.StreamEntry) []byte {
+	master := entries[0]
+	masterFields := entryFields(master)
+
+	lp := newListpack()
+	lp.appendInt(int64(len(entries)))
+	lp.appendInt(0)
+	lp.appendInt(int64(len(masterFields)))
+	for _, field := range masterFields {
+		lp.appendString(field)
+	}
+	lp.appendInt(0)
+
+	for _, entry := range entries {
+		fields := entryFields(entry)
+		sameFields := slices.Equal(fields, masterFields)
+
+		flags := int64(0)
+		if sameFields {
+			flags = streamItemSameFields
+		}
+
+		lp.appendInt(flags)
+		lp.appendInt(entry.Id.Ms - master.Id.Ms)
+		lp.appendInt(entry.Id.Sequence - master.Id.Sequence)
+
+		if !sameFields {
+			lp.appendInt(int64(len(fields)))
+		}
+
+		for i := 0; i+1 < len(entry.Pairs); i += 2 {
+			if !sameFields {
+				lp.appendString(entry.Pairs[i])
+			}
+
+			lp.appendString(entry.Pairs[i+1])
+		}
+
+		lpCount := int64(len(fields)) + 3
+		if !sameFields {
+			lpCount += int64(len(fields)) + 1
+		}
+
+		lp.appendInt(lpCount)
+	}
+
+	return lp.bytes()
+}
+
+func entryFields(entry store.StreamEntry) []string {
+	fields := make([]string, 0, len(entry.Pairs)/2)
+	for i := 0; i+1 < len(entry.Pairs); i += 2 {
+		fields = append(fields, entry.Pairs[i])
+	}
+
+	return fields
+}
+
+// readStream reads the stream types of Redis 5 to 7.
+func readStream(reader *bufio.Reader, valueType byte) (store.StoredValue, error) {
+	nodes, err := ReadLength(reader)
+	if err != nil {
+		return store.StoredValue{}, err
+	}
+
+	entries := []store.StreamEntry{}
+	for range nodes {
+		key, err := ReadString(reader)
+		if err != nil {
+			return store.StoredValue{}, err
+		}
+
+		if len(key) != 16 {
+			return store.StoredValue{}, fmt.Errorf("invalid stream node key of length %d", len(key))
+		}
+
+		node, err := ReadString(reader)
+		if err != nil {
+			return store.StoredValue{}, err
+		}
+
+		nodeEntries, err := decodeStreamNode([]byte(node), decodeRawStreamId([]byte(key)))
+		if err != nil {
+			return store.StoredValue{}, err
+		}
+
+		entries = append(entries, nodeEntries...)
+	}
+
+	//length, it is implied by the entries
+	if _, err := ReadLength(reader); err != nil {
+		return store.StoredValue{}, err
+	}
+
+	value := store.NewStreamValue(entries)
+
+	if value.XMeta.LastId, err = readStreamId(reader); err != nil {
+		return store.StoredValue{}, err
+	}
+
+	if valueType >= typeStreamListpacks2 {
+		//first entry id, also implied by the entries
+		if _, err := readStreamId(reader); err != nil {
+			return store.StoredValue{}, err
+		}
+
+		if value.XMeta.MaxDeletedId, err = readStreamId(reader); err != nil {
+			return store.StoredValue{}, err
+		}
+
+		entriesAdded, err := ReadLength(reader)
+		if err != nil {
+			return store.StoredValue{}, err
+		}
+
+		value.XMeta.EntriesAdded = int64(entriesAdded)
+	}
+
+	groups, err := ReadLength(reader)
+	if err != nil {
+		return store.StoredValue{}, err
+	}
+
+	for range groups {
+		name, group, err := readConsumerGroup(reader, valueType)
+		if err != nil {
+			return store.StoredValue{}, err
+		}
+
+		value.SetGroup(name, group)
+	}
+
+	return value, nil
+}
+
+func readConsumerGroup(reader *bufio.Reader, valueType byte) (string, store.StreamGroup, error) {
+	name, err := ReadString(reader)
+	if err != nil {
+		return "", store.StreamGroup{}, err
+	}
+
+	lastId, err := readStreamId(reader)
+	if err != nil {
+		return "", store.StreamGroup{}, err
+	}
+
+	//older versions don't know how many entries were read
+	entriesRead := int64(-1)
+	if valueType >= typeStreamListpacks2 {
+		n, err := ReadLength(reader)
+		if err != nil {
+			return "", store.StreamGroup{}, err
+		}
+
+		entriesRead = int64(n)
+	}
+
+	group := store.NewStreamGroup(lastId, entriesRead)
+
+	pending, err := ReadLength(reader)
+	if err != nil {
+		return "", store.StreamGroup{}, err
+	}
+
+	for range pending {
+		buf, err := readBytes(reader, 16+8)
+		if err != nil {
+			return "", store.StreamGroup{}, err
+		}
+
+		deliveryCount, err := ReadLength(reader)
+		if err != nil {
+			return "", store.StreamGroup{}, err
+		}
+
+		deliveryTime := int64(binary.LittleEndian.Uint64(buf[16:]))
+		group.Pending.Set(decodeRawStreamId(buf), store.PendingEntry{DeliveryTime: deliveryTime, DeliveryCount: int64(deliveryCount)})
+	}
+
+	consumers, err := ReadLength(reader)
+	if err != nil {
+		return "", store.StreamGroup{}, err
+	}
+
+	for range consumers {
+		consumerName, err := ReadString(reader)
+		if err != nil {
+			return "", store.StreamGroup{}, err
+		}
+
+		//the active time was added in RDB_TYPE_STREAM_LISTPACKS_3
+		times := uint64(8)
+		if valueType >= typeStreamListpacks3 {
+			times = 16
+		}
+
+		buf, err := readBytes(reader, times)
+		if err != nil {
+			return "", store.StreamGroup{}, err
+		}
+
+		seenTime := int64(binary.LittleEndian.Uint64(buf))
+		consumer := store.StreamConsumer{SeenTime: seenTime, ActiveTime: seenTime}
+		if valueType >= typeStreamListpacks3 {
+			consumer.ActiveTime = int64(binary.LittleEndian.Uint64(buf[8:]))
+		}
+
+		consumerPending, err := ReadLength(reader)
+		if err != nil {
+			return "", store.StreamGroup{}, err
+		}
+
+		for range consumerPending {
+			buf, err := readBytes(reader, 16)
+			if err != nil {
+				return "", store.StreamGroup{}, err
+			}
+
+			id := decodeRawStreamId(buf)
+			entry, ok := group.Pending.Get(id)
+			if !ok {
+				return "", store.StreamGroup{}, fmt.Errorf("consumer %q of group %q has entry %v that isn't pending", consumerName, name, id)
+			}
+
+			//the PEL is read before its owners
+			entry.Consumer = consumerName
+			group.Pending.Set(id, entry)
+			consumer.Pending.Set(id, struct{}{})
+		}
+
+		group.Consumers[consumerName] = consumer
+	}
+
+	return name, group, nil
+}
+
+func decodeRawStreamId(buf []byte) store.StreamId {
+	return store.StreamId{
+		Ms:       int64(binary.BigEndian.Uint64(buf[:8])),
+		Sequence: int64(binary.BigEndian.Uint64(buf[8:16])),
+	}
+}
+
+func readStreamId(reader *bufio.Reader) (store.StreamId, error) {
+	ms, err := ReadLength(reader)
+	if err != nil {
+		return store.StreamId{}, err
+	}
+
+	seq, err := ReadLength(reader)
+	if err != nil {
+		return store.StreamId{}, err
+	}
+
+	return store.StreamId{Ms: int64(ms), Sequence: int64(seq)}, nil
+}
+
+func decodeStreamNode(buf []byte, master store.StreamId) ([]store.StreamEntry, error) {
+	elements, err := decodeListpack(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	lp := &listpackIterator{elements: elements}
+
+	//count of valid and of deleted entries
+	lp.nextInt()
+	lp.nextInt()
+
+	masterFields := make([]string, lp.nextLength())
+	for i := range masterFields {
+		masterFields[i] = lp.next()
+	}
+
+	//master entry terminator
+	lp.next()
+
+	entries := []store.StreamEntry{}
+	for lp.err == nil && !lp.done() {
+		flags := lp.nextInt()
+		id := store.StreamId{Ms: master.Ms + lp.nextInt(), Sequence: master.Sequence + lp.nextInt()}
+
+		fields := masterFields
+		if flags&streamItemSameFields == 0 {
+			fields = make([]string, lp.nextLength())
+		}
+
+		pairs := make([]string, 0, len(fields)*2)
+		for i := range fields {
+			if flags&streamItemSameFields == 0 {
+				fields[i] = lp.next()
+			}
+
+			pairs = append(pairs, fields[i], lp.next())
+		}
+
+		//lp-count
+		lp.next()
+
+		if flags&streamItemDeleted == 0 {
+			entries = append(entries, store.NewStreamEntry(id, pairs))
+		}
+	}
+
+	if lp.err != nil {
+		return nil, lp.err
+	}
+
+	return entries, nil
+}
+
+// listpackIterator walks decoded listpack elements, the first error is
+// kept in err.
+type listpackIterator struct {
+	elements []string
+	pos      int
+	err      error
+}
+
+func (it *listpackIterator) done() bool {
+	return it.pos >= len(it.elements)
+}
+
+func (it *listpackIterator) next() string {
+	if it.err != nil {
+		return ""
+	}
+
+	if it.done() {
+		it.err = errBadListpack
+		return ""
+	}
+
+	it.pos++
+	return it.elements[it.pos-1]
+}
+
+// nextLength reads a count of following elements.
+func (it *listpackIterator) nextLength() int {
+	n := it.nextInt()
+	if n < 0 || n > int64(len(it.elements)-it.pos) {
+		it.err = errBadListpack
+		return 0
+	}
+
+	return int(n)
+}
+
+func (it *listpackIterator) nextInt() int64 {
+	element := it.next()
+	if it.err != nil {
+		return 0
+	}
+
+	n, err := strconv.ParseInt(element, 10, 64)
+	if err != nil {
+		it.err = errBadListpack
+	}
+
+	return n
+}
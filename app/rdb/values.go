@@ -0,0 +1,241 @@
+package rdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"redis-clone-go/app/store"
+)
+
+const (
+	typeString           = 0
+	typeList             = 1
+	typeSet              = 2
+	typeZset             = 3
+	typeHash             = 4
+	typeZset2            = 5
+	typeModulePreGA      = 6
+	typeModule2          = 7
+	typeHashZipmap       = 9
+	typeListZiplist      = 10
+	typeSetIntset        = 11
+	typeZsetZiplist      = 12
+	typeHashZiplist      = 13
+	typeListQuicklist    = 14
+	typeStreamListpacks  = 15
+	typeHashListpack     = 16
+	typeZsetListpack     = 17
+	typeListQuicklist2   = 18
+	typeStreamListpacks2 = 19
+	typeSetListpack      = 20
+	typeStreamListpacks3 = 21
+)
+
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+// errUnsupportedType is returned after a value of a type the store can't
+// hold was read, so loading can continue with the next key.
+var errUnsupportedType = errors.New("unsupported value type")
+
+// readValue reads a value of any type Redis 7 writes. Sets, sorted sets,
+// hashes and module values are skipped with errUnsupportedType.
+func readValue(reader *bufio.Reader, valueType byte) (store.StoredValue, error) {
+	switch valueType {
+	case typeString:
+		val, err := ReadString(reader)
+		return store.NewStringValue(val, -1), err
+	case typeList, typeListZiplist, typeListQuicklist, typeListQuicklist2:
+		elements, err := readList(reader, valueType)
+		return store.NewListValue(elements), err
+	case typeStreamListpacks, typeStreamListpacks2, typeStreamListpacks3:
+		return readStream(reader, valueType)
+	case typeSet:
+		return store.StoredValue{}, skipUnsupported(skipStrings(reader, 1))
+	case typeHash:
+		return store.StoredValue{}, skipUnsupported(skipStrings(reader, 2))
+	case typeZset, typeZset2:
+		return store.StoredValue{}, skipUnsupported(skipZset(reader, valueType))
+	case typeHashZipmap, typeSetIntset, typeZsetZiplist, typeHashZiplist,
+		typeHashListpack, typeZsetListpack, typeSetListpack:
+		_, err := ReadString(reader)
+		return store.StoredValue{}, skipUnsupported(err)
+	case typeModule2:
+		//module id
+		if _, err := ReadLength(reader); err != nil {
+			return store.StoredValue{}, err
+		}
+
+		return store.StoredValue{}, skipUnsupported(skipModuleValue(reader))
+	default:
+		return store.StoredValue{}, fmt.Errorf("can't load value type %d", valueType)
+	}
+}
+
+func skipUnsupported(err error) error {
+	if err != nil {
+		return err
+	}
+
+	return errUnsupportedType
+}
+
+func typeName(valueType byte) string {
+	switch valueType {
+	case typeSet, typeSetIntset, typeSetListpack:
+		return "set"
+	case typeZset, typeZset2, typeZsetZiplist, typeZsetListpack:
+		return "zset"
+	case typeHash, typeHashZipmap, typeHashZiplist, typeHashListpack:
+		return "hash"
+	case typeModulePreGA, typeModule2:
+		return "module"
+	default:
+		return fmt.Sprintf("type %d", valueType)
+	}
+}
+
+func readList(reader *bufio.Reader, valueType byte) ([]string, error) {
+	if valueType == typeListZiplist {
+		ziplist, err := ReadString(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		return decodeZiplist([]byte(ziplist))
+	}
+
+	length, err := ReadLength(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	elements := []string{}
+	for range length {
+		container := uint64(quicklistNodePacked)
+		if valueType == typeListQuicklist2 {
+			if container, err = ReadLength(reader); err != nil {
+				return nil, err
+			}
+		}
+
+		node, err := ReadString(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case valueType == typeList || container == quicklistNodePlain:
+			elements = append(elements, node)
+		case valueType == typeListQuicklist:
+			nodeElements, err := decodeZiplist([]byte(node))
+			if err != nil {
+				return nil, err
+			}
+
+			elements = append(elements, nodeElements...)
+		default:
+			nodeElements, err := decodeListpack([]byte(node))
+			if err != nil {
+				return nil, err
+			}
+
+			elements = append(elements, nodeElements...)
+		}
+	}
+
+	return elements, nil
+}
+
+// skipStrings skips a length followed by that many groups of n strings.
+func skipStrings(reader *bufio.Reader, n uint64) error {
+	length, err := ReadLength(reader)
+	if err != nil {
+		return err
+	}
+
+	for range length * n {
+		if _, err := ReadString(reader); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func skipZset(reader *bufio.Reader, valueType byte) error {
+	length, err := ReadLength(reader)
+	if err != nil {
+		return err
+	}
+
+	for range length {
+		if _, err := ReadString(reader); err != nil {
+			return err
+		}
+
+		//zset2 stores binary doubles, zset doubles as strings with a one
+		//byte length, where 253 to 255 stand for nan and the infinities
+		if valueType == typeZset2 {
+			if _, err := readBytes(reader, 8); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		length, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if length < 253 {
+			if _, err := readBytes(reader, uint64(length)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+const (
+	moduleOpcodeEOF    = 0
+	moduleOpcodeSInt   = 1
+	moduleOpcodeUInt   = 2
+	moduleOpcodeFloat  = 3
+	moduleOpcodeDouble = 4
+	moduleOpcodeString = 5
+)
+
+// skipModuleValue skips module data, which is a sequence of typed fields
+// ending with an EOF opcode.
+func skipModuleValue(reader *bufio.Reader) error {
+	for {
+		opcode, err := ReadLength(reader)
+		if err != nil {
+			return err
+		}
+
+		switch opcode {
+		case moduleOpcodeEOF:
+			return nil
+		case moduleOpcodeSInt, moduleOpcodeUInt:
+			_, err = ReadLength(reader)
+		case moduleOpcodeFloat:
+			_, err = readBytes(reader, 4)
+		case moduleOpcodeDouble:
+			_, err = readBytes(reader, 8)
+		case moduleOpcodeString:
+			_, err = ReadString(reader)
+		default:
+			return fmt.Errorf("unknown module opcode %d", opcode)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecodeEncodings(t *testing.T) {
+	tests := []struct {
+		name   string
+		decode func([]byte) ([]string, error)
+		input  []byte
+		want   []string
+	}{
+		{
+			"ziplist with small integers",
+			decodeZiplist,
+			[]byte{0x0f, 0, 0, 0, 0x0c, 0, 0, 0, 0x02, 0, 0x00, 0xf3, 0x02, 0xf6, 0xff},
+			[]string{"2", "5"},
+		},
+		{
+			"ziplist with string and int16",
+			decodeZiplist,
+			[]byte{0x13, 0, 0, 0, 0x0e, 0, 0, 0, 0x02, 0, 0x00, 0x02, 'h', 'i', 0x04, 0xc0, 0x30, 0xf8, 0xff},
+			[]string{"hi", "-2000"},
+		},
+		{
+			"intset",
+			decodeIntset,
+			[]byte{0x02, 0, 0, 0, 0x03, 0, 0, 0, 0x01, 0x00, 0xfe, 0xff, 0x03, 0x00},
+			[]string{"1", "-2", "3"},
+		},
+		{
+			"lzf literal and back reference",
+			func(b []byte) ([]string, error) {
+				out, err := decompressLZF(b, 21)
+				return []string{string(out)}, err
+			},
+			[]byte{0x00, 'a', 0xe0, 11, 0x00},
+			[]string{strings.Repeat("a", 21)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.decode(tt.input)
+			if err != nil {
+				t.Fatalf("decode error = %v", err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("decode = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestReadRedisTypes loads the encodings Redis 7 uses, including types the
+// store doesn't support.
+func TestReadRedisTypes(t *testing.T) {
+	lp := newListpack()
+	lp.appendString("a")
+	lp.appendString("42")
+
+	buf := []byte("REDIS0011")
+	buf = appendAux(buf, "redis-ver", "7.2.4")
+	buf = append(buf, opcodeSelectDb, 0)
+
+	//string encoded as an int16
+	buf = append(buf, typeString)
+	buf = AppendString(buf, "counter")
+	buf = append(buf, 0xc1, 0x39, 0x30)
+
+	buf = append(buf, typeListQuicklist2)
+	buf = AppendString(buf, "list")
+	buf = AppendLength(buf, 2)
+	buf = AppendLength(buf, quicklistNodePacked)
+	buf = AppendString(buf, string(lp.bytes()))
+	buf = AppendLength(buf, quicklistNodePlain)
+	buf = AppendString(buf, "plain")
+
+	buf = append(buf, typeSet)
+	buf = AppendString(buf, "set")
+	buf = AppendLength(buf, 2)
+	buf = AppendString(buf, "x")
+	buf = AppendString(buf, "y")
+
+	buf = append(buf, typeHashListpack)
+	buf = AppendString(buf, "hash")
+	buf = AppendString(buf, string(lp.bytes()))
+
+	buf = append(buf, typeZset2)
+	buf = AppendString(buf, "zset")
+	buf = AppendLength(buf, 1)
+	buf = AppendString(buf, "member")
+	buf = binary.LittleEndian.AppendUint64(buf, 0)
+
+	buf = append(buf, opcodeSelectDb, 1)
+	buf = append(buf, typeString)
+	buf = AppendString(buf, "other")
+	buf = AppendString(buf, "db")
+
+	buf = append(buf, opcodeEOF)
+	buf = binary.LittleEndian.AppendUint64(buf, UpdateChecksum(0, buf))
+
+	snapshot, err := Read(buf)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	if got := snapshot.Keys["counter"].Val; got != "12345" {
+		t.Errorf("counter = %q, want %q", got, "12345")
+	}
+
+	if got := snapshot.Keys["list"].Lval; !slices.Equal(got, []string{"a", "42", "plain"}) {
+		t.Errorf("list = %q", got)
+	}
+
+	skipped := []string{}
+	for _, key := range snapshot.Skipped {
+		skipped = append(skipped, fmt.Sprintf("%v:%v", key.Key, key.Reason))
+	}
+
+	want := []string{"set:set", "hash:hash", "zset:zset", "other:database"}
+	if !slices.Equal(skipped, want) {
+		t.Errorf("Skipped = %q, want %q", skipped, want)
+	}
+}
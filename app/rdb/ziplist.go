@@ -0,0 +1,110 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// ziplists are the predecessor of listpacks, Redis 7 still loads them from
+// RDB files written by older versions.
+
+const ziplistHeaderSize = 10
+const ziplistEnd = 0xff
+
+var errBadZiplist = errors.New("invalid ziplist")
+
+// decodeZiplist returns the elements of a ziplist, integers are formatted
+// as strings.
+func decodeZiplist(buf []byte) ([]string, error) {
+	if len(buf) < ziplistHeaderSize+1 || int(binary.LittleEndian.Uint32(buf)) != len(buf) {
+		return nil, errBadZiplist
+	}
+
+	elements := []string{}
+	pos := ziplistHeaderSize
+
+	for {
+		if pos >= len(buf) {
+			return nil, errBadZiplist
+		}
+
+		if buf[pos] == ziplistEnd {
+			return elements, nil
+		}
+
+		//the length of the previous entry
+		if buf[pos] == 0xfe {
+			pos += 5
+		} else {
+			pos++
+		}
+
+		if pos >= len(buf) {
+			return nil, errBadZiplist
+		}
+
+		element, size, err := decodeZiplistEntry(buf[pos:])
+		if err != nil {
+			return nil, err
+		}
+
+		elements = append(elements, element)
+		pos += size
+	}
+}
+
+// decodeZiplistEntry returns the element at the start of buf and the size
+// of its encoding.
+func decodeZiplistEntry(buf []byte) (string, int, error) {
+	first := buf[0]
+
+	strEntry := func(headerSize, length int) (string, int, error) {
+		if len(buf) < headerSize+length {
+			return "", 0, errBadZiplist
+		}
+
+		return string(buf[headerSize : headerSize+length]), headerSize + length, nil
+	}
+
+	intEntry := func(size int, value func([]byte) int64) (string, int, error) {
+		if len(buf) < 1+size {
+			return "", 0, errBadZiplist
+		}
+
+		return strconv.FormatInt(value(buf[1:1+size]), 10), 1 + size, nil
+	}
+
+	switch {
+	case first>>6 == 0:
+		return strEntry(1, int(first&0x3f))
+	case first>>6 == 1:
+		if len(buf) < 2 {
+			return "", 0, errBadZiplist
+		}
+
+		return strEntry(2, int(first&0x3f)<<8|int(buf[1]))
+	case first == 0x80:
+		if len(buf) < 5 {
+			return "", 0, errBadZiplist
+		}
+
+		return strEntry(5, int(binary.BigEndian.Uint32(buf[1:])))
+	case first == 0xc0:
+		return intEntry(2, func(b []byte) int64 { return int64(int16(binary.LittleEndian.Uint16(b))) })
+	case first == 0xd0:
+		return intEntry(4, func(b []byte) int64 { return int64(int32(binary.LittleEndian.Uint32(b))) })
+	case first == 0xe0:
+		return intEntry(8, func(b []byte) int64 { return int64(binary.LittleEndian.Uint64(b)) })
+	case first == 0xf0:
+		return intEntry(3, func(b []byte) int64 {
+			return int64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8)
+		})
+	case first == 0xfe:
+		return intEntry(1, func(b []byte) int64 { return int64(int8(b[0])) })
+	case first >= 0xf1 && first <= 0xfd:
+		return strconv.Itoa(int(first&0x0f) - 1), 1, nil
+	default:
+		return "", 0, errBadZiplist
+	}
+}
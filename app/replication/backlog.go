@@ -0,0 +1,49 @@
+package replication
+
+// backlog keeps the end of the replication stream in a circular buffer, so
+// a replica that was briefly disconnected can continue where it stopped.
+type backlog struct {
+	buf []byte
+	// next is where the next byte is written
+	next int
+	// histlen is the number of valid bytes, at most len(buf)
+	histlen int
+}
+
+func newBacklog(size int) *backlog {
+	return &backlog{buf: make([]byte, size)}
+}
+
+func (b *backlog) write(data []byte) {
+	size := len(b.buf)
+
+	//only the end of data fits if it is larger than the backlog
+	if len(data) > size {
+		data = data[len(data)-size:]
+	}
+
+	n := copy(b.buf[b.next:], data)
+	copy(b.buf, data[n:])
+
+	b.next = (b.next + len(data)) % size
+	b.histlen = min(b.histlen+len(data), size)
+}
+
+// last returns the last n bytes of the stream, it reports false if they
+// aren't all in the backlog anymore.
+func (b *backlog) last(n int64) ([]byte, bool) {
+	if n < 0 || n > int64(b.histlen) {
+		return nil, false
+	}
+
+	size := len(b.buf)
+	start := (b.next - int(n) + size) % size
+
+	data := make([]byte, 0, n)
+	if start+int(n) <= size {
+		return append(data, b.buf[start:start+int(n)]...), true
+	}
+
+	data = append(data, b.buf[start:]...)
+	return append(data, b.buf[:b.next]...), true
+}
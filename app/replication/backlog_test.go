@@ -0,0 +1,35 @@
+package replication
+
+import "testing"
+
+func TestBacklog(t *testing.T) {
+	b := newBacklog(8)
+
+	b.write([]byte("abc"))
+	if got, ok := b.last(3); !ok || string(got) != "abc" {
+		t.Errorf("last(3) = %q, %v, want \"abc\", true", got, ok)
+	}
+
+	if _, ok := b.last(4); ok {
+		t.Errorf("last(4) succeeded with only 3 bytes written")
+	}
+
+	//wraps around and drops the oldest bytes
+	b.write([]byte("defghij"))
+	if got, ok := b.last(8); !ok || string(got) != "cdefghij" {
+		t.Errorf("last(8) = %q, %v, want \"cdefghij\", true", got, ok)
+	}
+
+	if got, ok := b.last(0); !ok || len(got) != 0 {
+		t.Errorf("last(0) = %q, %v, want \"\", true", got, ok)
+	}
+
+	b.write([]byte("0123456789"))
+	if got, ok := b.last(8); !ok || string(got) != "23456789" {
+		t.Errorf("last(8) = %q, %v, want \"23456789\", true", got, ok)
+	}
+
+	if _, ok := b.last(9); ok {
+		t.Errorf("last(9) succeeded on a backlog of 8 bytes")
+	}
+}
@@ -0,0 +1,250 @@
+package replication
+
+import (
+	"bytes"
+	"fmt"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/rdb"
+	"sync"
+	"time"
+)
+
+// replicaBufferSize is the number of writes a replica can fall behind by
+// before it is disconnected, similar to Redis' replica output buffer limit.
+const replicaBufferSize = 1 << 16
+
+// pingPeriod is how often the master pings its replicas, so they can tell
+// an idle master from a dead one.
+const pingPeriod = 10 * time.Second
+
+// Replica is a replica connected to this server.
+type Replica struct {
+	Host string
+	Port string
+
+	// ackOffset is the offset the replica acknowledged with REPLCONF ACK,
+	// aofOffset the one it acknowledged to have fsynced to its AOF
+	ackOffset int64
+	aofOffset int64
+
+	output chan []byte
+	done   chan struct{}
+	once   sync.Once
+
+	// payload is sent before anything from output, it is either the
+	// FULLRESYNC reply, which is followed by the snapshot, or CONTINUE
+	// with the part of the backlog the replica is missing
+	payload  []byte
+	snapshot *rdb.Snapshot
+}
+
+var pingOnce sync.Once
+
+// acked is closed and replaced whenever a replica acknowledges an offset,
+// which wakes up the clients waiting in WAIT and WAITAOF.
+var acked = make(chan struct{})
+
+// Sync registers a replica that asked to continue the stream requestedId
+// after having received requestedOffset bytes of it. If the rest of that
+// stream is still in the backlog, it continues from there, otherwise it
+// gets a full resync from the snapshot. The caller has to make sure nothing
+// is written meanwhile, so no write is missed or sent twice.
+func Sync(host, port, requestedId string, requestedOffset int64, snapshot func() rdb.Snapshot) *Replica {
+	mu.Lock()
+	defer mu.Unlock()
+
+	replica := &Replica{
+		Host:   host,
+		Port:   port,
+		output: make(chan []byte, replicaBufferSize),
+		done:   make(chan struct{}),
+	}
+
+	if missing, ok := backlogSince(requestedId, requestedOffset); ok {
+		replica.payload = append(fmt.Appendf(nil, "+CONTINUE %v\r\n", replId), missing...)
+		fmt.Printf("Partial resynchronization of replica %v:%v, sending %d bytes of backlog\n", host, port, len(missing))
+	} else {
+		full := snapshot()
+		replica.snapshot = &full
+		replica.payload = fmt.Appendf(nil, "+FULLRESYNC %v %d\r\n", replId, offset)
+	}
+
+	if replBacklog == nil {
+		replBacklog = newBacklog(backlogSize)
+	}
+
+	replicas[replica] = struct{}{}
+	pingOnce.Do(func() { go pingReplicas() })
+
+	return replica
+}
+
+// backlogSince returns the part of the stream a replica is missing, mu has
+// to be held.
+func backlogSince(requestedId string, requestedOffset int64) ([]byte, bool) {
+	if replBacklog == nil || requestedOffset < 0 {
+		return nil, false
+	}
+
+	if requestedId != replId && (requestedId != replId2 || requestedOffset > secondOffset) {
+		return nil, false
+	}
+
+	return replBacklog.last(offset - requestedOffset)
+}
+
+// SyncPayload returns what has to be sent before anything from Output, for
+// a full resync that includes the encoded snapshot.
+func (r *Replica) SyncPayload() ([]byte, error) {
+	if r.snapshot == nil {
+		return r.payload, nil
+	}
+
+	var buf bytes.Buffer
+	if err := rdb.Write(&buf, *r.snapshot); err != nil {
+		return nil, err
+	}
+
+	r.snapshot = nil
+
+	payload := fmt.Appendf(r.payload, "$%d\r\n", buf.Len())
+	return append(payload, buf.Bytes()...), nil
+}
+
+// Output returns the replication stream that has to be written to the
+// replica's connection.
+func (r *Replica) Output() <-chan []byte {
+	return r.output
+}
+
+// Done is closed once the replica has been closed.
+func (r *Replica) Done() <-chan struct{} {
+	return r.done
+}
+
+func (r *Replica) Close() {
+	r.once.Do(func() {
+		close(r.done)
+	})
+}
+
+// push never blocks, a replica that can't keep up is disconnected and has
+// to sync again.
+func (r *Replica) push(data []byte) {
+	select {
+	case r.output <- data:
+	default:
+		r.Close()
+	}
+}
+
+func RemoveReplica(r *Replica) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(replicas, r)
+	r.Close()
+}
+
+// Ack records the offsets a replica acknowledged.
+func Ack(r *Replica, ackOffset, aofOffset int64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	r.ackOffset = max(r.ackOffset, ackOffset)
+	r.aofOffset = max(r.aofOffset, aofOffset)
+
+	close(acked)
+	acked = make(chan struct{})
+}
+
+// AckOffset returns the offset the replica acknowledged.
+func (r *Replica) AckOffset() int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return r.ackOffset
+}
+
+// Acked returns the number of replicas that acknowledged target, or with
+// aof that they fsynced it to their AOF. The channel is closed once the
+// next acknowledgement arrives.
+func Acked(target int64, aof bool) (int, <-chan struct{}) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	count := 0
+	for replica := range replicas {
+		offset := replica.ackOffset
+		if aof {
+			offset = replica.aofOffset
+		}
+
+		if offset >= target {
+			count++
+		}
+	}
+
+	return count, acked
+}
+
+// RequestAck asks the replicas to acknowledge their offset right away
+// instead of with their next periodic acknowledgement.
+func RequestAck() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if masterHost == "" && len(replicas) > 0 {
+		feedReplicas(protocol.FormatBulkStringArray([]string{"REPLCONF", "GETACK", "*"}))
+	}
+}
+
+// Replicas returns the connected replicas.
+func Replicas() []*Replica {
+	mu.Lock()
+	defer mu.Unlock()
+
+	result := make([]*Replica, 0, len(replicas))
+	for replica := range replicas {
+		result = append(result, replica)
+	}
+
+	return result
+}
+
+// Feed sends write commands to the replicas. Replicas of a replica get the
+// stream of its master instead, so they see the same offsets.
+func Feed(commands [][]string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if masterHost != "" {
+		return
+	}
+
+	for _, args := range commands {
+		feedReplicas(protocol.FormatBulkStringArray(args))
+	}
+}
+
+// feedReplicas appends data to the replication stream, mu has to be held.
+func feedReplicas(data []byte) {
+	offset += int64(len(data))
+
+	if replBacklog != nil {
+		replBacklog.write(data)
+	}
+
+	for replica := range replicas {
+		replica.push(data)
+	}
+}
+
+func pingReplicas() {
+	for range time.Tick(pingPeriod) {
+		mu.Lock()
+		if masterHost == "" && len(replicas) > 0 {
+			feedReplicas(protocol.FormatBulkStringArray([]string{"PING"}))
+		}
+		mu.Unlock()
+	}
+}
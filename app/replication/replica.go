@@ -0,0 +1,377 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"redis-clone-go/app/protocol"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// reconnectDelay is how long the replica waits before it connects to
+	// the master again after the link broke.
+	reconnectDelay = time.Second
+	// replTimeout is how long the master may stay silent before the link is
+	// considered broken, it pings more often than that.
+	replTimeout = 60 * time.Second
+	// ackPeriod is how often the replica acknowledges its offset.
+	ackPeriod = time.Second
+)
+
+// link states as reported by ROLE
+const (
+	stateConnect    = "connect"
+	stateConnecting = "connecting"
+	stateSync       = "sync"
+	stateConnected  = "connected"
+)
+
+// masterLink replicates from the master in its own goroutine until it is
+// closed, reconnecting whenever the connection breaks.
+type masterLink struct {
+	host  string
+	port  string
+	state string
+	conn  net.Conn
+	done  chan struct{}
+}
+
+// streamMu is held while a command from the master is applied and
+// forwarded to this server's own replicas.
+var streamMu sync.Mutex
+
+// newMasterLink starts replicating from host:port, mu has to be held.
+func newMasterLink(host, port string) *masterLink {
+	l := &masterLink{host: host, port: port, state: stateConnect, done: make(chan struct{})}
+	go l.run()
+	return l
+}
+
+// close stops the link, mu has to be held.
+func (l *masterLink) close() {
+	close(l.done)
+
+	if l.conn != nil {
+		l.conn.Close()
+	}
+}
+
+func (l *masterLink) closed() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *masterLink) run() {
+	for {
+		err := l.sync()
+		if l.closed() {
+			return
+		}
+
+		fmt.Println("Error replicating from master: ", err.Error())
+		l.setState(stateConnect)
+
+		select {
+		case <-l.done:
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (l *masterLink) setState(state string) {
+	mu.Lock()
+	defer mu.Unlock()
+	l.state = state
+}
+
+// sync connects to the master, loads its dataset and then applies the
+// replication stream until the connection breaks.
+func (l *masterLink) sync() error {
+	l.setState(stateConnecting)
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(l.host, l.port), replTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	mu.Lock()
+	if l.closed() {
+		mu.Unlock()
+		return nil
+	}
+
+	l.conn = conn
+	h := hooks
+	mu.Unlock()
+
+	//the stream is recorded, so it can be forwarded to this server's own
+	//replicas byte for byte
+	var recorded bytes.Buffer
+	reader := bufio.NewReader(io.TeeReader(conn, &recorded))
+	conn.SetDeadline(time.Now().Add(replTimeout))
+
+	if _, err := l.request(conn, reader, "PING"); err != nil {
+		return err
+	}
+
+	if _, err := l.request(conn, reader, "REPLCONF", "listening-port", h.Port()); err != nil {
+		return err
+	}
+
+	if _, err := l.request(conn, reader, "REPLCONF", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	//the master decides whether it can continue from where this server's
+	//stream ends, which may also be its own stream before it became a
+	//replica
+	mu.Lock()
+	requestedId, requestedOffset := replId, offset+1
+	mu.Unlock()
+
+	reply, err := l.request(conn, reader, "PSYNC", requestedId, strconv.FormatInt(requestedOffset, 10))
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(reply.Str)
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		if err := l.fullSync(reader, h, fields[1], fields[2]); err != nil {
+			return err
+		}
+	case len(fields) > 0 && len(fields) <= 2 && fields[0] == "CONTINUE":
+		l.continueSync(fields[1:])
+	default:
+		return fmt.Errorf("unexpected reply to PSYNC: %q", reply.Str)
+	}
+
+	recorded.Next(recorded.Len() - reader.Buffered())
+	return l.stream(conn, reader, &recorded, h)
+}
+
+// fullSync loads the dataset the master sent with FULLRESYNC.
+func (l *masterLink) fullSync(reader *bufio.Reader, h Hooks, masterReplId, masterOffset string) error {
+	startOffset, err := strconv.ParseInt(masterOffset, 10, 64)
+	if err != nil {
+		return fmt.Errorf("unexpected offset in FULLRESYNC: %q", masterOffset)
+	}
+
+	l.setState(stateSync)
+
+	data, err := readPayload(reader)
+	if err != nil {
+		return fmt.Errorf("error receiving the master's dataset: %w", err)
+	}
+
+	streamMu.Lock()
+	defer streamMu.Unlock()
+
+	if err := h.Load(data); err != nil {
+		return fmt.Errorf("error loading the master's dataset: %w", err)
+	}
+
+	fmt.Printf("Full resynchronization with master %v:%v\n", l.host, l.port)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	replId = masterReplId
+	offset = startOffset
+	replId2 = strings.Repeat("0", 40)
+	secondOffset = -1
+	replBacklog = newBacklog(backlogSize)
+	l.state = stateConnected
+
+	//the replicas of this server have to sync with the new dataset
+	for replica := range replicas {
+		replica.Close()
+	}
+
+	return nil
+}
+
+// continueSync continues the stream after CONTINUE. If the master's stream
+// has a new id, e.g. because it was promoted, the old one is kept as the
+// secondary id.
+func (l *masterLink) continueSync(newReplId []string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	fmt.Printf("Partial resynchronization with master %v:%v\n", l.host, l.port)
+	l.state = stateConnected
+
+	if replBacklog == nil {
+		replBacklog = newBacklog(backlogSize)
+	}
+
+	if len(newReplId) == 0 || newReplId[0] == replId {
+		return
+	}
+
+	replId2 = replId
+	secondOffset = offset
+	replId = newReplId[0]
+
+	//the replicas of this server have to learn the new id
+	for replica := range replicas {
+		replica.Close()
+	}
+}
+
+// stream applies the commands of the replication stream and forwards them
+// to this server's own replicas. recorded holds the bytes read from the
+// connection that weren't applied yet.
+func (l *masterLink) stream(conn net.Conn, reader *bufio.Reader, recorded *bytes.Buffer, h Hooks) error {
+	var writeMu sync.Mutex
+	sendAck := func() {
+		fsynced := h.FsyncedOffset()
+		ack := protocol.FormatBulkStringArray([]string{"REPLCONF", "ACK", strconv.FormatInt(Offset(), 10), "FACK", strconv.FormatInt(fsynced, 10)})
+
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.Write(ack)
+	}
+
+	h.Applied(Offset())
+	sendAck()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(ackPeriod):
+				sendAck()
+			}
+		}
+	}()
+
+	replay := h.Replayer()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(replTimeout))
+
+		command, err := protocol.ParseCommand(reader)
+		if err != nil {
+			return err
+		}
+
+		raw := recorded.Next(recorded.Len() - reader.Buffered())
+
+		//the acknowledged offset doesn't include the GETACK itself
+		if command.Name == "REPLCONF" && len(command.Args) > 0 && strings.EqualFold(command.Args[0], "GETACK") {
+			sendAck()
+			l.apply(command, nil, raw, h)
+			continue
+		}
+
+		l.apply(command, replay, raw, h)
+	}
+}
+
+// apply executes a command from the master, unless replay is nil, and
+// forwards it to this server's own replicas.
+func (l *masterLink) apply(command *protocol.Command, replay func(*protocol.Command), raw []byte, h Hooks) {
+	streamMu.Lock()
+	defer streamMu.Unlock()
+
+	if replay != nil {
+		replay(command)
+	}
+
+	mu.Lock()
+	feedReplicas(bytes.Clone(raw))
+	applied := offset
+	mu.Unlock()
+
+	h.Applied(applied)
+}
+
+// PauseStream stops applying the stream from the master until resume is
+// called. A replica of this server registered meanwhile continues from the
+// current offset, so the stream has to be paused while its snapshot is
+// taken.
+func PauseStream() (resume func()) {
+	streamMu.Lock()
+	return streamMu.Unlock
+}
+
+// request sends a handshake command and reads the reply, error replies are
+// returned as errors.
+func (l *masterLink) request(conn net.Conn, reader *bufio.Reader, args ...string) (protocol.Reply, error) {
+	if _, err := conn.Write(protocol.FormatBulkStringArray(args)); err != nil {
+		return protocol.Reply{}, err
+	}
+
+	reply, err := protocol.ParseReply(reader)
+	if err != nil {
+		return reply, err
+	}
+
+	if reply.Type == protocol.ErrorReply {
+		return reply, fmt.Errorf("master replied to %v with: %v", args[0], reply.Str)
+	}
+
+	return reply, nil
+}
+
+// readPayload reads the RDB file sent after FULLRESYNC. Unlike a bulk
+// string it isn't followed by CRLF, and the master may send newlines to
+// keep the connection alive while it prepares the file.
+func readPayload(reader *bufio.Reader) ([]byte, error) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimSuffix(line, "\r\n")
+		if line == "\n" || line == "" {
+			continue
+		}
+
+		length, ok := strings.CutPrefix(line, "$")
+		if !ok {
+			return nil, fmt.Errorf("unexpected line %q", line)
+		}
+
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid payload length %q", length)
+		}
+
+		data := make([]byte, n)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+
+		return data, nil
+	}
+}
+
+// ReplicaState describes the link to the master for ROLE.
+func ReplicaState() (host, port, state string, ok bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if link == nil {
+		return "", "", "", false
+	}
+
+	return link.host, link.port, link.state, true
+}
@@ -0,0 +1,37 @@
+package replication
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestReadPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"payload", "$5\r\nREDIS*1\r\n", "REDIS", false},
+		{"keepalive newlines", "\n\n$5\r\nREDIS", "REDIS", false},
+		{"empty", "$0\r\n", "", false},
+		{"not a payload", "+OK\r\n", "", true},
+		{"truncated", "$10\r\nREDIS", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(tt.input))
+			got, err := readPayload(reader)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readPayload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("readPayload() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package replication
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"redis-clone-go/app/protocol"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Hooks connect the replication link to the dataset. They are called from
+// the link's goroutine and have to take care of locking themselves.
+type Hooks struct {
+	// Load replaces the dataset with an RDB file received from the master.
+	Load func(data []byte) error
+	// Replayer returns the function that executes the commands received
+	// from the master. Every connection gets a new one, so a transaction
+	// cut off by a disconnect isn't continued.
+	Replayer func() func(command *protocol.Command)
+	// Port returns the port the server listens on, which is announced to
+	// the master.
+	Port func() string
+	// Applied is called with the new offset once a command from the master
+	// was applied.
+	Applied func(offset int64)
+	// FsyncedOffset returns the offset up to which the AOF is on disk, it
+	// is acknowledged to the master for WAITAOF.
+	FsyncedOffset func() int64
+}
+
+var (
+	mu sync.Mutex
+	// replId and offset identify the replication stream, a replica takes
+	// them over from its master.
+	replId = newReplId()
+	offset int64
+	// replId2 is the id of the previous master's stream, which is valid up
+	// to secondOffset. It lets the replicas of a promoted replica continue
+	// partially.
+	replId2            = strings.Repeat("0", 40)
+	secondOffset int64 = -1
+
+	backlogSize = 1024 * 1024
+	// replBacklog is created once the first replica syncs or this replica
+	// synced with its master.
+	replBacklog *backlog
+
+	masterHost string
+	masterPort string
+	link       *masterLink
+	replicas   = map[*Replica]struct{}{}
+
+	// readOnly makes a replica refuse writes from normal clients, with
+	// serveStaleData off it refuses most commands while the link to its
+	// master is down.
+	readOnly       = true
+	serveStaleData = true
+
+	// started is set once the dataset was loaded, the link to the master
+	// isn't established before.
+	started bool
+	hooks   Hooks
+)
+
+// Start connects to the master if one was configured on startup.
+func Start(h Hooks) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	hooks = h
+	started = true
+
+	if masterHost != "" {
+		link = newMasterLink(masterHost, masterPort)
+	}
+}
+
+// IsReplica reports whether the server replicates from a master.
+func IsReplica() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return masterHost != ""
+}
+
+// WritesAllowed reports whether normal clients may write, which they can't
+// on a read-only replica.
+func WritesAllowed() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return masterHost == "" || !readOnly
+}
+
+// StaleRefused reports whether the server is a replica that lost the link
+// to its master and doesn't serve stale data meanwhile.
+func StaleRefused() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return masterHost != "" && !serveStaleData && (link == nil || link.state != stateConnected)
+}
+
+func ReplicaOf() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if masterHost == "" {
+		return ""
+	}
+
+	return masterHost + " " + masterPort
+}
+
+// SetReplicaOf makes the server a replica of "host port", or a master
+// again for an empty value or "no one".
+func SetReplicaOf(value string) error {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) == 2 && strings.EqualFold(fields[0], "no") && strings.EqualFold(fields[1], "one") {
+		becomeMaster()
+		return nil
+	}
+
+	if len(fields) != 2 {
+		return errors.New("argument must be 'host port' or 'no one'")
+	}
+
+	if port, err := strconv.Atoi(fields[1]); err != nil || port < 1 || port > 65535 {
+		return errors.New("invalid master port")
+	}
+
+	becomeReplica(fields[0], fields[1])
+	return nil
+}
+
+func becomeMaster() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if masterHost == "" {
+		return
+	}
+
+	if link != nil {
+		link.close()
+		link = nil
+	}
+
+	masterHost, masterPort = "", ""
+
+	//the stream continues under a new id, replicas that followed the old
+	//master can still continue from the backlog
+	replId2 = replId
+	secondOffset = offset
+	replId = newReplId()
+	fmt.Printf("Promoted to master, new replication id %v, previous %v valid up to offset %d\n", replId, replId2, secondOffset)
+}
+
+func becomeReplica(host, port string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if host == masterHost && port == masterPort {
+		return
+	}
+
+	if link != nil {
+		link.close()
+		link = nil
+	}
+
+	//the replicas of this server have to sync with the new dataset
+	for replica := range replicas {
+		replica.Close()
+	}
+
+	masterHost, masterPort = host, port
+	if started {
+		link = newMasterLink(host, port)
+	}
+}
+
+// Offset returns the replication offset, the number of bytes of the
+// replication stream produced or processed so far.
+func Offset() int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return offset
+}
+
+func ReadOnly() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return yesNo(readOnly)
+}
+
+func SetReadOnly(value string) error {
+	return setYesNo(&readOnly, value)
+}
+
+func ServeStaleData() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return yesNo(serveStaleData)
+}
+
+func SetServeStaleData(value string) error {
+	return setYesNo(&serveStaleData, value)
+}
+
+func BacklogSize() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return strconv.Itoa(backlogSize)
+}
+
+// SetBacklogSize sets the size of the backlog in bytes, the history in the
+// current backlog is lost.
+func SetBacklogSize(value string) error {
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 16*1024 {
+		return errors.New("argument must be a size in bytes of at least 16384")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	backlogSize = size
+	if replBacklog != nil {
+		replBacklog = newBacklog(size)
+	}
+
+	return nil
+}
+
+func newReplId() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+
+	return "no"
+}
+
+func setYesNo(target *bool, value string) error {
+	if value != "yes" && value != "no" {
+		return errors.New("argument must be 'yes' or 'no'")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	*target = value == "yes"
+	return nil
+}
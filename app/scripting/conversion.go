@@ -0,0 +1,104 @@
+package scripting
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"redis-clone-go/app/protocol"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+func stringsToTable(L *lua.LState, values []string) *lua.LTable {
+	table := L.CreateTable(len(values), 0)
+	for _, value := range values {
+		table.Append(lua.LString(value))
+	}
+
+	return table
+}
+
+// respToLua converts a command reply with the same rules as Redis, e.g.
+// nulls become false and status replies become {ok=...} tables.
+func respToLua(L *lua.LState, reply []byte) (lua.LValue, error) {
+	parsed, err := protocol.ParseReply(bufio.NewReader(bytes.NewReader(reply)))
+	if err != nil {
+		return lua.LNil, fmt.Errorf("error parsing reply: %w", err)
+	}
+
+	return replyToLua(L, parsed), nil
+}
+
+func replyToLua(L *lua.LState, reply protocol.Reply) lua.LValue {
+	if reply.IsNull {
+		return lua.LFalse
+	}
+
+	switch reply.Type {
+	case protocol.SimpleStringReply:
+		table := L.NewTable()
+		table.RawSetString("ok", lua.LString(reply.Str))
+		return table
+	case protocol.ErrorReply:
+		table := L.NewTable()
+		table.RawSetString("err", lua.LString(reply.Str))
+		return table
+	case protocol.IntegerReply:
+		return lua.LNumber(reply.Int)
+	case protocol.ArrayReply:
+		table := L.CreateTable(len(reply.Array), 0)
+		for _, element := range reply.Array {
+			table.Append(replyToLua(L, element))
+		}
+
+		return table
+	default:
+		return lua.LString(reply.Str)
+	}
+}
+
+// luaToResp converts a script's return value to a RESP reply.
+func luaToResp(value lua.LValue) []byte {
+	switch value := value.(type) {
+	case lua.LString:
+		return protocol.FormatBulkString(string(value))
+	case lua.LNumber:
+		//like Redis, numbers are truncated to integers
+		return protocol.FormatInt(int(math.Trunc(float64(value))), false)
+	case lua.LBool:
+		if value {
+			return protocol.FormatInt(1, false)
+		}
+
+		return protocol.FormatNullBulkString()
+	case *lua.LTable:
+		if msg, ok := value.RawGetString("err").(lua.LString); ok {
+			return protocol.FormatError(fmt.Errorf("%v", string(msg)))
+		}
+
+		if msg, ok := value.RawGetString("ok").(lua.LString); ok {
+			return protocol.FormatSimpleString(string(msg))
+		}
+
+		//arrays end at the first nil, like in Redis
+		elements := [][]byte{}
+		for i := 1; ; i++ {
+			element := value.RawGetInt(i)
+			if element == lua.LNil {
+				break
+			}
+
+			elements = append(elements, luaToResp(element))
+		}
+
+		reply := fmt.Appendf(nil, "*%d\r\n", len(elements))
+		for _, element := range elements {
+			reply = append(reply, element...)
+		}
+
+		return reply
+	default:
+		return protocol.FormatNullBulkString()
+	}
+}
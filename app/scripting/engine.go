@@ -0,0 +1,178 @@
+package scripting
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+var ErrNoScript = errors.New("NOSCRIPT No matching script. Please use EVAL.")
+
+var Scripts = NewEngine()
+
+// CallFunc runs a command on behalf of a script and returns its RESP
+// reply, and whether the command writes to the dataset. Write commands
+// have to be rejected if readOnly is set.
+type CallFunc func(args []string, readOnly bool) (reply []byte, isWrite bool, err error)
+
+// callHandler is a CallFunc bound to a single script execution.
+type callHandler func(args []string) (reply []byte, isWrite bool, err error)
+
+type Engine struct {
+	mu      sync.Mutex
+	scripts map[string]*lua.FunctionProto
+	running *runningScript
+}
+
+type runningScript struct {
+	cancel context.CancelFunc
+	killed bool
+	wrote  bool
+}
+
+func NewEngine() *Engine {
+	return &Engine{
+		scripts: make(map[string]*lua.FunctionProto),
+	}
+}
+
+// Load compiles the script and adds it to the cache, it returns the SHA1
+// digest the script can be run with.
+func (e *Engine) Load(source string) (string, error) {
+	digest := sha1.Sum([]byte(source))
+	sha := hex.EncodeToString(digest[:])
+
+	e.mu.Lock()
+	_, ok := e.scripts[sha]
+	e.mu.Unlock()
+
+	if ok {
+		return sha, nil
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(source), "user_script")
+	if err != nil {
+		return "", fmt.Errorf("ERR Error compiling script (new function): %w", err)
+	}
+
+	proto, err := lua.Compile(chunk, "@user_script")
+	if err != nil {
+		return "", fmt.Errorf("ERR Error compiling script (new function): %w", err)
+	}
+
+	e.mu.Lock()
+	e.scripts[sha] = proto
+	e.mu.Unlock()
+
+	return sha, nil
+}
+
+func (e *Engine) Exists(sha string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.scripts[strings.ToLower(sha)]
+	return ok
+}
+
+func (e *Engine) Flush() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.scripts = make(map[string]*lua.FunctionProto)
+}
+
+// Run executes a cached script. The caller is responsible for making the
+// execution atomic, commands issued by the script go through call.
+func (e *Engine) Run(sha string, keys, args []string, call CallFunc) ([]byte, error) {
+	e.mu.Lock()
+	proto, ok := e.scripts[strings.ToLower(sha)]
+	if !ok {
+		e.mu.Unlock()
+		return nil, ErrNoScript
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	running := &runningScript{cancel: cancel}
+	e.running = running
+	e.mu.Unlock()
+
+	defer func() {
+		e.mu.Lock()
+		e.running = nil
+		e.mu.Unlock()
+	}()
+
+	L := newState(func(args []string) ([]byte, bool, error) {
+		reply, isWrite, err := call(args, false)
+		if isWrite {
+			e.mu.Lock()
+			running.wrote = true
+			e.mu.Unlock()
+		}
+
+		return reply, isWrite, err
+	})
+	defer L.Close()
+
+	L.SetContext(ctx)
+	L.SetGlobal("KEYS", stringsToTable(L, keys))
+	L.SetGlobal("ARGV", stringsToTable(L, args))
+
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		e.mu.Lock()
+		killed := running.killed
+		e.mu.Unlock()
+
+		if killed {
+			return nil, errors.New("ERR Script killed by user with SCRIPT KILL...")
+		}
+
+		return nil, scriptError(err)
+	}
+
+	return luaToResp(L.Get(-1)), nil
+}
+
+// Kill stops the running script, as long as it hasn't written anything.
+func (e *Engine) Kill() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.running == nil {
+		return errors.New("NOTBUSY No scripts in execution right now.")
+	}
+
+	if e.running.wrote {
+		return errors.New("UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+	}
+
+	e.running.killed = true
+	e.running.cancel()
+	return nil
+}
+
+// scriptError returns errors raised by redis.call unchanged, other errors
+// are reported like Redis does for runtime errors.
+func scriptError(err error) error {
+	var apiErr *lua.ApiError
+	if errors.As(err, &apiErr) {
+		if table, ok := apiErr.Object.(*lua.LTable); ok {
+			if msg, ok := table.RawGetString("err").(lua.LString); ok {
+				return errors.New(string(msg))
+			}
+		}
+
+		return fmt.Errorf("ERR Error running script: %v", apiErr.Object)
+	}
+
+	return fmt.Errorf("ERR Error running script: %w", err)
+}
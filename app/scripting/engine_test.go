@@ -0,0 +1,57 @@
+package scripting
+
+import (
+	"redis-clone-go/app/protocol"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	call := func(args []string, readOnly bool) ([]byte, bool, error) {
+		switch args[0] {
+		case "GET":
+			return protocol.FormatBulkString("value of " + args[1]), false, nil
+		case "PING":
+			return protocol.FormatSimpleString("PONG"), false, nil
+		default:
+			return protocol.FormatNullBulkString(), false, nil
+		}
+	}
+
+	tests := []struct {
+		name   string
+		script string
+		keys   []string
+		args   []string
+		want   string
+	}{
+		{"string", "return 'hi'", nil, nil, "$2\r\nhi\r\n"},
+		{"number is truncated", "return 3.7", nil, nil, ":3\r\n"},
+		{"true and false", "return {true, false}", nil, nil, "*2\r\n:1\r\n$-1\r\n"},
+		{"array stops at nil", "return {1, nil, 2}", nil, nil, "*1\r\n:1\r\n"},
+		{"keys and argv", "return {KEYS[1], ARGV[1]}", []string{"k"}, []string{"a"}, "*2\r\n$1\r\nk\r\n$1\r\na\r\n"},
+		{"call returns bulk string", "return redis.call('GET', KEYS[1])", []string{"k"}, nil, "$10\r\nvalue of k\r\n"},
+		{"status reply round trip", "return redis.call('PING')", nil, nil, "+PONG\r\n"},
+		{"null becomes false", "return redis.call('MISSING') == false", nil, nil, ":1\r\n"},
+		{"error reply", "return redis.error_reply('ERR nope')", nil, nil, "-ERR nope\r\n"},
+	}
+
+	engine := NewEngine()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sha, err := engine.Load(tt.script)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			got, err := engine.Run(sha, tt.keys, tt.args, call)
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("Run() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,471 @@
+package scripting
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"redis-clone-go/app/glob"
+	"redis-clone-go/app/rdb"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// loadTimeout bounds the time the top level code of a library may run.
+const loadTimeout = 500 * time.Millisecond
+
+var Functions = NewFunctionRegistry()
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+var errReadOnlyFunction = errors.New("ERR Can not execute a script with write flag using *_ro command.")
+
+type FunctionRegistry struct {
+	mu        sync.Mutex
+	libraries map[string]*Library
+	functions map[string]*Function
+	running   *runningScript
+}
+
+type Library struct {
+	Name      string
+	Code      string
+	Functions []*Function
+
+	// mu serializes calls, functions of a library share one Lua state.
+	mu    sync.Mutex
+	state *lua.LState
+	// call is set while one of the library's functions runs.
+	call callHandler
+}
+
+type Function struct {
+	Name        string
+	Description string
+	Flags       []string
+	Library     *Library
+
+	callback *lua.LFunction
+}
+
+func NewFunctionRegistry() *FunctionRegistry {
+	return &FunctionRegistry{
+		libraries: make(map[string]*Library),
+		functions: make(map[string]*Function),
+	}
+}
+
+// Load creates a library from code starting with a "#!lua name=<library>"
+// line and returns the library name.
+func (r *FunctionRegistry) Load(code string, replace bool) (string, error) {
+	lib, err := newLibrary(code)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.add(lib, replace); err != nil {
+		lib.state.Close()
+		return "", err
+	}
+
+	return lib.Name, nil
+}
+
+func (r *FunctionRegistry) add(lib *Library, replace bool) error {
+	existing, exists := r.libraries[lib.Name]
+	if exists && !replace {
+		return fmt.Errorf("ERR Library '%v' already exists", lib.Name)
+	}
+
+	for _, fn := range lib.Functions {
+		if other, ok := r.functions[fn.Name]; ok && other.Library != existing {
+			return fmt.Errorf("ERR Function %v already exists", fn.Name)
+		}
+	}
+
+	if exists {
+		r.remove(existing)
+	}
+
+	r.libraries[lib.Name] = lib
+	for _, fn := range lib.Functions {
+		r.functions[fn.Name] = fn
+	}
+
+	return nil
+}
+
+func (r *FunctionRegistry) remove(lib *Library) {
+	for _, fn := range lib.Functions {
+		delete(r.functions, fn.Name)
+	}
+
+	//the state is left to the garbage collector, a function of the library
+	//may still be running
+	delete(r.libraries, lib.Name)
+}
+
+func (r *FunctionRegistry) Delete(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lib, ok := r.libraries[name]
+	if !ok {
+		return errors.New("ERR Library not found")
+	}
+
+	r.remove(lib)
+	return nil
+}
+
+func (r *FunctionRegistry) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, lib := range r.libraries {
+		r.remove(lib)
+	}
+}
+
+// Libraries returns the libraries whose name matches the glob-style
+// pattern, sorted by name. An empty pattern matches every library.
+func (r *FunctionRegistry) Libraries(pattern string) []*Library {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	libraries := []*Library{}
+	for name, lib := range r.libraries {
+		if pattern == "" || glob.Match(pattern, name) {
+			libraries = append(libraries, lib)
+		}
+	}
+
+	slices.SortFunc(libraries, func(a, b *Library) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return libraries
+}
+
+// Dump serializes all libraries in the format of Redis' FUNCTION DUMP.
+func (r *FunctionRegistry) Dump() []byte {
+	payload := []byte{}
+	for _, lib := range r.Libraries("") {
+		payload = append(payload, rdb.OpcodeFunction)
+		payload = rdb.AppendString(payload, lib.Code)
+	}
+
+	return rdb.AppendPayloadFooter(payload)
+}
+
+// Restore loads the libraries of a FUNCTION DUMP payload. The policy is
+// one of FLUSH, APPEND or REPLACE, like in Redis.
+func (r *FunctionRegistry) Restore(payload []byte, policy string) error {
+	body, err := rdb.VerifyPayload(payload)
+	if err != nil {
+		return err
+	}
+
+	libraries := []*Library{}
+	reader := bufio.NewReader(bytes.NewReader(body))
+
+	for {
+		opcode, err := reader.ReadByte()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if opcode != rdb.OpcodeFunction {
+			return errors.New("ERR given type is not a function")
+		}
+
+		code, err := rdb.ReadString(reader)
+		if err != nil {
+			return fmt.Errorf("ERR failed loading the function payload: %w", err)
+		}
+
+		lib, err := newLibrary(code)
+		if err != nil {
+			return err
+		}
+
+		libraries = append(libraries, lib)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if policy == "FLUSH" {
+		for _, lib := range r.libraries {
+			r.remove(lib)
+		}
+	}
+
+	for _, lib := range libraries {
+		if err := r.add(lib, policy == "REPLACE"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Call runs a function. Functions flagged no-writes can't run write
+// commands, and only those may be called with readOnly set.
+func (r *FunctionRegistry) Call(name string, keys, args []string, readOnly bool, call CallFunc) ([]byte, error) {
+	r.mu.Lock()
+	fn, ok := r.functions[name]
+	if !ok {
+		r.mu.Unlock()
+		return nil, errors.New("ERR Function not found")
+	}
+
+	noWrites := slices.Contains(fn.Flags, "no-writes")
+	if readOnly && !noWrites {
+		r.mu.Unlock()
+		return nil, errReadOnlyFunction
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	running := &runningScript{cancel: cancel}
+	r.running = running
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		r.running = nil
+		r.mu.Unlock()
+	}()
+
+	lib := fn.Library
+	lib.mu.Lock()
+	defer lib.mu.Unlock()
+
+	lib.call = func(args []string) ([]byte, bool, error) {
+		reply, isWrite, err := call(args, noWrites)
+		if isWrite {
+			r.mu.Lock()
+			running.wrote = true
+			r.mu.Unlock()
+		}
+
+		return reply, isWrite, err
+	}
+	defer func() { lib.call = nil }()
+
+	L := lib.state
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	L.Push(fn.callback)
+	L.Push(stringsToTable(L, keys))
+	L.Push(stringsToTable(L, args))
+
+	if err := L.PCall(2, 1, nil); err != nil {
+		r.mu.Lock()
+		killed := running.killed
+		r.mu.Unlock()
+
+		if killed {
+			return nil, errors.New("ERR Script killed by user with FUNCTION KILL...")
+		}
+
+		return nil, scriptError(err)
+	}
+
+	result := L.Get(-1)
+	L.Pop(1)
+	return luaToResp(result), nil
+}
+
+// Kill stops the running function, as long as it hasn't written anything.
+func (r *FunctionRegistry) Kill() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.running == nil {
+		return errors.New("NOTBUSY No scripts in execution right now.")
+	}
+
+	if r.running.wrote {
+		return errors.New("UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+	}
+
+	r.running.killed = true
+	r.running.cancel()
+	return nil
+}
+
+func newLibrary(code string) (*Library, error) {
+	name, body, err := parseLibraryMetadata(code)
+	if err != nil {
+		return nil, err
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(body), name)
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling function: %w", err)
+	}
+
+	proto, err := lua.Compile(chunk, "@user_function")
+	if err != nil {
+		return nil, fmt.Errorf("ERR Error compiling function: %w", err)
+	}
+
+	lib := &Library{Name: name, Code: code}
+	lib.state = newState(func(args []string) ([]byte, bool, error) {
+		if lib.call == nil {
+			return nil, false, errors.New("ERR redis.call can not be used while loading a library")
+		}
+
+		return lib.call(args)
+	})
+
+	loading := true
+	redis := lib.state.GetGlobal("redis").(*lua.LTable)
+	redis.RawSetString("register_function", lib.state.NewFunction(func(L *lua.LState) int {
+		if !loading {
+			L.RaiseError("redis.register_function can only be called on FUNCTION LOAD command")
+		}
+
+		fn, err := parseRegisterFunctionArgs(L)
+		if err != nil {
+			L.RaiseError("%v", err)
+		}
+
+		for _, existing := range lib.Functions {
+			if existing.Name == fn.Name {
+				L.RaiseError("Function already exists in the library")
+			}
+		}
+
+		fn.Library = lib
+		lib.Functions = append(lib.Functions, fn)
+		return 0
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+
+	lib.state.SetContext(ctx)
+	lib.state.Push(lib.state.NewFunctionFromProto(proto))
+	err = lib.state.PCall(0, 0, nil)
+	lib.state.RemoveContext()
+	loading = false
+
+	if err != nil {
+		lib.state.Close()
+		message := strings.TrimPrefix(scriptError(err).Error(), "ERR ")
+		return nil, fmt.Errorf("ERR Error registering functions: %v", message)
+	}
+
+	if len(lib.Functions) == 0 {
+		lib.state.Close()
+		return nil, errors.New("ERR No functions registered")
+	}
+
+	return lib, nil
+}
+
+// parseLibraryMetadata parses the "#!lua name=<library>" line and returns
+// the library name and the code without it.
+func parseLibraryMetadata(code string) (string, string, error) {
+	if !strings.HasPrefix(code, "#!") {
+		return "", "", errors.New("ERR Missing library metadata")
+	}
+
+	shebang, body, _ := strings.Cut(code, "\n")
+	parts := strings.Fields(strings.TrimPrefix(shebang, "#!"))
+	if len(parts) == 0 {
+		return "", "", errors.New("ERR Missing library metadata")
+	}
+
+	if parts[0] != "lua" {
+		return "", "", fmt.Errorf("ERR Engine '%v' not found", parts[0])
+	}
+
+	name := ""
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || key != "name" {
+			return "", "", fmt.Errorf("ERR Invalid metadata value given: %v", part)
+		}
+
+		name = value
+	}
+
+	if name == "" {
+		return "", "", errors.New("ERR Library name was not given")
+	}
+
+	if !validName.MatchString(name) {
+		return "", "", errors.New("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+
+	//keep line numbers in errors intact
+	return name, "\n" + body, nil
+}
+
+// parseRegisterFunctionArgs supports both redis.register_function(name,
+// callback) and the table form with function_name, callback, flags and
+// description.
+func parseRegisterFunctionArgs(L *lua.LState) (*Function, error) {
+	fn := &Function{Flags: []string{}}
+
+	switch L.GetTop() {
+	case 2:
+		fn.Name = L.CheckString(1)
+		fn.callback = L.CheckFunction(2)
+	case 1:
+		table := L.CheckTable(1)
+
+		name, ok := table.RawGetString("function_name").(lua.LString)
+		if !ok {
+			return nil, errors.New("redis.register_function must get a function name argument")
+		}
+
+		callback, ok := table.RawGetString("callback").(*lua.LFunction)
+		if !ok {
+			return nil, errors.New("redis.register_function must get a callback argument")
+		}
+
+		fn.Name = string(name)
+		fn.callback = callback
+
+		if description, ok := table.RawGetString("description").(lua.LString); ok {
+			fn.Description = string(description)
+		}
+
+		if flags, ok := table.RawGetString("flags").(*lua.LTable); ok {
+			for i := 1; i <= flags.Len(); i++ {
+				flag := lua.LVAsString(flags.RawGetInt(i))
+				if !slices.Contains([]string{"no-writes", "allow-oom", "allow-stale", "no-cluster", "allow-cross-slot-keys"}, flag) {
+					return nil, fmt.Errorf("unknown flag given: %v", flag)
+				}
+
+				fn.Flags = append(fn.Flags, flag)
+			}
+		}
+	default:
+		return nil, errors.New("wrong number of arguments to redis.register_function")
+	}
+
+	if !validName.MatchString(fn.Name) {
+		return nil, errors.New("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+
+	return fn, nil
+}
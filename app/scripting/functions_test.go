@@ -0,0 +1,59 @@
+package scripting
+
+import "testing"
+
+func TestParseLibraryMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		wantName string
+		wantErr  bool
+	}{
+		{"name", "#!lua name=mylib\nreturn 1", "mylib", false},
+		{"missing shebang", "return 1", "", true},
+		{"unknown engine", "#!js name=mylib\nreturn 1", "", true},
+		{"missing name", "#!lua\nreturn 1", "", true},
+		{"unknown metadata", "#!lua name=mylib foo=bar\nreturn 1", "", true},
+		{"invalid name", "#!lua name=my-lib\nreturn 1", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, _, err := parseLibraryMetadata(tt.code)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLibraryMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if name != tt.wantName {
+				t.Errorf("parseLibraryMetadata() = %q, want %q", name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	code := "#!lua name=mylib\nredis.register_function('f', function() return 1 end)"
+
+	source := NewFunctionRegistry()
+	if _, err := source.Load(code, false); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	target := NewFunctionRegistry()
+	if err := target.Restore(source.Dump(), "APPEND"); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	if err := target.Restore(source.Dump(), "APPEND"); err == nil {
+		t.Errorf("Restore() with APPEND of an existing library succeeded")
+	}
+
+	if err := target.Restore(source.Dump(), "REPLACE"); err != nil {
+		t.Errorf("Restore() with REPLACE error = %v", err)
+	}
+
+	libraries := target.Libraries("")
+	if len(libraries) != 1 || libraries[0].Code != code {
+		t.Errorf("Libraries() = %v, want the restored library", libraries)
+	}
+}
@@ -0,0 +1,122 @@
+package scripting
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// newState creates a Lua state with the libraries Redis exposes to
+// scripts and the redis table.
+func newState(call callHandler) *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	//scripts must not access the file system
+	for _, name := range []string{"dofile", "loadfile"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	redis := L.NewTable()
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call":         redisCall(call, true),
+		"pcall":        redisCall(call, false),
+		"error_reply":  errorReply,
+		"status_reply": statusReply,
+		"sha1hex":      sha1Hex,
+		"log":          func(L *lua.LState) int { return 0 },
+	})
+
+	for name, level := range map[string]int{"LOG_DEBUG": 0, "LOG_VERBOSE": 1, "LOG_NOTICE": 2, "LOG_WARNING": 3} {
+		redis.RawSetString(name, lua.LNumber(level))
+	}
+
+	L.SetGlobal("redis", redis)
+	return L
+}
+
+// redisCall implements redis.call and redis.pcall, which only differ in
+// raising errors or returning them as error tables.
+func redisCall(call callHandler, raise bool) lua.LGFunction {
+	return func(L *lua.LState) int {
+		args := make([]string, L.GetTop())
+		for i := range args {
+			switch arg := L.Get(i + 1).(type) {
+			case lua.LString, lua.LNumber:
+				args[i] = lua.LVAsString(arg)
+			default:
+				return raiseOrReturn(L, "ERR Lua redis lib command arguments must be strings or integers", raise)
+			}
+		}
+
+		if len(args) == 0 {
+			return raiseOrReturn(L, "ERR Please specify at least one argument for this redis lib call", raise)
+		}
+
+		reply, _, err := call(args)
+		if err != nil {
+			return raiseOrReturn(L, err.Error(), raise)
+		}
+
+		value, err := respToLua(L, reply)
+		if err != nil {
+			return raiseOrReturn(L, fmt.Sprintf("ERR %v", err), raise)
+		}
+
+		if table, ok := value.(*lua.LTable); ok && raise && table.RawGetString("err") != lua.LNil {
+			L.Error(table, 1)
+			return 0
+		}
+
+		L.Push(value)
+		return 1
+	}
+}
+
+func raiseOrReturn(L *lua.LState, msg string, raise bool) int {
+	table := L.NewTable()
+	table.RawSetString("err", lua.LString(msg))
+
+	if raise {
+		L.Error(table, 1)
+		return 0
+	}
+
+	L.Push(table)
+	return 1
+}
+
+func errorReply(L *lua.LState) int {
+	table := L.NewTable()
+	table.RawSetString("err", lua.LString(L.CheckString(1)))
+	L.Push(table)
+	return 1
+}
+
+func statusReply(L *lua.LState) int {
+	table := L.NewTable()
+	table.RawSetString("ok", lua.LString(L.CheckString(1)))
+	L.Push(table)
+	return 1
+}
+
+func sha1Hex(L *lua.LState) int {
+	digest := sha1.Sum([]byte(L.CheckString(1)))
+	L.Push(lua.LString(hex.EncodeToString(digest[:])))
+	return 1
+}
@@ -0,0 +1,303 @@
+package sentinel
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"net"
+	"redis-clone-go/app/protocol"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// failover states as reported by SENTINEL MASTER
+const (
+	stateWaitStart          = "wait_start"
+	stateSelectReplica      = "select_slave"
+	stateSendReplicaOfNoOne = "send_slaveof_noone"
+	stateWaitPromotion      = "wait_promotion"
+	stateReconfReplicas     = "reconf_slaves"
+)
+
+// maxDesync is the longest a sentinel waits before asking for votes, so
+// sentinels that notice the master is down at the same time don't all vote
+// for themselves.
+const maxDesync = time.Second
+
+type failover struct {
+	state string
+	epoch int64
+	// forced failovers, from SENTINEL FAILOVER, don't need votes
+	forced bool
+	// electionStart is when votes are requested
+	electionStart time.Time
+	stateChanged  time.Time
+	promoted      *instance
+}
+
+// startFailover begins a failover in a new epoch, mu has to be held.
+func (m *master) startFailover(now time.Time, forced bool) {
+	currentEpoch++
+	m.event("+new-epoch", strconv.FormatInt(currentEpoch, 10))
+	m.event("+try-failover", m.describe(m.current()))
+
+	f := &failover{
+		state:         stateWaitStart,
+		epoch:         currentEpoch,
+		forced:        forced,
+		electionStart: now.Add(rand.N(maxDesync)),
+		stateChanged:  now,
+	}
+
+	if forced {
+		f.state = stateSelectReplica
+	}
+
+	m.failover = f
+	m.failoverStart = now
+}
+
+func (m *master) abortFailover(reason string) {
+	m.event(reason, m.describe(m.current()))
+	m.failover = nil
+}
+
+func (m *master) setFailoverState(state string, now time.Time) {
+	m.failover.state = state
+	m.failover.stateChanged = now
+	m.event("+failover-state-"+state, m.describe(m.current()))
+}
+
+// vote gives this sentinel's vote in epoch to runId, unless it already
+// voted in that epoch. It returns who it voted for. mu has to be held.
+func (m *master) vote(runId string, epoch int64, now time.Time) (string, int64) {
+	if epoch > currentEpoch {
+		currentEpoch = epoch
+		m.event("+new-epoch", strconv.FormatInt(currentEpoch, 10))
+	}
+
+	if m.leaderEpoch < epoch && currentEpoch <= epoch {
+		m.leader = runId
+		m.leaderEpoch = currentEpoch
+		m.event("+vote-for-leader", runId, strconv.FormatInt(m.leaderEpoch, 10))
+
+		//a sentinel that voted for another one doesn't try to fail over
+		//the master itself for a while
+		if runId != myId {
+			m.failoverStart = now
+		}
+	}
+
+	return m.leader, m.leaderEpoch
+}
+
+// electedLeader counts the votes of epoch and returns the winner, if any
+// sentinel got both the majority of all sentinels and the quorum. This
+// sentinel votes for itself if it hasn't voted yet. mu has to be held.
+func (m *master) electedLeader(epoch int64, now time.Time) string {
+	votes := map[string]int{}
+	for _, p := range m.sentinels {
+		if p.leader != "" && p.leaderEpoch == epoch {
+			votes[p.leader]++
+		}
+	}
+
+	leader, _ := m.vote(myId, epoch, now)
+	if m.leaderEpoch == epoch {
+		votes[leader]++
+	}
+
+	return winner(votes, len(m.sentinels)+1, m.quorum)
+}
+
+// winner returns the candidate with the most votes, if it has the votes
+// of a majority of voters and at least quorum votes.
+func winner(votes map[string]int, voters, quorum int) string {
+	best, most := "", 0
+	for candidate, n := range votes {
+		if n > most || n == most && candidate < best {
+			best, most = candidate, n
+		}
+	}
+
+	if most < voters/2+1 || most < quorum {
+		return ""
+	}
+
+	return best
+}
+
+// selectReplica picks the replica to promote: a healthy one that recently
+// reported its role, with the largest replication offset. mu has to be
+// held.
+func (m *master) selectReplica(now time.Time) *instance {
+	candidates := []*instance{}
+	for _, inst := range m.instances {
+		if inst.addr == m.addr || inst.sdown || inst.role != "slave" {
+			continue
+		}
+
+		if now.Sub(inst.lastOk) > 5*pingPeriod || now.Sub(inst.roleReportedAt) > 5*rolePeriod {
+			continue
+		}
+
+		candidates = append(candidates, inst)
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	return slices.MinFunc(candidates, compareCandidates)
+}
+
+func compareCandidates(a, b *instance) int {
+	if c := cmp.Compare(b.replOffset, a.replOffset); c != 0 {
+		return c
+	}
+
+	return cmp.Compare(a.addr, b.addr)
+}
+
+// stepFailover advances the failover in progress, following
+// sentinelFailoverStateMachine in Redis.
+func (m *master) stepFailover(now time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	f := m.failover
+	if f == nil {
+		return
+	}
+
+	switch f.state {
+	case stateWaitStart:
+		if now.Before(f.electionStart) {
+			return
+		}
+
+		if !m.current().sdown {
+			m.abortFailover("-failover-abort-master-not-down")
+			return
+		}
+
+		leader := m.electedLeader(f.epoch, now)
+		if leader != myId {
+			if now.Sub(f.electionStart) > min(10*time.Second, m.failoverTimeout) {
+				m.abortFailover("-failover-abort-not-elected")
+			}
+
+			return
+		}
+
+		m.event("+elected-leader", m.describe(m.current()))
+		m.setFailoverState(stateSelectReplica, now)
+	case stateSelectReplica:
+		replica := m.selectReplica(now)
+		if replica == nil {
+			m.abortFailover("-failover-abort-no-good-slave")
+			return
+		}
+
+		f.promoted = replica
+		m.event("+selected-slave", m.describe(replica))
+		m.setFailoverState(stateSendReplicaOfNoOne, now)
+	case stateSendReplicaOfNoOne:
+		if now.Sub(f.stateChanged) > m.failoverTimeout {
+			m.abortFailover("-failover-abort-slave-timeout")
+			return
+		}
+
+		if f.promoted.sdown {
+			return
+		}
+
+		replica := f.promoted
+		mu.Unlock()
+		reply, err := replica.link.do("REPLICAOF", "NO", "ONE")
+		mu.Lock()
+
+		if m.failover != f {
+			return
+		}
+
+		if err == nil && reply.Type != protocol.ErrorReply {
+			m.setFailoverState(stateWaitPromotion, now)
+		}
+	case stateWaitPromotion:
+		//the promotion is noticed by processRole
+		if now.Sub(f.stateChanged) > m.failoverTimeout {
+			m.abortFailover("-failover-abort-slave-timeout")
+		}
+	case stateReconfReplicas:
+		m.reconfReplicas(f, now)
+	}
+}
+
+// promoted is called once the selected replica reports itself as master.
+// The new configuration gets the failover's epoch, so it wins over older
+// ones when sentinels exchange hellos. mu has to be held.
+func (m *master) promoted(now time.Time) {
+	f := m.failover
+	m.configEpoch = f.epoch
+	m.event("+promoted-slave", m.describe(f.promoted))
+	m.setFailoverState(stateReconfReplicas, now)
+}
+
+// reconfReplicas tells the other replicas to replicate from the promoted
+// one and switches to it. Replicas that are down, including the old
+// master, are reconfigured once they are back. mu has to be held and is
+// released while the replicas are contacted.
+func (m *master) reconfReplicas(f *failover, now time.Time) {
+	host, port := f.promoted.hostPort()
+
+	replicas := []*instance{}
+	for _, inst := range m.instances {
+		if inst != f.promoted && inst.addr != m.addr && !inst.sdown {
+			replicas = append(replicas, inst)
+		}
+	}
+
+	mu.Unlock()
+	sent := []*instance{}
+	for _, inst := range replicas {
+		if reply, err := inst.link.do("REPLICAOF", host, port); err == nil && reply.Type != protocol.ErrorReply {
+			sent = append(sent, inst)
+		}
+	}
+	mu.Lock()
+
+	if m.failover != f {
+		return
+	}
+
+	for _, inst := range sent {
+		m.event("+slave-reconf-sent", m.describe(inst))
+	}
+
+	m.event("+failover-end", m.describe(m.current()))
+	m.switchMaster(f.promoted.addr, now)
+}
+
+// switchMaster makes addr the master, the old master becomes one of its
+// replicas. mu has to be held.
+func (m *master) switchMaster(addr string, now time.Time) {
+	oldHost, oldPort, _ := net.SplitHostPort(m.addr)
+	newHost, newPort, _ := net.SplitHostPort(addr)
+
+	m.addInstance(addr, now)
+	m.addr = addr
+	m.odown = false
+	m.failover = nil
+	m.leader = ""
+
+	inst := m.current()
+	inst.sdown = false
+	inst.lastOk = now
+
+	for _, p := range m.sentinels {
+		p.masterDown = false
+	}
+
+	m.event("+switch-master", m.name, oldHost, oldPort, newHost, newPort)
+}
@@ -0,0 +1,248 @@
+package sentinel
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"redis-clone-go/app/protocol"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// helloChannel is where sentinels announce themselves and their view of
+// the master, on the master and on each of its replicas.
+const helloChannel = "__sentinel__:hello"
+
+// link is a connection for requests to a server or another sentinel. It
+// connects on demand and is only used by the goroutine monitoring its
+// master, so it needs no locking.
+type link struct {
+	addr   string
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// do sends a command and reads its reply. Error replies aren't errors, a
+// broken connection is closed and reopened by the next request.
+func (l *link) do(args ...string) (protocol.Reply, error) {
+	if l.conn == nil {
+		conn, err := net.DialTimeout("tcp", l.addr, requestTimeout)
+		if err != nil {
+			return protocol.Reply{}, err
+		}
+
+		l.conn = conn
+		l.reader = bufio.NewReader(conn)
+	}
+
+	l.conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if _, err := l.conn.Write(protocol.FormatBulkStringArray(args)); err != nil {
+		l.close()
+		return protocol.Reply{}, err
+	}
+
+	reply, err := protocol.ParseReply(l.reader)
+	if err != nil {
+		l.close()
+		return reply, err
+	}
+
+	return reply, nil
+}
+
+// localHost is the address this sentinel connects from, other sentinels
+// reach it there.
+func (l *link) localHost() string {
+	if l.conn == nil {
+		return ""
+	}
+
+	host, _, _ := net.SplitHostPort(l.conn.LocalAddr().String())
+	return host
+}
+
+func (l *link) close() {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+}
+
+// instance is a monitored server, either the master or one of its
+// replicas. After a failover the same instance changes its role.
+type instance struct {
+	addr string
+	link link
+
+	lastPing time.Time
+	// lastOk is when the instance last replied to a PING, it is
+	// subjectively down once that is longer ago than down-after-milliseconds
+	lastOk   time.Time
+	sdown    bool
+	lastRole time.Time
+	// lastHello is when this sentinel last announced itself on the instance
+	lastHello time.Time
+
+	// the last reply to ROLE
+	role           string
+	roleChanged    time.Time
+	masterAddr     string
+	masterLinkUp   bool
+	replOffset     int64
+	roleReportedAt time.Time
+
+	// helloConn is the subscription to the hello channel, closed when the
+	// master isn't monitored anymore
+	helloConn net.Conn
+}
+
+func newInstance(addr string, now time.Time) *instance {
+	return &instance{addr: addr, link: link{addr: addr}, lastOk: now, roleChanged: now}
+}
+
+func (inst *instance) hostPort() (string, string) {
+	host, port, _ := net.SplitHostPort(inst.addr)
+	return host, port
+}
+
+// peer is another sentinel monitoring the same master, discovered through
+// its hello messages.
+type peer struct {
+	runId     string
+	addr      string
+	link      link
+	lastHello time.Time
+
+	// the last reply to SENTINEL is-master-down-by-addr
+	lastAsk     time.Time
+	masterDown  bool
+	downReplyAt time.Time
+	leader      string
+	leaderEpoch int64
+}
+
+func (p *peer) info(now time.Time) []string {
+	host, port, _ := net.SplitHostPort(p.addr)
+	leader := p.leader
+	if leader == "" {
+		leader = "*"
+	}
+
+	return []string{
+		"name", p.runId,
+		"ip", host,
+		"port", port,
+		"runid", p.runId,
+		"flags", "sentinel",
+		"last-hello-message", sinceMs(p.lastHello, now),
+		"voted-leader", leader,
+		"voted-leader-epoch", strconv.FormatInt(p.leaderEpoch, 10),
+	}
+}
+
+// hello is what a sentinel announces on the hello channel.
+type hello struct {
+	host              string
+	port              string
+	runId             string
+	currentEpoch      int64
+	masterName        string
+	masterHost        string
+	masterPort        string
+	masterConfigEpoch int64
+}
+
+func (h hello) String() string {
+	return fmt.Sprintf("%v,%v,%v,%d,%v,%v,%v,%d", h.host, h.port, h.runId, h.currentEpoch,
+		h.masterName, h.masterHost, h.masterPort, h.masterConfigEpoch)
+}
+
+func parseHello(message string) (hello, error) {
+	fields := strings.Split(message, ",")
+	if len(fields) != 8 {
+		return hello{}, fmt.Errorf("malformed hello message %q", message)
+	}
+
+	h := hello{
+		host:       fields[0],
+		port:       fields[1],
+		runId:      fields[2],
+		masterName: fields[4],
+		masterHost: fields[5],
+		masterPort: fields[6],
+	}
+
+	var err1, err2 error
+	h.currentEpoch, err1 = strconv.ParseInt(fields[3], 10, 64)
+	h.masterConfigEpoch, err2 = strconv.ParseInt(fields[7], 10, 64)
+	if err1 != nil || err2 != nil {
+		return hello{}, fmt.Errorf("malformed hello message %q", message)
+	}
+
+	return h, nil
+}
+
+// watchHello subscribes to the hello channel of inst until the master
+// isn't monitored anymore, reconnecting whenever the connection breaks.
+func (m *master) watchHello(inst *instance) {
+	for {
+		m.subscribeHello(inst)
+
+		select {
+		case <-m.done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (m *master) subscribeHello(inst *instance) error {
+	conn, err := net.DialTimeout("tcp", inst.addr, requestTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	mu.Lock()
+	if m.stopped() {
+		mu.Unlock()
+		return nil
+	}
+
+	inst.helloConn = conn
+	mu.Unlock()
+
+	if _, err := conn.Write(protocol.FormatBulkStringArray([]string{"SUBSCRIBE", helloChannel})); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		//hellos are sent every helloPeriod, a silent instance is probably
+		//gone
+		conn.SetReadDeadline(time.Now().Add(5 * helloPeriod))
+
+		reply, err := protocol.ParseReply(reader)
+		if err != nil {
+			return err
+		}
+
+		if len(reply.Array) == 3 && reply.Array[0].Str == "message" {
+			if h, err := parseHello(reply.Array[2].Str); err == nil {
+				m.processHello(h)
+			}
+		}
+	}
+}
+
+// sinceMs formats the milliseconds since t, as reported by SENTINEL
+// MASTERS and friends.
+func sinceMs(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return "-1"
+	}
+
+	return strconv.FormatInt(now.Sub(t).Milliseconds(), 10)
+}
@@ -0,0 +1,560 @@
+package sentinel
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"redis-clone-go/app/protocol"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDownAfter       = 30 * time.Second
+	defaultFailoverTimeout = 3 * time.Minute
+
+	// tickPeriod is how often the state of a master is checked, the other
+	// periods are multiples of it.
+	tickPeriod = 100 * time.Millisecond
+	// requestTimeout bounds a single request to an instance or a sentinel.
+	requestTimeout = 500 * time.Millisecond
+	pingPeriod     = time.Second
+	// rolePeriod is how often instances are asked for their role, every
+	// second while the master is down or failed over.
+	rolePeriod     = 10 * time.Second
+	fastRolePeriod = time.Second
+	helloPeriod    = 2 * time.Second
+	// askPeriod is how often the other sentinels are asked about a master
+	// that is subjectively down. Their answers are valid for five periods.
+	askPeriod = time.Second
+)
+
+// master is a monitored master together with its replicas and the other
+// sentinels monitoring it.
+type master struct {
+	name            string
+	quorum          int
+	downAfter       time.Duration
+	failoverTimeout time.Duration
+	// configEpoch is the epoch of the failover that made addr the master
+	configEpoch int64
+
+	// addr is the current master, all other instances are its replicas
+	addr      string
+	instances map[string]*instance
+	sentinels map[string]*peer
+
+	odown bool
+	// leader is who this sentinel voted for in leaderEpoch
+	leader      string
+	leaderEpoch int64
+
+	failover *failover
+	// failoverStart is when this sentinel last tried, or voted for another
+	// sentinel, to fail the master over
+	failoverStart time.Time
+
+	// retired are sentinels replaced by a restarted one, their links are
+	// closed by the goroutine using them
+	retired []*peer
+
+	done chan struct{}
+}
+
+func newMaster(name, host, port string, quorum int) *master {
+	addr := net.JoinHostPort(host, port)
+	return &master{
+		name:            name,
+		quorum:          quorum,
+		downAfter:       defaultDownAfter,
+		failoverTimeout: defaultFailoverTimeout,
+		addr:            addr,
+		instances:       map[string]*instance{addr: newInstance(addr, time.Now())},
+		sentinels:       map[string]*peer{},
+		done:            make(chan struct{}),
+	}
+}
+
+// start begins monitoring, mu has to be held.
+func (m *master) start() {
+	for _, inst := range m.instances {
+		go m.watchHello(inst)
+	}
+
+	go m.run()
+}
+
+// stop ends monitoring, mu has to be held.
+func (m *master) stop() {
+	close(m.done)
+
+	for _, inst := range m.instances {
+		if inst.helloConn != nil {
+			inst.helloConn.Close()
+		}
+	}
+}
+
+func (m *master) stopped() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// current returns the master instance, mu has to be held.
+func (m *master) current() *instance {
+	return m.instances[m.addr]
+}
+
+// addInstance starts monitoring a replica reported by the master, mu has to
+// be held.
+func (m *master) addInstance(addr string, now time.Time) *instance {
+	if inst, ok := m.instances[addr]; ok {
+		return inst
+	}
+
+	inst := newInstance(addr, now)
+	m.instances[addr] = inst
+	m.event("+slave", m.describe(inst))
+
+	if started && !m.stopped() {
+		go m.watchHello(inst)
+	}
+
+	return inst
+}
+
+func (m *master) setOption(option, value string) error {
+	switch strings.ToLower(option) {
+	case "down-after-milliseconds", "failover-timeout":
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || ms <= 0 {
+			return fmt.Errorf("Invalid argument '%v' for SENTINEL SET '%v'", value, option)
+		}
+
+		if strings.EqualFold(option, "down-after-milliseconds") {
+			m.downAfter = time.Duration(ms) * time.Millisecond
+		} else {
+			m.failoverTimeout = time.Duration(ms) * time.Millisecond
+		}
+	case "quorum":
+		quorum, err := strconv.Atoi(value)
+		if err != nil || quorum <= 0 {
+			return errors.New("Quorum must be 1 or greater.")
+		}
+
+		m.quorum = quorum
+	default:
+		return fmt.Errorf("Invalid argument '%v' for SENTINEL SET", option)
+	}
+
+	return nil
+}
+
+// describe names an instance in events, like Redis does.
+func (m *master) describe(inst *instance) string {
+	masterHost, masterPort, _ := net.SplitHostPort(m.addr)
+	if inst.addr == m.addr {
+		return fmt.Sprintf("master %v %v %v", m.name, masterHost, masterPort)
+	}
+
+	host, port := inst.hostPort()
+	return fmt.Sprintf("slave %v %v %v @ %v %v %v", inst.addr, host, port, m.name, masterHost, masterPort)
+}
+
+func (m *master) info(now time.Time) []string {
+	inst := m.current()
+	host, port := inst.hostPort()
+
+	flags := []string{"master"}
+	if inst.sdown {
+		flags = append(flags, "s_down")
+	}
+	if m.odown {
+		flags = append(flags, "o_down")
+	}
+	if m.failover != nil {
+		flags = append(flags, "failover_in_progress")
+	}
+
+	state := "none"
+	if m.failover != nil {
+		state = m.failover.state
+	}
+
+	return []string{
+		"name", m.name,
+		"ip", host,
+		"port", port,
+		"flags", strings.Join(flags, ","),
+		"last-ok-ping-reply", sinceMs(inst.lastOk, now),
+		"role-reported", inst.role,
+		"num-slaves", strconv.Itoa(len(m.instances) - 1),
+		"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+		"quorum", strconv.Itoa(m.quorum),
+		"down-after-milliseconds", strconv.FormatInt(m.downAfter.Milliseconds(), 10),
+		"failover-timeout", strconv.FormatInt(m.failoverTimeout.Milliseconds(), 10),
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+		"failover-state", state,
+	}
+}
+
+func (m *master) replicaInfo(inst *instance, now time.Time) []string {
+	host, port := inst.hostPort()
+
+	flags := "slave"
+	if inst.sdown {
+		flags += ",s_down"
+	}
+
+	masterHost, masterPort, _ := net.SplitHostPort(inst.masterAddr)
+	linkStatus := "err"
+	if inst.masterLinkUp {
+		linkStatus = "ok"
+	}
+
+	return []string{
+		"name", inst.addr,
+		"ip", host,
+		"port", port,
+		"flags", flags,
+		"last-ok-ping-reply", sinceMs(inst.lastOk, now),
+		"role-reported", inst.role,
+		"master-host", masterHost,
+		"master-port", masterPort,
+		"master-link-status", linkStatus,
+		"slave-repl-offset", strconv.FormatInt(inst.replOffset, 10),
+	}
+}
+
+// run checks the master every tickPeriod until it isn't monitored anymore.
+func (m *master) run() {
+	ticker := time.NewTicker(tickPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			m.closeLinks()
+			return
+		case <-ticker.C:
+			m.tick(time.Now())
+		}
+	}
+}
+
+func (m *master) closeLinks() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, inst := range m.instances {
+		inst.link.close()
+	}
+
+	for _, p := range m.sentinels {
+		p.link.close()
+	}
+}
+
+// tick follows sentinelHandleRedisInstance in Redis: it polls the
+// instances, decides whether the master is down and drives a failover.
+func (m *master) tick(now time.Time) {
+	mu.Lock()
+	instances := slices.Collect(maps.Values(m.instances))
+	mu.Unlock()
+
+	for _, inst := range instances {
+		m.poll(inst, now)
+	}
+
+	mu.Lock()
+	for _, inst := range m.instances {
+		m.checkSubjectivelyDown(inst, now)
+	}
+
+	m.checkObjectivelyDown(now)
+	if m.odown && m.failover == nil && now.Sub(m.failoverStart) >= 2*m.failoverTimeout {
+		m.startFailover(now, false)
+	}
+	mu.Unlock()
+
+	m.stepFailover(now)
+	m.askOtherSentinels(now)
+}
+
+// poll pings inst, asks for its role and announces this sentinel on it
+// when each is due. Nothing else is sent to an instance that doesn't reply
+// to PING.
+func (m *master) poll(inst *instance, now time.Time) {
+	mu.Lock()
+	pingDue := now.Sub(inst.lastPing) >= pingPeriod
+	period := rolePeriod
+	if m.current().sdown || m.failover != nil {
+		period = fastRolePeriod
+	}
+	roleDue := now.Sub(inst.lastRole) >= period
+	helloDue := now.Sub(inst.lastHello) >= helloPeriod
+	mu.Unlock()
+
+	if pingDue {
+		reply, err := inst.link.do("PING")
+
+		mu.Lock()
+		inst.lastPing = now
+		//a server that is loading or lost its master is still alive
+		valid := err == nil && (reply.Type != protocol.ErrorReply ||
+			strings.HasPrefix(reply.Str, "LOADING") || strings.HasPrefix(reply.Str, "MASTERDOWN"))
+		if valid {
+			inst.lastOk = now
+		}
+		mu.Unlock()
+
+		if !valid {
+			return
+		}
+	}
+
+	if roleDue {
+		reply, err := inst.link.do("ROLE")
+
+		mu.Lock()
+		inst.lastRole = now
+		var fix string
+		if err == nil {
+			fix = m.processRole(inst, reply, now)
+		}
+		mu.Unlock()
+
+		if fix != "" {
+			host, port, _ := net.SplitHostPort(fix)
+			inst.link.do("REPLICAOF", host, port)
+		}
+	}
+
+	if helloDue {
+		mu.Lock()
+		inst.lastHello = now
+		host, port, _ := net.SplitHostPort(m.addr)
+		h := hello{
+			port:              announcePort,
+			runId:             myId,
+			currentEpoch:      currentEpoch,
+			masterName:        m.name,
+			masterHost:        host,
+			masterPort:        port,
+			masterConfigEpoch: m.configEpoch,
+		}
+		mu.Unlock()
+
+		h.host = inst.link.localHost()
+		if h.host != "" {
+			inst.link.do("PUBLISH", helloChannel, h.String())
+		}
+	}
+}
+
+// processRole records the reply to ROLE. A replica that doesn't replicate
+// from the current master is told to, the address it has to replicate
+// from is returned. mu has to be held.
+func (m *master) processRole(inst *instance, reply protocol.Reply, now time.Time) (fix string) {
+	if len(reply.Array) == 0 {
+		return ""
+	}
+
+	role := reply.Array[0].Str
+	if role != inst.role {
+		inst.role = role
+		inst.roleChanged = now
+	}
+	inst.roleReportedAt = now
+
+	switch {
+	case role == "master" && len(reply.Array) == 3:
+		inst.replOffset = reply.Array[1].Int
+		inst.masterAddr = ""
+		inst.masterLinkUp = false
+
+		if inst.addr == m.addr {
+			for _, replica := range reply.Array[2].Array {
+				if len(replica.Array) == 3 {
+					m.addInstance(net.JoinHostPort(replica.Array[0].Str, replica.Array[1].Str), now)
+				}
+			}
+		}
+	case role == "slave" && len(reply.Array) == 5:
+		inst.masterAddr = net.JoinHostPort(reply.Array[1].Str, strconv.FormatInt(reply.Array[2].Int, 10))
+		inst.masterLinkUp = reply.Array[3].Str == "connected"
+		inst.replOffset = reply.Array[4].Int
+	default:
+		return ""
+	}
+
+	if f := m.failover; f != nil {
+		if f.state == stateWaitPromotion && inst == f.promoted && role == "master" {
+			m.promoted(now)
+		}
+
+		return ""
+	}
+
+	//an instance that should be a replica of the current master is
+	//reconfigured, but only while the master looks sane, otherwise this
+	//sentinel's view may be the outdated one
+	masterInst := m.current()
+	if inst == masterInst || masterInst.sdown || masterInst.role != "master" {
+		return ""
+	}
+
+	switch {
+	case role == "master" && now.Sub(inst.roleChanged) > 4*helloPeriod:
+		m.event("+convert-to-slave", m.describe(inst))
+		return m.addr
+	case role == "slave" && inst.masterAddr != m.addr:
+		m.event("+fix-slave-config", m.describe(inst))
+		return m.addr
+	}
+
+	return ""
+}
+
+// checkSubjectivelyDown marks an instance down when it didn't reply to
+// PING for down-after-milliseconds, mu has to be held.
+func (m *master) checkSubjectivelyDown(inst *instance, now time.Time) {
+	down := now.Sub(inst.lastOk) > m.downAfter
+
+	if down && !inst.sdown {
+		inst.sdown = true
+		m.event("+sdown", m.describe(inst))
+	} else if !down && inst.sdown {
+		inst.sdown = false
+		m.event("-sdown", m.describe(inst))
+	}
+}
+
+// checkObjectivelyDown marks the master down once quorum sentinels,
+// including this one, agree that it is subjectively down. mu has to be
+// held.
+func (m *master) checkObjectivelyDown(now time.Time) {
+	votes := 0
+	if m.current().sdown {
+		votes = 1
+		for _, p := range m.sentinels {
+			if p.masterDown && now.Sub(p.downReplyAt) < 5*askPeriod {
+				votes++
+			}
+		}
+	}
+
+	down := votes > 0 && votes >= m.quorum
+	if down && !m.odown {
+		m.odown = true
+		m.event("+odown", m.describe(m.current()), fmt.Sprintf("#quorum %d/%d", votes, m.quorum))
+	} else if !down && m.odown {
+		m.odown = false
+		m.event("-odown", m.describe(m.current()))
+	}
+}
+
+// askOtherSentinels asks the other sentinels whether they consider the
+// master down too. While this sentinel tries to become the leader of a
+// failover it also asks for their votes.
+func (m *master) askOtherSentinels(now time.Time) {
+	mu.Lock()
+	if !m.current().sdown {
+		mu.Unlock()
+		return
+	}
+
+	host, port, _ := net.SplitHostPort(m.addr)
+	epoch := currentEpoch
+	runId := "*"
+	if f := m.failover; f != nil && f.state == stateWaitStart && !f.forced && !now.Before(f.electionStart) {
+		epoch = f.epoch
+		runId = myId
+	}
+
+	for _, p := range m.retired {
+		p.link.close()
+	}
+	m.retired = nil
+
+	peers := []*peer{}
+	for _, p := range m.sentinels {
+		if p.link.addr != p.addr {
+			p.link.close()
+			p.link = link{addr: p.addr}
+		}
+
+		if now.Sub(p.lastAsk) >= askPeriod {
+			p.lastAsk = now
+			peers = append(peers, p)
+		}
+	}
+	mu.Unlock()
+
+	for _, p := range peers {
+		reply, err := p.link.do("SENTINEL", "is-master-down-by-addr", host, port, strconv.FormatInt(epoch, 10), runId)
+		if err != nil || len(reply.Array) != 3 {
+			continue
+		}
+
+		mu.Lock()
+		p.masterDown = reply.Array[0].Int == 1
+		p.downReplyAt = now
+		if leader := reply.Array[1].Str; leader != "*" {
+			p.leader = leader
+			p.leaderEpoch = reply.Array[2].Int
+		}
+		mu.Unlock()
+	}
+}
+
+// processHello records a sentinel's announcement. If it knows a newer
+// configuration of the master, i.e. a failover happened, this sentinel
+// switches to it.
+func (m *master) processHello(h hello) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if h.runId == myId || h.masterName != m.name || m.stopped() {
+		return
+	}
+
+	addr := net.JoinHostPort(h.host, h.port)
+	p, ok := m.sentinels[h.runId]
+	if !ok {
+		//a sentinel that restarted has a new run id
+		for runId, other := range m.sentinels {
+			if other.addr == addr {
+				m.event("-dup-sentinel", fmt.Sprintf("sentinel %v %v %v @ %v", runId, h.host, h.port, m.describe(m.current())))
+				m.retired = append(m.retired, other)
+				delete(m.sentinels, runId)
+			}
+		}
+
+		p = &peer{runId: h.runId, addr: addr, link: link{addr: addr}}
+		m.sentinels[h.runId] = p
+		m.event("+sentinel", fmt.Sprintf("sentinel %v %v %v @ %v", h.runId, h.host, h.port, m.describe(m.current())))
+	}
+
+	p.addr = addr
+	p.lastHello = time.Now()
+
+	if h.currentEpoch > currentEpoch {
+		currentEpoch = h.currentEpoch
+		m.event("+new-epoch", strconv.FormatInt(currentEpoch, 10))
+	}
+
+	masterAddr := net.JoinHostPort(h.masterHost, h.masterPort)
+	if h.masterConfigEpoch > m.configEpoch && masterAddr != m.addr {
+		m.event("+config-update-from", fmt.Sprintf("sentinel %v %v %v @ %v", h.runId, h.host, h.port, m.describe(m.current())))
+		m.configEpoch = h.masterConfigEpoch
+		m.switchMaster(masterAddr, time.Now())
+	}
+}
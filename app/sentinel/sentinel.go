@@ -0,0 +1,332 @@
+package sentinel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"redis-clone-go/app/pubsub"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoSuchMaster       = errors.New("ERR No such master with that name")
+	ErrFailoverInProgress = errors.New("INPROG Failover already in progress")
+	ErrNoGoodReplica      = errors.New("NOGOODSLAVE No suitable replica to promote")
+)
+
+var (
+	// mu protects the state of all monitored masters. Network requests are
+	// made without holding it.
+	mu sync.Mutex
+
+	enabled bool
+	started bool
+	myId    = newRunId()
+	// currentEpoch is the highest epoch this sentinel has seen, failovers
+	// and votes happen in an epoch
+	currentEpoch int64
+	// announcePort is the port other sentinels reach this one at
+	announcePort string
+
+	masters = map[string]*master{}
+)
+
+// Enabled reports whether the server runs as a sentinel.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled
+}
+
+// Configure applies a sentinel directive like "monitor mymaster 127.0.0.1
+// 6379 2". An empty directive turns sentinel mode on.
+func Configure(directive string) error {
+	fields := strings.Fields(directive)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(fields) == 0 {
+		enabled = true
+		return nil
+	}
+
+	if !enabled {
+		return errors.New("sentinel directive while not in sentinel mode")
+	}
+
+	switch {
+	case strings.EqualFold(fields[0], "monitor") && len(fields) == 5:
+		return addMaster(fields[1], fields[2], fields[3], fields[4])
+	case len(fields) == 3:
+		m, ok := masters[fields[1]]
+		if !ok {
+			return fmt.Errorf("no such master %q", fields[1])
+		}
+
+		return m.setOption(fields[0], fields[2])
+	default:
+		return fmt.Errorf("unknown sentinel directive %q", directive)
+	}
+}
+
+// Start begins monitoring the configured masters. Other sentinels reach
+// this one at port.
+func Start(port string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	started = true
+	announcePort = port
+
+	for _, m := range masters {
+		m.start()
+	}
+}
+
+// addMaster starts monitoring a master, mu has to be held.
+func addMaster(name, host, port, quorum string) error {
+	if _, ok := masters[name]; ok {
+		return errors.New("Duplicated master name")
+	}
+
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return errors.New("Invalid port number")
+	}
+
+	q, err := strconv.Atoi(quorum)
+	if err != nil || q <= 0 {
+		return errors.New("Quorum must be 1 or greater.")
+	}
+
+	m := newMaster(name, host, port, q)
+	masters[name] = m
+
+	if started {
+		m.start()
+	}
+
+	return nil
+}
+
+// Monitor implements SENTINEL MONITOR.
+func Monitor(name, host, port, quorum string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := addMaster(name, host, port, quorum); err != nil {
+		return fmt.Errorf("ERR %w", err)
+	}
+
+	m := masters[name]
+	m.event("+monitor", m.describe(m.current()), fmt.Sprintf("quorum %d", m.quorum))
+	return nil
+}
+
+// Remove implements SENTINEL REMOVE, the master isn't monitored anymore.
+func Remove(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	m, ok := masters[name]
+	if !ok {
+		return ErrNoSuchMaster
+	}
+
+	m.event("-monitor", m.describe(m.current()))
+	m.stop()
+	delete(masters, name)
+	return nil
+}
+
+// Set implements SENTINEL SET with option/value pairs.
+func Set(name string, options []string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	m, ok := masters[name]
+	if !ok {
+		return ErrNoSuchMaster
+	}
+
+	for i := 0; i+1 < len(options); i += 2 {
+		if err := m.setOption(options[i], options[i+1]); err != nil {
+			return fmt.Errorf("ERR %w", err)
+		}
+	}
+
+	return nil
+}
+
+// MasterAddr returns the current address of the master, which changes
+// after a failover.
+func MasterAddr(name string) (host, port string, ok bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	m, ok := masters[name]
+	if !ok {
+		return "", "", false
+	}
+
+	host, port, _ = net.SplitHostPort(m.addr)
+	return host, port, true
+}
+
+// MasterNames returns the names of the monitored masters in order.
+func MasterNames() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	names := make([]string, 0, len(masters))
+	for name := range masters {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// Masters describes all monitored masters as field/value lists.
+func Masters() [][]string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	result := [][]string{}
+	for _, name := range slices.Sorted(maps.Keys(masters)) {
+		result = append(result, masters[name].info(time.Now()))
+	}
+
+	return result
+}
+
+// Master describes one master as a field/value list.
+func Master(name string) ([]string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	m, ok := masters[name]
+	if !ok {
+		return nil, ErrNoSuchMaster
+	}
+
+	return m.info(time.Now()), nil
+}
+
+// Replicas describes the known replicas of a master.
+func Replicas(name string) ([][]string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	m, ok := masters[name]
+	if !ok {
+		return nil, ErrNoSuchMaster
+	}
+
+	now := time.Now()
+	result := [][]string{}
+	for _, addr := range slices.Sorted(maps.Keys(m.instances)) {
+		if addr != m.addr {
+			result = append(result, m.replicaInfo(m.instances[addr], now))
+		}
+	}
+
+	return result, nil
+}
+
+// Sentinels describes the other sentinels monitoring a master.
+func Sentinels(name string) ([][]string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	m, ok := masters[name]
+	if !ok {
+		return nil, ErrNoSuchMaster
+	}
+
+	now := time.Now()
+	result := [][]string{}
+	for _, runId := range slices.Sorted(maps.Keys(m.sentinels)) {
+		result = append(result, m.sentinels[runId].info(now))
+	}
+
+	return result, nil
+}
+
+// IsMasterDownByAddr answers another sentinel asking whether this one
+// considers the master at host:port down. With a run id other than "*" the
+// other sentinel also asks for this one's vote in epoch.
+func IsMasterDownByAddr(host, port string, epoch int64, runId string) (down bool, leader string, leaderEpoch int64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	addr := net.JoinHostPort(host, port)
+	for _, m := range masters {
+		if m.addr != addr {
+			continue
+		}
+
+		down = m.current().sdown
+		if runId != "*" {
+			leader, leaderEpoch = m.vote(runId, epoch, time.Now())
+		}
+
+		break
+	}
+
+	if leader == "" {
+		leader = "*"
+	}
+
+	return down, leader, leaderEpoch
+}
+
+// Failover implements SENTINEL FAILOVER, which promotes a replica without
+// asking the other sentinels.
+func Failover(name string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	m, ok := masters[name]
+	if !ok {
+		return ErrNoSuchMaster
+	}
+
+	if m.failover != nil {
+		return ErrFailoverInProgress
+	}
+
+	now := time.Now()
+	if m.selectReplica(now) == nil {
+		return ErrNoGoodReplica
+	}
+
+	m.startFailover(now, true)
+	return nil
+}
+
+// MyId returns the run id of this sentinel.
+func MyId() string {
+	return myId
+}
+
+func newRunId() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// event logs a sentinel event and publishes it to the clients of this
+// sentinel on the channel named after the event, like "+switch-master".
+func (m *master) event(name string, fields ...string) {
+	message := strings.Join(fields, " ")
+	fmt.Printf("%v %v\n", name, message)
+	pubsub.PS.Publish(name, message)
+}
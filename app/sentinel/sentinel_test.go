@@ -0,0 +1,42 @@
+package sentinel
+
+import "testing"
+
+func TestParseHello(t *testing.T) {
+	h := hello{"127.0.0.1", "26379", "abc", 3, "mymaster", "127.0.0.1", "6379", 2}
+
+	got, err := parseHello(h.String())
+	if err != nil || got != h {
+		t.Errorf("parseHello(%q) = %+v, %v, want %+v", h.String(), got, err, h)
+	}
+
+	for _, message := range []string{"", "a,b,c", "127.0.0.1,26379,abc,x,mymaster,127.0.0.1,6379,2"} {
+		if _, err := parseHello(message); err == nil {
+			t.Errorf("parseHello(%q) succeeded", message)
+		}
+	}
+}
+
+func TestWinner(t *testing.T) {
+	tests := []struct {
+		name   string
+		votes  map[string]int
+		voters int
+		quorum int
+		want   string
+	}{
+		{"majority", map[string]int{"a": 2, "b": 1}, 3, 2, "a"},
+		{"split", map[string]int{"a": 1, "b": 1, "c": 1}, 3, 2, ""},
+		{"below quorum", map[string]int{"a": 2}, 3, 3, ""},
+		{"not a majority of all voters", map[string]int{"a": 2}, 5, 2, ""},
+		{"single sentinel", map[string]int{"a": 1}, 1, 1, "a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := winner(tt.votes, tt.voters, tt.quorum); got != tt.want {
+				t.Errorf("winner() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
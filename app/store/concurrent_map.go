@@ -3,24 +3,46 @@ package store
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"sync"
 )
 
 var ErrKeyNotFound = errors.New("key not found")
 
 var CM = &ConcurrentMap[StoredValue]{
-	db: make(map[string]StoredValue),
+	db:      make(map[string]StoredValue),
+	watched: make(map[string]*watchedKey),
+	carry:   carryListeners,
 }
 
 type ConcurrentMap[T any] struct {
 	mu sync.RWMutex
 	db map[string]T
+	// watched are the keys clients WATCH, like Redis' watched_keys. Only
+	// they have a version, which changes whenever they are written or
+	// deleted.
+	watched map[string]*watchedKey
+	version uint64
+	// carry returns the value Set stores in place of an existing one, to
+	// keep what outlives a value
+	carry func(old, val T) T
+}
+
+type watchedKey struct {
+	watchers int
+	version  uint64
 }
 
 func (cm *ConcurrentMap[T]) Set(key string, val T) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
+
+	if old, ok := cm.db[key]; ok && cm.carry != nil {
+		val = cm.carry(old, val)
+	}
+
 	cm.db[key] = val
+	cm.touch(key)
 }
 
 func (cm *ConcurrentMap[T]) Get(key string) (val T, ok bool) {
@@ -47,6 +69,31 @@ func (cm *ConcurrentMap[T]) SetOrUpdate(
 		}
 	}
 
+	cm.db[key] = val
+	cm.touch(key)
+	return val, nil
+}
+
+// SetOrUpdateQuietly is SetOrUpdate for changes that aren't changes to the
+// data, like clients blocking on the key. WATCH and the save rules don't
+// see them, Touch is called for the ones that turn out to be.
+func (cm *ConcurrentMap[T]) SetOrUpdateQuietly(
+	key string,
+	set func() T,
+	update func(*T) error,
+) (T, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	val, ok := cm.db[key]
+	if !ok {
+		val = set()
+	} else {
+		if err := update(&val); err != nil {
+			return val, err
+		}
+	}
+
 	cm.db[key] = val
 	return val, nil
 }
@@ -55,6 +102,23 @@ func (cm *ConcurrentMap[T]) Update(key string, update func(val *T) error) (T, er
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
+	val, err := cm.update(key, update)
+	if err == nil {
+		cm.touch(key)
+	}
+
+	return val, err
+}
+
+// UpdateQuietly is Update for changes that aren't changes to the data, see
+// SetOrUpdateQuietly.
+func (cm *ConcurrentMap[T]) UpdateQuietly(key string, update func(val *T) error) (T, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.update(key, update)
+}
+
+func (cm *ConcurrentMap[T]) update(key string, update func(val *T) error) (T, error) {
 	val, ok := cm.db[key]
 	if !ok {
 		return val, fmt.Errorf("key %q: %w", key, ErrKeyNotFound)
@@ -72,4 +136,121 @@ func (cm *ConcurrentMap[T]) Delete(key string) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 	delete(cm.db, key)
+	cm.touch(key)
+}
+
+// Replace deletes a key, or replaces its value if replace returns one to
+// keep in its place. It returns the previous value.
+func (cm *ConcurrentMap[T]) Replace(key string, replace func(T) (T, bool)) (T, bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	val, ok := cm.db[key]
+	if !ok {
+		return val, false
+	}
+
+	if newVal, keep := replace(val); keep {
+		cm.db[key] = newVal
+	} else {
+		delete(cm.db, key)
+	}
+
+	cm.touch(key)
+	return val, true
+}
+
+// Clear deletes all keys.
+func (cm *ConcurrentMap[T]) Clear() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	for key := range cm.db {
+		delete(cm.db, key)
+		cm.touch(key)
+	}
+}
+
+// Watch starts tracking the version of a key and returns it. Each Watch has
+// to be matched by an Unwatch.
+func (cm *ConcurrentMap[T]) Watch(key string) uint64 {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	watched, ok := cm.watched[key]
+	if !ok {
+		watched = &watchedKey{version: cm.version}
+		cm.watched[key] = watched
+	}
+
+	watched.watchers++
+	return watched.version
+}
+
+func (cm *ConcurrentMap[T]) Unwatch(key string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	watched, ok := cm.watched[key]
+	if !ok {
+		return
+	}
+
+	watched.watchers--
+	if watched.watchers == 0 {
+		delete(cm.watched, key)
+	}
+}
+
+// Version returns the current version of a watched key, see watched.
+func (cm *ConcurrentMap[T]) Version(key string) uint64 {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	if watched, ok := cm.watched[key]; ok {
+		return watched.version
+	}
+
+	return 0
+}
+
+// Touch records a change of a key made with one of the quiet updates.
+func (cm *ConcurrentMap[T]) Touch(key string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.touch(key)
+}
+
+// Changes returns the number of writes and deletes since startup.
+func (cm *ConcurrentMap[T]) Changes() uint64 {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.version
+}
+
+// Snapshot returns a shallow copy of the map and the number of changes it
+// includes.
+func (cm *ConcurrentMap[T]) Snapshot() (map[string]T, uint64) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return maps.Clone(cm.db), cm.version
+}
+
+func (cm *ConcurrentMap[T]) touch(key string) {
+	cm.version++
+	if watched, ok := cm.watched[key]; ok {
+		watched.version = cm.version
+	}
+}
+
+func (cm *ConcurrentMap[T]) Keys() []string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	keys := make([]string, 0, len(cm.db))
+	for key := range cm.db {
+		keys = append(keys, key)
+	}
+
+	return keys
 }
@@ -0,0 +1,322 @@
+package store
+
+import (
+	"iter"
+	"slices"
+)
+
+// idTreeDegree is the most keys a node of an idTree holds before it splits.
+const idTreeDegree = 64
+
+// idTree is a B+tree keyed by stream ids, Redis uses radix trees for the
+// same lookups. Nodes are never modified once they are in a tree, changes
+// copy the path to the changed leaf and share the rest. A copy of an idTree
+// is a snapshot then, which is what copies of a StoredValue rely on.
+type idTree[V any] struct {
+	root *idTreeNode[V]
+	len  int
+}
+
+type idTreeNode[V any] struct {
+	// keys are the keys of the values of a leaf, or the smallest key of
+	// each child of an inner node. The first child also holds the keys
+	// smaller than its key.
+	keys     []StreamId
+	values   []V
+	children []*idTreeNode[V]
+}
+
+func (n *idTreeNode[V]) isLeaf() bool {
+	return n.children == nil
+}
+
+func (n *idTreeNode[V]) clone() *idTreeNode[V] {
+	return &idTreeNode[V]{slices.Clone(n.keys), slices.Clone(n.values), slices.Clone(n.children)}
+}
+
+// search returns the index of the first key >= id and whether it is id.
+func (n *idTreeNode[V]) search(id StreamId) (int, bool) {
+	return slices.BinarySearchFunc(n.keys, id, compareIds)
+}
+
+// childIndex returns the index of the child that holds id.
+func (n *idTreeNode[V]) childIndex(id StreamId) int {
+	i, found := n.search(id)
+	if found || i == 0 {
+		return i
+	}
+
+	return i - 1
+}
+
+func compareIds(a, b StreamId) int {
+	switch {
+	case a.IsGreaterThan(b):
+		return 1
+	case b.IsGreaterThan(a):
+		return -1
+	default:
+		return 0
+	}
+}
+
+func (t idTree[V]) Len() int {
+	return t.len
+}
+
+func (t idTree[V]) Get(id StreamId) (V, bool) {
+	var zero V
+
+	n := t.root
+	if n == nil {
+		return zero, false
+	}
+
+	for !n.isLeaf() {
+		n = n.children[n.childIndex(id)]
+	}
+
+	i, found := n.search(id)
+	if !found {
+		return zero, false
+	}
+
+	return n.values[i], true
+}
+
+// Set adds a value or replaces the value of id.
+func (t *idTree[V]) Set(id StreamId, value V) {
+	if t.root == nil {
+		t.root = &idTreeNode[V]{keys: []StreamId{id}, values: []V{value}}
+		t.len = 1
+		return
+	}
+
+	root, split, added := t.root.set(id, value)
+	if split != nil {
+		root = &idTreeNode[V]{
+			keys:     []StreamId{root.keys[0], split.keys[0]},
+			children: []*idTreeNode[V]{root, split},
+		}
+	}
+
+	t.root = root
+	if added {
+		t.len++
+	}
+}
+
+// set returns the copy of the node with the value set, and the second half
+// of the copy if it had to be split.
+func (n *idTreeNode[V]) set(id StreamId, value V) (*idTreeNode[V], *idTreeNode[V], bool) {
+	n = n.clone()
+	added := false
+
+	if n.isLeaf() {
+		i, found := n.search(id)
+		if found {
+			n.values[i] = value
+		} else {
+			n.keys = slices.Insert(n.keys, i, id)
+			n.values = slices.Insert(n.values, i, value)
+			added = true
+		}
+	} else {
+		i := n.childIndex(id)
+
+		var split *idTreeNode[V]
+		n.children[i], split, added = n.children[i].set(id, value)
+		n.keys[i] = n.children[i].keys[0]
+
+		if split != nil {
+			n.keys = slices.Insert(n.keys, i+1, split.keys[0])
+			n.children = slices.Insert(n.children, i+1, split)
+		}
+	}
+
+	if len(n.keys) <= idTreeDegree {
+		return n, nil, added
+	}
+
+	half := len(n.keys) / 2
+	split := &idTreeNode[V]{keys: slices.Clone(n.keys[half:])}
+	n.keys = n.keys[:half:half]
+
+	if n.isLeaf() {
+		split.values = slices.Clone(n.values[half:])
+		n.values = n.values[:half:half]
+	} else {
+		split.children = slices.Clone(n.children[half:])
+		n.children = n.children[:half:half]
+	}
+
+	return n, split, added
+}
+
+// Delete removes the value of id. Nodes are merged only once they are
+// empty, which is enough for trees that shrink from their head.
+func (t *idTree[V]) Delete(id StreamId) bool {
+	if t.root == nil {
+		return false
+	}
+
+	root, deleted := t.root.delete(id)
+	if !deleted {
+		return false
+	}
+
+	//an inner root with a single child is replaced by it
+	for root != nil && !root.isLeaf() && len(root.keys) == 1 {
+		root = root.children[0]
+	}
+
+	t.root = root
+	t.len--
+	return true
+}
+
+// delete returns the copy of the node without id, or nil if it became
+// empty.
+func (n *idTreeNode[V]) delete(id StreamId) (*idTreeNode[V], bool) {
+	if n.isLeaf() {
+		i, found := n.search(id)
+		if !found {
+			return n, false
+		}
+
+		if len(n.keys) == 1 {
+			return nil, true
+		}
+
+		n = n.clone()
+		n.keys = slices.Delete(n.keys, i, i+1)
+		n.values = slices.Delete(n.values, i, i+1)
+		return n, true
+	}
+
+	i := n.childIndex(id)
+	child, deleted := n.children[i].delete(id)
+	if !deleted {
+		return n, false
+	}
+
+	if child == nil && len(n.keys) == 1 {
+		return nil, true
+	}
+
+	n = n.clone()
+	if child == nil {
+		n.keys = slices.Delete(n.keys, i, i+1)
+		n.children = slices.Delete(n.children, i, i+1)
+	} else {
+		n.keys[i] = child.keys[0]
+		n.children[i] = child
+	}
+
+	return n, true
+}
+
+func (t idTree[V]) First() (StreamId, V, bool) {
+	var zero V
+
+	n := t.root
+	if n == nil {
+		return StreamId{}, zero, false
+	}
+
+	for !n.isLeaf() {
+		n = n.children[0]
+	}
+
+	return n.keys[0], n.values[0], true
+}
+
+func (t idTree[V]) Last() (StreamId, V, bool) {
+	var zero V
+
+	n := t.root
+	if n == nil {
+		return StreamId{}, zero, false
+	}
+
+	for !n.isLeaf() {
+		n = n.children[len(n.children)-1]
+	}
+
+	last := len(n.keys) - 1
+	return n.keys[last], n.values[last], true
+}
+
+// Floor returns the greatest id <= id and its value.
+func (t idTree[V]) Floor(id StreamId) (StreamId, V, bool) {
+	var zero V
+
+	n := t.root
+	if n == nil {
+		return StreamId{}, zero, false
+	}
+
+	for !n.isLeaf() {
+		n = n.children[n.childIndex(id)]
+	}
+
+	i, found := n.search(id)
+	if !found {
+		//the keys of inner nodes are the smallest of their children, so
+		//only ids before the first one end up before a leaf's first key
+		if i == 0 {
+			return StreamId{}, zero, false
+		}
+
+		i--
+	}
+
+	return n.keys[i], n.values[i], true
+}
+
+// Ascend iterates over the ids >= from and their values, in order.
+func (t idTree[V]) Ascend(from StreamId) iter.Seq2[StreamId, V] {
+	return func(yield func(StreamId, V) bool) {
+		if t.root != nil {
+			t.root.ascend(from, yield)
+		}
+	}
+}
+
+func (n *idTreeNode[V]) ascend(from StreamId, yield func(StreamId, V) bool) bool {
+	if n.isLeaf() {
+		i, _ := n.search(from)
+		for ; i < len(n.keys); i++ {
+			if !yield(n.keys[i], n.values[i]) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	for i := n.childIndex(from); i < len(n.children); i++ {
+		if !n.children[i].ascend(from, yield) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (t idTree[V]) nodeCount() int {
+	if t.root == nil {
+		return 0
+	}
+
+	return t.root.nodeCount()
+}
+
+func (n *idTreeNode[V]) nodeCount() int {
+	count := 1
+	for _, child := range n.children {
+		count += child.nodeCount()
+	}
+
+	return count
+}
@@ -13,7 +13,9 @@ const (
 type StoredValue struct {
 	Val             string
 	Lval            []string
-	Xval            []StreamEntry
+	Xval            Stream
+	XMeta           StreamMetadata
+	XGroups         map[string]StreamGroup
 	Type            StoredValueType
 	ExpiresBy       int64
 	ListListeners   []chan string
@@ -36,29 +38,71 @@ func (sv *StoredValue) AddStreamListener(listener StreamListener) {
 	}
 }
 
+// IsBlockedOnly reports whether the value only holds the clients blocked on
+// a key that doesn't exist.
+func (sv *StoredValue) IsBlockedOnly() bool {
+	switch sv.Type {
+	case TypeList:
+		return len(sv.Lval) == 0 && len(sv.ListListeners) > 0
+	case TypeStream:
+		return sv.Xval.Len() == 0 && sv.XMeta == StreamMetadata{} && len(sv.XGroups) == 0 && len(sv.StreamListeners) > 0
+	default:
+		return false
+	}
+}
+
+// DeleteKey deletes a key and returns its value. Like in Redis, the clients
+// blocked on the key keep waiting for it to be written again, so they stay
+// in its place.
+func DeleteKey(key string) (StoredValue, bool) {
+	//only the blocked clients are left, there is nothing to delete
+	if sv, ok := CM.Get(key); ok && sv.IsBlockedOnly() {
+		return sv, true
+	}
+
+	return CM.Replace(key, func(sv StoredValue) (StoredValue, bool) {
+		switch {
+		case len(sv.ListListeners) > 0:
+			return StoredValue{Lval: []string{}, Type: TypeList, ExpiresBy: -1, ListListeners: sv.ListListeners}, true
+		case len(sv.StreamListeners) > 0:
+			return StoredValue{Type: TypeStream, ExpiresBy: -1, StreamListeners: sv.StreamListeners}, true
+		default:
+			return sv, false
+		}
+	})
+}
+
+// carryListeners keeps the clients blocked on a key that is overwritten,
+// they wait for it to be written as they did before.
+func carryListeners(old, sv StoredValue) StoredValue {
+	sv.ListListeners = old.ListListeners
+	sv.StreamListeners = old.StreamListeners
+	return sv
+}
+
 func (sv *StoredValue) IsExpired() bool {
 	return sv.ExpiresBy != -1 && time.Now().UnixMilli() > sv.ExpiresBy
 }
 
 func NewStringValue(val string, expiresBy int64) StoredValue {
-	return StoredValue{val, nil, nil, TypeString, expiresBy, nil, nil}
+	return StoredValue{val, nil, Stream{}, StreamMetadata{}, nil, TypeString, expiresBy, nil, nil}
 }
 
 func NewListValue(lval []string) StoredValue {
-	return StoredValue{"", lval, nil, TypeList, -1, nil, nil}
+	return StoredValue{"", lval, Stream{}, StreamMetadata{}, nil, TypeList, -1, nil, nil}
 }
 
 func NewListListener(c chan string) StoredValue {
-	return StoredValue{"", []string{}, nil, TypeList, -1, []chan string{c}, nil}
+	return StoredValue{"", []string{}, Stream{}, StreamMetadata{}, nil, TypeList, -1, []chan string{c}, nil}
 }
 
 func NewStreamValue(xval []StreamEntry) StoredValue {
-	return StoredValue{"", nil, xval, TypeStream, -1, nil, nil}
+	return StoredValue{"", nil, NewStream(xval), NewStreamMetadata(xval), nil, TypeStream, -1, nil, nil}
 }
 
 // TODO: find better name?
 func NewStreamListener(listener StreamListener) StoredValue {
-	return StoredValue{"", nil, []StreamEntry{}, TypeStream, -1, nil, []StreamListener{listener}}
+	return StoredValue{"", nil, Stream{}, StreamMetadata{}, nil, TypeStream, -1, nil, []StreamListener{listener}}
 }
 
 type StreamListener struct {
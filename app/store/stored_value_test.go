@@ -0,0 +1,26 @@
+package store
+
+import "testing"
+
+func TestOverwrittenKeyKeepsListeners(t *testing.T) {
+	defer CM.Delete("blocked")
+
+	listener := make(chan string, 1)
+	CM.SetOrUpdateQuietly("blocked", func() StoredValue {
+		return NewListListener(listener)
+	}, func(sv *StoredValue) error {
+		sv.AddListListener(listener)
+		return nil
+	})
+
+	CM.Set("blocked", NewStringValue("x", -1))
+	if sv, _ := CM.Get("blocked"); sv.Type != TypeString || len(sv.ListListeners) != 1 {
+		t.Fatalf("overwritten value = %+v", sv)
+	}
+
+	//once deleted, the client waits for the list to be pushed to again
+	DeleteKey("blocked")
+	if sv, _ := CM.Get("blocked"); !sv.IsBlockedOnly() || sv.ListListeners[0] != listener {
+		t.Errorf("deleted value = %+v", sv)
+	}
+}
@@ -0,0 +1,374 @@
+package store
+
+import (
+	"encoding/binary"
+	"iter"
+	"slices"
+)
+
+// A stream node holds up to StreamNodeMaxEntries entries or, past its first
+// entry, StreamNodeMaxBytes bytes of them, the defaults of Redis'
+// stream-node-max-entries and stream-node-max-bytes.
+const (
+	StreamNodeMaxEntries = 100
+	StreamNodeMaxBytes   = 4096
+)
+
+// Stream stores its entries in macro-nodes indexed by the id of their first
+// entry, like Redis' listpacks in a radix tree. Entries are encoded as
+// deltas from the first entry of their node, without their fields when
+// they have the same ones. Appending and trimming the head only touch the
+// first or last node, and a lookup only decodes a single node.
+//
+// Like idTree, a Stream is never changed in place, a copy is a snapshot.
+type Stream struct {
+	nodes idTree[*streamNode]
+	// tail is the node appended to, it only joins nodes once it is full
+	tail   *streamNode
+	length int
+}
+
+type streamNode struct {
+	master       StreamId
+	masterFields []string
+	// data holds the encoded entries, those before start were trimmed.
+	// used is how much of its array the copies of the node filled, only the
+	// copy that filled all of it can append in place.
+	data  []byte
+	used  *int
+	start int
+	count int
+	// last is the id of the last entry, which starts at lastPos
+	last    StreamId
+	lastPos int
+}
+
+func NewStream(entries []StreamEntry) Stream {
+	s := Stream{}
+	for _, entry := range entries {
+		s.Append(entry)
+	}
+
+	return s
+}
+
+func (s *Stream) Len() int {
+	return s.length
+}
+
+// Append adds an entry, its id has to be greater than the last one.
+func (s *Stream) Append(entry StreamEntry) {
+	s.length++
+
+	node := s.tail
+	if node == nil || node.count >= StreamNodeMaxEntries || len(node.data) >= StreamNodeMaxBytes {
+		if node != nil {
+			s.nodes.Set(node.master, node)
+		}
+
+		node = &streamNode{master: entry.Id, masterFields: entryFields(entry.Pairs)}
+	} else {
+		node = node.clone()
+	}
+
+	node.append(entry)
+	s.tail = node
+}
+
+func (s *Stream) First() (StreamEntry, bool) {
+	node := s.firstNode()
+	if node == nil {
+		return StreamEntry{}, false
+	}
+
+	entry, _ := node.decode(node.start)
+	return entry, true
+}
+
+func (s *Stream) Last() (StreamEntry, bool) {
+	if s.tail == nil {
+		return StreamEntry{}, false
+	}
+
+	entry, _ := s.tail.decode(s.tail.lastPos)
+	return entry, true
+}
+
+// Range returns up to count entries whose ids are between start and end,
+// all of them if count is 0.
+func (s *Stream) Range(start, end StreamId, count int) []StreamEntry {
+	result := []StreamEntry{}
+
+	for node := range s.ascend(start) {
+		if node.master.IsGreaterThan(end) {
+			break
+		}
+
+		if start.IsGreaterThan(node.last) {
+			continue
+		}
+
+		for entry := range node.entries() {
+			if entry.Id.IsGreaterThan(end) {
+				return result
+			}
+
+			if start.IsGreaterThan(entry.Id) {
+				continue
+			}
+
+			result = append(result, entry)
+			if count > 0 && len(result) == count {
+				return result
+			}
+		}
+	}
+
+	return result
+}
+
+// All iterates over the entries in order.
+func (s *Stream) All() iter.Seq[StreamEntry] {
+	return func(yield func(StreamEntry) bool) {
+		for node := range s.ascend(StreamId{}) {
+			for entry := range node.entries() {
+				if !yield(entry) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Nodes returns the number of macro-nodes.
+func (s *Stream) Nodes() int {
+	if s.tail == nil {
+		return 0
+	}
+
+	return s.nodes.Len() + 1
+}
+
+// TreeNodes returns the number of nodes of the index of the macro-nodes.
+func (s *Stream) TreeNodes() int {
+	return s.nodes.nodeCount()
+}
+
+// ascend iterates over the nodes, starting with the one holding from.
+func (s *Stream) ascend(from StreamId) iter.Seq[*streamNode] {
+	return func(yield func(*streamNode) bool) {
+		if s.tail == nil {
+			return
+		}
+
+		//only the tail can hold ids from its first one on
+		if !s.tail.master.IsGreaterThan(from) {
+			yield(s.tail)
+			return
+		}
+
+		//the node holding from begins before it
+		if master, _, ok := s.nodes.Floor(from); ok {
+			from = master
+		}
+
+		for _, node := range s.nodes.Ascend(from) {
+			if !yield(node) {
+				return
+			}
+		}
+
+		yield(s.tail)
+	}
+}
+
+func (s *Stream) firstNode() *streamNode {
+	if _, node, ok := s.nodes.First(); ok {
+		return node
+	}
+
+	return s.tail
+}
+
+// TrimMaxLen removes the oldest entries beyond maxLen and returns how many
+// it removed, see trim.
+func (s *Stream) TrimMaxLen(maxLen int, approx bool, limit int) int {
+	return s.trim(func(id StreamId, length int) bool {
+		return length > maxLen
+	}, approx, limit)
+}
+
+// TrimMinId removes the entries with ids smaller than minId and returns how
+// many it removed, see trim.
+func (s *Stream) TrimMinId(minId StreamId, approx bool, limit int) int {
+	return s.trim(func(id StreamId, length int) bool {
+		return minId.IsGreaterThan(id)
+	}, approx, limit)
+}
+
+// trim removes entries from the head as long as remove returns true for
+// the first one and the length of the stream. Like in Redis, approximate
+// trims only remove whole nodes, and a limit greater than 0 caps the number
+// of entries removed.
+func (s *Stream) trim(remove func(id StreamId, length int) bool, approx bool, limit int) int {
+	removed := 0
+
+	for s.length > 0 {
+		node := s.firstNode()
+
+		//the last entry of the node going means they all do
+		if remove(node.last, s.length-node.count+1) {
+			if limit > 0 && removed+node.count > limit {
+				break
+			}
+
+			if node == s.tail {
+				s.tail = nil
+			} else {
+				s.nodes.Delete(node.master)
+			}
+
+			s.length -= node.count
+			removed += node.count
+			continue
+		}
+
+		if approx {
+			break
+		}
+
+		node = node.clone()
+		for node.count > 0 && remove(node.first(), s.length) {
+			node.trimFirst()
+			s.length--
+			removed++
+		}
+
+		if s.tail != nil && node.master == s.tail.master {
+			s.tail = node
+		} else {
+			s.nodes.Set(node.master, node)
+		}
+
+		break
+	}
+
+	return removed
+}
+
+func (n *streamNode) clone() *streamNode {
+	clone := *n
+	return &clone
+}
+
+// append encodes an entry as the differences of its id to the master id,
+// a flag telling whether it has the master's fields, its fields unless it
+// does and its values.
+func (n *streamNode) append(entry StreamEntry) {
+	fields := entryFields(entry.Pairs)
+	sameFields := slices.Equal(fields, n.masterFields)
+
+	//appending to an array another copy filled further would overwrite
+	//its entries
+	if n.used == nil || *n.used != len(n.data) {
+		n.data = slices.Clip(n.data)
+		n.used = new(int)
+	}
+
+	n.lastPos = len(n.data)
+	n.data = binary.AppendVarint(n.data, entry.Id.Ms-n.master.Ms)
+	n.data = binary.AppendVarint(n.data, entry.Id.Sequence-n.master.Sequence)
+
+	if sameFields {
+		n.data = append(n.data, 1)
+	} else {
+		n.data = append(n.data, 0)
+		n.data = binary.AppendUvarint(n.data, uint64(len(entry.Pairs)))
+	}
+
+	for i, value := range entry.Pairs {
+		if sameFields && i%2 == 0 {
+			continue
+		}
+
+		n.data = binary.AppendUvarint(n.data, uint64(len(value)))
+		n.data = append(n.data, value...)
+	}
+
+	*n.used = len(n.data)
+	n.count++
+	n.last = entry.Id
+}
+
+func (n *streamNode) entries() iter.Seq[StreamEntry] {
+	return func(yield func(StreamEntry) bool) {
+		for pos := n.start; pos < len(n.data); {
+			entry, next := n.decode(pos)
+			if !yield(entry) {
+				return
+			}
+
+			pos = next
+		}
+	}
+}
+
+// decode decodes the entry at pos and returns the position of the next.
+func (n *streamNode) decode(pos int) (StreamEntry, int) {
+	msDelta, size := binary.Varint(n.data[pos:])
+	pos += size
+	seqDelta, size := binary.Varint(n.data[pos:])
+	pos += size
+
+	id := StreamId{Ms: n.master.Ms + msDelta, Sequence: n.master.Sequence + seqDelta}
+	sameFields := n.data[pos] == 1
+	pos++
+
+	var pairs []string
+	if sameFields {
+		pairs = make([]string, 0, len(n.masterFields)*2)
+		for _, field := range n.masterFields {
+			value := ""
+			value, pos = n.decodeString(pos)
+			pairs = append(pairs, field, value)
+		}
+	} else {
+		length, size := binary.Uvarint(n.data[pos:])
+		pos += size
+
+		pairs = make([]string, length)
+		for i := range pairs {
+			pairs[i], pos = n.decodeString(pos)
+		}
+	}
+
+	return NewStreamEntry(id, pairs), pos
+}
+
+func (n *streamNode) decodeString(pos int) (string, int) {
+	length, size := binary.Uvarint(n.data[pos:])
+	pos += size
+	end := pos + int(length)
+	return string(n.data[pos:end]), end
+}
+
+func (n *streamNode) first() StreamId {
+	entry, _ := n.decode(n.start)
+	return entry.Id
+}
+
+// trimFirst drops the first entry. Its bytes stay, they are shared with
+// the copies of the node.
+func (n *streamNode) trimFirst() {
+	_, n.start = n.decode(n.start)
+	n.count--
+}
+
+func entryFields(pairs []string) []string {
+	fields := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		fields = append(fields, pairs[i])
+	}
+
+	return fields
+}
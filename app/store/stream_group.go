@@ -0,0 +1,184 @@
+package store
+
+import "maps"
+
+// StreamGroup is a consumer group of a stream. Its pending entries list
+// (PEL) holds the entries delivered to its consumers and not acknowledged
+// yet, each consumer also has the list of the ones it was delivered.
+//
+// Like the stream, a group is shared by the copies of its StoredValue.
+// Changes are made to a copy from Clone, which is stored with SetGroup.
+type StreamGroup struct {
+	LastId StreamId
+	// EntriesRead is the number of entries of the stream up to LastId, -1
+	// if it isn't known
+	EntriesRead int64
+	Pending     idTree[PendingEntry]
+	Consumers   map[string]StreamConsumer
+}
+
+type PendingEntry struct {
+	Consumer      string
+	DeliveryTime  int64
+	DeliveryCount int64
+}
+
+type StreamConsumer struct {
+	SeenTime   int64
+	ActiveTime int64
+	Pending    idTree[struct{}]
+}
+
+func NewStreamGroup(lastId StreamId, entriesRead int64) StreamGroup {
+	return StreamGroup{LastId: lastId, EntriesRead: entriesRead, Consumers: map[string]StreamConsumer{}}
+}
+
+func NewStreamConsumer(now int64) StreamConsumer {
+	return StreamConsumer{SeenTime: now, ActiveTime: -1}
+}
+
+func (g StreamGroup) Clone() StreamGroup {
+	g.Consumers = maps.Clone(g.Consumers)
+	return g
+}
+
+// Deliver adds an entry to the PEL of a consumer with the given delivery
+// time and count, taking it from the consumer it was pending for.
+func (g *StreamGroup) Deliver(id StreamId, consumer string, deliveryTime, deliveryCount int64) {
+	if pending, ok := g.Pending.Get(id); ok && pending.Consumer != consumer {
+		previous := g.Consumers[pending.Consumer]
+		previous.Pending.Delete(id)
+		g.Consumers[pending.Consumer] = previous
+	}
+
+	g.Pending.Set(id, PendingEntry{consumer, deliveryTime, deliveryCount})
+
+	owner := g.Consumers[consumer]
+	owner.Pending.Set(id, struct{}{})
+	g.Consumers[consumer] = owner
+}
+
+// Ack removes an entry from the PEL, it returns false if it wasn't pending.
+func (g *StreamGroup) Ack(id StreamId) bool {
+	pending, ok := g.Pending.Get(id)
+	if !ok {
+		return false
+	}
+
+	g.Pending.Delete(id)
+
+	owner := g.Consumers[pending.Consumer]
+	owner.Pending.Delete(id)
+	g.Consumers[pending.Consumer] = owner
+	return true
+}
+
+// DeleteConsumer removes a consumer and acknowledges its pending entries,
+// it returns how many it had.
+func (g *StreamGroup) DeleteConsumer(name string) int {
+	consumer := g.Consumers[name]
+	for id := range consumer.Pending.Ascend(StreamId{}) {
+		g.Pending.Delete(id)
+	}
+
+	delete(g.Consumers, name)
+	return consumer.Pending.Len()
+}
+
+// SetGroup stores a group, replacing the one with the same name.
+func (sv *StoredValue) SetGroup(name string, group StreamGroup) {
+	sv.XGroups = maps.Clone(sv.XGroups)
+	if sv.XGroups == nil {
+		sv.XGroups = map[string]StreamGroup{}
+	}
+
+	sv.XGroups[name] = group
+}
+
+func (sv *StoredValue) DeleteGroup(name string) {
+	sv.XGroups = maps.Clone(sv.XGroups)
+	delete(sv.XGroups, name)
+}
+
+// EntriesRead returns the number of entries of the stream up to a group's
+// last id, or -1 if the deleted entries make it unknown. Like Redis, it
+// counts on from the group's counter when no entry after the last id was
+// deleted, and otherwise derives it from the first entry.
+func (sv *StoredValue) EntriesRead(group StreamGroup) int64 {
+	if group.EntriesRead != -1 && !sv.hasTombstonesAfter(group.LastId) {
+		return group.EntriesRead
+	}
+
+	return sv.estimateEntriesRead(group.LastId)
+}
+
+// Lag returns the number of entries the group still has to read, or -1 if
+// it isn't known.
+func (sv *StoredValue) Lag(group StreamGroup) int64 {
+	if sv.XMeta.EntriesAdded == 0 {
+		return 0
+	}
+
+	entriesRead := sv.EntriesRead(group)
+	if entriesRead == -1 {
+		return -1
+	}
+
+	return sv.XMeta.EntriesAdded - entriesRead
+}
+
+// AdvanceGroup moves the last id of a group to an entry it just read.
+func (sv *StoredValue) AdvanceGroup(group *StreamGroup, id StreamId) {
+	if group.EntriesRead != -1 && !sv.hasTombstonesAfter(id) {
+		group.EntriesRead++
+	} else if sv.XMeta.EntriesAdded > 0 {
+		group.EntriesRead = sv.estimateEntriesRead(id)
+	}
+
+	group.LastId = id
+}
+
+// hasTombstonesAfter reports whether entries at or after id may have been
+// deleted.
+func (sv *StoredValue) hasTombstonesAfter(id StreamId) bool {
+	if sv.Xval.Len() == 0 || sv.XMeta.MaxDeletedId == (StreamId{}) {
+		return false
+	}
+
+	return !id.IsGreaterThan(sv.XMeta.MaxDeletedId)
+}
+
+func (sv *StoredValue) estimateEntriesRead(id StreamId) int64 {
+	meta := sv.XMeta
+	if meta.EntriesAdded == 0 {
+		return 0
+	}
+
+	if sv.Xval.Len() == 0 && !id.IsGreaterThan(meta.LastId) {
+		return meta.EntriesAdded
+	}
+
+	if id == meta.LastId {
+		return meta.EntriesAdded
+	}
+
+	if id.IsGreaterThan(meta.LastId) {
+		return -1
+	}
+
+	first, _ := sv.Xval.First()
+	if meta.MaxDeletedId != (StreamId{}) && !first.Id.IsGreaterThan(meta.MaxDeletedId) {
+		return -1
+	}
+
+	//without deletions from the first entry on, the entries before it are
+	//all the ones trimmed
+	switch {
+	case first.Id.IsGreaterThan(id):
+		return meta.EntriesAdded - int64(sv.Xval.Len())
+	case first.Id == id:
+		return meta.EntriesAdded - int64(sv.Xval.Len()) + 1
+	default:
+		return -1
+	}
+}
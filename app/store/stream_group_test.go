@@ -0,0 +1,94 @@
+package store
+
+import (
+	"slices"
+	"testing"
+)
+
+func pendingIds(tree idTree[struct{}]) []string {
+	ids := []string{}
+	for id := range tree.Ascend(StreamId{}) {
+		ids = append(ids, id.String())
+	}
+
+	return ids
+}
+
+func TestStreamGroupPending(t *testing.T) {
+	group := NewStreamGroup(StreamId{}, 0)
+	group.Consumers["alice"] = NewStreamConsumer(1)
+	group.Consumers["bob"] = NewStreamConsumer(1)
+
+	for i := range 4 {
+		group.Deliver(StreamId{Ms: int64(i + 1)}, "alice", 10, 1)
+	}
+
+	snapshot := group
+	group = group.Clone()
+
+	//a claimed entry moves to the PEL of its new owner
+	group.Deliver(StreamId{Ms: 2}, "bob", 20, 2)
+	if pending, _ := group.Pending.Get(StreamId{Ms: 2}); pending != (PendingEntry{"bob", 20, 2}) {
+		t.Errorf("claimed entry = %+v", pending)
+	}
+
+	if !group.Ack(StreamId{Ms: 3}) || group.Ack(StreamId{Ms: 3}) {
+		t.Errorf("Ack() acknowledged an entry twice or not at all")
+	}
+
+	if got := pendingIds(group.Consumers["alice"].Pending); !slices.Equal(got, []string{"1-0", "4-0"}) {
+		t.Errorf("alice has %v pending", got)
+	}
+
+	if got := pendingIds(group.Consumers["bob"].Pending); !slices.Equal(got, []string{"2-0"}) {
+		t.Errorf("bob has %v pending", got)
+	}
+
+	if deleted := group.DeleteConsumer("alice"); deleted != 2 || group.Pending.Len() != 1 {
+		t.Errorf("DeleteConsumer() = %d, %d entries left pending", deleted, group.Pending.Len())
+	}
+
+	//the group it was cloned from doesn't change
+	if got := pendingIds(snapshot.Consumers["alice"].Pending); snapshot.Pending.Len() != 4 || len(got) != 4 {
+		t.Errorf("snapshot has %d entries pending, alice %v", snapshot.Pending.Len(), got)
+	}
+}
+
+func TestStreamGroupLag(t *testing.T) {
+	newValue := func() StoredValue {
+		sv := NewStreamValue(nil)
+		for i := range 10 {
+			entry := NewStreamEntry(StreamId{Ms: int64(i + 1)}, []string{"field", "value"})
+			sv.Xval.Append(entry)
+			sv.XMeta.AddEntry(entry)
+		}
+
+		return sv
+	}
+
+	tests := []struct {
+		name  string
+		edit  func(sv *StoredValue)
+		group StreamGroup
+		want  int64
+	}{
+		{"new group", func(sv *StoredValue) {}, NewStreamGroup(StreamId{}, 0), 10},
+		{"read up to the middle", func(sv *StoredValue) {}, NewStreamGroup(StreamId{Ms: 4}, 4), 6},
+		{"read everything", func(sv *StoredValue) {}, NewStreamGroup(StreamId{Ms: 10}, 10), 0},
+		{"unknown entries read at the last id", func(sv *StoredValue) {}, NewStreamGroup(StreamId{Ms: 10}, -1), 0},
+		{"trimmed before the last id", func(sv *StoredValue) { sv.Xval.TrimMaxLen(8, false, 0) }, NewStreamGroup(StreamId{Ms: 1}, -1), 8},
+		{"deleted after the last id", func(sv *StoredValue) { sv.XMeta.MaxDeletedId = StreamId{Ms: 7} }, NewStreamGroup(StreamId{Ms: 4}, 4), -1},
+		{"deleted before the last id", func(sv *StoredValue) { sv.XMeta.MaxDeletedId = StreamId{Ms: 3} }, NewStreamGroup(StreamId{Ms: 4}, 4), 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sv := newValue()
+			tt.edit(&sv)
+
+			if got := sv.Lag(tt.group); got != tt.want {
+				t.Errorf("Lag() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package store
 import (
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -19,15 +20,10 @@ func (id StreamId) String() string {
 	return fmt.Sprintf("%v-%v", id.Ms, id.Sequence)
 }
 
-func (id *StreamId) GenerateValues(previousIds []StreamEntry) {
-	var latestId *StreamId
-
-	if len(previousIds) > 0 {
-		latestId = &previousIds[len(previousIds)-1].Id
-	}
-
+func (id *StreamId) GenerateValues(lastId StreamId) {
 	if id.generateMs {
-		id.Ms = time.Now().UnixMilli()
+		//never go back in time, even if the last id was set into the future
+		id.Ms = max(time.Now().UnixMilli(), lastId.Ms)
 		id.generateMs = false
 	}
 
@@ -35,16 +31,8 @@ func (id *StreamId) GenerateValues(previousIds []StreamEntry) {
 		return
 	}
 
-	latestMs := int64(0)
-	latestSequence := int64(0)
-
-	if latestId != nil {
-		latestMs = latestId.Ms
-		latestSequence = latestId.Sequence
-	}
-
-	if id.Ms == latestMs {
-		id.Sequence = latestSequence + 1
+	if id.Ms == lastId.Ms {
+		id.Sequence = lastId.Sequence + 1
 	} else {
 		id.Sequence = 0
 	}
@@ -52,22 +40,33 @@ func (id *StreamId) GenerateValues(previousIds []StreamEntry) {
 	id.generateSequence = false
 }
 
-func (id *StreamId) CanAppendKey(previousIds []StreamEntry) bool {
-	if len(previousIds) == 0 {
-		return true
-	}
-
-	latest := previousIds[len(previousIds)-1].Id
-
-	if id.Ms < latest.Ms {
-		return false
+// Next returns the smallest id greater than id, unless id is the greatest
+// possible one.
+func (id StreamId) Next() (StreamId, bool) {
+	switch {
+	case id.Sequence < math.MaxInt64:
+		return StreamId{Ms: id.Ms, Sequence: id.Sequence + 1}, true
+	case id.Ms < math.MaxInt64:
+		return StreamId{Ms: id.Ms + 1, Sequence: 0}, true
+	default:
+		return StreamId{}, false
 	}
+}
 
-	if id.Ms == latest.Ms && id.Sequence <= latest.Sequence {
-		return false
+// Prev returns the greatest id smaller than id, unless id is 0-0.
+func (id StreamId) Prev() (StreamId, bool) {
+	switch {
+	case id.Sequence > 0:
+		return StreamId{Ms: id.Ms, Sequence: id.Sequence - 1}, true
+	case id.Ms > 0:
+		return StreamId{Ms: id.Ms - 1, Sequence: math.MaxInt64}, true
+	default:
+		return StreamId{}, false
 	}
+}
 
-	return true
+func (id *StreamId) CanAppendKey(lastId StreamId) bool {
+	return id.IsGreaterThan(lastId)
 }
 
 func (id *StreamId) IsEqualTo(idToCompare StreamId) bool {
@@ -0,0 +1,25 @@
+package store
+
+// StreamMetadata is tracked separately from the entries of a stream, so it
+// survives entries being removed and can be restored with XSETID.
+type StreamMetadata struct {
+	LastId       StreamId
+	MaxDeletedId StreamId
+	EntriesAdded int64
+}
+
+func NewStreamMetadata(entries []StreamEntry) StreamMetadata {
+	meta := StreamMetadata{}
+
+	if len(entries) > 0 {
+		meta.LastId = entries[len(entries)-1].Id
+		meta.EntriesAdded = int64(len(entries))
+	}
+
+	return meta
+}
+
+func (meta *StreamMetadata) AddEntry(entry StreamEntry) {
+	meta.LastId = entry.Id
+	meta.EntriesAdded++
+}
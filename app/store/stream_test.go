@@ -0,0 +1,226 @@
+package store
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func newTestStream(length int) Stream {
+	s := Stream{}
+	for i := range length {
+		id := StreamId{Ms: int64(i/2 + 1), Sequence: int64(i % 2)}
+		pairs := []string{"field", strconv.Itoa(i)}
+
+		//some entries don't have the fields of their node's first entry
+		if i%7 == 0 {
+			pairs = append(pairs, "other", "value")
+		}
+
+		s.Append(NewStreamEntry(id, pairs))
+	}
+
+	return s
+}
+
+func entryIds(entries []StreamEntry) []string {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.Id.String()
+	}
+
+	return ids
+}
+
+func TestStreamRange(t *testing.T) {
+	s := newTestStream(6) // 1-0 1-1 2-0 2-1 3-0 3-1
+	maxId := StreamId{Ms: math.MaxInt64, Sequence: math.MaxInt64}
+
+	tests := []struct {
+		name  string
+		start StreamId
+		end   StreamId
+		count int
+		want  []string
+	}{
+		{"before first entry", StreamId{Ms: 0, Sequence: 5}, StreamId{Ms: 1, Sequence: 0}, 0, []string{"1-0"}},
+		{"exact match", StreamId{Ms: 2, Sequence: 0}, StreamId{Ms: 2, Sequence: 0}, 0, []string{"2-0"}},
+		{"between entries", StreamId{Ms: 2, Sequence: 5}, maxId, 0, []string{"3-0", "3-1"}},
+		{"after last entry", StreamId{Ms: 4, Sequence: 0}, maxId, 0, []string{}},
+		{"count", StreamId{}, maxId, 4, []string{"1-0", "1-1", "2-0", "2-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := entryIds(s.Range(tt.start, tt.end, tt.count)); !slices.Equal(got, tt.want) {
+				t.Errorf("Range() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamManyNodes(t *testing.T) {
+	const length = 100_000
+	s := newTestStream(length)
+
+	if s.Len() != length || s.Nodes() != length/StreamNodeMaxEntries {
+		t.Fatalf("Len() = %d, Nodes() = %d", s.Len(), s.Nodes())
+	}
+
+	i := 0
+	for entry := range s.All() {
+		wantId := StreamId{Ms: int64(i/2 + 1), Sequence: int64(i % 2)}
+		wantPairs := 2
+		if i%7 == 0 {
+			wantPairs = 4
+		}
+
+		if entry.Id != wantId || entry.Pairs[1] != strconv.Itoa(i) || len(entry.Pairs) != wantPairs {
+			t.Fatalf("entry %d = %+v", i, entry)
+		}
+
+		i++
+	}
+
+	if i != length {
+		t.Fatalf("All() returned %d entries, want %d", i, length)
+	}
+
+	//a range spanning several nodes
+	start := StreamId{Ms: 12_345, Sequence: 1}
+	got := s.Range(start, StreamId{Ms: 20_000, Sequence: 0}, 0)
+	if len(got) != 15_310 || got[0].Id != start || got[len(got)-1].Pairs[1] != "39998" {
+		t.Errorf("Range() returned %d entries from %v to %v", len(got), got[0].Id, got[len(got)-1].Id)
+	}
+
+	if last, _ := s.Last(); last.Pairs[1] != strconv.Itoa(length-1) {
+		t.Errorf("Last() = %+v", last)
+	}
+}
+
+func TestStreamTrim(t *testing.T) {
+	tests := []struct {
+		name        string
+		trim        func(s *Stream) int
+		wantRemoved int
+		wantFirst   string
+	}{
+		{"exact maxlen", func(s *Stream) int { return s.TrimMaxLen(750, false, 0) }, 250, "126-0"},
+		{"approximate maxlen", func(s *Stream) int { return s.TrimMaxLen(750, true, 0) }, 200, "101-0"},
+		{"approximate maxlen with limit", func(s *Stream) int { return s.TrimMaxLen(0, true, 250) }, 200, "101-0"},
+		{"limit below a node", func(s *Stream) int { return s.TrimMaxLen(0, true, 50) }, 0, "1-0"},
+		{"exact minid", func(s *Stream) int { return s.TrimMinId(StreamId{Ms: 126, Sequence: 1}, false, 0) }, 251, "126-1"},
+		{"approximate minid", func(s *Stream) int { return s.TrimMinId(StreamId{Ms: 126, Sequence: 1}, true, 0) }, 200, "101-0"},
+		{"everything", func(s *Stream) int { return s.TrimMaxLen(0, false, 0) }, 1000, ""},
+		{"nothing", func(s *Stream) int { return s.TrimMaxLen(1000, false, 0) }, 0, "1-0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStream(1000)
+
+			if removed := tt.trim(&s); removed != tt.wantRemoved {
+				t.Errorf("trim removed %d entries, want %d", removed, tt.wantRemoved)
+			}
+
+			if s.Len() != 1000-tt.wantRemoved {
+				t.Errorf("Len() = %d, want %d", s.Len(), 1000-tt.wantRemoved)
+			}
+
+			first, ok := s.First()
+			if got := first.Id.String(); ok && got != tt.wantFirst || !ok && tt.wantFirst != "" {
+				t.Errorf("First() = %v, want %v", got, tt.wantFirst)
+			}
+
+			if got := len(slices.Collect(s.All())); got != s.Len() {
+				t.Errorf("All() returned %d entries, want %d", got, s.Len())
+			}
+
+			//trimmed streams keep growing
+			s.Append(NewStreamEntry(StreamId{Ms: 1000}, []string{"field", "value"}))
+			if last, _ := s.Last(); last.Id != (StreamId{Ms: 1000}) || s.Len() != 1001-tt.wantRemoved {
+				t.Errorf("Last() = %v after Append, Len() = %d", last.Id, s.Len())
+			}
+		})
+	}
+}
+
+func TestStreamSnapshot(t *testing.T) {
+	s := newTestStream(250)
+	snapshot := s
+	want := slices.Collect(snapshot.All())
+
+	s.TrimMaxLen(120, false, 0)
+	for i := range 300 {
+		s.Append(NewStreamEntry(StreamId{Ms: 1000, Sequence: int64(i)}, []string{"field", "new"}))
+	}
+
+	//a copy of the snapshot appends to the node the stream appended to
+	other := snapshot
+	other.Append(NewStreamEntry(StreamId{Ms: 2000}, []string{"field", "other"}))
+
+	got := slices.Collect(snapshot.All())
+	if snapshot.Len() != 250 || len(got) != 250 {
+		t.Fatalf("snapshot has %d entries, Len() = %d", len(got), snapshot.Len())
+	}
+
+	for i := range got {
+		if got[i].Id != want[i].Id || !slices.Equal(got[i].Pairs, want[i].Pairs) {
+			t.Fatalf("snapshot entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if last, _ := s.Last(); last.Pairs[1] != "new" || s.Len() != 420 {
+		t.Errorf("stream Last() = %+v, Len() = %d", last, s.Len())
+	}
+
+	if last, _ := other.Last(); last.Pairs[1] != "other" {
+		t.Errorf("copy Last() = %+v", last)
+	}
+}
+
+func TestIdTree(t *testing.T) {
+	tree := idTree[int]{}
+	const length = 10_000
+
+	for i := range length {
+		tree.Set(StreamId{Ms: int64(2 * i)}, i)
+	}
+
+	snapshot := tree
+	for i := range length / 2 {
+		tree.Delete(StreamId{Ms: int64(2 * i)})
+	}
+
+	if tree.Len() != length/2 || snapshot.Len() != length {
+		t.Fatalf("Len() = %d, snapshot Len() = %d", tree.Len(), snapshot.Len())
+	}
+
+	if _, _, ok := tree.Floor(StreamId{Ms: length - 1}); ok {
+		t.Errorf("Floor() found an id before the first one")
+	}
+
+	if id, value, ok := snapshot.Floor(StreamId{Ms: length + 1}); !ok || id.Ms != length || value != length/2 {
+		t.Errorf("snapshot Floor() = %v, %d, %v", id, value, ok)
+	}
+
+	i := length / 2
+	for id, value := range tree.Ascend(StreamId{}) {
+		if id.Ms != int64(2*i) || value != i {
+			t.Fatalf("Ascend() = %v, %d at %d", id, value, i)
+		}
+
+		i++
+	}
+
+	if i != length {
+		t.Errorf("Ascend() stopped at %d", i)
+	}
+
+	for i := range length / 2 {
+		if _, ok := snapshot.Get(StreamId{Ms: int64(2 * i)}); !ok {
+			t.Fatalf("snapshot lost %d", 2*i)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/store"
+	"sync"
+)
+
+// execLock makes transactions atomic: single reads share it, while writes
+// and EXEC hold it exclusively until all their commands have run.
+var execLock sync.RWMutex
+
+type transaction struct {
+	commands []*protocol.Command
+	// aborted is set when a command failed validation while queueing,
+	// EXEC then discards the whole transaction.
+	aborted bool
+}
+
+// watchedKey is the state of a key at the time it was watched.
+type watchedKey struct {
+	version uint64
+	expired bool
+}
+
+func (c *client) watch(keys []string) ([]byte, error) {
+	if c.tx != nil {
+		return nil, errors.New("ERR WATCH inside MULTI is not allowed")
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("ERR wrong number of arguments for 'watch' command")
+	}
+
+	if c.watched == nil {
+		c.watched = make(map[string]watchedKey)
+	}
+
+	for _, key := range keys {
+		//like in Redis, watching a key again keeps its first state
+		if _, ok := c.watched[key]; ok {
+			continue
+		}
+
+		c.watched[key] = watchedKey{store.CM.Watch(key), isExpired(key)}
+	}
+
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+func (c *client) unwatch() {
+	for key := range c.watched {
+		store.CM.Unwatch(key)
+	}
+
+	c.watched = nil
+}
+
+// watchedKeysChanged reports whether a watched key was written, deleted or
+// has expired since it was watched.
+func (c *client) watchedKeysChanged() bool {
+	for key, watched := range c.watched {
+		if store.CM.Version(key) != watched.version {
+			return true
+		}
+
+		if !watched.expired && isExpired(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isExpired(key string) bool {
+	storedValue, ok := store.CM.Get(key)
+	return ok && storedValue.IsExpired()
+}
+
+func (c *client) multi() ([]byte, error) {
+	if c.tx != nil {
+		return nil, errors.New("ERR MULTI calls can not be nested")
+	}
+
+	c.tx = &transaction{}
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+func (c *client) discard() ([]byte, error) {
+	if c.tx == nil {
+		return nil, errors.New("ERR DISCARD without MULTI")
+	}
+
+	c.tx = nil
+	c.unwatch()
+	return protocol.FormatSimpleString("OK"), nil
+}
+
+func (c *client) queue(command *protocol.Command) ([]byte, error) {
+	spec, err := lookupCommand(command)
+	if err == nil {
+		err = c.checkReplica(spec)
+	}
+
+	if err == nil {
+		err = c.checkCluster(command)
+	}
+
+	if err != nil {
+		c.tx.aborted = true
+		return nil, err
+	}
+
+	c.tx.commands = append(c.tx.commands, command)
+	return protocol.FormatSimpleString("QUEUED"), nil
+}
+
+func (c *client) exec() ([]byte, error) {
+	if c.tx == nil {
+		return nil, errors.New("ERR EXEC without MULTI")
+	}
+
+	tx := c.tx
+	c.tx = nil
+	defer c.unwatch()
+
+	if tx.aborted {
+		return nil, errors.New("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	if err := c.checkCluster(tx.commands...); err != nil {
+		return nil, err
+	}
+
+	execLock.Lock()
+	defer execLock.Unlock()
+
+	if c.watchedKeysChanged() {
+		return protocol.FormatNullArray(), nil
+	}
+
+	c.executing = true
+	defer func() {
+		c.executing = false
+		c.flushPropagated()
+	}()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(tx.commands))
+
+	for _, command := range tx.commands {
+		//commands were validated while queueing
+		spec, _ := lookupCommand(command)
+
+		response, err := call(c, spec, command)
+		if err != nil {
+			buf.Write(protocol.FormatError(err))
+			continue
+		}
+
+		buf.Write(response)
+	}
+
+	return buf.Bytes(), nil
+}
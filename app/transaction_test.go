@@ -0,0 +1,216 @@
+package main
+
+import (
+	"redis-clone-go/app/protocol"
+	"redis-clone-go/app/replication"
+	"redis-clone-go/app/store"
+	"testing"
+	"time"
+)
+
+// run handles a command the way it is read from a connection.
+func run(c *client, name string, args ...string) (string, error) {
+	response, err := handleCommand(c, &protocol.Command{Name: name, Args: args})
+	return string(response), err
+}
+
+func TestTransaction(t *testing.T) {
+	defer store.CM.Delete("tx")
+
+	tests := []struct {
+		name    string
+		command []string
+		want    string
+		wantErr string
+	}{
+		{"multi", []string{"MULTI"}, "+OK\r\n", ""},
+		{"nested multi", []string{"MULTI"}, "", "ERR MULTI calls can not be nested"},
+		{"queue set", []string{"SET", "tx", "1"}, "+QUEUED\r\n", ""},
+		{"queue get", []string{"GET", "tx"}, "+QUEUED\r\n", ""},
+		{"exec", []string{"EXEC"}, "*2\r\n+OK\r\n$1\r\n1\r\n", ""},
+		{"exec without multi", []string{"EXEC"}, "", "ERR EXEC without MULTI"},
+		{"discard without multi", []string{"DISCARD"}, "", "ERR DISCARD without MULTI"},
+		{"multi again", []string{"MULTI"}, "+OK\r\n", ""},
+		{"queue discarded set", []string{"SET", "tx", "2"}, "+QUEUED\r\n", ""},
+		{"discard", []string{"DISCARD"}, "+OK\r\n", ""},
+		{"discarded set didn't run", []string{"GET", "tx"}, "$1\r\n1\r\n", ""},
+	}
+
+	c := newClient(nil)
+	for _, tt := range tests {
+		got, err := run(c, tt.command[0], tt.command[1:]...)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("%s: %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestTransactionAborted(t *testing.T) {
+	defer store.CM.Delete("tx")
+
+	tests := []struct {
+		name    string
+		command []string
+	}{
+		{"unknown command", []string{"NOSUCHCOMMAND", "tx"}},
+		{"wrong number of arguments", []string{"SET", "tx"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClient(nil)
+			run(c, "MULTI")
+			run(c, "SET", "tx", "1")
+
+			if _, err := run(c, tt.command[0], tt.command[1:]...); err == nil {
+				t.Fatalf("queueing %q succeeded", tt.command)
+			}
+
+			//the commands queued before the error don't run either
+			_, err := run(c, "EXEC")
+			if err == nil || err.Error() != "EXECABORT Transaction discarded because of previous errors." {
+				t.Errorf("EXEC error = %v", err)
+			}
+
+			if _, ok := store.CM.Get("tx"); ok {
+				t.Errorf("a command of the aborted transaction ran")
+			}
+
+			if c.tx != nil {
+				t.Errorf("the transaction wasn't discarded")
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	defer store.CM.Delete("watched")
+
+	tests := []struct {
+		name      string
+		setup     func(c *client)
+		meanwhile []string
+		wantNil   bool
+	}{
+		{"unchanged", func(c *client) { run(c, "WATCH", "watched") }, nil, false},
+		{"changed", func(c *client) { run(c, "WATCH", "watched") }, []string{"SET", "watched", "other"}, true},
+		{"expired", func(c *client) {
+			run(c, "SET", "watched", "1", "PX", "20")
+			run(c, "WATCH", "watched")
+			time.Sleep(30 * time.Millisecond)
+		}, nil, true},
+		{"unwatched", func(c *client) {
+			run(c, "WATCH", "watched")
+			run(c, "UNWATCH")
+		}, []string{"SET", "watched", "other"}, false},
+		{"discarded", func(c *client) {
+			run(c, "WATCH", "watched")
+			run(c, "MULTI")
+			run(c, "DISCARD")
+		}, []string{"SET", "watched", "other"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.CM.Delete("watched")
+
+			c := newClient(nil)
+			tt.setup(c)
+
+			if tt.meanwhile != nil {
+				if _, err := run(newClient(nil), tt.meanwhile[0], tt.meanwhile[1:]...); err != nil {
+					t.Fatalf("%q error = %v", tt.meanwhile, err)
+				}
+			}
+
+			run(c, "MULTI")
+			run(c, "SET", "watched", "tx")
+
+			got, err := run(c, "EXEC")
+			if err != nil {
+				t.Fatalf("EXEC error = %v", err)
+			}
+
+			if isNil := got == string(protocol.FormatNullArray()); isNil != tt.wantNil {
+				t.Errorf("EXEC = %q", got)
+			}
+		})
+	}
+}
+
+func TestWatchInsideMulti(t *testing.T) {
+	c := newClient(nil)
+	run(c, "MULTI")
+	defer run(c, "DISCARD")
+
+	if _, err := run(c, "WATCH", "watched"); err == nil || err.Error() != "ERR WATCH inside MULTI is not allowed" {
+		t.Errorf("WATCH error = %v", err)
+	}
+}
+
+func TestCommandsWaitForTheLock(t *testing.T) {
+	store.CM.Set("locked", store.NewStringValue("1", 0))
+	defer store.CM.Delete("locked")
+	run(newClient(nil), "XADD", "locked-stream", "1-0", "field", "value")
+	defer store.CM.Delete("locked-stream")
+
+	tests := [][]string{
+		{"GET", "locked"},
+		{"SET", "locked", "2"},
+		{"XREAD", "STREAMS", "locked-stream", "0"},
+	}
+
+	for _, command := range tests {
+		t.Run(command[0], func(t *testing.T) {
+			//a transaction or a background save holds the lock
+			execLock.Lock()
+
+			done := make(chan struct{})
+			go func() {
+				run(newClient(nil), command[0], command[1:]...)
+				close(done)
+			}()
+
+			select {
+			case <-done:
+				t.Errorf("%q ran while the lock was held", command)
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			execLock.Unlock()
+			<-done
+		})
+	}
+}
+
+func TestReadOnlyReplica(t *testing.T) {
+	replication.SetReplicaOf("localhost 1")
+	defer replication.SetReplicaOf("no one")
+
+	c := newClient(nil)
+	if _, err := run(c, "SET", "readonly", "1"); err == nil || err.Error() != "READONLY You can't write against a read only replica." {
+		t.Errorf("SET error = %v", err)
+	}
+
+	if _, err := run(c, "GET", "readonly"); err != nil {
+		t.Errorf("GET error = %v", err)
+	}
+
+	//queueing a write fails the same way and aborts the transaction
+	run(c, "MULTI")
+	if _, err := run(c, "SET", "readonly", "1"); err == nil {
+		t.Errorf("queueing SET succeeded")
+	}
+
+	if _, err := run(c, "EXEC"); err == nil {
+		t.Errorf("EXEC of a transaction with a write succeeded")
+	}
+}